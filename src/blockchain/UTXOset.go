@@ -1,8 +1,8 @@
 package blockchain
 
 import (
+	"BlockChain/src/storage"
 	"BlockChain/src/utils"
-	"badger"
 	"encoding/hex"
 )
 
@@ -13,69 +13,49 @@ type UTXO struct {
 }
 
 //type UTXOSet struct {
-//	UTXODb *badger.DB
+//	UTXODb storage.KV
 //}
 
 // FindEnoughUTXOFromSet find enough UTXO from UTXO set
 // return UTXO total value
 // map[string][]int: TxID->[unspent output index]
-func FindEnoughUTXOFromSet(utxoDb *badger.DB, address []byte, amount int) (int, map[string][]int) {
+func FindEnoughUTXOFromSet(utxoDb storage.Reader, address []byte, amount int) (int, map[string][]int) {
 	// utxos stores unspent output indexes for each transaction ID
 	utxos := make(map[string][]int)
 
 	// sum holds the accumulated value of unspent outputs
 	sum := 0
 
-	// Read-only transaction to traverse the UTXO set
-	err := utxoDb.View(func(txn *badger.Txn) error {
-		// Iterator options to enhance performance
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-
-		// Traverse the UTXO set starting from the specified prefix
-		prefix := []byte(ChainStateTable)
-		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
-			item := iter.Item()
-
-			// Extract the transaction ID
-			key := item.KeyCopy(nil)[1:] // Assuming the first byte is skipped for the prefix
-			id := hex.EncodeToString(key)
-
-			// Process the value associated with the key (i.e., the serialized UTXO)
-			err := item.Value(func(val []byte) error {
-				// Deserialize the value to []UTXO
-				var utxo []UTXO
-				err := utils.Deserialize(val, &utxo)
-				if err != nil {
-					return err
-				}
-
-				// Iterate through the UTXOs
-				for _, out := range utxo {
-					// Check if the output belongs to the given address
-					if out.Output.CanBeUnlocked(address) {
-						// Accumulate the value of unspent outputs
-						sum += out.Output.Value
-						// Store the index of the unspent output for the corresponding transaction ID
-						utxos[id] = append(utxos[id], out.Index)
-					}
-				}
-				return nil
-			})
-			if err != nil {
-				return err
+	// Traverse the UTXO set starting from the specified prefix
+	prefix := []byte(ChainStateTable)
+	iter := utxoDb.NewIterator(prefix)
+	defer iter.Release()
+	for iter.Next() {
+		// Extract the transaction ID
+		id := hex.EncodeToString(iter.Key()[len(prefix):])
+
+		// Deserialize the value to []UTXO
+		var utxo []UTXO
+		err := utils.Deserialize(iter.Value(), &utxo)
+		utils.HandleError(err)
+
+		// Iterate through the UTXOs
+		for _, out := range utxo {
+			// Check if the output belongs to the given address
+			if out.Output.CanBeUnlocked(address) {
+				// Accumulate the value of unspent outputs
+				sum += out.Output.Value
+				// Store the index of the unspent output for the corresponding transaction ID
+				utxos[id] = append(utxos[id], out.Index)
 			}
+		}
 
-			// Exit the loop if the accumulated sum meets or exceeds the required amount
-			if sum >= amount {
-				break
-			}
+		// Exit the loop if the accumulated sum meets or exceeds the required amount
+		if sum >= amount {
+			break
 		}
-		return nil
-	})
-	utils.HandleError(err)
+	}
+	utils.HandleError(iter.Error())
 
 	// Return the total accumulated sum of unspent outputs and their corresponding indexes
 	if sum >= amount {
@@ -86,49 +66,29 @@ func FindEnoughUTXOFromSet(utxoDb *badger.DB, address []byte, amount int) (int,
 }
 
 // GetBalanceFromSet calculates the balance of an address by summing up the values of its unspent outputs
-func GetBalanceFromSet(utxoDb *badger.DB, address []byte) int {
+func GetBalanceFromSet(utxoDb storage.Reader, address []byte) int {
 	// Initialize the balance to zero
 	balance := 0
 
-	// Read-only transaction to traverse the UTXO set
-	err := utxoDb.View(func(txn *badger.Txn) error {
-		// Iterator options to enhance performance
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-
-		// Seek to the prefix associated with the UTXO set
-		prefix := []byte(ChainStateTable)
-		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
-			item := iter.Item()
-
-			// Process the value associated with the key (i.e., the serialized UTXO)
-			err := item.Value(func(val []byte) error {
-				// Deserialize the value to []UTXO
-				var utxo []UTXO
-				err := utils.Deserialize(val, &utxo)
-				if err != nil {
-					return err
-				}
-
-				// Iterate through the UTXOs
-				for _, out := range utxo {
-					// Check if the output belongs to the given address
-					if out.Output.CanBeUnlocked(address) {
-						// Accumulate the value of unspent outputs
-						balance += out.Output.Value
-					}
-				}
-				return nil
-			})
-			if err != nil {
-				return err
+	// Traverse the UTXO set
+	iter := utxoDb.NewIterator([]byte(ChainStateTable))
+	defer iter.Release()
+	for iter.Next() {
+		// Deserialize the value to []UTXO
+		var utxo []UTXO
+		err := utils.Deserialize(iter.Value(), &utxo)
+		utils.HandleError(err)
+
+		// Iterate through the UTXOs
+		for _, out := range utxo {
+			// Check if the output belongs to the given address
+			if out.Output.CanBeUnlocked(address) {
+				// Accumulate the value of unspent outputs
+				balance += out.Output.Value
 			}
 		}
-		return nil
-	})
-	utils.HandleError(err)
+	}
+	utils.HandleError(iter.Error())
 
 	// Return the calculated balance for the given address
 	return balance
@@ -136,46 +96,41 @@ func GetBalanceFromSet(utxoDb *badger.DB, address []byte) int {
 
 // ReindexUTXOSet updates the UTXO set in the database with a new set of UTXOs
 // utxoMap represents the updated UTXO set obtained from chain.FindUTXO
-func ReindexUTXOSet(utxoDb *badger.DB, utxoMap map[string][]UTXO) {
-	// Delete all existing UTXO items from the database
-	err := utxoDb.Update(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
-		iter := txn.NewIterator(opts)
-		defer iter.Close()
-
-		prefix := []byte("c")
-		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
-			item := iter.Item()
-			key := item.KeyCopy(nil)
-
-			if err := txn.Delete(key); err != nil {
-				return err
-			}
-		}
+func ReindexUTXOSet(utxoDb storage.KV, utxoMap map[string][]UTXO) {
+	batch := utxoDb.NewBatch()
 
-		// Add the new UTXO items to the database
-		for id, utxos := range utxoMap {
-			txId, err := hex.DecodeString(id)
-			utils.HandleError(err)
-			serializeData, err := utils.Serialize(utxos)
-			utils.HandleError(err)
-			err = txn.Set(append([]byte(ChainStateTable), txId...), serializeData)
-			utils.HandleError(err)
-		}
-		return nil
-	})
+	// Delete all existing UTXO items from the database
+	prefix := []byte(ChainStateTable)
+	iter := utxoDb.NewIterator(prefix)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	err := iter.Error()
+	iter.Release()
 	utils.HandleError(err)
+
+	// Add the new UTXO items to the database
+	for id, utxos := range utxoMap {
+		txId, err := hex.DecodeString(id)
+		utils.HandleError(err)
+		serializeData, err := utils.Serialize(utxos)
+		utils.HandleError(err)
+		batch.Set(append([]byte(ChainStateTable), txId...), serializeData)
+	}
+	utils.HandleError(batch.Write())
 }
 
 // UpdateUTXOSet updates the UTXO set when a new block is added to the blockchain
-func UpdateUTXOSet(utxoDb *badger.DB, block *Block) bool {
+func UpdateUTXOSet(utxoDb storage.KV, block *Block) bool {
 	for _, tx := range block.Transactions {
 		utxoMap := make(map[string][]UTXO)
 		var utxos []UTXO
 
-		// Delete spent outputs and update UTXO map
+		// Delete spent outputs and update UTXO map, coinbase spends nothing
 		for _, in := range tx.Inputs {
+			if tx.IsCoinBase() {
+				break
+			}
 			id := hex.EncodeToString(in.TxID)
 			if _, ok := utxoMap[id]; !ok {
 				serializeData, err := ReadFromDB(utxoDb, []byte(ChainStateTable), in.TxID)
@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"BlockChain/src/utils"
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -17,11 +18,16 @@ type Block struct {
 
 // BlockHeader represents the header of a block
 type BlockHeader struct {
-	Timestamp int64  `json:"timestamp"` // Timestamp when the block was created
-	Hash      []byte `json:"hash"`      // Hash of the block
-	PrevHash  []byte `json:"prevHash"`  // Hash of the previous block
-	Height    uint64 `json:"height"`    // Height of the block in the blockchain
-	//MerkleRoot []byte // Placeholder for Merkle Root (commented out)
+	Timestamp  int64       `json:"timestamp"`           // Timestamp when the block was created
+	Hash       []byte      `json:"hash"`                // Hash of the block
+	PrevHash   []byte      `json:"prevHash"`            // Hash of the previous block
+	Height     uint64      `json:"height"`              // Height of the block in the blockchain
+	MerkleRoot []byte      `json:"merkleRoot"`          // Merkle root of the block transactions
+	Bits       uint32      `json:"bits"`                // Proof of work difficulty bits, 0 for non-PoW blocks
+	Nonce      uint64      `json:"nonce"`               // Proof of work nonce
+	Justify    *QuorumCert `json:"justify,omitempty"`   // Certificate of the previous block, set by HotStuff
+	VRF        []byte      `json:"vrf,omitempty"`       // VRF proof of the proposer, set by pBFT with VRF leader election
+	StateRoot  []byte      `json:"stateRoot,omitempty"` // root of the state snapshot SnapshotDelay blocks before, set by pBFT
 }
 
 // NewBlock creates a new block with the provided data
@@ -39,13 +45,84 @@ func NewBlock(preHash []byte, Txs []*Transaction, height uint64) *Block {
 		Transactions:       Txs,
 		TransactionCounter: len(Txs),
 	}
-	blockBytes, err := utils.Serialize(&block)
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	block.Header.Hash = block.CalculateHash()
+
+	return &block
+}
+
+// NewCertifiedBlock creates a new block carrying the certificate of its previous block
+func NewCertifiedBlock(preHash []byte, Txs []*Transaction, height uint64, justify *QuorumCert) *Block {
+	block := NewBlock(preHash, Txs, height)
+	block.Header.Justify = justify
+	block.Header.Hash = block.CalculateHash()
+	return block
+}
+
+// CalculateHash computes the hash of the block with an empty hash field
+func (b *Block) CalculateHash() []byte {
+	header := *b.Header
+	header.Hash = []byte{}
+	blockCopy := Block{
+		Header:             &header,
+		Transactions:       b.Transactions,
+		TransactionCounter: b.TransactionCounter,
+	}
+	blockBytes, err := utils.Serialize(&blockCopy)
 	utils.HandleError(err)
-	block.Header.Hash = utils.Sha256Hash(blockBytes)
+	return utils.Sha256Hash(blockBytes)
+}
+
+// NewWorkBlock creates a proof of work block template, the hash and nonce are set by mining
+func NewWorkBlock(preHash []byte, Txs []*Transaction, height uint64, bits uint32) *Block {
+	// Create header
+	header := &BlockHeader{}
+	header.Timestamp = time.Now().Unix()
+	header.PrevHash = preHash
+	header.Hash = []byte{}
+	header.Height = height
+	header.Bits = bits
+
+	// Create the block
+	block := Block{
+		Header:             header,
+		Transactions:       Txs,
+		TransactionCounter: len(Txs),
+	}
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
 
 	return &block
 }
 
+// CalculateMerkleRoot computes the merkle root of the block transaction IDs
+func (b *Block) CalculateMerkleRoot() []byte {
+	if len(b.Transactions) == 0 {
+		return []byte{}
+	}
+	var ids [][]byte
+	for _, tx := range b.Transactions {
+		ids = append(ids, tx.ID)
+	}
+	return utils.NewMerkleTree(ids).Root.Hash
+}
+
+// SerializeHeader serializes the header fields covered by proof of work, excluding hash and nonce.
+// StateRoot, VRF and Justify are set by other engines only and proof of work headers must leave them empty,
+// PrevHash and MerkleRoot must be hashes of fixed size so no bytes move between the joined fields
+func (header *BlockHeader) SerializeHeader() []byte {
+	data := bytes.Join(
+		[][]byte{
+			header.PrevHash,
+			header.MerkleRoot,
+			utils.Int2Bytes(header.Timestamp),
+			utils.Int2Bytes(int64(header.Height)),
+			utils.Int2Bytes(int64(header.Bits)),
+		},
+		[]byte{},
+	)
+	return data
+}
+
 // NewGenesisBlock creates a genesis block with the given address
 func NewGenesisBlock(address []byte) (*Block, error) {
 	// Check address validity
@@ -61,7 +138,7 @@ func NewGenesisBlock(address []byte) (*Block, error) {
 	header.Height = 1
 
 	// Create a genesis transaction
-	genesisTx := NewCoinbaseTx(address, GenesisValue)
+	genesisTx := NewCoinbaseTx(address, ActiveParams().GenesisValue)
 
 	// Serialize Transactions
 	Txs := []*Transaction{genesisTx}
@@ -72,9 +149,8 @@ func NewGenesisBlock(address []byte) (*Block, error) {
 		Transactions:       Txs,
 		TransactionCounter: len(Txs),
 	}
-	genesisBlockBytes, err := utils.Serialize(genesisBlock)
-	utils.HandleError(err)
-	genesisBlock.Header.Hash = utils.Sha256Hash(genesisBlockBytes)
+	genesisBlock.Header.MerkleRoot = genesisBlock.CalculateMerkleRoot()
+	genesisBlock.Header.Hash = genesisBlock.CalculateHash()
 
 	return &genesisBlock, nil
 }
@@ -93,6 +169,14 @@ func (b *Block) Show() {
 	fmt.Printf("    Hash: %s\n", hex.EncodeToString(b.Header.Hash))
 	fmt.Printf("    PrevHash: %s\n", hex.EncodeToString(b.Header.PrevHash))
 	fmt.Printf("    Height: %d\n", b.Header.Height)
+	fmt.Printf("    MerkleRoot: %s\n", hex.EncodeToString(b.Header.MerkleRoot))
+	if b.Header.Bits != 0 {
+		fmt.Printf("    Bits: %d\n", b.Header.Bits)
+		fmt.Printf("    Nonce: %d\n", b.Header.Nonce)
+	}
+	if len(b.Header.StateRoot) != 0 {
+		fmt.Printf("    StateRoot: %s\n", hex.EncodeToString(b.Header.StateRoot))
+	}
 	fmt.Println("-----------------------------------Transactions Information----------------------------------")
 	fmt.Printf("  TransactionCounter: %d\n", b.TransactionCounter)
 	fmt.Printf("  Transactions:\n")
@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"BlockChain/src/mycrypto"
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/binary"
+)
+
+// QuorumCert certifies a block with the signatures of a quorum of validators
+type QuorumCert struct {
+	View       uint64           `json:"view"`              // view the block was certified in
+	Height     uint64           `json:"height"`            // certified block height
+	BlockHash  []byte           `json:"blockHash"`         // certified block hash
+	Signatures []*CertSignature `json:"signatures"`        // validator signatures of CertDigest
+	AggSign    []byte           `json:"aggSign,omitempty"` // BLS aggregate signature replacing Signatures
+	Bitmap     []byte           `json:"bitmap,omitempty"`  // validator indexes of the aggregate signature
+}
+
+// CertSignature is the signature of one validator in a certificate
+type CertSignature struct {
+	ID      string `json:"id"`                // signer ID
+	Sign    []byte `json:"sign"`              // signature
+	PubKey  []byte `json:"pubKey"`            // signer public key
+	BLSSign []byte `json:"blsSign,omitempty"` // signer BLS signature of CertDigest
+}
+
+// CertDigest returns the digest validators sign to certify a block in a view
+func CertDigest(blockHash []byte, view uint64) []byte {
+	viewBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(viewBuf, view)
+	return utils.Sha256Hash(append(append([]byte{}, blockHash...), viewBuf...))
+}
+
+// AddSignature adds a signature to the certificate, a signer is counted only once
+func (qc *QuorumCert) AddSignature(sig *CertSignature) bool {
+	for _, s := range qc.Signatures {
+		if s.ID == sig.ID || bytes.Equal(s.PubKey, sig.PubKey) {
+			return false
+		}
+	}
+	qc.Signatures = append(qc.Signatures, sig)
+	return true
+}
+
+// Verify checks the certificate carries at least quorum valid signatures of distinct replicas,
+// replicas are the public keys of a fixed replica set in index order, unknown or repeated signers are rejected
+func (qc *QuorumCert) Verify(replicas [][]byte, quorum uint64) bool {
+	digest := CertDigest(qc.BlockHash, qc.View)
+	signers := make(map[int]struct{})
+	for _, sig := range qc.Signatures {
+		index := -1
+		for i, key := range replicas {
+			if bytes.Equal(key, sig.PubKey) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return false
+		}
+		if _, exists := signers[index]; exists {
+			return false
+		}
+		if !mycrypto.Verify(mycrypto.Bytes2PublicKey(sig.PubKey), digest, sig.Sign) {
+			return false
+		}
+		signers[index] = struct{}{}
+	}
+	return uint64(len(signers)) >= quorum
+}
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"BlockChain/src/mycrypto"
+	"fmt"
+	"testing"
+)
+
+func TestQuorumCert_Verify(t *testing.T) {
+	qc := &QuorumCert{
+		View:      1,
+		Height:    1,
+		BlockHash: []byte("block"),
+	}
+	digest := CertDigest(qc.BlockHash, qc.View)
+	var replicas [][]byte
+	for i := 0; i < 3; i++ {
+		pub, priv, err := mycrypto.GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sign, err := mycrypto.Sign(priv, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, mycrypto.PublicKey2Bytes(pub))
+		sig := &CertSignature{ID: fmt.Sprintf("node%d", i), Sign: sign, PubKey: mycrypto.PublicKey2Bytes(pub)}
+		if !qc.AddSignature(sig) {
+			t.Fatal("add signature fail")
+		}
+		if qc.AddSignature(sig) {
+			t.Fatal("duplicate signature added")
+		}
+	}
+	fmt.Println(qc.Verify(replicas, 3), qc.Verify(replicas, 4))
+	if !qc.Verify(replicas, 3) || qc.Verify(replicas, 4) {
+		t.Fatal("verify quorum error")
+	}
+
+	// signers must be distinct configured replicas
+	if qc.Verify(replicas[1:], 2) {
+		t.Fatal("verify certificate of unknown signer")
+	}
+	repeated := *qc
+	repeated.Signatures = []*CertSignature{qc.Signatures[0], qc.Signatures[0], qc.Signatures[1]}
+	if repeated.Verify(replicas, 2) {
+		t.Fatal("verify certificate of repeated signer")
+	}
+
+	// a certificate of another view must not verify
+	qc.View = 2
+	if qc.Verify(replicas, 3) {
+		t.Fatal("verify certificate of wrong view")
+	}
+}
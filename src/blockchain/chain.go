@@ -1,12 +1,14 @@
 package blockchain
 
 import (
+	"BlockChain/src/storage"
 	"BlockChain/src/utils"
-	"badger"
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"log"
+	"math/big"
 	"strconv"
 	"sync"
 )
@@ -15,7 +17,10 @@ import (
 type Chain struct {
 	Tip        []byte      // Hash of the latest block
 	BestHeight uint64      // Height of the best block in the chain
-	DataBase   *badger.DB  // Database to store blockchain data
+	Base       uint64      // Height of the oldest stored block, above 1 for a chain restored from a snapshot
+	Pruned     uint64      // Height of the latest block whose body is pruned, 0 if no block is pruned
+	pruneDepth uint64      // Blocks with bodies kept below the tip, 0 keeps all blocks
+	DataBase   storage.KV  // Database to store blockchain data
 	log        *log.Logger // Logger for the blockchain
 	Lock       sync.Mutex  // Mutex for synchronizing access to the blockchain
 }
@@ -23,7 +28,7 @@ type Chain struct {
 // BlockIterator iterates over blocks in the blockchain
 type BlockIterator struct {
 	CurrentHash []byte      // Hash of the current block being iterated
-	DataBase    *badger.DB  // Database to fetch block data
+	DataBase    storage.KV  // Database to fetch block data
 	Lock        *sync.Mutex // Mutex for synchronizing access to the iterator
 }
 
@@ -54,26 +59,93 @@ func (iterator *BlockIterator) Next() *Block {
 	return &currentBlock
 }
 
-// FindBlocksInRange finds all blocks within a given height range in the blockchain
+// FindBlocksInRange finds all blocks with bodies within a given height range in the blockchain, in height order
 func (chain *Chain) FindBlocksInRange(min, max uint64) []*Block {
-	var blocksInRange []*Block
+	// pruned blocks only keep their headers
+	if pruned := chain.PrunedHeight(); min <= pruned {
+		min = pruned + 1
+	}
+	return chain.findInRange(min, max)
+}
 
-	iter := chain.Iterator()
-	for {
-		block := iter.Next()
+// FindHeadersInRange finds headers of all blocks within a given height range in height order, including pruned blocks
+func (chain *Chain) FindHeadersInRange(min, max uint64) []*BlockHeader {
+	var headers []*BlockHeader
+	for _, block := range chain.findInRange(min, max) {
+		headers = append(headers, block.Header)
+	}
+	return headers
+}
 
-		if block.Header.Height >= min && block.Header.Height <= max {
-			// Add the block to the result list if its height is within the specified range
-			blocksInRange = append(blocksInRange, block)
+// findInRange reads blocks of the range in height order through the height index
+func (chain *Chain) findInRange(min, max uint64) []*Block {
+	chain.Lock.Lock()
+	if min < chain.Base {
+		min = chain.Base
+	}
+	if max > chain.BestHeight {
+		max = chain.BestHeight
+	}
+	chain.Lock.Unlock()
+
+	var blocksInRange []*Block
+	for height := min; height <= max; height++ {
+		block := chain.findBlockByHeight(height)
+		if block == nil {
+			break
 		}
+		blocksInRange = append(blocksInRange, block)
+	}
+	return blocksInRange
+}
+
+// hashAt returns the hash of the main chain block at height, nil if not indexed
+func (chain *Chain) hashAt(height uint64) []byte {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	hash, err := ReadFromDB(chain.DataBase, []byte(HeightTable), binary.BigEndian.AppendUint64(nil, height))
+	if err != nil {
+		return nil
+	}
+	return hash
+}
+
+// writeHeight indexes hash as the main chain block at height, the caller holds chain.Lock
+func (chain *Chain) writeHeight(height uint64, hash []byte) error {
+	return WriteToDB(chain.DataBase, []byte(HeightTable), binary.BigEndian.AppendUint64(nil, height), hash)
+}
 
-		// Stop iterating if the current block is the genesis block
-		if block.IsGenesisBlock() {
+// reindexHeights points the height index at the main chain ending at tip, entries above tip are
+// deleted and entries of the replaced branch are overwritten down to the fork point
+func (chain *Chain) reindexHeights(tip *Block, oldHeight uint64) error {
+	chain.Lock.Lock()
+	for height := tip.Header.Height + 1; height <= oldHeight; height++ {
+		if err := DeleteFromDB(chain.DataBase, []byte(HeightTable), binary.BigEndian.AppendUint64(nil, height)); err != nil {
+			chain.Lock.Unlock()
+			return err
+		}
+	}
+	chain.Lock.Unlock()
+	for block := tip; block != nil && !bytes.Equal(chain.hashAt(block.Header.Height), block.Header.Hash); {
+		chain.Lock.Lock()
+		err := chain.writeHeight(block.Header.Height, block.Header.Hash)
+		chain.Lock.Unlock()
+		if err != nil {
+			return err
+		}
+		if chain.isBase(block) {
 			break
 		}
+		block = chain.findBlockByHash(block.Header.PrevHash)
 	}
+	return nil
+}
 
-	return blocksInRange
+// isBase checks the block is the oldest stored block, the genesis block unless restored from a snapshot
+func (chain *Chain) isBase(block *Block) bool {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return block.IsGenesisBlock() || block.Header.Height <= chain.Base
 }
 
 // CreateChain creates a new blockchain with a genesis block
@@ -95,8 +167,14 @@ func CreateChain(address []byte, path, logPath string) (*Chain, error) {
 		return nil, err
 	}
 
-	// Add the latest block flag to the database
+	// Add the latest block flag, the schema version and the chain ID to the database
 	err = WriteToDB(db, []byte(BlockTable), []byte(TipHashKey), genesisBlock.Header.Hash)
+	if err == nil {
+		err = writeSchemaVersion(db, SchemaVersion)
+	}
+	if err == nil {
+		err = writeChainID(db)
+	}
 	if err != nil {
 		l.Panic("fail to write block data into database")
 		return nil, err
@@ -106,6 +184,7 @@ func CreateChain(address []byte, path, logPath string) (*Chain, error) {
 	chain := &Chain{
 		Tip:        genesisBlock.Header.Hash,
 		BestHeight: 1,
+		Base:       1,
 		DataBase:   db,
 		log:        l,
 	}
@@ -127,12 +206,27 @@ func LoadChain(path, logPath string) (*Chain, error) {
 		return nil, err
 	}
 
+	// Refuse databases written by newer nodes or of other networks
+	version, err := checkSchemaVersion(db)
+	if err == nil {
+		err = checkChainID(db)
+	}
+	if err != nil {
+		db.Close()
+		l.Println("Open database fail: ", err)
+		return nil, err
+	}
+
 	// Read the tip hash from the database
 	latestHash, err := ReadFromDB(db, []byte(BlockTable), []byte(TipHashKey))
 
 	// Initialize an empty chain if no tip hash is found
 	var chain *Chain
 	if err != nil {
+		if err := writeSchemaVersion(db, SchemaVersion); err != nil {
+			db.Close()
+			return nil, err
+		}
 		chain = &Chain{
 			Tip:        nil,
 			BestHeight: 0,
@@ -145,18 +239,39 @@ func LoadChain(path, logPath string) (*Chain, error) {
 	// Retrieve the best height
 	serializeData, err := ReadFromDB(db, []byte(BlockTable), latestHash)
 	var heightBlock Block
-	err = utils.Deserialize(serializeData, &heightBlock)
+	if err == nil {
+		err = utils.Deserialize(serializeData, &heightBlock)
+	}
 	if err != nil {
-		l.Panic("fail to read Tip Block")
+		db.Close()
+		l.Println("Read tip block fail: ", err)
 		return nil, err
 	}
+	// Chains restored from a snapshot start above genesis
+	base := uint64(1)
+	if data, err := ReadFromDB(db, []byte(BlockTable), []byte(BaseHeightKey)); err == nil && len(data) == 8 {
+		base = binary.BigEndian.Uint64(data)
+	}
+	var pruned uint64
+	if data, err := ReadFromDB(db, []byte(BlockTable), []byte(PrunedHeightKey)); err == nil && len(data) == 8 {
+		pruned = binary.BigEndian.Uint64(data)
+	}
 	chain = &Chain{
 		Tip:        latestHash,
 		BestHeight: heightBlock.Header.Height,
+		Base:       base,
+		Pruned:     pruned,
 		DataBase:   db,
 		log:        l,
 	}
 
+	// upgrade databases of older nodes in place
+	if err := chain.migrate(version); err != nil {
+		db.Close()
+		l.Println("Migrate database fail: ", err)
+		return nil, err
+	}
+
 	return chain, nil
 }
 
@@ -167,8 +282,15 @@ func (chain *Chain) AddGenesisBlock(genesisBlock *Block) {
 	utils.HandleError(err)
 	chain.Lock.Lock()
 	err = WriteToDB(chain.DataBase, []byte(BlockTable), genesisBlock.Header.Hash, data)
+	if err == nil {
+		err = WriteToDB(chain.DataBase, []byte(BlockTable), []byte(GenesisHashKey), genesisBlock.Header.Hash)
+	}
+	if err == nil {
+		err = chain.writeHeight(genesisBlock.Header.Height, genesisBlock.Header.Hash)
+	}
 	chain.Lock.Unlock()
 	utils.HandleError(err)
+	utils.HandleError(chain.writeWork(genesisBlock, big.NewInt(0)))
 
 	// Add the GenesisBlock output to the UTXO set
 	genesisOutput := genesisBlock.Transactions[0].Outputs[0]
@@ -183,6 +305,8 @@ func (chain *Chain) AddGenesisBlock(genesisBlock *Block) {
 	err = WriteToDB(chain.DataBase, []byte(ChainStateTable), genesisBlock.Transactions[0].ID, data)
 	chain.Lock.Unlock()
 	utils.HandleError(err)
+	chain.updateTxIndex(genesisBlock)
+	chain.markTxIndex()
 }
 
 // AddBlock adds a block to the chain
@@ -208,6 +332,14 @@ func (chain *Chain) AddBlock(block *Block) bool {
 
 		return true
 	}
+	if len(block.Transactions) > ActiveParams().MaxBlockTxs {
+		chain.log.Println("Too many transactions in block, height: ", block.Header.Height)
+		return false
+	}
+	if !chain.CheckStateRoot(block) {
+		chain.log.Println("State root not match snapshot, height: ", block.Header.Height)
+		return false
+	}
 	// get previous block from database
 	chain.Lock.Lock()
 	preBlockData, err := ReadFromDB(chain.DataBase, []byte(BlockTable), preHash)
@@ -225,15 +357,28 @@ func (chain *Chain) AddBlock(block *Block) bool {
 		return false
 	}
 
-	if bytes.Equal(preBlock.Header.Hash, block.Header.PrevHash) {
+	// the block must extend the current tip, side branches go through AddSideBlock
+	chain.Lock.Lock()
+	extendTip := bytes.Equal(chain.Tip, preBlock.Header.Hash)
+	chain.Lock.Unlock()
+
+	if extendTip && bytes.Equal(preBlock.Header.Hash, block.Header.PrevHash) {
 		// add to database
 		serializeData, err := utils.Serialize(block)
 		if err != nil {
 			chain.log.Println("Serialize block fail")
 			return false
 		}
+		err = chain.writeWork(block, chain.GetTotalWork(preHash))
+		if err != nil {
+			chain.log.Println("Write block work fail")
+			return false
+		}
 		chain.Lock.Lock()
 		err = WriteToDB(chain.DataBase, []byte(BlockTable), block.Header.Hash, serializeData)
+		if err == nil {
+			err = chain.writeHeight(block.Header.Height, block.Header.Hash)
+		}
 		if err != nil {
 			defer chain.Lock.Unlock()
 			chain.log.Println("Add block to database fail")
@@ -252,15 +397,148 @@ func (chain *Chain) AddBlock(block *Block) bool {
 
 		// update UTXO set
 		ok := UpdateUTXOSet(chain.DataBase, block)
-		if !ok {
+		if !ok && chain.complete() {
 			ReindexUTXOSet(chain.DataBase, chain.FindUTXO())
+			chain.ReindexTxs()
+		} else if !ok {
+			chain.log.Println("Update UTXO set fail, height: ", block.Header.Height)
+		} else {
+			chain.updateTxIndex(block)
 		}
+		// count governance votes and change validator set at epoch boundary
+		chain.updateValidators(block)
+		if block.Header.Height%SnapshotInterval == 0 {
+			if err := chain.writeSnapshot(block); err != nil {
+				chain.log.Println("Write state snapshot fail: ", err)
+			}
+		}
+		chain.prune()
 		return true
 	}
 
 	return false
 }
 
+// AddSideBlock stores a block whose parent is known without moving the tip,
+// the block becomes part of the main chain only after Reorganize
+func (chain *Chain) AddSideBlock(block *Block) bool {
+	if !chain.HaveBlock(block.Header.PrevHash) {
+		chain.log.Println("Previous block of side block not found")
+		return false
+	}
+	if len(block.Transactions) > ActiveParams().MaxBlockTxs {
+		chain.log.Println("Too many transactions in side block")
+		return false
+	}
+	serializeData, err := utils.Serialize(block)
+	if err != nil {
+		chain.log.Println("Serialize block fail")
+		return false
+	}
+	err = chain.writeWork(block, chain.GetTotalWork(block.Header.PrevHash))
+	if err != nil {
+		chain.log.Println("Write block work fail")
+		return false
+	}
+	chain.Lock.Lock()
+	err = WriteToDB(chain.DataBase, []byte(BlockTable), block.Header.Hash, serializeData)
+	chain.Lock.Unlock()
+	if err != nil {
+		chain.log.Println("Add side block to database fail")
+		return false
+	}
+	return true
+}
+
+// Reorganize switches the tip to a stored block and rebuilds the UTXO set from the new main chain,
+// side branch transactions were checked against the main chain only, so the branch is replayed
+// from genesis and the tip is kept if a transaction is invalid on the branch
+func (chain *Chain) Reorganize(hash []byte) bool {
+	if !chain.complete() {
+		chain.log.Println("Reorganize needs all block bodies")
+		return false
+	}
+	block := chain.findBlockByHash(hash)
+	if block == nil {
+		chain.log.Println("Reorganize to unknown block")
+		return false
+	}
+	utxos, err := chain.replayBranch(block)
+	if err != nil {
+		chain.log.Println("Side branch invalid, keep tip: ", err)
+		return false
+	}
+	chain.Lock.Lock()
+	err = WriteToDB(chain.DataBase, []byte(BlockTable), []byte(TipHashKey), block.Header.Hash)
+	if err != nil {
+		defer chain.Lock.Unlock()
+		chain.log.Println("Update tip fail")
+		return false
+	}
+	oldHeight := chain.BestHeight
+	chain.Tip = block.Header.Hash
+	chain.BestHeight = block.Header.Height
+	chain.Lock.Unlock()
+	if err := chain.reindexHeights(block, oldHeight); err != nil {
+		chain.log.Println("Update height index fail: ", err)
+	}
+
+	chain.log.Printf("Reorganize chain to %s, height: %d", hex.EncodeToString(hash), block.Header.Height)
+	ReindexUTXOSet(chain.DataBase, utxos)
+	chain.ReindexTxs()
+	return true
+}
+
+// GetTotalWork returns the cumulative work of the chain ending at block hash
+func (chain *Chain) GetTotalWork(hash []byte) *big.Int {
+	chain.Lock.Lock()
+	data, err := ReadFromDB(chain.DataBase, []byte(WorkTable), hash)
+	chain.Lock.Unlock()
+	if err != nil {
+		return big.NewInt(0)
+	}
+	return new(big.Int).SetBytes(data)
+}
+
+// writeWork stores the cumulative work of block on top of its parent work
+func (chain *Chain) writeWork(block *Block, parentWork *big.Int) error {
+	work := new(big.Int).Add(parentWork, utils.Work(block.Header.Bits))
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return WriteToDB(chain.DataBase, []byte(WorkTable), block.Header.Hash, work.Bytes())
+}
+
+// GetBlock returns a block by its hash
+func (chain *Chain) GetBlock(hash []byte) *Block {
+	return chain.findBlockByHash(hash)
+}
+
+// AddCert stores the commit certificate of a block
+func (chain *Chain) AddCert(cert *QuorumCert) error {
+	data, err := utils.Serialize(cert)
+	if err != nil {
+		return err
+	}
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return WriteToDB(chain.DataBase, []byte(CertTable), cert.BlockHash, data)
+}
+
+// GetCert returns the commit certificate of a block, nil if it is not stored
+func (chain *Chain) GetCert(hash []byte) *QuorumCert {
+	chain.Lock.Lock()
+	data, err := ReadFromDB(chain.DataBase, []byte(CertTable), hash)
+	chain.Lock.Unlock()
+	if err != nil {
+		return nil
+	}
+	var cert QuorumCert
+	if utils.Deserialize(data, &cert) != nil {
+		return nil
+	}
+	return &cert
+}
+
 // HaveBlock checks if a block with a given hash exists in the chain
 func (chain *Chain) HaveBlock(hash []byte) bool {
 	// Check if the block exists in the chain
@@ -305,32 +583,22 @@ func (chain *Chain) findBlockByHash(hash []byte) *Block {
 	return &block
 }
 
-// FindBlockByHeight returns a block by its height
+// FindBlockByHeight returns a main chain block by its height
 func (chain *Chain) findBlockByHeight(height uint64) *Block {
-	// Check if the block exists in the chain
-
-	iter := chain.Iterator()
-	for {
-		block := iter.Next()
-
-		if block.Header.Height == height {
-			return block
-		} else if block.Header.Height < height {
-			chain.log.Println("wrong height")
-			return nil
-		}
-
-		// Stop iterating if the current block is the genesis block
-		if block.IsGenesisBlock() {
-			break
-		}
+	hash := chain.hashAt(height)
+	if hash == nil {
+		chain.log.Println("block not found")
+		return nil
 	}
-	chain.log.Println("block not found")
-	return nil
+	return chain.findBlockByHash(hash)
 }
 
 // FindTransaction returns a transaction by its ID
 func (chain *Chain) FindTransaction(id []byte) (*Transaction, error) {
+	// transactions with unspent outputs are indexed, bodies of old blocks may be pruned
+	if tx := chain.getIndexedTx(id); tx != nil {
+		return tx, nil
+	}
 	// Search for a transaction by ID in the blockchain
 	iter := chain.Iterator()
 	for {
@@ -342,7 +610,7 @@ func (chain *Chain) FindTransaction(id []byte) (*Transaction, error) {
 			}
 		}
 
-		if block.IsGenesisBlock() {
+		if chain.isBase(block) {
 			break
 		}
 	}
@@ -392,7 +660,7 @@ func (chain *Chain) FindUTXO() map[string][]UTXO {
 			}
 		}
 
-		if block.IsGenesisBlock() {
+		if chain.isBase(block) {
 			break
 		}
 	}
@@ -412,3 +680,14 @@ func (chain *Chain) GetHeight() uint64 {
 	defer chain.Lock.Unlock()
 	return chain.BestHeight
 }
+
+// GetGenesisHash returns the hash of the genesis block, nil for an empty chain or a chain restored from a snapshot
+func (chain *Chain) GetGenesisHash() []byte {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	hash, err := ReadFromDB(chain.DataBase, []byte(BlockTable), []byte(GenesisHashKey))
+	if err != nil {
+		return nil
+	}
+	return hash
+}
@@ -2,11 +2,9 @@ package blockchain
 
 const (
 	// Block
-	MaxTransactionLen = 9                 // MaxTransactionLen defines the maximum number of transactions per block
-	GenesisData       = "a Genesis Block" // GenesisData represents the initial data for the genesis block
+	GenesisData = "a Genesis Block" // GenesisData represents the initial data for the genesis block
 
 	// Wallet
-	AddressVersion      = 0x00                       // AddressVersion represents the version for wallet addresses
 	LocalPublicKeyFile  = "./wallet/public_key.pem"  // LocalPublicKeyFile defines the path for the local public key file
 	LocalPrivateKeyFile = "./wallet/private_key.pem" // LocalPrivateKeyFile defines the path for the local private key file
 
@@ -17,11 +15,22 @@ const (
 	DataBaseFile    = "./database/MANIFEST" // DataBaseFile represents the file containing the blockchain database
 	DataBasePath    = "./database"          // DataBasePath defines the path for the blockchain database
 	TipHashKey      = "l"                   // TipHashKey represents the key for the tip (latest block hash) in the database
+	BaseHeightKey   = "base"                // BaseHeightKey represents the key for the height of the oldest stored block
+	PrunedHeightKey = "pruned"              // PrunedHeightKey represents the key for the height of the latest block whose body is pruned
+	TxIndexKey      = "txindex"             // TxIndexKey represents the key marking the transaction index as built
+	SchemaKey       = "schema"              // SchemaKey represents the key for the schema version of the database
+	ChainIDKey      = "chainid"             // ChainIDKey represents the key for the chain ID of the network the database belongs to
+	GenesisHashKey  = "genesis"             // GenesisHashKey represents the key for the hash of the genesis block
 	BlockTable      = "b"                   // BlockTable represents the table storing block data in the database
 	ChainStateTable = "c"                   // ChainStateTable represents the table storing chain state in the database
+	WorkTable       = "w"                   // WorkTable represents the table storing cumulative work of each block
+	CertTable       = "q"                   // CertTable represents the table storing the commit certificate of each block
+	RaftTable       = "r"                   // RaftTable represents the table storing raft term, vote and uncompacted log entries
+	ValidatorTable  = "v"                   // ValidatorTable represents the table storing the validator set and governance votes
+	SnapshotTable   = "s"                   // SnapshotTable represents the table storing state snapshot manifests and chunks
+	TxIndexTable    = "t"                   // TxIndexTable represents the table storing transactions with unspent outputs
+	HeightTable     = "h"                   // HeightTable represents the table storing the main chain block hash of each height
 	MaxUTXOSize     = 1024                  // MaxUTXOSize defines the maximum size of the unspent transaction output set
-	GenesisValue    = 114514                // GenesisValue represents the initial value for the genesis block
-	MinerReward     = 10                    // MinerReward defines the reward for miners when mining a block
 
 	// Transaction pool
 	MaxTxPoolSize = 1024 // MaxTxPoolSize defines the maximum size of the transaction pool
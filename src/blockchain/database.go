@@ -1,57 +1,51 @@
 package blockchain
 
 import (
-	"badger"
+	"BlockChain/src/storage"
 )
 
-// OpenDatabase opens a BadgerDB database at the specified path
-func OpenDatabase(dbPath string) (*badger.DB, error) {
-	opts := badger.DefaultOptions(dbPath)
-	opts.Logger = nil
-	db, err := badger.Open(opts)
-	return db, err
+// OpenDatabase opens a BadgerDB database at the specified path, or an in-memory database for storage.MemoryPath
+func OpenDatabase(dbPath string) (storage.KV, error) {
+	return storage.Open(dbPath)
 }
 
 // WriteToDB writes a key-value pair to the database with a specified table prefix
-func WriteToDB(db *badger.DB, tablePrefix, key, value []byte) error {
-	return db.Update(func(txn *badger.Txn) error {
-		err := txn.Set(append(tablePrefix, key...), value)
-		return err
-	})
+func WriteToDB(db storage.KV, tablePrefix, key, value []byte) error {
+	return db.Set(tableKey(tablePrefix, key), value)
 }
 
 // ReadFromDB reads a value from the database using the specified table prefix and key
-func ReadFromDB(db *badger.DB, tablePrefix, key []byte) ([]byte, error) {
-	var value []byte
-	err := db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(append(tablePrefix, key...))
-		if err != nil {
-			return err
-		}
-
-		err = item.Value(func(val []byte) error {
-			value = val
-			return nil
-		})
-		return err
-	})
-	return value, err
+func ReadFromDB(db storage.Reader, tablePrefix, key []byte) ([]byte, error) {
+	return db.Get(tableKey(tablePrefix, key))
 }
 
 // UpdateInDB updates a value in the database using the specified table prefix and key
-func UpdateInDB(db *badger.DB, tablePrefix, key, updatedValue []byte) error {
-	return db.Update(func(txn *badger.Txn) error {
-		err := txn.Set(append(tablePrefix, key...), updatedValue)
-		return err
-	})
+func UpdateInDB(db storage.KV, tablePrefix, key, updatedValue []byte) error {
+	return db.Set(tableKey(tablePrefix, key), updatedValue)
 }
 
 // DeleteFromDB deletes a value from the database using the specified table prefix and key
-func DeleteFromDB(db *badger.DB, tablePrefix, key []byte) error {
-	return db.Update(func(txn *badger.Txn) error {
-		err := txn.Delete(append(tablePrefix, key...))
+func DeleteFromDB(db storage.KV, tablePrefix, key []byte) error {
+	return db.Delete(tableKey(tablePrefix, key))
+}
+
+// ClearTable deletes all keys of a table in one batch
+func ClearTable(db storage.KV, tablePrefix []byte) error {
+	batch := db.NewBatch()
+	iter := db.NewIterator(tablePrefix)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	err := iter.Error()
+	iter.Release()
+	if err != nil {
 		return err
-	})
+	}
+	return batch.Write()
+}
+
+func tableKey(tablePrefix, key []byte) []byte {
+	return append(append([]byte{}, tablePrefix...), key...)
 }
 
 //// 导入包
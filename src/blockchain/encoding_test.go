@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"BlockChain/src/utils"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// golden vectors of the canonical encoding, a change here breaks hashes of existing chains
+const (
+	goldenTx        = "0120dd990ce8a9bce0c3ce314c9f7d01ed84d0552597c6de17544d833ba2b1d865f901010100000000000000010466726f6d0102010301000000000000000a02746f010400"
+	goldenTxID      = "dd990ce8a9bce0c3ce314c9f7d01ed84d0552597c6de17544d833ba2b1d865f9"
+	goldenHeader    = "01000000006553f10020bc297d857d14c68c73eafeb04464dd87f33484b089c5ed88b895561ce42a142101aa00000000000000022076bd0c3df0e19a4b2bae2f96fce484d5c775584c5d5da1e9d251f99e7258f5f5000000030000000000000004000000"
+	goldenBlockHash = "bc297d857d14c68c73eafeb04464dd87f33484b089c5ed88b895561ce42a1421"
+)
+
+func TestEncoding_Golden(t *testing.T) {
+	tx := &Transaction{
+		Inputs:  []TXinput{{TxID: []byte{0x01}, Index: 1, FromAddress: []byte("from"), Signature: []byte{0x02}, PublicKeyBytes: []byte{0x03}}},
+		Outputs: []TXoutput{{Value: 10, ToAddress: []byte("to"), PublicKeyHash: []byte{0x04}}},
+	}
+	tx.ID = HashTransaction(tx)
+	data, err := utils.Serialize(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Transaction: ", hex.EncodeToString(data))
+	fmt.Println("Transaction ID: ", hex.EncodeToString(tx.ID))
+	if hex.EncodeToString(data) != goldenTx || hex.EncodeToString(tx.ID) != goldenTxID {
+		t.Fatal("transaction encoding not match golden vector")
+	}
+
+	block := &Block{
+		Header: &BlockHeader{
+			Timestamp: 1700000000,
+			PrevHash:  []byte{0xaa},
+			Height:    2,
+			Bits:      3,
+			Nonce:     4,
+		},
+		TransactionCounter: 1,
+		Transactions:       []*Transaction{tx},
+	}
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	block.Header.Hash = block.CalculateHash()
+	data, err = utils.Serialize(block.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Header: ", hex.EncodeToString(data))
+	fmt.Println("Block hash: ", hex.EncodeToString(block.Header.Hash))
+	if hex.EncodeToString(data) != goldenHeader || hex.EncodeToString(block.Header.Hash) != goldenBlockHash {
+		t.Fatal("block encoding not match golden vector")
+	}
+
+	// decoded blocks hash the same, empty and nil byte slices encode the same
+	var decoded Block
+	data, _ = utils.Serialize(block)
+	if err := utils.Deserialize(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	coinbase := NewCoinbaseTx([]byte("to"), 1)
+	var decodedTx Transaction
+	txData, _ := utils.Serialize(coinbase)
+	if err := utils.Deserialize(txData, &decodedTx); err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(decoded.CalculateHash()) != hex.EncodeToString(block.Header.Hash) ||
+		hex.EncodeToString(HashTransaction(&decodedTx)) != hex.EncodeToString(coinbase.ID) {
+		t.Fatal("hash changed after decoding")
+	}
+}
@@ -0,0 +1,159 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Export file: ExportMagic and ExportVersion, then one record per block in height order,
+// each record is a 4 byte big endian length followed by the JSON encoded ExportRecord
+const (
+	ExportMagic         = "BCEX"   // first bytes of an export file
+	ExportVersion       = 1        // version of the export file format
+	MaxExportRecordSize = 64 << 20 // largest record accepted by import
+)
+
+// ExportRecord is a block with its commit certificate if stored
+type ExportRecord struct {
+	Block *Block      `json:"block"`
+	Cert  *QuorumCert `json:"cert,omitempty"`
+}
+
+// ImportVerifier checks an imported block and its commit certificate with consensus rules
+type ImportVerifier func(block *Block, cert *QuorumCert) error
+
+// Export writes all blocks from genesis to tip with their commit certificates, returns the number of blocks
+func (chain *Chain) Export(w io.Writer) (uint64, error) {
+	if chain.GetHeight() == 0 {
+		return 0, errors.New("chain is empty")
+	}
+	if !chain.complete() {
+		return 0, errors.New("chain without all block bodies can not be exported")
+	}
+	hashes := chain.mainHashes()
+
+	header := append([]byte(ExportMagic), binary.BigEndian.AppendUint32(nil, ExportVersion)...)
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	var count uint64
+	for _, hash := range hashes {
+		block := chain.GetBlock(hash)
+		if block == nil {
+			return count, fmt.Errorf("block %x not found", hash)
+		}
+		data, err := json.Marshal(&ExportRecord{Block: block, Cert: chain.GetCert(block.Header.Hash)})
+		if err != nil {
+			return count, err
+		}
+		if _, err := w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+			return count, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// mainHashes returns hashes of the stored main chain blocks from the oldest to the tip,
+// blocks are read one by one by callers to keep memory low
+func (chain *Chain) mainHashes() [][]byte {
+	var hashes [][]byte
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		hashes = append(hashes, block.Header.Hash)
+		if chain.isBase(block) {
+			break
+		}
+	}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes
+}
+
+// Import reads an export file and adds its blocks above the tip through AddBlock after verifying
+// transactions and consensus rules, blocks already in chain are skipped, returns the number of added blocks
+func (chain *Chain) Import(r io.Reader, verifier ImportVerifier) (uint64, error) {
+	header := make([]byte, len(ExportMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if string(header[:len(ExportMagic)]) != ExportMagic {
+		return 0, errors.New("not a chain export file")
+	}
+	if version := binary.BigEndian.Uint32(header[len(ExportMagic):]); version != ExportVersion {
+		return 0, fmt.Errorf("unsupported export version: %d", version)
+	}
+
+	var count uint64
+	size := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, size); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		n := binary.BigEndian.Uint32(size)
+		if n > MaxExportRecordSize {
+			return count, errors.New("export record too large")
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return count, err
+		}
+		var record ExportRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return count, err
+		}
+		block := record.Block
+		if block == nil || block.Header == nil {
+			return count, errors.New("empty block record")
+		}
+		if chain.HaveBlock(block.Header.Hash) {
+			continue
+		}
+		if err := chain.importBlock(block, record.Cert, verifier); err != nil {
+			return count, fmt.Errorf("import block %d: %v", block.Header.Height, err)
+		}
+		count++
+	}
+}
+
+// importBlock verifies a block extends the tip and adds it with its certificate
+func (chain *Chain) importBlock(block *Block, cert *QuorumCert, verifier ImportVerifier) error {
+	chain.Lock.Lock()
+	tip, height := chain.Tip, chain.BestHeight
+	chain.Lock.Unlock()
+	if block.Header.Height != height+1 || (height != 0 && !bytes.Equal(block.Header.PrevHash, tip)) {
+		return errors.New("block not linked to tip")
+	}
+	if !bytes.Equal(block.CalculateMerkleRoot(), block.Header.MerkleRoot) {
+		return errors.New("merkle root not match")
+	}
+	if cert != nil && (!bytes.Equal(cert.BlockHash, block.Header.Hash) || cert.Height != block.Header.Height) {
+		return errors.New("commit certificate not match block")
+	}
+	if !block.IsGenesisBlock() && !VerifyTransactions(chain, block.Transactions) {
+		return errors.New("verify transactions fail")
+	}
+	if verifier != nil {
+		if err := verifier(block, cert); err != nil {
+			return err
+		}
+	}
+	if !chain.AddBlock(block) {
+		return errors.New("add block fail")
+	}
+	if cert != nil {
+		return chain.AddCert(cert)
+	}
+	return nil
+}
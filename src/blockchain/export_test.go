@@ -0,0 +1,54 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestChain_ExportImport(t *testing.T) {
+	dir := t.TempDir()
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), filepath.Join(dir, "from"), filepath.Join(dir, "from.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	for height := uint64(2); height <= 5; height++ {
+		block := NewBlock(chain.Tip, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, height)}, height)
+		if !chain.AddBlock(block) {
+			t.Fatal("add block fail, height: ", height)
+		}
+	}
+	cert := &QuorumCert{Height: 5, BlockHash: chain.Tip}
+	if err := chain.AddCert(cert); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	count, err := chain.Export(&buf)
+	if err != nil || count != 5 {
+		t.Fatal("export fail: ", count, err)
+	}
+	data := buf.Bytes()
+
+	to, err := LoadChain(filepath.Join(dir, "to"), filepath.Join(dir, "to.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer to.DataBase.Close()
+	count, err = to.Import(bytes.NewReader(data), nil)
+	fmt.Println("Import: ", count, err)
+	if err != nil || count != 5 || !bytes.Equal(to.Tip, chain.Tip) || to.GetCert(to.Tip) == nil {
+		t.Fatal("import fail")
+	}
+
+	// imported blocks are skipped, other file versions are rejected
+	if count, err = to.Import(bytes.NewReader(data), nil); err != nil || count != 0 {
+		t.Fatal("import existing blocks fail")
+	}
+	if _, err := to.Import(bytes.NewReader([]byte("BCEX\x00\x00\x00\x02")), nil); err == nil {
+		t.Fatal("import unsupported version")
+	}
+}
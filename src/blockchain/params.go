@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"BlockChain/src/storage"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ChainParams defines the network a node belongs to, nodes of different networks
+// use different p2p protocols and refuse addresses and databases of each other
+type ChainParams struct {
+	Name            string // network name selected in config
+	ChainID         uint32 // chain ID recorded in the database
+	AddressVersion  byte   // version prefix of wallet addresses
+	GenesisValue    int    // value of the genesis coinbase
+	MinerReward     int    // max reward of a mined block
+	MaxBlockTxs     int    // max transactions of a block, the genesis block excluded
+	ViewTimeout     uint64 // default pbft and hotstuff view change timeout in seconds
+	SyncPollingTime uint64 // block synchronization polling interval in seconds
+}
+
+var (
+	MainNetParams = ChainParams{
+		Name:            "mainnet",
+		ChainID:         1,
+		AddressVersion:  0x00,
+		GenesisValue:    114514,
+		MinerReward:     10,
+		MaxBlockTxs:     9,
+		ViewTimeout:     20,
+		SyncPollingTime: 5,
+	}
+	TestNetParams = ChainParams{
+		Name:            "testnet",
+		ChainID:         2,
+		AddressVersion:  0x6f,
+		GenesisValue:    114514,
+		MinerReward:     10,
+		MaxBlockTxs:     9,
+		ViewTimeout:     20,
+		SyncPollingTime: 5,
+	}
+	// RegTestParams is for local development, blocks are larger and timeouts shorter
+	RegTestParams = ChainParams{
+		Name:            "regtest",
+		ChainID:         3,
+		AddressVersion:  0x3c,
+		GenesisValue:    114514,
+		MinerReward:     10,
+		MaxBlockTxs:     64,
+		ViewTimeout:     5,
+		SyncPollingTime: 1,
+	}
+)
+
+var networks = map[string]*ChainParams{
+	MainNetParams.Name: &MainNetParams,
+	TestNetParams.Name: &TestNetParams,
+	RegTestParams.Name: &RegTestParams,
+}
+
+// activeParams is selected once on startup before the chain is loaded
+var activeParams = &MainNetParams
+
+// SelectParams selects the parameters of a network by name, empty name selects mainnet
+func SelectParams(name string) error {
+	if name == "" {
+		name = MainNetParams.Name
+	}
+	params, ok := networks[name]
+	if !ok {
+		return fmt.Errorf("unknown network %q", name)
+	}
+	activeParams = params
+	return nil
+}
+
+// ActiveParams returns the parameters of the selected network
+func ActiveParams() *ChainParams {
+	return activeParams
+}
+
+// checkChainID refuses databases of other networks, databases without a chain ID are adopted
+func checkChainID(db storage.KV) error {
+	data, err := ReadFromDB(db, []byte(BlockTable), []byte(ChainIDKey))
+	if err == storage.ErrNotFound {
+		return writeChainID(db)
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) != 4 {
+		return errors.New("invalid chain ID record")
+	}
+	if id := binary.BigEndian.Uint32(data); id != activeParams.ChainID {
+		return fmt.Errorf("database belongs to chain ID %d, node is on %s with chain ID %d", id, activeParams.Name, activeParams.ChainID)
+	}
+	return nil
+}
+
+func writeChainID(db storage.KV) error {
+	return WriteToDB(db, []byte(BlockTable), []byte(ChainIDKey), binary.BigEndian.AppendUint32(nil, activeParams.ChainID))
+}
@@ -0,0 +1,56 @@
+package blockchain
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectParams(t *testing.T) {
+	defer SelectParams(MainNetParams.Name)
+	if SelectParams("devnet") == nil {
+		t.Fatal("select unknown network")
+	}
+
+	dir := t.TempDir()
+	path, logPath := filepath.Join(dir, "db"), filepath.Join(dir, "chain.log")
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), path, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.DataBase.Close()
+
+	// addresses and databases of mainnet are refused on testnet
+	if err := SelectParams(TestNetParams.Name); err != nil {
+		t.Fatal(err)
+	}
+	if CheckAddress(wallet.GetAddress()) {
+		t.Fatal("accept address of other network")
+	}
+	if _, err := LoadChain(path, logPath); err == nil {
+		t.Fatal("load database of other network")
+	} else {
+		fmt.Println(err)
+	}
+	testWallet := CreateWallet()
+	fmt.Println("Testnet address: ", string(testWallet.GetAddress()))
+	if !CheckAddress(testWallet.GetAddress()) {
+		t.Fatal("check testnet address fail")
+	}
+
+	// blocks above the limit are not added
+	SelectParams(MainNetParams.Name)
+	chain, err = LoadChain(path, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	var txs []*Transaction
+	for i := 0; i <= ActiveParams().MaxBlockTxs; i++ {
+		txs = append(txs, NewRewardTx(wallet.GetAddress(), 1, uint64(i)))
+	}
+	if chain.AddBlock(NewBlock(chain.Tip, txs, 2)) {
+		t.Fatal("add block above transaction limit")
+	}
+}
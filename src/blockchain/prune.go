@@ -0,0 +1,171 @@
+package blockchain
+
+import (
+	"BlockChain/src/utils"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// Pruning keeps headers of all blocks and bodies of the latest blocks,
+// transactions with unspent outputs are kept in TxIndexTable to verify inputs spending them
+const (
+	MinPruneDepth = SnapshotInterval + SnapshotDelay // blocks with bodies kept, enough to serve the latest snapshot
+	PruneBatch    = 16                               // blocks pruned at once
+)
+
+// updateTxIndex indexes transactions of a block with unspent outputs and drops spent ones,
+// called after the UTXO set is updated
+func (chain *Chain) updateTxIndex(block *Block) {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	for _, tx := range block.Transactions {
+		if _, err := ReadFromDB(chain.DataBase, []byte(ChainStateTable), tx.ID); err == nil {
+			data, err := utils.Serialize(tx)
+			if err == nil {
+				err = WriteToDB(chain.DataBase, []byte(TxIndexTable), tx.ID, data)
+			}
+			if err != nil {
+				chain.log.Println("Index transaction fail: ", err)
+			}
+		}
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if _, err := ReadFromDB(chain.DataBase, []byte(ChainStateTable), in.TxID); err != nil {
+				DeleteFromDB(chain.DataBase, []byte(TxIndexTable), in.TxID)
+			}
+		}
+	}
+}
+
+// getIndexedTx returns an indexed transaction, nil if it is not indexed
+func (chain *Chain) getIndexedTx(id []byte) *Transaction {
+	chain.Lock.Lock()
+	data, err := ReadFromDB(chain.DataBase, []byte(TxIndexTable), id)
+	chain.Lock.Unlock()
+	if err != nil {
+		return nil
+	}
+	var tx Transaction
+	if utils.Deserialize(data, &tx) != nil {
+		return nil
+	}
+	return &tx
+}
+
+// ReindexTxs rebuilds the transaction index from the UTXO set and the stored blocks,
+// the chain must keep all block bodies
+func (chain *Chain) ReindexTxs() {
+	chain.Lock.Lock()
+	err := ClearTable(chain.DataBase, []byte(TxIndexTable))
+	chain.Lock.Unlock()
+	if err != nil || chain.GetHeight() == 0 {
+		return
+	}
+	utxos := chain.FindUTXO()
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		for _, tx := range block.Transactions {
+			if len(utxos[hex.EncodeToString(tx.ID)]) == 0 {
+				continue
+			}
+			data, err := utils.Serialize(tx)
+			if err != nil {
+				continue
+			}
+			chain.Lock.Lock()
+			err = WriteToDB(chain.DataBase, []byte(TxIndexTable), tx.ID, data)
+			chain.Lock.Unlock()
+			if err != nil {
+				chain.log.Println("Index transaction fail: ", err)
+			}
+		}
+		if chain.isBase(block) {
+			break
+		}
+	}
+	chain.markTxIndex()
+}
+
+// markTxIndex records the transaction index covers the whole UTXO set
+func (chain *Chain) markTxIndex() {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), []byte(TxIndexKey), []byte{1}); err != nil {
+		chain.log.Println("Mark transaction index fail")
+	}
+}
+
+// SetPruneDepth enables pruning bodies of blocks more than depth blocks below the tip, 0 keeps all blocks
+func (chain *Chain) SetPruneDepth(depth uint64) error {
+	if depth == 0 {
+		return nil
+	}
+	if depth < MinPruneDepth {
+		return errors.New("prune depth below MinPruneDepth")
+	}
+	chain.Lock.Lock()
+	chain.pruneDepth = depth
+	chain.Lock.Unlock()
+	chain.prune()
+	return nil
+}
+
+// PrunedHeight returns the height of the latest block whose body is pruned, 0 if no block is pruned
+func (chain *Chain) PrunedHeight() uint64 {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return chain.Pruned
+}
+
+// complete checks all blocks since genesis are stored with bodies
+func (chain *Chain) complete() bool {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return chain.Base <= 1 && chain.Pruned == 0
+}
+
+// prune drops bodies of blocks deeper than the prune depth in batches of PruneBatch,
+// the genesis block and all headers are kept
+func (chain *Chain) prune() {
+	chain.Lock.Lock()
+	depth, pruned, height := chain.pruneDepth, chain.Pruned, chain.BestHeight
+	chain.Lock.Unlock()
+	if depth == 0 || height < depth+pruned+PruneBatch {
+		return
+	}
+	to := height - depth
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		if block.Header.Height <= to && !block.IsGenesisBlock() && len(block.Transactions) != 0 {
+			block.Transactions = nil
+			data, err := utils.Serialize(block)
+			if err != nil {
+				chain.log.Println("Serialize pruned block fail")
+				return
+			}
+			chain.Lock.Lock()
+			err = WriteToDB(chain.DataBase, []byte(BlockTable), block.Header.Hash, data)
+			chain.Lock.Unlock()
+			if err != nil {
+				chain.log.Println("Prune block fail: ", err)
+				return
+			}
+		}
+		if block.Header.Height <= pruned+1 || chain.isBase(block) {
+			break
+		}
+	}
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), []byte(PrunedHeightKey), binary.BigEndian.AppendUint64(nil, to)); err != nil {
+		chain.log.Println("Update pruned height fail")
+		return
+	}
+	chain.Pruned = to
+	chain.log.Println("Prune block bodies up to height: ", to)
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import (
+	"BlockChain/src/storage"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestChain_Prune(t *testing.T) {
+	dir := t.TempDir()
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.SetPruneDepth(MinPruneDepth); err != nil {
+		t.Fatal(err)
+	}
+	genesis := chain.Tip
+	for height := uint64(2); height <= MinPruneDepth+PruneBatch+1; height++ {
+		block := NewBlock(chain.Tip, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, height)}, height)
+		if !chain.AddBlock(block) {
+			t.Fatal("add block fail, height: ", height)
+		}
+	}
+	fmt.Println("Height: ", chain.GetHeight(), "pruned: ", chain.PrunedHeight())
+	if chain.PrunedHeight() != PruneBatch {
+		t.Fatal("wrong pruned height")
+	}
+
+	// headers of pruned blocks are kept, bodies are not served
+	if len(chain.FindHeadersInRange(2, 2)) != 1 || len(chain.FindBlocksInRange(2, 2)) != 0 {
+		t.Fatal("pruned block not kept as header")
+	}
+	if block := chain.FindBlock("2"); block == nil || len(block.Transactions) != 0 {
+		t.Fatal("pruned block keeps its body")
+	}
+
+	// transactions with unspent outputs are still found
+	tx := chain.GetBlock(genesis).Transactions[0]
+	if _, err := chain.FindTransaction(tx.ID); err != nil {
+		t.Fatal(err)
+	}
+	reward := NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, 2)
+	if _, err := chain.FindTransaction(reward.ID); err != nil {
+		t.Fatal(err)
+	}
+}
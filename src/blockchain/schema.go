@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"BlockChain/src/storage"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the database layout written by this node,
+// databases without SchemaKey are version 0
+const SchemaVersion = 4
+
+// MinSchemaVersion is the oldest version which can be upgraded. Blocks and transactions of versions 0 and 1
+// are stored and hashed over gob and json encodings, re-encoding them would break every hash, and
+// transactions of version 2 are signed without the chain ID, only their owners could sign them again
+const MinSchemaVersion = 3
+
+// Migration upgrades a database from Version-1 to Version in place,
+// migrations changing the encoding of stored values must read raw values from the database
+type Migration struct {
+	Version     uint64
+	Description string
+	Migrate     func(chain *Chain) error
+}
+
+// migrations are run in order for versions above the stored one, from MinSchemaVersion
+var migrations = []Migration{
+	{Version: 4, Description: "index blocks by height", Migrate: migrateHeightIndex},
+}
+
+// migrateHeightIndex indexes the stored main chain blocks by height
+func migrateHeightIndex(chain *Chain) error {
+	tip := chain.findBlockByHash(chain.Tip)
+	if tip == nil {
+		return errors.New("tip block not found")
+	}
+	return chain.reindexHeights(tip, tip.Header.Height)
+}
+
+// readSchemaVersion reads the schema version of a database, 0 if it is not recorded
+func readSchemaVersion(db storage.Reader) (uint64, error) {
+	data, err := ReadFromDB(db, []byte(BlockTable), []byte(SchemaKey))
+	if err == storage.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, errors.New("invalid schema version record")
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+func writeSchemaVersion(db storage.KV, version uint64) error {
+	return WriteToDB(db, []byte(BlockTable), []byte(SchemaKey), binary.BigEndian.AppendUint64(nil, version))
+}
+
+// checkSchemaVersion refuses databases written by newer nodes, and stored chains
+// older than MinSchemaVersion before any of their blocks is decoded
+func checkSchemaVersion(db storage.Reader) (uint64, error) {
+	version, err := readSchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := ReadFromDB(db, []byte(BlockTable), []byte(TipHashKey)); err == nil && version < MinSchemaVersion {
+		return version, fmt.Errorf("database schema version %d is older than the oldest upgradable version %d, remove the database and synchronize the chain again", version, MinSchemaVersion)
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("database schema version %d is newer than supported version %d, upgrade the node", version, SchemaVersion)
+	}
+	return version, nil
+}
+
+// GetSchemaVersion returns the schema version of the chain database
+func (chain *Chain) GetSchemaVersion() uint64 {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	version, err := readSchemaVersion(chain.DataBase)
+	if err != nil {
+		chain.log.Println("Read schema version fail: ", err)
+	}
+	return version
+}
+
+// migrate runs the migrations above version, the version is recorded after each one
+// so an interrupted upgrade continues from the last finished migration
+func (chain *Chain) migrate(version uint64) error {
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		chain.log.Printf("Migrate database to schema version %d: %s\n", m.Version, m.Description)
+		if err := m.Migrate(chain); err != nil {
+			return fmt.Errorf("migrate to schema version %d: %v", m.Version, err)
+		}
+		chain.Lock.Lock()
+		err := writeSchemaVersion(chain.DataBase, m.Version)
+		chain.Lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
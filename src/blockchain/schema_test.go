@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadChain_Migrate(t *testing.T) {
+	dir := t.TempDir()
+	path, logPath := filepath.Join(dir, "db"), filepath.Join(dir, "chain.log")
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), path, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chain.GetSchemaVersion() != SchemaVersion {
+		t.Fatal("new database without schema version")
+	}
+
+	// migrations above the stored version run in order and record their version
+	saved := migrations
+	var ran []uint64
+	migrations = nil
+	for v := uint64(1); v <= SchemaVersion+1; v++ {
+		v := v
+		migrations = append(migrations, Migration{Version: v, Migrate: func(chain *Chain) error { ran = append(ran, v); return nil }})
+	}
+	err = chain.migrate(SchemaVersion - 1)
+	migrations = saved
+	fmt.Println("Migrations: ", ran, "version: ", chain.GetSchemaVersion())
+	if err != nil || fmt.Sprint(ran) != fmt.Sprint([]uint64{SchemaVersion, SchemaVersion + 1}) || chain.GetSchemaVersion() != SchemaVersion+1 {
+		t.Fatal("migrate fail")
+	}
+
+	// databases of newer nodes are refused
+	chain.DataBase.Close()
+	if _, err := LoadChain(path, logPath); err == nil {
+		t.Fatal("load newer database")
+	} else {
+		fmt.Println(err)
+	}
+
+	// chains stored before the canonical encoding or signed without chain ID can not be upgraded
+	for _, version := range []uint64{1, 2} {
+		old := filepath.Join(dir, fmt.Sprint("old", version))
+		chain, err = CreateChain(wallet.GetAddress(), old, logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeSchemaVersion(chain.DataBase, version)
+		chain.DataBase.Close()
+		if _, err := LoadChain(old, logPath); err == nil {
+			t.Fatal("load database older than MinSchemaVersion")
+		} else {
+			fmt.Println(err)
+		}
+	}
+}
+
+func TestLoadChain_MigrateHeightIndex(t *testing.T) {
+	dir := t.TempDir()
+	path, logPath := filepath.Join(dir, "db"), filepath.Join(dir, "chain.log")
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), path, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		chain.AddBlock(NewBlock(chain.Tip, []*Transaction{}, chain.GetHeight()+1))
+	}
+	tip := chain.Tip
+
+	// a version 3 database has no height index
+	for height := uint64(1); height <= chain.GetHeight(); height++ {
+		if err := DeleteFromDB(chain.DataBase, []byte(HeightTable), binary.BigEndian.AppendUint64(nil, height)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSchemaVersion(chain.DataBase, 3)
+	chain.DataBase.Close()
+
+	chain, err = LoadChain(path, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	blocks := chain.FindBlocksInRange(1, chain.GetHeight())
+	fmt.Println("version: ", chain.GetSchemaVersion(), "indexed blocks: ", len(blocks))
+	if chain.GetSchemaVersion() != SchemaVersion || len(blocks) != 4 || !bytes.Equal(blocks[3].Header.Hash, tip) {
+		t.Fatal("height index not rebuilt")
+	}
+}
+
+func TestLoadChain_GobFixture(t *testing.T) {
+	dir := t.TempDir()
+	path, logPath := filepath.Join(dir, "gob"), filepath.Join(dir, "chain.log")
+
+	// a chain of a node before the schema version, its tip block is gob encoded
+	wallet := CreateWallet()
+	genesis, err := NewGenesisBlock(wallet.GetAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(genesis); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteToDB(db, []byte(BlockTable), genesis.Header.Hash, buf.Bytes())
+	WriteToDB(db, []byte(BlockTable), []byte(TipHashKey), genesis.Header.Hash)
+	db.Close()
+
+	for _, version := range []uint64{0, 1} {
+		if version != 0 {
+			db, err := OpenDatabase(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeSchemaVersion(db, version)
+			db.Close()
+		}
+		chain, err := LoadChain(path, logPath)
+		fmt.Printf("load version %d: %v\n", version, err)
+		if err == nil {
+			chain.DataBase.Close()
+			t.Fatal("load gob encoded database")
+		}
+	}
+
+	// the database is refused before it is written
+	db, err = OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := ReadFromDB(db, []byte(BlockTable), []byte(ChainIDKey)); err == nil {
+		t.Fatal("chain ID written into refused database")
+	}
+}
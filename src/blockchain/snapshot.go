@@ -0,0 +1,327 @@
+package blockchain
+
+import (
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// State snapshots: the UTXO set with its indexed transactions and the validator set after every SnapshotInterval blocks are stored in chunks,
+// the root of a snapshot is committed in the header of the block SnapshotDelay blocks later
+const (
+	SnapshotInterval  = 128 // blocks between state snapshots
+	SnapshotDelay     = 16  // blocks after a snapshot its root is committed, above the pBFT sequence window
+	SnapshotChunkSize = 256 // UTXO set entries per chunk
+	SnapshotKeep      = 2   // latest snapshots kept in SnapshotTable
+	snapshotManifest  = "m" // key prefix of snapshot manifests in SnapshotTable
+	snapshotChunk     = "k" // key prefix of snapshot chunks in SnapshotTable
+)
+
+// SnapshotEntry is a UTXO set item, key is the transaction ID and value the serialized UTXOs
+type SnapshotEntry struct {
+	Key   []byte
+	Value []byte
+	Tx    []byte // indexed transaction, empty if not indexed
+}
+
+// SnapshotManifest describes the state snapshot taken after a block
+type SnapshotManifest struct {
+	Height      uint64   `json:"height"`      // height of the snapshot block
+	BlockHash   []byte   `json:"blockHash"`   // hash of the snapshot block
+	Validators  []byte   `json:"validators"`  // validator set in JSON, null if the chain has none
+	ChunkHashes [][]byte `json:"chunkHashes"` // sha256 hash of each chunk
+	Root        []byte   `json:"root"`        // merkle root of the validator set and chunk hashes
+}
+
+// CalculateRoot computes the merkle root of the validator set hash and the chunk hashes
+func (m *SnapshotManifest) CalculateRoot() []byte {
+	leaves := [][]byte{utils.Sha256Hash(m.Validators)}
+	leaves = append(leaves, m.ChunkHashes...)
+	return utils.NewMerkleTree(leaves).Root.Hash
+}
+
+// CommitHeight returns the height of the block committing the root of a snapshot
+func CommitHeight(snapshotHeight uint64) uint64 {
+	return snapshotHeight + SnapshotDelay
+}
+
+// isCommitHeight checks the block of height carries a snapshot root
+func isCommitHeight(height uint64) bool {
+	return height > SnapshotDelay && (height-SnapshotDelay)%SnapshotInterval == 0
+}
+
+func snapshotKey(prefix string, height uint64, index int) []byte {
+	key := append([]byte(prefix), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[1:], height)
+	if index >= 0 {
+		key = binary.BigEndian.AppendUint32(key, uint32(index))
+	}
+	return key
+}
+
+// writeSnapshot stores the state after block in chunks and drops snapshots older than SnapshotKeep
+func (chain *Chain) writeSnapshot(block *Block) error {
+	var entries []SnapshotEntry
+	chain.Lock.Lock()
+	snapshot := chain.DataBase.Snapshot()
+	chain.Lock.Unlock()
+	prefix := []byte(ChainStateTable)
+	iter := snapshot.NewIterator(prefix)
+	// keys are iterated in order, every node builds the same chunks
+	for iter.Next() {
+		entry := SnapshotEntry{Key: iter.Key()[len(prefix):], Value: iter.Value()}
+		// restored chains verify inputs with indexed transactions
+		if tx, err := ReadFromDB(snapshot, []byte(TxIndexTable), entry.Key); err == nil {
+			entry.Tx = tx
+		}
+		entries = append(entries, entry)
+	}
+	err := iter.Error()
+	iter.Release()
+	snapshot.Release()
+	if err != nil {
+		return err
+	}
+
+	// json keeps map keys sorted, unlike gob
+	validators, err := json.Marshal(chain.GetValidators())
+	if err != nil {
+		return err
+	}
+	manifest := &SnapshotManifest{
+		Height:     block.Header.Height,
+		BlockHash:  block.Header.Hash,
+		Validators: validators,
+	}
+	var chunks [][]byte
+	for start := 0; start < len(entries); start += SnapshotChunkSize {
+		end := start + SnapshotChunkSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		chunk, err := utils.Serialize(entries[start:end])
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, chunk)
+		manifest.ChunkHashes = append(manifest.ChunkHashes, utils.Sha256Hash(chunk))
+	}
+	manifest.Root = manifest.CalculateRoot()
+	if err := chain.storeSnapshot(manifest, chunks); err != nil {
+		return err
+	}
+	if block.Header.Height > SnapshotKeep*SnapshotInterval {
+		chain.deleteSnapshot(block.Header.Height - SnapshotKeep*SnapshotInterval)
+	}
+	return nil
+}
+
+// storeSnapshot writes the manifest and chunks of a snapshot
+func (chain *Chain) storeSnapshot(manifest *SnapshotManifest, chunks [][]byte) error {
+	data, err := utils.Serialize(manifest)
+	if err != nil {
+		return err
+	}
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	for i, chunk := range chunks {
+		if err := WriteToDB(chain.DataBase, []byte(SnapshotTable), snapshotKey(snapshotChunk, manifest.Height, i), chunk); err != nil {
+			return err
+		}
+	}
+	return WriteToDB(chain.DataBase, []byte(SnapshotTable), snapshotKey(snapshotManifest, manifest.Height, -1), data)
+}
+
+// deleteSnapshot removes the manifest and chunks of the snapshot at height
+func (chain *Chain) deleteSnapshot(height uint64) {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	batch := chain.DataBase.NewBatch()
+	iter := chain.DataBase.NewIterator(append([]byte(SnapshotTable), snapshotKey(snapshotChunk, height, -1)...))
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	iter.Release()
+	batch.Delete(append([]byte(SnapshotTable), snapshotKey(snapshotManifest, height, -1)...))
+	if err := batch.Write(); err != nil {
+		chain.log.Println("Delete snapshot fail: ", err)
+	}
+}
+
+// GetSnapshot returns the manifest of the snapshot at height, nil if it is not stored
+func (chain *Chain) GetSnapshot(height uint64) *SnapshotManifest {
+	chain.Lock.Lock()
+	data, err := ReadFromDB(chain.DataBase, []byte(SnapshotTable), snapshotKey(snapshotManifest, height, -1))
+	chain.Lock.Unlock()
+	if err != nil {
+		return nil
+	}
+	var manifest SnapshotManifest
+	if utils.Deserialize(data, &manifest) != nil {
+		return nil
+	}
+	return &manifest
+}
+
+// GetSnapshotChunk returns a chunk of the snapshot at height, nil if it is not stored
+func (chain *Chain) GetSnapshotChunk(height uint64, index int) []byte {
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	data, err := ReadFromDB(chain.DataBase, []byte(SnapshotTable), snapshotKey(snapshotChunk, height, index))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// LatestSnapshot returns the manifest of the latest snapshot whose root is committed in chain, nil if none
+func (chain *Chain) LatestSnapshot() *SnapshotManifest {
+	height := chain.GetHeight()
+	if height < CommitHeight(SnapshotInterval) {
+		return nil
+	}
+	return chain.GetSnapshot((height - SnapshotDelay) / SnapshotInterval * SnapshotInterval)
+}
+
+// StateCommitment returns the snapshot root a block of height commits, nil if the block commits none
+// or the snapshot is not stored
+func (chain *Chain) StateCommitment(height uint64) []byte {
+	if !isCommitHeight(height) {
+		return nil
+	}
+	manifest := chain.GetSnapshot(height - SnapshotDelay)
+	if manifest == nil {
+		return nil
+	}
+	return manifest.Root
+}
+
+// CheckStateRoot checks the state root of a block matches the local snapshot, the root is optional
+// and only set at commit heights
+func (chain *Chain) CheckStateRoot(block *Block) bool {
+	if len(block.Header.StateRoot) == 0 {
+		return true
+	}
+	if !isCommitHeight(block.Header.Height) {
+		return false
+	}
+	root := chain.StateCommitment(block.Header.Height)
+	return root == nil || bytes.Equal(root, block.Header.StateRoot)
+}
+
+// VerifySnapshot checks a snapshot manifest against the blocks from the snapshot block to the block
+// committing its root, the last block must be authenticated by the caller
+func VerifySnapshot(manifest *SnapshotManifest, blocks []*Block) error {
+	if manifest == nil || manifest.Height == 0 || manifest.Height%SnapshotInterval != 0 {
+		return errors.New("wrong snapshot height")
+	}
+	if len(blocks) != SnapshotDelay+1 {
+		return errors.New("wrong number of snapshot blocks")
+	}
+	if !bytes.Equal(manifest.CalculateRoot(), manifest.Root) {
+		return errors.New("snapshot root not match chunks")
+	}
+	for i, block := range blocks {
+		if block == nil || block.Header == nil {
+			return errors.New("empty snapshot block")
+		}
+		if !bytes.Equal(block.CalculateHash(), block.Header.Hash) {
+			return errors.New("snapshot block hash not match")
+		}
+		if i == 0 {
+			if block.Header.Height != manifest.Height || !bytes.Equal(block.Header.Hash, manifest.BlockHash) {
+				return errors.New("snapshot block not match manifest")
+			}
+			continue
+		}
+		if block.Header.Height != blocks[i-1].Header.Height+1 || !bytes.Equal(block.Header.PrevHash, blocks[i-1].Header.Hash) {
+			return errors.New("snapshot blocks not linked")
+		}
+	}
+	if !bytes.Equal(blocks[SnapshotDelay].Header.StateRoot, manifest.Root) {
+		return errors.New("snapshot root not committed")
+	}
+	return nil
+}
+
+// RestoreSnapshot writes a verified snapshot into an empty chain and makes its block the tip,
+// blocks below the snapshot are not stored
+func (chain *Chain) RestoreSnapshot(manifest *SnapshotManifest, chunks [][]byte, block *Block) error {
+	if chain.GetHeight() != 0 {
+		return errors.New("chain is not empty")
+	}
+	if len(chunks) != len(manifest.ChunkHashes) || !bytes.Equal(block.Header.Hash, manifest.BlockHash) {
+		return errors.New("snapshot not match manifest")
+	}
+	var entries []SnapshotEntry
+	for i, chunk := range chunks {
+		if !bytes.Equal(utils.Sha256Hash(chunk), manifest.ChunkHashes[i]) {
+			return errors.New("snapshot chunk hash not match")
+		}
+		var chunkEntries []SnapshotEntry
+		if err := utils.Deserialize(chunk, &chunkEntries); err != nil {
+			return err
+		}
+		entries = append(entries, chunkEntries...)
+	}
+	var vs *ValidatorSet
+	if err := json.Unmarshal(manifest.Validators, &vs); err != nil {
+		return err
+	}
+
+	batch := chain.DataBase.NewBatch()
+	for _, entry := range entries {
+		batch.Set(append([]byte(ChainStateTable), entry.Key...), entry.Value)
+		if len(entry.Tx) != 0 {
+			batch.Set(append([]byte(TxIndexTable), entry.Key...), entry.Tx)
+		}
+	}
+	chain.Lock.Lock()
+	err := batch.Write()
+	chain.Lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if vs != nil {
+		if err := chain.writeValidators(vs); err != nil {
+			return err
+		}
+	}
+	// keep the snapshot to check its commitment and serve other nodes
+	if err := chain.storeSnapshot(manifest, chunks); err != nil {
+		return err
+	}
+
+	data, err := utils.Serialize(block)
+	if err != nil {
+		return err
+	}
+	if err := chain.writeWork(block, big.NewInt(0)); err != nil {
+		return err
+	}
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), block.Header.Hash, data); err != nil {
+		return err
+	}
+	if err := chain.writeHeight(block.Header.Height, block.Header.Hash); err != nil {
+		return err
+	}
+	base := binary.BigEndian.AppendUint64(nil, block.Header.Height)
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), []byte(BaseHeightKey), base); err != nil {
+		return err
+	}
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), []byte(TipHashKey), block.Header.Hash); err != nil {
+		return err
+	}
+	if err := WriteToDB(chain.DataBase, []byte(BlockTable), []byte(TxIndexKey), []byte{1}); err != nil {
+		return err
+	}
+	chain.Tip = block.Header.Hash
+	chain.BestHeight = block.Header.Height
+	chain.Base = block.Header.Height
+	return nil
+}
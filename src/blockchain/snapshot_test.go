@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"BlockChain/src/utils"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestVerifySnapshot(t *testing.T) {
+	chunk, err := utils.Serialize([]SnapshotEntry{{Key: []byte("tx"), Value: []byte("utxos")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*Block
+	prev := []byte("parent")
+	for height := uint64(SnapshotInterval); height <= CommitHeight(SnapshotInterval); height++ {
+		block := NewBlock(prev, nil, height)
+		blocks = append(blocks, block)
+		prev = block.Header.Hash
+	}
+	manifest := &SnapshotManifest{
+		Height:      SnapshotInterval,
+		BlockHash:   blocks[0].Header.Hash,
+		Validators:  []byte("null"),
+		ChunkHashes: [][]byte{utils.Sha256Hash(chunk)},
+	}
+	manifest.Root = manifest.CalculateRoot()
+	commit := blocks[SnapshotDelay]
+	commit.Header.StateRoot = manifest.Root
+	commit.Header.Hash = commit.CalculateHash()
+	fmt.Println("Root: ", hex.EncodeToString(manifest.Root))
+	if err := VerifySnapshot(manifest, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	// a root not committed by the last block must not verify
+	commit.Header.StateRoot = []byte("root")
+	commit.Header.Hash = commit.CalculateHash()
+	if VerifySnapshot(manifest, blocks) == nil {
+		t.Fatal("verify uncommitted snapshot")
+	}
+	commit.Header.StateRoot = manifest.Root
+	commit.Header.Hash = commit.CalculateHash()
+
+	// a changed chunk hash must not match the root
+	manifest.ChunkHashes[0] = utils.Sha256Hash([]byte("chunk"))
+	if VerifySnapshot(manifest, blocks) == nil {
+		t.Fatal("verify snapshot with wrong chunk hash")
+	}
+}
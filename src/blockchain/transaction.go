@@ -3,8 +3,8 @@ package blockchain
 import (
 	"BlockChain/src/mycrypto"
 	"BlockChain/src/utils"
+	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 )
@@ -14,6 +14,8 @@ type Transaction struct {
 	ID      []byte     `json:"ID"`      // ID represents the unique identifier for the transaction
 	Inputs  []TXinput  `json:"Inputs"`  // Inputs include the details of the transaction inputs
 	Outputs []TXoutput `json:"Outputs"` // Outputs include the details of the transaction outputs
+
+	Governance *Governance `json:"governance,omitempty"` // validator set change vote, nil for transfers
 }
 
 // IsCoinBase checks if the transaction is a coinbase transaction
@@ -21,6 +23,11 @@ func (Tx *Transaction) IsCoinBase() bool {
 	return len(Tx.Inputs) == 1 && Tx.Inputs[0].Index == -1
 }
 
+// IsGovernance checks if the transaction is a validator set change vote
+func (Tx *Transaction) IsGovernance() bool {
+	return Tx.Governance != nil
+}
+
 // NewCoinbaseTx creates a coinbase transaction, used to reward miners
 func NewCoinbaseTx(to []byte, reward int) *Transaction {
 	input := TXinput{
@@ -40,6 +47,15 @@ func NewCoinbaseTx(to []byte, reward int) *Transaction {
 	return Tx
 }
 
+// NewRewardTx creates a block reward coinbase transaction,
+// the block height is put in the input signature to keep the ID unique
+func NewRewardTx(to []byte, reward int, height uint64) *Transaction {
+	Tx := NewCoinbaseTx(to, reward)
+	Tx.Inputs[0].Signature = utils.Int2Bytes(int64(height))
+	Tx.ID = HashTransaction(Tx)
+	return Tx
+}
+
 // NewTransaction creates a new transaction transferring a specified amount between wallets
 func NewTransaction(wallet *Wallet, chain *Chain, to []byte, amount int) (*Transaction, error) {
 	// Check the validity of the addresses and the amount
@@ -68,7 +84,7 @@ func NewTransaction(wallet *Wallet, chain *Chain, to []byte, amount int) (*Trans
 				return nil, errors.New("fail to find previous Tx")
 			}
 			// Sign the input using the wallet's private key
-			message := HashTransaction(preTx)
+			message := SigningDigest(preTx)
 			sig, err := mycrypto.Sign(wallet.privateKey, message)
 			utils.HandleError(err)
 			input.SetSignature(sig)
@@ -98,10 +114,35 @@ func NewTransaction(wallet *Wallet, chain *Chain, to []byte, amount int) (*Trans
 func VerifyTransactions(chain *Chain, Txs []*Transaction) bool {
 	usedOutputs := make(map[string]struct{})
 	for _, Tx := range Txs {
+		// Governance vote moves no coins
+		if Tx.IsGovernance() && (len(Tx.Inputs) != 0 || len(Tx.Outputs) != 0) {
+			chain.log.Println("Governance with inputs or outputs")
+			return false
+		}
+		// Outputs must pay to addresses of this network
+		for _, output := range Tx.Outputs {
+			if !CheckAddress(output.ToAddress) {
+				chain.log.Println("Output address of other network")
+				return false
+			}
+		}
 		// Check if it's a coinbase transaction
 		if Tx.IsCoinBase() == true {
 			continue
 		}
+		// Governance vote must be signed by a current validator
+		if Tx.IsGovernance() {
+			vs := chain.GetValidators()
+			if vs == nil {
+				chain.log.Println("Governance without validator set")
+				return false
+			}
+			if err := vs.VerifyVote(Tx.Governance); err != nil {
+				chain.log.Println("Governance verify error: ", err)
+				return false
+			}
+			continue
+		}
 
 		for _, input := range Tx.Inputs {
 			// Find the previous transaction for each input
@@ -119,9 +160,7 @@ func VerifyTransactions(chain *Chain, Txs []*Transaction) bool {
 			usedOutputs[outputIdentifier] = struct{}{}
 
 			// Verify the signature for each input
-			signature := input.Signature
-			message := HashTransaction(preTx)
-			if mycrypto.Verify(mycrypto.Bytes2PublicKey(input.PublicKeyBytes), message, signature) == false {
+			if !verifyInputSignature(preTx, input) {
 				chain.log.Println("Signature verify error")
 				return false
 			}
@@ -130,11 +169,46 @@ func VerifyTransactions(chain *Chain, Txs []*Transaction) bool {
 	return true
 }
 
+// CheckCoinbase checks only the first transaction of a block is a coinbase, paying positive outputs of at most reward
+func CheckCoinbase(block *Block, reward int) error {
+	for i, tx := range block.Transactions {
+		if !tx.IsCoinBase() {
+			continue
+		}
+		if i != 0 {
+			return errors.New("coinbase is not the first transaction")
+		}
+		value := 0
+		for _, out := range tx.Outputs {
+			if out.Value <= 0 {
+				return errors.New("coinbase output value not positive")
+			}
+			// the sum stays at most reward, so it can not overflow
+			if out.Value > reward-value {
+				return errors.New("block reward too large")
+			}
+			value += out.Value
+		}
+	}
+	return nil
+}
+
+// SigningDigest computes the message signed by inputs spending outputs of preTx,
+// it commits to the chain ID so signatures of other networks are invalid
+func SigningDigest(preTx *Transaction) []byte {
+	return utils.Sha256Hash(append(binary.BigEndian.AppendUint32(nil, ActiveParams().ChainID), HashTransaction(preTx)...))
+}
+
+// verifyInputSignature checks an input is signed over the signing digest of the transaction it spends
+func verifyInputSignature(preTx *Transaction, input TXinput) bool {
+	return mycrypto.Verify(mycrypto.Bytes2PublicKey(input.PublicKeyBytes), SigningDigest(preTx), input.Signature)
+}
+
 // HashTransaction computes the hash of a transaction using its inputs and outputs
 func HashTransaction(tx *Transaction) []byte {
 	txCopy := tx.TrimmedCopy()
 	txCopy.ID = []byte{}
-	raw, err := json.Marshal(txCopy)
+	raw, err := utils.Serialize(&txCopy)
 	if err != nil {
 		fmt.Println("Error during serialization:", err)
 		return nil
@@ -156,7 +230,7 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 		outputs = append(outputs, TXoutput{vout.Value, vout.ToAddress, vout.PublicKeyHash})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.Governance}
 
 	return txCopy
 }
@@ -1,7 +1,10 @@
 package blockchain
 
 import (
+	"BlockChain/src/storage"
 	"fmt"
+	"math"
+	"path/filepath"
 	"testing"
 )
 
@@ -75,3 +78,95 @@ func TestVerifyTransactions(t *testing.T) {
 	}
 	fmt.Println("success")
 }
+
+func TestVerifyTransactions_ChainID(t *testing.T) {
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(t.TempDir(), "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	tx, err := NewTransaction(wallet, chain, CreateWallet().GetAddress(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyTransactions(chain, []*Transaction{tx}) {
+		t.Fatal("verify transaction fail")
+	}
+
+	// signatures of a chain with the same addresses but another chain ID are not replayable
+	staging := MainNetParams
+	staging.ChainID = 99
+	activeParams = &staging
+	defer SelectParams(MainNetParams.Name)
+	fmt.Println("Signing digest on chain 99: ", fmt.Sprintf("%x", SigningDigest(tx)))
+	if VerifyTransactions(chain, []*Transaction{tx}) {
+		t.Fatal("verify transaction signed on other chain")
+	}
+}
+
+func TestVerifyTransactions_Governance(t *testing.T) {
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(t.TempDir(), "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.InitValidators([][]byte{wallet.GetPublicKeyBytes()}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	newValidator := CreateWallet()
+	vote := func() *Transaction {
+		tx, err := NewGovernanceTx(wallet, AddValidator, newValidator.GetPublicKeyBytes(), 1, nil, chain.GetValidators().Epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	if !VerifyTransactions(chain, []*Transaction{vote()}) {
+		t.Fatal("verify governance transaction fail")
+	}
+
+	// a signed vote can not carry coins, neither minted outputs nor a coinbase input
+	minted := vote()
+	minted.Outputs = NewCoinbaseTx(wallet.GetAddress(), 1000).Outputs
+	coinbase := vote()
+	coinbase.Inputs = NewCoinbaseTx(wallet.GetAddress(), 1000).Inputs
+	for _, tx := range []*Transaction{minted, coinbase} {
+		fmt.Printf("inputs: %d, outputs: %d\n", len(tx.Inputs), len(tx.Outputs))
+		if VerifyTransactions(chain, []*Transaction{tx}) {
+			t.Fatal("accept governance transaction with inputs or outputs")
+		}
+	}
+}
+
+func TestCheckCoinbase(t *testing.T) {
+	wallet := CreateWallet()
+	reward := NewRewardTx(wallet.GetAddress(), 10, 2)
+	if err := CheckCoinbase(NewBlock([]byte("prev"), []*Transaction{reward}, 2), 10); err != nil {
+		t.Fatal(err)
+	}
+	// blocks of chains without mining reward mint nothing
+	err := CheckCoinbase(NewBlock([]byte("prev"), []*Transaction{reward}, 2), 0)
+	fmt.Println("coinbase without reward: ", err)
+	if err == nil {
+		t.Fatal("accept coinbase above block reward")
+	}
+	second := NewRewardTx(wallet.GetAddress(), 1, 2)
+	if CheckCoinbase(NewBlock([]byte("prev"), []*Transaction{reward, second}, 2), 20) == nil {
+		t.Fatal("accept second coinbase")
+	}
+	// a negative output can not be paired with a spendable one
+	negative := NewRewardTx(wallet.GetAddress(), 10, 2)
+	negative.Outputs = append(negative.Outputs, TXoutput{Value: -5, ToAddress: wallet.GetAddress()})
+	err = CheckCoinbase(NewBlock([]byte("prev"), []*Transaction{negative}, 2), 10)
+	fmt.Println("negative output: ", err)
+	if err == nil {
+		t.Fatal("accept coinbase with negative output")
+	}
+	overflow := NewRewardTx(wallet.GetAddress(), math.MaxInt, 2)
+	overflow.Outputs = append(overflow.Outputs, TXoutput{Value: math.MaxInt, ToAddress: wallet.GetAddress()})
+	if CheckCoinbase(NewBlock([]byte("prev"), []*Transaction{overflow}, 2), 10) == nil {
+		t.Fatal("accept overflowing coinbase")
+	}
+}
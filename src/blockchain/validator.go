@@ -0,0 +1,435 @@
+package blockchain
+
+import (
+	"BlockChain/src/mycrypto"
+	"BlockChain/src/utils"
+	"bytes"
+	"crypto/elliptic"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Validator set change operations
+const (
+	AddValidator    = "add"    // add a validator
+	RemoveValidator = "remove" // remove a validator
+	EpochLength     = 16       // blocks per epoch, changes take effect after the last block of an epoch
+	validatorSetKey = "set"    // key of the validator set in ValidatorTable
+)
+
+// Governance is the vote of a current validator to add or remove a validator
+type Governance struct {
+	Op     string `json:"op"`               // AddValidator or RemoveValidator
+	PubKey []byte `json:"pubKey"`           // public key of the changed validator
+	Epoch  uint64 `json:"epoch"`            // epoch of the voting validator set
+	Power  uint64 `json:"power"`            // voting power of an added validator
+	BLSKey []byte `json:"blsKey,omitempty"` // BLS public key with proof of possession of an added validator
+	Voter  []byte `json:"voter"`            // public key of the voting validator
+	Sign   []byte `json:"sign"`             // voter signature of Digest
+}
+
+// Digest returns the digest a validator signs to vote for a change on the chain of the network
+func (g *Governance) Digest() []byte {
+	buf := make([]byte, 20)
+	binary.BigEndian.PutUint64(buf[:8], g.Epoch)
+	binary.BigEndian.PutUint64(buf[8:16], g.Power)
+	binary.BigEndian.PutUint32(buf[16:], ActiveParams().ChainID)
+	return utils.Sha256Hash(bytes.Join([][]byte{[]byte(g.Op), g.PubKey, buf, g.BLSKey}, nil))
+}
+
+// key identifies the change a vote is for
+func (g *Governance) key() string {
+	return fmt.Sprintf("%s/%x/%d/%x", g.Op, g.PubKey, g.Power, g.BLSKey)
+}
+
+// NewGovernanceTx creates a signed governance transaction voting for a validator set change
+// power and blsKey are the voting power and BLS key with proof of possession of an added validator
+func NewGovernanceTx(wallet *Wallet, op string, pubKey []byte, power uint64, blsKey []byte, epoch uint64) (*Transaction, error) {
+	if op != AddValidator && op != RemoveValidator {
+		return nil, errors.New("unknown governance operation")
+	}
+	if op == RemoveValidator {
+		power = 0
+		blsKey = nil
+	}
+	g := &Governance{
+		Op:     op,
+		PubKey: pubKey,
+		Epoch:  epoch,
+		Power:  power,
+		BLSKey: blsKey,
+		Voter:  wallet.GetPublicKeyBytes(),
+	}
+	sign, err := mycrypto.Sign(wallet.GetPrivateKey(), g.Digest())
+	if err != nil {
+		return nil, errors.New("sign governance vote fail")
+	}
+	g.Sign = sign
+	Tx := &Transaction{Governance: g}
+	Tx.ID = HashTransaction(Tx)
+	return Tx, nil
+}
+
+// ValidatorSet is the validator set of an epoch with the votes collected in it
+type ValidatorSet struct {
+	Epoch      uint64              // current epoch
+	Validators [][]byte            // validator public keys in index order
+	Powers     []uint64            // voting power of each validator, 1 if not set
+	BLSKeys    [][]byte            // BLS public key of each validator, empty if validators sign with ECDSA only
+	Votes      map[string][]string // change key -> hex public keys of voters
+	Scheduled  []*Governance       // changes with a quorum of votes, applied at epoch boundary
+}
+
+// NodeNum returns the number of validators
+func (vs *ValidatorSet) NodeNum() uint64 {
+	return uint64(len(vs.Validators))
+}
+
+// SameMembers checks two validator sets have the same validators, voting powers and BLS keys
+func (vs *ValidatorSet) SameMembers(other *ValidatorSet) bool {
+	if vs.NodeNum() != other.NodeNum() || len(vs.BLSKeys) != len(other.BLSKeys) {
+		return false
+	}
+	for i, key := range vs.Validators {
+		if !bytes.Equal(key, other.Validators[i]) || vs.Power(key) != other.Power(key) {
+			return false
+		}
+	}
+	for i, key := range vs.BLSKeys {
+		if !bytes.Equal(key, other.BLSKeys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// MaxFault returns the max fault validator number
+func (vs *ValidatorSet) MaxFault() uint64 {
+	if len(vs.Validators) == 0 {
+		return 0
+	}
+	return (vs.NodeNum() - 1) / 3
+}
+
+// PowerOf returns the voting power of the validator at index
+func (vs *ValidatorSet) PowerOf(index uint64) uint64 {
+	if index < uint64(len(vs.Powers)) {
+		return vs.Powers[index]
+	}
+	return 1
+}
+
+// Power returns the voting power of a public key, 0 if it is not a validator
+func (vs *ValidatorSet) Power(pubKey []byte) uint64 {
+	index, ok := vs.IndexOf(pubKey)
+	if !ok {
+		return 0
+	}
+	return vs.PowerOf(index)
+}
+
+// TotalPower returns the voting power of all validators
+func (vs *ValidatorSet) TotalPower() uint64 {
+	var total uint64
+	for i := range vs.Validators {
+		total += vs.PowerOf(uint64(i))
+	}
+	return total
+}
+
+// HasQuorum checks power is more than 2/3 of the total voting power
+func (vs *ValidatorSet) HasQuorum(power uint64) bool {
+	return power*3 > vs.TotalPower()*2
+}
+
+// Proposer returns the validator index of a rotation number,
+// each validator proposes in proportion to its voting power
+func (vs *ValidatorSet) Proposer(n uint64) uint64 {
+	total := vs.TotalPower()
+	if total == 0 {
+		return 0
+	}
+	n %= total
+	for i := range vs.Validators {
+		power := vs.PowerOf(uint64(i))
+		if n < power {
+			return uint64(i)
+		}
+		n -= power
+	}
+	return 0
+}
+
+// VerifyCert checks the certificate is signed by validators with a quorum of voting power
+func (vs *ValidatorSet) VerifyCert(qc *QuorumCert) bool {
+	if len(qc.AggSign) != 0 {
+		return vs.verifyCompactCert(qc)
+	}
+	digest := CertDigest(qc.BlockHash, qc.View)
+	signers := make(map[string]struct{})
+	var power uint64
+	for _, sig := range qc.Signatures {
+		key := string(sig.PubKey)
+		if _, exists := signers[key]; exists {
+			continue
+		}
+		p := vs.Power(sig.PubKey)
+		if p == 0 || !mycrypto.Verify(mycrypto.Bytes2PublicKey(sig.PubKey), digest, sig.Sign) {
+			return false
+		}
+		signers[key] = struct{}{}
+		power += p
+	}
+	return vs.HasQuorum(power)
+}
+
+// HasBLS checks every validator has a BLS public key
+func (vs *ValidatorSet) HasBLS() bool {
+	return len(vs.Validators) > 0 && len(vs.BLSKeys) == len(vs.Validators)
+}
+
+// BLSKeyOf returns the BLS public key of a validator public key, nil if it has none
+func (vs *ValidatorSet) BLSKeyOf(pubKey []byte) []byte {
+	index, ok := vs.IndexOf(pubKey)
+	if !ok || !vs.HasBLS() {
+		return nil
+	}
+	return vs.BLSKeys[index]
+}
+
+// CompactCert aggregates the BLS signatures of a certificate into one signature and a validator bitmap
+func (vs *ValidatorSet) CompactCert(qc *QuorumCert) (*QuorumCert, error) {
+	if !vs.HasBLS() {
+		return nil, errors.New("validator set has no bls key")
+	}
+	bitmap := make([]byte, (len(vs.Validators)+7)/8)
+	var signs [][]byte
+	for _, sig := range qc.Signatures {
+		index, ok := vs.IndexOf(sig.PubKey)
+		if !ok || len(sig.BLSSign) == 0 || bitmap[index/8]&(1<<(index%8)) != 0 {
+			continue
+		}
+		bitmap[index/8] |= 1 << (index % 8)
+		signs = append(signs, sig.BLSSign)
+	}
+	agg, err := mycrypto.BLSAggregate(signs)
+	if err != nil {
+		return nil, err
+	}
+	return &QuorumCert{
+		View:      qc.View,
+		Height:    qc.Height,
+		BlockHash: qc.BlockHash,
+		AggSign:   agg,
+		Bitmap:    bitmap,
+	}, nil
+}
+
+// verifyCompactCert checks the aggregate signature of the validators in the bitmap with one pairing check
+func (vs *ValidatorSet) verifyCompactCert(qc *QuorumCert) bool {
+	if !vs.HasBLS() || len(qc.Bitmap) != (len(vs.Validators)+7)/8 {
+		return false
+	}
+	var pubKeys [][]byte
+	var power uint64
+	for i := range vs.Validators {
+		if qc.Bitmap[i/8]&(1<<(i%8)) != 0 {
+			pubKeys = append(pubKeys, vs.BLSKeys[i])
+			power += vs.PowerOf(uint64(i))
+		}
+	}
+	if !vs.HasQuorum(power) {
+		return false
+	}
+	return mycrypto.BLSVerifyAggregate(pubKeys, CertDigest(qc.BlockHash, qc.View), qc.AggSign)
+}
+
+// IndexOf returns the index of a validator public key
+func (vs *ValidatorSet) IndexOf(pubKey []byte) (uint64, bool) {
+	for i, v := range vs.Validators {
+		if bytes.Equal(v, pubKey) {
+			return uint64(i), true
+		}
+	}
+	return 0, false
+}
+
+// VerifyVote checks a governance vote is signed by a current validator for a valid change
+func (vs *ValidatorSet) VerifyVote(g *Governance) error {
+	if g.Epoch != vs.Epoch {
+		return errors.New("vote of another epoch")
+	}
+	if _, ok := vs.IndexOf(g.Voter); !ok {
+		return errors.New("voter is not a validator")
+	}
+	if !mycrypto.Verify(mycrypto.Bytes2PublicKey(g.Voter), g.Digest(), g.Sign) {
+		return errors.New("verify vote signature fail")
+	}
+	_, member := vs.IndexOf(g.PubKey)
+	switch g.Op {
+	case AddValidator:
+		if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), g.PubKey); x == nil {
+			return errors.New("invalid validator public key")
+		}
+		if member {
+			return errors.New("already a validator")
+		}
+		if g.Power == 0 {
+			return errors.New("zero voting power")
+		}
+		if _, ok := mycrypto.BLSVerifyKey(g.BLSKey); vs.HasBLS() && !ok {
+			return errors.New("invalid validator bls key")
+		}
+	case RemoveValidator:
+		if !member {
+			return errors.New("not a validator")
+		}
+	default:
+		return errors.New("unknown governance operation")
+	}
+	return nil
+}
+
+// addVote counts a verified vote, the change is scheduled once its voters have a quorum of voting power
+func (vs *ValidatorSet) addVote(g *Governance) {
+	if vs.Votes == nil {
+		vs.Votes = make(map[string][]string)
+	}
+	key := g.key()
+	voter := hex.EncodeToString(g.Voter)
+	var before uint64
+	for _, v := range vs.Votes[key] {
+		if v == voter {
+			return
+		}
+		pubKey, _ := hex.DecodeString(v)
+		before += vs.Power(pubKey)
+	}
+	vs.Votes[key] = append(vs.Votes[key], voter)
+	if !vs.HasQuorum(before) && vs.HasQuorum(before+vs.Power(g.Voter)) {
+		vs.Scheduled = append(vs.Scheduled, g)
+	}
+}
+
+// applyScheduled applies scheduled changes and starts a new epoch
+func (vs *ValidatorSet) applyScheduled(epoch uint64) {
+	for _, g := range vs.Scheduled {
+		index, member := vs.IndexOf(g.PubKey)
+		switch {
+		case g.Op == AddValidator && !member:
+			if vs.HasBLS() {
+				vs.BLSKeys = append(vs.BLSKeys, g.BLSKey[:mycrypto.BLSPublicKeyLen])
+			}
+			vs.Powers = vs.powers()
+			vs.Validators = append(vs.Validators, g.PubKey)
+			vs.Powers = append(vs.Powers, g.Power)
+		case g.Op == RemoveValidator && member && len(vs.Validators) > 1:
+			if vs.HasBLS() {
+				vs.BLSKeys = append(vs.BLSKeys[:index:index], vs.BLSKeys[index+1:]...)
+			}
+			vs.Powers = vs.powers()
+			vs.Validators = append(vs.Validators[:index:index], vs.Validators[index+1:]...)
+			vs.Powers = append(vs.Powers[:index:index], vs.Powers[index+1:]...)
+		}
+	}
+	vs.Epoch = epoch
+	vs.Votes = nil
+	vs.Scheduled = nil
+}
+
+// powers returns the voting power of every validator
+func (vs *ValidatorSet) powers() []uint64 {
+	powers := make([]uint64, len(vs.Validators))
+	for i := range vs.Validators {
+		powers[i] = vs.PowerOf(uint64(i))
+	}
+	return powers
+}
+
+// InitValidators stores the initial validator set if the chain has none,
+// powers are the voting power of each validator, empty gives every validator power 1,
+// blsKeys are BLS public keys with proof of possession of each validator, empty for ECDSA only
+func (chain *Chain) InitValidators(pubKeys [][]byte, powers []uint64, blsKeys [][]byte) error {
+	if len(pubKeys) == 0 || chain.GetValidators() != nil {
+		return nil
+	}
+	if len(powers) != 0 && len(powers) != len(pubKeys) {
+		return errors.New("validator power number not match")
+	}
+	for _, power := range powers {
+		if power == 0 {
+			return errors.New("zero voting power")
+		}
+	}
+	if len(blsKeys) != 0 && len(blsKeys) != len(pubKeys) {
+		return errors.New("validator bls key number not match")
+	}
+	var blsPubKeys [][]byte
+	for _, k := range blsKeys {
+		blsPubKey, ok := mycrypto.BLSVerifyKey(k)
+		if !ok {
+			return errors.New("invalid validator bls key")
+		}
+		blsPubKeys = append(blsPubKeys, blsPubKey)
+	}
+	vs := &ValidatorSet{
+		Epoch:      chain.GetHeight() / EpochLength,
+		Validators: pubKeys,
+		Powers:     powers,
+		BLSKeys:    blsPubKeys,
+	}
+	return chain.writeValidators(vs)
+}
+
+// GetValidators returns the current validator set, nil if the chain has none
+func (chain *Chain) GetValidators() *ValidatorSet {
+	chain.Lock.Lock()
+	data, err := ReadFromDB(chain.DataBase, []byte(ValidatorTable), []byte(validatorSetKey))
+	chain.Lock.Unlock()
+	if err != nil {
+		return nil
+	}
+	var vs ValidatorSet
+	if utils.Deserialize(data, &vs) != nil {
+		return nil
+	}
+	return &vs
+}
+
+func (chain *Chain) writeValidators(vs *ValidatorSet) error {
+	data, err := utils.Serialize(vs)
+	if err != nil {
+		return err
+	}
+	chain.Lock.Lock()
+	defer chain.Lock.Unlock()
+	return WriteToDB(chain.DataBase, []byte(ValidatorTable), []byte(validatorSetKey), data)
+}
+
+// updateValidators counts governance votes of a block added to the tip,
+// and applies scheduled changes after the last block of an epoch
+func (chain *Chain) updateValidators(block *Block) {
+	vs := chain.GetValidators()
+	if vs == nil {
+		return
+	}
+	for _, tx := range block.Transactions {
+		if !tx.IsGovernance() {
+			continue
+		}
+		if err := vs.VerifyVote(tx.Governance); err != nil {
+			chain.log.Println("Skip governance vote: ", err)
+			continue
+		}
+		vs.addVote(tx.Governance)
+	}
+	if block.Header.Height%EpochLength == 0 {
+		vs.applyScheduled(block.Header.Height / EpochLength)
+		chain.log.Printf("Start epoch %d, validators: %d", vs.Epoch, vs.NodeNum())
+	}
+	if err := chain.writeValidators(vs); err != nil {
+		chain.log.Println("Update validator set fail")
+	}
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import (
+	"BlockChain/src/mycrypto"
+	"fmt"
+	"testing"
+)
+
+func TestValidatorSet_Votes(t *testing.T) {
+	var wallets []*Wallet
+	var pubKeys [][]byte
+	for i := 0; i < 4; i++ {
+		w := CreateWallet()
+		wallets = append(wallets, w)
+		pubKeys = append(pubKeys, w.GetPublicKeyBytes())
+	}
+	newValidator := CreateWallet()
+	vs := &ValidatorSet{Epoch: 1, Validators: pubKeys}
+
+	// votes of an outsider and of another epoch are rejected
+	tx, _ := NewGovernanceTx(newValidator, AddValidator, newValidator.GetPublicKeyBytes(), 1, nil, 1)
+	fmt.Println("outsider vote: ", vs.VerifyVote(tx.Governance))
+	if vs.VerifyVote(tx.Governance) == nil {
+		t.Error("vote of non validator accepted")
+	}
+	tx, _ = NewGovernanceTx(wallets[0], AddValidator, newValidator.GetPublicKeyBytes(), 1, nil, 0)
+	if vs.VerifyVote(tx.Governance) == nil {
+		t.Error("vote of another epoch accepted")
+	}
+
+	// 2f+1 votes schedule the change, a duplicate vote is counted once
+	for i := 0; i < 3; i++ {
+		tx, _ = NewGovernanceTx(wallets[i], AddValidator, newValidator.GetPublicKeyBytes(), 1, nil, 1)
+		if err := vs.VerifyVote(tx.Governance); err != nil {
+			t.Fatal(err)
+		}
+		vs.addVote(tx.Governance)
+		vs.addVote(tx.Governance)
+		fmt.Printf("votes: %d, scheduled: %d\n", len(vs.Votes[tx.Governance.key()]), len(vs.Scheduled))
+	}
+	if len(vs.Scheduled) != 1 || vs.NodeNum() != 4 {
+		t.Fatal("change not scheduled")
+	}
+
+	vs.applyScheduled(2)
+	index, ok := vs.IndexOf(newValidator.GetPublicKeyBytes())
+	fmt.Printf("epoch: %d, validators: %d, total power: %d, new index: %d\n", vs.Epoch, vs.NodeNum(), vs.TotalPower(), index)
+	if !ok || vs.Epoch != 2 || vs.NodeNum() != 5 || vs.TotalPower() != 5 || len(vs.Votes) != 0 {
+		t.Error("apply validator set change error")
+	}
+}
+
+func TestValidatorSet_Power(t *testing.T) {
+	var pubKeys [][]byte
+	for i := 0; i < 4; i++ {
+		pubKeys = append(pubKeys, CreateWallet().GetPublicKeyBytes())
+	}
+	vs := &ValidatorSet{Validators: pubKeys, Powers: []uint64{4, 2, 1, 1}}
+
+	// quorum is more than 2/3 of total power 8
+	fmt.Println("quorum of 5, 6: ", vs.HasQuorum(5), vs.HasQuorum(6))
+	if vs.HasQuorum(5) || !vs.HasQuorum(6) {
+		t.Error("weighted quorum error")
+	}
+
+	// primary nodes rotate in proportion to voting power
+	count := make(map[uint64]int)
+	for n := uint64(0); n < 16; n++ {
+		count[vs.Proposer(n)]++
+	}
+	fmt.Println("proposer count: ", count)
+	if count[0] != 8 || count[1] != 4 || count[2] != 2 || count[3] != 2 {
+		t.Error("weighted proposer error")
+	}
+
+	// a snapshot of a later epoch with the same members is signed by the same validators
+	later := &ValidatorSet{Epoch: 3, Validators: pubKeys, Powers: []uint64{4, 2, 1, 1}}
+	changed := &ValidatorSet{Validators: pubKeys, Powers: []uint64{1, 2, 1, 4}}
+	if !vs.SameMembers(later) || vs.SameMembers(changed) || vs.SameMembers(&ValidatorSet{Validators: pubKeys[:3]}) {
+		t.Error("compare validator set members error")
+	}
+}
+
+func TestValidatorSet_CompactCert(t *testing.T) {
+	var wallets []*Wallet
+	var pubKeys, blsKeys [][]byte
+	for i := 0; i < 4; i++ {
+		w := CreateWallet()
+		wallets = append(wallets, w)
+		pubKeys = append(pubKeys, w.GetPublicKeyBytes())
+		blsKeys = append(blsKeys, mycrypto.DeriveBLSKey(w.GetPrivateKey()).PublicKey())
+	}
+	vs := &ValidatorSet{Validators: pubKeys, BLSKeys: blsKeys}
+
+	// three of four validators sign the block
+	hash := []byte("block")
+	qc := &QuorumCert{View: 1, Height: 5, BlockHash: hash}
+	for _, w := range wallets[1:] {
+		sign, _ := mycrypto.Sign(w.GetPrivateKey(), CertDigest(hash, 1))
+		qc.AddSignature(&CertSignature{
+			ID:      string(w.GetAddress()),
+			Sign:    sign,
+			PubKey:  w.GetPublicKeyBytes(),
+			BLSSign: mycrypto.DeriveBLSKey(w.GetPrivateKey()).Sign(CertDigest(hash, 1)),
+		})
+	}
+	compact, err := vs.CompactCert(qc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("bitmap: %08b, aggregate signature: %d bytes\n", compact.Bitmap, len(compact.AggSign))
+	if !vs.VerifyCert(compact) {
+		t.Error("verify compact certificate fail")
+	}
+
+	// a bitmap claiming another signer or a lower quorum fails
+	compact.Bitmap[0] = 0x07
+	if vs.VerifyCert(compact) {
+		t.Error("compact certificate with wrong bitmap accepted")
+	}
+	compact.Bitmap[0] = 0x06
+	if vs.VerifyCert(compact) {
+		t.Error("compact certificate below quorum accepted")
+	}
+}
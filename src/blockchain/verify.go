@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// BlockChecker checks the hash of a stored block with the rules of the consensus engine
+type BlockChecker func(block *Block) error
+
+// ChainCheck is the result of VerifyChain
+type ChainCheck struct {
+	Blocks   uint64 // blocks verified from genesis
+	UTXOs    int    // transactions with unspent outputs in the rebuilt UTXO set
+	Diverged error  // first divergence found, nil if blocks and UTXO set are consistent
+	Repaired bool   // the stored UTXO set was replaced by the rebuilt one
+}
+
+// VerifyChain walks the chain from genesis, checks hash, linkage and transactions of each block,
+// rebuilds the UTXO set in memory and compares it with ChainStateTable.
+// With repair set, a diverged UTXO set is replaced when all blocks are valid,
+// checker verifies block hashes of non-genesis blocks, nil checks them with CalculateHash
+func (chain *Chain) VerifyChain(checker BlockChecker, repair bool) (*ChainCheck, error) {
+	if chain.GetHeight() == 0 {
+		return nil, errors.New("chain is empty")
+	}
+	if !chain.complete() {
+		return nil, errors.New("chain without all block bodies can not be verified")
+	}
+
+	result := &ChainCheck{}
+	utxos := make(map[string][]UTXO)
+	var prev *Block
+	for _, hash := range chain.mainHashes() {
+		block := chain.GetBlock(hash)
+		if block == nil {
+			result.Diverged = fmt.Errorf("block %x not found", hash)
+			return result, nil
+		}
+		if err := chain.verifyStoredBlock(block, prev, checker); err != nil {
+			result.Diverged = fmt.Errorf("block %d: %v", block.Header.Height, err)
+			return result, nil
+		}
+		if err := spendOutputs(utxos, block); err != nil {
+			result.Diverged = fmt.Errorf("block %d: %v", block.Header.Height, err)
+			return result, nil
+		}
+		prev = block
+		result.Blocks++
+	}
+	result.UTXOs = len(utxos)
+
+	if err := chain.compareUTXOSet(utxos); err != nil {
+		result.Diverged = err
+		if repair {
+			ReindexUTXOSet(chain.DataBase, utxos)
+			chain.ReindexTxs()
+			result.Repaired = true
+			chain.log.Println("Repair UTXO set: ", err)
+		}
+	}
+	return result, nil
+}
+
+// verifyStoredBlock checks a block against its parent, prev is nil for the genesis block
+func (chain *Chain) verifyStoredBlock(block, prev *Block, checker BlockChecker) error {
+	if prev == nil {
+		if !block.IsGenesisBlock() {
+			return errors.New("first block is not genesis")
+		}
+	} else if block.Header.Height != prev.Header.Height+1 || !bytes.Equal(block.Header.PrevHash, prev.Header.Hash) {
+		return errors.New("block not linked to parent")
+	}
+	if prev == nil || checker == nil {
+		if !bytes.Equal(block.CalculateHash(), block.Header.Hash) {
+			return errors.New("block hash not match")
+		}
+	} else if err := checker(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.CalculateMerkleRoot(), block.Header.MerkleRoot) {
+		return errors.New("merkle root not match")
+	}
+
+	// governance votes were checked with the validator set of their epoch, which is not kept
+	var txs []*Transaction
+	for _, tx := range block.Transactions {
+		if !tx.IsGovernance() {
+			txs = append(txs, tx)
+		} else if len(tx.Inputs) != 0 || len(tx.Outputs) != 0 {
+			return errors.New("governance transaction with inputs or outputs")
+		}
+	}
+	if prev != nil && !VerifyTransactions(chain, txs) {
+		return errors.New("verify transactions fail")
+	}
+	return nil
+}
+
+// spendOutputs applies the transactions of a block to an in memory UTXO set,
+// inputs must spend outputs of earlier transactions which are still unspent
+func spendOutputs(utxos map[string][]UTXO, block *Block) error {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinBase() {
+			for _, in := range tx.Inputs {
+				id := hex.EncodeToString(in.TxID)
+				var left []UTXO
+				for _, utxo := range utxos[id] {
+					if utxo.Index != in.Index {
+						left = append(left, utxo)
+					}
+				}
+				if len(left) == len(utxos[id]) {
+					return fmt.Errorf("input %s:%d spends no unspent output", id, in.Index)
+				}
+				if len(left) == 0 {
+					delete(utxos, id)
+				} else {
+					utxos[id] = left
+				}
+			}
+		}
+		var outputs []UTXO
+		for i, out := range tx.Outputs {
+			outputs = append(outputs, UTXO{Index: i, Output: out})
+		}
+		if len(outputs) != 0 {
+			utxos[hex.EncodeToString(tx.ID)] = outputs
+		}
+	}
+	return nil
+}
+
+// replayBranch rebuilds the UTXO set of the chain ending at tip from genesis, inputs must spend
+// unspent outputs of the branch with valid signatures
+func (chain *Chain) replayBranch(tip *Block) (map[string][]UTXO, error) {
+	var branch []*Block
+	for block := tip; ; {
+		branch = append(branch, block)
+		if block.IsGenesisBlock() {
+			break
+		}
+		block = chain.findBlockByHash(block.Header.PrevHash)
+		if block == nil {
+			return nil, errors.New("branch not linked to genesis")
+		}
+	}
+
+	utxos := make(map[string][]UTXO)
+	txs := make(map[string]*Transaction) // transactions with unspent outputs
+	for i := len(branch) - 1; i >= 0; i-- {
+		block := branch[i]
+		for _, tx := range block.Transactions {
+			if tx.IsCoinBase() {
+				continue
+			}
+			for _, in := range tx.Inputs {
+				preTx := txs[hex.EncodeToString(in.TxID)]
+				if preTx == nil || !verifyInputSignature(preTx, in) {
+					return nil, fmt.Errorf("block %d: invalid input of transaction %x", block.Header.Height, tx.ID)
+				}
+			}
+		}
+		if err := spendOutputs(utxos, block); err != nil {
+			return nil, fmt.Errorf("block %d: %v", block.Header.Height, err)
+		}
+		for _, tx := range block.Transactions {
+			txs[hex.EncodeToString(tx.ID)] = tx
+		}
+		for id := range txs {
+			if _, ok := utxos[id]; !ok {
+				delete(txs, id)
+			}
+		}
+	}
+	return utxos, nil
+}
+
+// compareUTXOSet compares a rebuilt UTXO set with ChainStateTable, returns the first differing transaction
+func (chain *Chain) compareUTXOSet(utxos map[string][]UTXO) error {
+	stored := make(map[string][]byte)
+	prefix := []byte(ChainStateTable)
+	chain.Lock.Lock()
+	iter := chain.DataBase.NewIterator(prefix)
+	for iter.Next() {
+		stored[hex.EncodeToString(iter.Key()[len(prefix):])] = iter.Value()
+	}
+	err := iter.Error()
+	iter.Release()
+	chain.Lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(stored)+len(utxos))
+	for id := range stored {
+		ids = append(ids, id)
+	}
+	for id := range utxos {
+		if _, ok := stored[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		value, ok := stored[id]
+		if !ok {
+			return fmt.Errorf("transaction %s missing in UTXO set", id)
+		}
+		if _, ok := utxos[id]; !ok {
+			return fmt.Errorf("transaction %s has no unspent outputs but is in UTXO set", id)
+		}
+		data, err := utils.Serialize(utxos[id])
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, value) {
+			return fmt.Errorf("unspent outputs of transaction %s not match", id)
+		}
+	}
+	return nil
+}
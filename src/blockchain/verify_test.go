@@ -0,0 +1,103 @@
+package blockchain
+
+import (
+	"BlockChain/src/storage"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestChain_VerifyChain(t *testing.T) {
+	dir := t.TempDir()
+	wallet := CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	for height := uint64(2); height <= 4; height++ {
+		block := NewBlock(chain.Tip, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, height)}, height)
+		if !chain.AddBlock(block) {
+			t.Fatal("add block fail, height: ", height)
+		}
+	}
+	tx, err := NewTransaction(wallet, chain, CreateWallet().GetAddress(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chain.AddBlock(NewBlock(chain.Tip, []*Transaction{tx}, 5)) {
+		t.Fatal("add block fail, height: 5")
+	}
+
+	result, err := chain.VerifyChain(nil, false)
+	fmt.Println("Verify: ", result.Blocks, result.UTXOs, result.Diverged)
+	if err != nil || result.Blocks != 5 || result.Diverged != nil {
+		t.Fatal("verify consistent chain fail")
+	}
+
+	// a lost UTXO entry is reported, then repaired
+	if err := DeleteFromDB(chain.DataBase, []byte(ChainStateTable), tx.ID); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ = chain.VerifyChain(nil, false); result.Diverged == nil || result.Repaired {
+		t.Fatal("diverged UTXO set not found")
+	}
+	if result, _ = chain.VerifyChain(nil, true); !result.Repaired {
+		t.Fatal("UTXO set not repaired")
+	}
+	if result, _ = chain.VerifyChain(nil, false); result.Diverged != nil {
+		t.Fatal("UTXO set diverged after repair: ", result.Diverged)
+	}
+}
+
+func TestChain_ReorganizeInvalidBranch(t *testing.T) {
+	dir := t.TempDir()
+	wallet, other := CreateWallet(), CreateWallet()
+	chain, err := CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	fork := NewBlock(chain.Tip, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, 2)}, 2)
+	if !chain.AddBlock(fork) {
+		t.Fatal("add block fail, height: 2")
+	}
+	if !chain.AddBlock(NewBlock(chain.Tip, []*Transaction{NewRewardTx(other.GetAddress(), ActiveParams().MinerReward, 3)}, 3)) {
+		t.Fatal("add block fail, height: 3")
+	}
+	// the output spent by tx exists only on the main chain
+	tx, err := NewTransaction(other, chain, wallet.GetAddress(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	side := NewBlock(fork.Header.Hash, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, 3)}, 3)
+	if !chain.AddSideBlock(side) {
+		t.Fatal("add side block fail, height: 3")
+	}
+	invalid := NewBlock(side.Header.Hash, []*Transaction{tx}, 4)
+	if !chain.AddSideBlock(invalid) {
+		t.Fatal("add side block fail, height: 4")
+	}
+	tip := chain.Tip
+	if chain.Reorganize(invalid.Header.Hash) {
+		t.Fatal("reorganize to branch spending missing output")
+	}
+	if string(chain.Tip) != string(tip) {
+		t.Fatal("tip moved by invalid reorganize")
+	}
+
+	valid := NewBlock(side.Header.Hash, []*Transaction{NewRewardTx(wallet.GetAddress(), ActiveParams().MinerReward, 4)}, 4)
+	if !chain.AddSideBlock(valid) || !chain.Reorganize(valid.Header.Hash) {
+		t.Fatal("reorganize to valid branch fail")
+	}
+	if result, err := chain.VerifyChain(nil, false); err != nil || result.Diverged != nil {
+		t.Fatal("UTXO set diverged after reorganize")
+	}
+	// the height index follows the new branch
+	blocks := chain.FindBlocksInRange(2, 4)
+	fmt.Println("blocks in range: ", len(blocks))
+	if len(blocks) != 3 || string(blocks[1].Header.Hash) != string(side.Header.Hash) || string(blocks[2].Header.Hash) != string(valid.Header.Hash) {
+		t.Fatal("height index not updated by reorganize")
+	}
+}
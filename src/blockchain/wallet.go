@@ -128,7 +128,7 @@ func GenerateAddress(publicKeyBytes []byte) []byte {
 	ripemd160HashValue := utils.Ripemd160Hash(keyHash)
 
 	// add version number as prefix
-	versionHash := append([]byte{AddressVersion}, ripemd160HashValue...)
+	versionHash := append([]byte{ActiveParams().AddressVersion}, ripemd160HashValue...)
 
 	// calculate checksum
 	checksum := utils.CalculateChecksum(versionHash)
@@ -144,9 +144,15 @@ func Address2PublicKeyHash(address []byte) []byte {
 	return utils.Base58Decode(address)
 }
 
-// CheckAddress check checksum of an address
+// CheckAddress check version and checksum of an address, addresses of other networks are invalid
 func CheckAddress(addr []byte) bool {
+	if len(addr) == 0 {
+		return false
+	}
 	publickHash := utils.Base58Decode(addr)
+	if len(publickHash) < 5 || publickHash[0] != ActiveParams().AddressVersion {
+		return false
+	}
 	// last 4 byte is checksum
 	addr_checksum := publickHash[len(publickHash)-4:]
 
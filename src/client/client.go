@@ -2,12 +2,16 @@ package client
 
 import (
 	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	"BlockChain/src/consensus"
+	"BlockChain/src/mycrypto"
 	"BlockChain/src/network"
 	"BlockChain/src/pool"
 	"BlockChain/src/utils"
 	"bufio"
-	"encoding/json"
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -21,7 +25,8 @@ type Client struct {
 	isConsensus bool
 	chain       *blockchain.Chain
 	network     *p2pnet.P2PNet
-	consensus   *consensus.PBFT
+	consensus   consensus.Engine
+	requests    *consensus.RequestClient
 	wallet      *blockchain.Wallet
 	txPool      *pool.TxPool
 	blockPool   *pool.BlockPool
@@ -35,26 +40,62 @@ func CreateClient(config *Config, c *blockchain.Chain, w *blockchain.Wallet) (*C
 	l := utils.NewLogger("[client] ", config.ClientCfg.LogPath)
 
 	// initialize the network
-	net := p2pnet.CreateNode(config.P2PNetCfg.PriKeyPath, config.P2PNetCfg.ListenAddr, config.P2PNetCfg.LogPath)
+	net := p2pnet.CreateNode(blockchain.ActiveParams().Name, config.P2PNetCfg.PriKeyPath, config.P2PNetCfg.ListenAddr, config.P2PNetCfg.LogPath)
+	// peers of other chains are disconnected on handshake, the genesis hash is known once synchronized
+	net.SetHandshake(func() *p2pnet.Handshake {
+		return localHandshake(config, c)
+	})
 
 	// initialize TxPool
 	txPool := pool.NewTxPool(config.TxPoolFull, net, config.TxPoolCfg.LogPath)
 
 	// initialize BlockPool
 	blockPool := pool.NewBlockPool(config.BlockPoolFull, net, c, config.BlockPoolCfg.LogPath)
+	// snapshots are trusted through commit certificates of the configured validators only
+	fastSync := config.BlockPoolCfg.FastSync
+	if fastSync && len(config.PBFTCfg.Validators) == 0 {
+		l.Println("Fast sync needs validator public keys to verify commit certificates, disabled")
+		fastSync = false
+	}
+	// only pBFT headers commit to the state snapshots
+	if fastSync && config.PBFTCfg.Engine != "" && config.PBFTCfg.Engine != consensus.EnginePBFT {
+		l.Println("Fast sync needs the pBFT engine, disabled")
+		fastSync = false
+	}
+	blockPool.SetFastSync(fastSync)
+
+	// prune old block bodies on storage constrained nodes
+	if err := c.SetPruneDepth(config.ChainCfg.PruneDepth); err != nil {
+		l.Panic("Set prune depth fail: ", err)
+		return nil, err
+	}
+
+	// initialize on chain validator set, governance votes change it later
+	if err := initValidators(config, c); err != nil {
+		l.Panic("Initialize validator set fail: ", err)
+		return nil, err
+	}
+
+	// initialize the pBFT client library
+	requests := consensus.NewRequestClient(config.PBFTCfg.MaxFaultNode, net, w, config.PBFTCfg.LogPath)
+	requests.SetValidators(c.GetValidators)
 
 	// initialize the consensus
-	pbft, err := consensus.NewPBFT(config.PBFTCfg.NodeNum, config.PBFTCfg.Index, config.PBFTCfg.MaxFaultNode, config.PBFTCfg.View, txPool, blockPool, net, c, w, config.PBFTCfg.LogPath)
+	engine, err := createEngine(config, txPool, blockPool, net, c, w, requests)
 	if err != nil {
-		l.Panic("Initialize pBFT consensus fail")
+		l.Panic("Initialize consensus fail: ", err)
 		return nil, err
 	}
 
+	// check blocks received from peers with consensus rules
+	blockPool.SetVerifier(engine.VerifyBlock)
+
 	client := &Client{
 		isConsensus: config.PBFTCfg.IsConsensusNode,
 		chain:       c,
 		network:     net,
-		consensus:   pbft,
+		consensus:   engine,
+		requests:    requests,
 		wallet:      w,
 		txPool:      txPool,
 		blockPool:   blockPool,
@@ -64,15 +105,99 @@ func CreateClient(config *Config, c *blockchain.Chain, w *blockchain.Wallet) (*C
 	return client, nil
 }
 
+// initValidators stores the configured pBFT validators as the initial on chain validator set
+func initValidators(config *Config, c *blockchain.Chain) error {
+	var pubKeys [][]byte
+	for _, v := range config.PBFTCfg.Validators {
+		pubKey, err := hex.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("wrong validator public key: %s", v)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	var blsKeys [][]byte
+	for _, v := range config.PBFTCfg.ValidatorBLS {
+		blsKey, err := hex.DecodeString(v)
+		if err != nil {
+			return fmt.Errorf("wrong validator bls key: %s", v)
+		}
+		blsKeys = append(blsKeys, blsKey)
+	}
+	return c.InitValidators(pubKeys, config.PBFTCfg.ValidatorPowers, blsKeys)
+}
+
+// createEngine creates the consensus engine selected in config
+func createEngine(config *Config, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, c *blockchain.Chain, w *blockchain.Wallet, rc *consensus.RequestClient) (consensus.Engine, error) {
+	switch config.PBFTCfg.Engine {
+	case "", consensus.EnginePBFT:
+		engine, err := consensus.NewPBFT(config.PBFTCfg.NodeNum, config.PBFTCfg.Index, config.PBFTCfg.MaxFaultNode, config.PBFTCfg.View, config.PBFTCfg.ViewTimeout, config.PBFTCfg.LeaderElection, tp, bp, net, c, w, config.PBFTCfg.LogPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := engine.SetSignatureScheme(config.PBFTCfg.SignatureScheme); err != nil {
+			return nil, err
+		}
+		// observers check commit certificates of announced blocks
+		bp.SetCertVerifier(engine.VerifyCert)
+		// consensus node replies client requests
+		if config.PBFTCfg.IsConsensusNode {
+			engine.SetRequestClient(rc)
+		}
+		return engine, nil
+	case consensus.EngineSolo:
+		return consensus.NewSolo(config.PBFTCfg.BlockInterval, tp, bp, net, c, w, config.PBFTCfg.LogPath)
+	case consensus.EngineHotStuff:
+		engine, err := consensus.NewHotStuff(config.PBFTCfg.NodeNum, config.PBFTCfg.Index, config.PBFTCfg.MaxFaultNode, config.PBFTCfg.View, tp, bp, net, c, w, config.PBFTCfg.LogPath)
+		if err != nil {
+			return nil, err
+		}
+		// blocks from peers need the quorum certificate stored when they were committed
+		bp.SetCertVerifier(engine.VerifyCert)
+		return engine, nil
+	case consensus.EngineRaft:
+		return consensus.NewRaft(config.PBFTCfg.NodeNum, config.PBFTCfg.Index, tp, bp, net, c, w, config.PBFTCfg.LogPath)
+	case consensus.EnginePoW:
+		// heaviest chain wins between competing miners
+		bp.SetForkChoice(true)
+		// mined blocks are broadcast, their work is checked by the verifier
+		bp.SetBroadcast(true)
+		engine, err := consensus.NewPoW(config.PBFTCfg.Bits, tp, bp, net, c, w, config.PBFTCfg.LogPath)
+		if err != nil {
+			return nil, err
+		}
+		// headers are checked before their bodies are downloaded
+		bp.SetHeaderVerifier(engine.VerifyHeader)
+		return engine, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine: %s", config.PBFTCfg.Engine)
+	}
+}
+
+// localHandshake announces the role of the node and the message types its components handle
+func localHandshake(config *Config, c *blockchain.Chain) *p2pnet.Handshake {
+	handshake := &p2pnet.Handshake{
+		MessageTypes: []p2pnet.MessageType{p2pnet.BlockMsg, p2pnet.TransactionMsg, p2pnet.RequestMsg, p2pnet.ReplyMsg},
+		ChainID:      blockchain.ActiveParams().ChainID,
+		Genesis:      c.GetGenesisHash(),
+		BestHeight:   c.GetHeight(),
+		Role:         p2pnet.RoleObserver,
+	}
+	if config.PBFTCfg.IsConsensusNode {
+		handshake.MessageTypes = append(handshake.MessageTypes, p2pnet.ConsensusMsg)
+		handshake.Role = p2pnet.RoleValidator
+	}
+	return handshake
+}
+
 // Run executes the client operations, including running the various components.
 func (c *Client) Run(wg *sync.WaitGroup, exitChan chan struct{}) error {
 	// Start the p2p network
 	c.log.Println("Run p2p net")
 	go c.network.StartNode()
 
-	// Run pBFT consensus
+	// Run consensus engine
 	if c.isConsensus {
-		c.log.Println("Run pBFT consensus")
+		c.log.Println("Run consensus engine")
 		go c.consensus.Run()
 	}
 
@@ -80,6 +205,10 @@ func (c *Client) Run(wg *sync.WaitGroup, exitChan chan struct{}) error {
 	c.log.Println("Run Transaction Pool")
 	go c.txPool.Run()
 
+	// Run pBFT client library
+	c.log.Println("Run Request Client")
+	go c.requests.Run()
+
 	// Start block synchronization
 	c.log.Println("Run Block Pool")
 	go c.blockPool.Run()
@@ -127,6 +256,27 @@ func (c *Client) handleCmd(cmd []string) bool {
 		} else {
 			fmt.Println("Please input address and amount")
 		}
+	case "gov":
+		// Vote for a validator set change, an added validator has power 1 if not given,
+		// validators signing with BLS need the BLS key of the added validator
+		if len(cmd) >= 3 && len(cmd) <= 5 && (cmd[1] == blockchain.AddValidator || cmd[1] == blockchain.RemoveValidator) {
+			pubKey, err := hex.DecodeString(cmd[2])
+			power := uint64(1)
+			if err == nil && len(cmd) >= 4 {
+				power, err = strconv.ParseUint(cmd[3], 10, 64)
+			}
+			var blsKey []byte
+			if err == nil && len(cmd) == 5 {
+				blsKey, err = hex.DecodeString(cmd[4])
+			}
+			if err != nil {
+				fmt.Println("wrong public key, power or bls key")
+			} else {
+				c.createGovernance(cmd[1], pubKey, power, blsKey)
+			}
+		} else {
+			fmt.Println("Please input add or remove, validator public key and power")
+		}
 	case "s":
 		c.showStatus() // Display the status
 	case "b":
@@ -141,6 +291,24 @@ func (c *Client) handleCmd(cmd []string) bool {
 		} else {
 			fmt.Println("Please input block id")
 		}
+	case "export":
+		if len(cmd) == 2 {
+			c.exportChain(cmd[1])
+		} else {
+			fmt.Println("Please input export file path")
+		}
+	case "import":
+		if len(cmd) == 2 {
+			c.importChain(cmd[1])
+		} else {
+			fmt.Println("Please input import file path")
+		}
+	case "verifychain":
+		if len(cmd) == 1 || (len(cmd) == 2 && cmd[1] == "repair") {
+			c.verifyChain(len(cmd) == 2)
+		} else {
+			fmt.Println("Please input verifychain or verifychain repair")
+		}
 	default:
 		fmt.Println("Unknown command, use \"help\" or \"h\" for usage")
 	}
@@ -155,12 +323,132 @@ func (c *Client) createTransaction(amount int, to []byte) {
 	if err != nil {
 		return // If creating transaction fails, exit function
 	}
+	c.submitTransaction(tx)
+}
+
+// createGovernance creates a governance vote of the client wallet for a validator set change
+func (c *Client) createGovernance(op string, pubKey []byte, power uint64, blsKey []byte) {
+	vs := c.chain.GetValidators()
+	if vs == nil {
+		fmt.Println("Validator set is not on chain")
+		return
+	}
+	if _, ok := vs.IndexOf(c.wallet.GetPublicKeyBytes()); !ok {
+		fmt.Println("Only validators can vote")
+		return
+	}
+	tx, err := blockchain.NewGovernanceTx(c.wallet, op, pubKey, power, blsKey, vs.Epoch)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	c.submitTransaction(tx)
+}
+
+// exportChain writes all blocks with their commit certificates to a file
+func (c *Client) exportChain(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println("Create export file fail: ", err)
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	count, err := c.chain.Export(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Println("Export chain fail: ", err)
+		return
+	}
+	fmt.Printf("Export %d blocks to %s\n", count, path)
+}
+
+// importChain adds the blocks of an export file above the tip after full validation
+func (c *Client) importChain(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Open import file fail: ", err)
+		return
+	}
+	defer f.Close()
+	count, err := c.chain.Import(bufio.NewReader(f), c.verifyImported)
+	if err != nil {
+		fmt.Println("Import chain fail: ", err)
+	}
+	fmt.Printf("Import %d blocks, chain height: %d\n", count, c.chain.GetHeight())
+}
+
+// verifyImported checks an imported block with the consensus rules of received blocks,
+// pBFT and HotStuff blocks need a valid certificate and only mined blocks carry a reward
+func (c *Client) verifyImported(block *blockchain.Block, cert *blockchain.QuorumCert) error {
+	// proof of work blocks are hashed over their header only
+	if c.config.PBFTCfg.Engine != consensus.EnginePoW && !bytes.Equal(block.CalculateHash(), block.Header.Hash) {
+		return errors.New("block hash not match")
+	}
+	if err := c.consensus.VerifyBlock(block); err != nil {
+		return err
+	}
+	if block.IsGenesisBlock() {
+		return nil
+	}
+	if engine, ok := c.consensus.(interface {
+		VerifyCert(*blockchain.QuorumCert) bool
+	}); ok && (cert == nil || !engine.VerifyCert(cert)) {
+		return errors.New("missing or invalid commit certificate")
+	}
+	reward := 0
+	if c.config.PBFTCfg.Engine == consensus.EnginePoW {
+		reward = blockchain.ActiveParams().MinerReward
+	}
+	return blockchain.CheckCoinbase(block, reward)
+}
+
+// verifyChain checks the stored chain and UTXO set and reports the first divergence
+func (c *Client) verifyChain(repair bool) {
+	var checker blockchain.BlockChecker
+	// proof of work blocks are hashed over their header only
+	if engine, ok := c.consensus.(*consensus.PoW); ok {
+		checker = func(block *blockchain.Block) error {
+			return engine.VerifyHeader(block.Header)
+		}
+	}
+	result, err := c.chain.VerifyChain(checker, repair)
+	if err != nil {
+		fmt.Println("Verify chain fail: ", err)
+		return
+	}
+	fmt.Printf("Verified %d blocks, %d transactions with unspent outputs\n", result.Blocks, result.UTXOs)
+	if result.Diverged == nil {
+		fmt.Println("Chain is consistent")
+		return
+	}
+	fmt.Println("First divergence: ", result.Diverged)
+	if result.Repaired {
+		fmt.Println("UTXO set rebuilt from blocks")
+	}
+}
+
+// submitTransaction adds a transaction to the local transaction pool and broadcasts it to connected peers
+func (c *Client) submitTransaction(tx *blockchain.Transaction) {
+	var err error
+	// pBFT clients send a request and wait for f+1 matching replies
+	if c.usePBFTRequest() {
+		err = c.requests.Submit(tx, func(reply *consensus.ReplyMessage, count int) {
+			fmt.Printf("\nTransaction %x committed, height: %d, block: %x, replies: %d\n> ", reply.TxID, reply.Height, reply.BlockHash, count)
+		})
+		if err != nil {
+			c.log.Println("Submit request failed: ", err)
+		}
+		return
+	}
 
 	// Add the transaction to the local transaction pool
 	c.txPool.AddTransaction(tx)
 
 	// Marshal the transaction to JSON for broadcasting
-	txByte, err := json.Marshal(tx)
+	txByte, err := codec.Marshal(tx)
 	if err != nil {
 		c.log.Println("Marshal transaction failed")
 		return // If marshaling fails, exit function
@@ -171,7 +459,7 @@ func (c *Client) createTransaction(amount int, to []byte) {
 		Type:    pool.SendTxMsg,
 		TxBytes: txByte,
 	}
-	payload, err := json.Marshal(txMessage)
+	payload, err := codec.Marshal(txMessage)
 	if err != nil {
 		c.log.Println("Marshal TxMessage failed")
 		return // If marshaling fails, exit function
@@ -187,6 +475,11 @@ func (c *Client) createTransaction(amount int, to []byte) {
 	c.network.Broadcast(msg)
 }
 
+// usePBFTRequest checks if transactions are sent with the pBFT request protocol
+func (c *Client) usePBFTRequest() bool {
+	return c.config.PBFTCfg.Engine == "" || c.config.PBFTCfg.Engine == consensus.EnginePBFT
+}
+
 // getBalance get balance of the client
 func (c *Client) getBalance() int {
 	return blockchain.GetBalanceFromSet(c.chain.DataBase, c.wallet.GetAddress())
@@ -196,6 +489,8 @@ func (c *Client) getBalance() int {
 func (c *Client) showStatus() {
 	// Display wallet information
 	fmt.Println("Address: ", string(c.wallet.GetAddress()))
+	fmt.Println("Public Key: ", hex.EncodeToString(c.wallet.GetPublicKeyBytes()))
+	fmt.Println("BLS Key: ", hex.EncodeToString(mycrypto.DeriveBLSKey(c.wallet.GetPrivateKey()).KeyWithProof()))
 	fmt.Println("Balance: ", c.getBalance())
 
 	// Display blockchain status
@@ -203,6 +498,9 @@ func (c *Client) showStatus() {
 	fmt.Println("Height: ", c.chain.GetHeight())
 	tip := c.chain.GetTip()
 	fmt.Println("Tip: ", tip)
+	if pruned := c.chain.PrunedHeight(); pruned != 0 {
+		fmt.Println("Pruned height: ", pruned)
+	}
 	headBlock := c.chain.FindBlock(tip)
 	headBlock.Show()
 
@@ -210,15 +508,31 @@ func (c *Client) showStatus() {
 	fmt.Println("\nPoolStatus: ")
 	fmt.Println("TxPool count: ", c.txPool.Count())
 	fmt.Println("BlockPool count: ", c.blockPool.Count())
+	if height, target := c.blockPool.SyncProgress(); target > height {
+		fmt.Printf("Block sync: %d/%d\n", height, target)
+	}
 
-	// Display pBFT consensus status
-	fmt.Println("\npBFT consensus status: ")
-	if c.consensus.IsPrimary() {
+	// Display consensus status
+	status := c.consensus.Status()
+	fmt.Printf("\n%s consensus status: \n", status.Engine)
+	if status.IsPrimary {
 		fmt.Println("Is Primary Node: true")
 	} else {
 		fmt.Println("Is Primary Node: false")
 	}
-	fmt.Println("View: ", c.consensus.GetView())
+	fmt.Println("View: ", status.View)
+	if status.Difficulty != 0 {
+		fmt.Println("Difficulty: ", status.Difficulty)
+	}
+	if status.NodeNum != 0 {
+		fmt.Printf("Epoch: %d, validators: %d\n", status.Epoch, status.NodeNum)
+	}
+	if status.Timeout != 0 {
+		fmt.Println("View change timeout: ", status.Timeout)
+	}
+	if status.Syncing {
+		fmt.Println("Catching up committed blocks, voting paused")
+	}
 
 	// Display network status
 	fmt.Println("\nNetwork Status: ")
@@ -233,4 +547,8 @@ func (c *Client) Usages() {
 	fmt.Println("tx: tx <amount> <address>   create new transaction")
 	fmt.Println("s:  Show current status of block chain")
 	fmt.Println("b:  Search block by hash or height")
+	fmt.Println("gov: gov <add|remove> <public key> [power] [bls key]   vote for a validator set change")
+	fmt.Println("export: export <file>   export blocks with commit certificates to a file")
+	fmt.Println("import: import <file>   import and validate blocks from an export file")
+	fmt.Println("verifychain: verifychain [repair]   verify blocks from genesis and the UTXO set, repair replaces a diverged UTXO set")
 }
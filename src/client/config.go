@@ -21,8 +21,9 @@ type WalletCfg struct {
 }
 
 type ChainCfg struct {
-	ChainDataBasePath string `json:"chainDataBasePath"`
-	MaxTxPerBlock     int    `json:"maxTxPerBlock"`
+	Network           string `json:"network"`           // chain parameters profile: mainnet, testnet or regtest
+	ChainDataBasePath string `json:"chainDataBasePath"` // ":memory:" keeps the chain in memory for ephemeral dev nodes
+	PruneDepth        uint64 `json:"pruneDepth"`        // blocks with bodies kept below the tip, 0 keeps all blocks
 	LogPath           string `json:"logPath"`
 }
 
@@ -34,12 +35,21 @@ type P2PNetCfg struct {
 }
 
 type PBFTCfg struct {
-	IsConsensusNode bool   `json:"is_consensus_node"`
-	View            uint64 `json:"view"`
-	Index           uint64 `json:"index"`
-	NodeNum         uint64 `json:"nodeNum"`
-	MaxFaultNode    uint64 `json:"maxFaultNode"`
-	LogPath         string `json:"logPath"`
+	IsConsensusNode bool     `json:"is_consensus_node"`
+	Engine          string   `json:"engine"`        // consensus engine: pbft, hotstuff, raft, solo, pow
+	BlockInterval   uint64   `json:"blockInterval"` // solo seal interval in seconds, 0 seals when tx pool is full
+	Bits            uint32   `json:"bits"`          // pow initial difficulty bits
+	View            uint64   `json:"view"`
+	LeaderElection  string   `json:"leaderElection"` // pbft primary election: rotate or vrf, vrf needs validators
+	ViewTimeout     uint64   `json:"viewTimeout"`    // pbft initial view change timeout in seconds, 0 uses network default
+	Index           uint64   `json:"index"`
+	NodeNum         uint64   `json:"nodeNum"`
+	MaxFaultNode    uint64   `json:"maxFaultNode"`
+	Validators      []string `json:"validators"`      // pbft initial validator public keys in hex by index, required by hotstuff and to verify certificates of peers
+	ValidatorPowers []uint64 `json:"validatorPowers"` // voting power of each initial validator, empty gives power 1
+	ValidatorBLS    []string `json:"validatorBls"`    // BLS key with proof of possession of each initial validator in hex, empty for ECDSA only
+	SignatureScheme string   `json:"signatureScheme"` // pbft commit certificate signature: ecdsa or bls, bls needs validator BLS keys
+	LogPath         string   `json:"logPath"`
 }
 
 type TxPoolCfg struct {
@@ -49,6 +59,7 @@ type TxPoolCfg struct {
 
 type BlockPoolCfg struct {
 	BlockPoolFull int    `json:"blockPoolFull"`
+	FastSync      bool   `json:"fastSync"` // synchronize an empty chain from a state snapshot committed by pBFT, requires validators
 	LogPath       string `json:"logPath"`
 }
 
@@ -111,8 +122,8 @@ func DefaultConfig() *Config {
 			PriKeyPath: "./wallet/private_key.pem",
 		},
 		ChainCfg: ChainCfg{
+			Network:           "mainnet",
 			ChainDataBasePath: "./database",
-			MaxTxPerBlock:     9,
 			LogPath:           "./log/chain.log",
 		},
 		P2PNetCfg: P2PNetCfg{
@@ -123,7 +134,11 @@ func DefaultConfig() *Config {
 		},
 		PBFTCfg: PBFTCfg{
 			IsConsensusNode: false,
+			Engine:          "pbft",
+			BlockInterval:   0,
+			Bits:            0,
 			View:            0,
+			ViewTimeout:     0,
 			Index:           0,
 			NodeNum:         0,
 			MaxFaultNode:    0,
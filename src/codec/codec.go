@@ -0,0 +1,366 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Version is the first byte of every encoded value, decoding other versions fails
+const Version = 1
+
+// Encoding rules, values are written in this order without field names:
+//   - bool: one byte, 0 or 1
+//   - uint8..uint64, int8..int64: big endian of the type size, int and uint use 8 bytes
+//   - string, []byte: uvarint length and the bytes, nil and empty slices encode the same
+//   - slice: uvarint count and the elements, array: the elements
+//   - pointer: byte 0 for nil, byte 1 followed by the value
+//   - struct: exported fields in declaration order, fields tagged `codec:"-"` are skipped
+//   - map: uvarint count and the entries sorted by encoded key
+//
+// Floats, interfaces, channels and functions can not be encoded.
+// Decoding is canonical: lengths must be minimal uvarints and map keys strictly increasing,
+// so every value has exactly one encoding.
+
+// Marshal encodes v, pointers at the top level are followed to the value
+func Marshal(v interface{}) ([]byte, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, errors.New("codec: marshal nil value")
+		}
+		value = value.Elem()
+	}
+	buf := bytes.NewBuffer([]byte{Version})
+	if err := encode(buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes data into v, which must be a non-nil pointer,
+// nil pointers below it are allocated and all data must be consumed
+func Unmarshal(data []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("codec: unmarshal target must be a non-nil pointer")
+	}
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	if len(data) == 0 || data[0] != Version {
+		return errors.New("codec: unsupported encoding version")
+	}
+	d := &decoder{data: data[1:]}
+	if err := d.decode(value); err != nil {
+		return err
+	}
+	if len(d.data) != 0 {
+		return errors.New("codec: trailing data")
+	}
+	return nil
+}
+
+func skipField(field reflect.StructField) bool {
+	return field.PkgPath != "" || field.Tag.Get("codec") == "-"
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Uint8:
+		buf.WriteByte(uint8(v.Uint()))
+	case reflect.Uint16:
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(v.Uint())))
+	case reflect.Uint32:
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v.Uint())))
+	case reflect.Uint64, reflect.Uint:
+		buf.Write(binary.BigEndian.AppendUint64(nil, v.Uint()))
+	case reflect.Int8:
+		buf.WriteByte(uint8(v.Int()))
+	case reflect.Int16:
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(v.Int())))
+	case reflect.Int32:
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v.Int())))
+	case reflect.Int64, reflect.Int:
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(v.Int())))
+	case reflect.String:
+		writeBytes(buf, []byte(v.String()))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeBytes(buf, v.Bytes())
+			return nil
+		}
+		buf.Write(binary.AppendUvarint(nil, uint64(v.Len())))
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteByte(0)
+			return nil
+		}
+		buf.WriteByte(1)
+		return encode(buf, v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if skipField(v.Type().Field(i)) {
+				continue
+			}
+			if err := encode(buf, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// entries are sorted by encoded key so equal maps encode the same
+		entries := make([][2][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var key, value bytes.Buffer
+			if err := encode(&key, iter.Key()); err != nil {
+				return err
+			}
+			if err := encode(&value, iter.Value()); err != nil {
+				return err
+			}
+			entries = append(entries, [2][]byte{key.Bytes(), value.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i][0], entries[j][0]) < 0
+		})
+		buf.Write(binary.AppendUvarint(nil, uint64(len(entries))))
+		for _, entry := range entries {
+			buf.Write(entry[0])
+			buf.Write(entry[1])
+		}
+	default:
+		return fmt.Errorf("codec: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	buf.Write(data)
+}
+
+type decoder struct {
+	data []byte
+}
+
+var errShort = errors.New("codec: unexpected end of data")
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.data)) < n {
+		return nil, errShort
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// length reads a minimal uvarint count of items taking at least min bytes each,
+// so the items allocated are bounded by the remaining data
+func (d *decoder) length(min uint64) (int, error) {
+	n, size := binary.Uvarint(d.data)
+	if size == 0 {
+		return 0, errShort
+	}
+	if size < 0 || size != len(binary.AppendUvarint(nil, n)) {
+		return 0, errors.New("codec: overlong or non-minimal length")
+	}
+	d.data = d.data[size:]
+	if min == 0 {
+		min = 1
+	}
+	if n > uint64(len(d.data))/min {
+		return 0, errShort
+	}
+	return int(n), nil
+}
+
+// minSize returns the fewest bytes an encoded value of type t takes
+func minSize(t reflect.Type) uint64 {
+	switch t.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		return intSize(t.Kind())
+	case reflect.Array:
+		return uint64(t.Len()) * minSize(t.Elem())
+	case reflect.Struct:
+		var size uint64
+		for i := 0; i < t.NumField(); i++ {
+			if !skipField(t.Field(i)) {
+				size += minSize(t.Field(i).Type)
+			}
+		}
+		return size
+	}
+	// bool, pointer flag and the length of strings, slices and maps
+	return 1
+}
+
+func (d *decoder) uint(size uint64) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func intSize(kind reflect.Kind) uint64 {
+	switch kind {
+	case reflect.Uint8, reflect.Int8:
+		return 1
+	case reflect.Uint16, reflect.Int16:
+		return 2
+	case reflect.Uint32, reflect.Int32:
+		return 4
+	}
+	return 8
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		if b > 1 {
+			return errors.New("codec: invalid bool")
+		}
+		v.SetBool(b == 1)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		n, err := d.uint(intSize(v.Kind()))
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
+		size := intSize(v.Kind())
+		n, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// sign extend from the encoded size
+		shift := 64 - 8*size
+		v.SetInt(int64(n<<shift) >> shift)
+	case reflect.String:
+		n, err := d.length(1)
+		if err != nil {
+			return err
+		}
+		b, _ := d.next(uint64(n))
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := d.length(minSize(v.Type().Elem()))
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := d.next(uint64(n))
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		present, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		switch present {
+		case 0:
+			v.Set(reflect.Zero(v.Type()))
+		case 1:
+			elem := reflect.New(v.Type().Elem())
+			if err := d.decode(elem.Elem()); err != nil {
+				return err
+			}
+			v.Set(elem)
+		default:
+			return errors.New("codec: invalid pointer flag")
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if skipField(v.Type().Field(i)) {
+				continue
+			}
+			if err := d.decode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length(minSize(v.Type().Key()) + minSize(v.Type().Elem()))
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		var last []byte
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			start := d.data
+			if err := d.decode(key); err != nil {
+				return err
+			}
+			// encoded keys are sorted and unique
+			encoded := start[:len(start)-len(d.data)]
+			if i > 0 && bytes.Compare(last, encoded) >= 0 {
+				return errors.New("codec: map keys not sorted or duplicated")
+			}
+			last = encoded
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("codec: unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package codec
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Name string
+	Tags []string
+}
+
+type sample struct {
+	Flag    bool
+	Small   uint8
+	Signed  int32
+	Size    int
+	Data    []byte
+	Empty   []byte
+	Inner   *inner
+	Missing *inner
+	Items   []inner
+	Fixed   [2]uint16
+	Counts  map[string]uint64
+	Skipped string `codec:"-"`
+	hidden  int
+}
+
+func TestMarshal_Golden(t *testing.T) {
+	v := &sample{
+		Flag:    true,
+		Small:   7,
+		Signed:  -2,
+		Size:    300,
+		Data:    []byte{0xde, 0xad},
+		Empty:   []byte{},
+		Inner:   &inner{Name: "a", Tags: []string{"x"}},
+		Items:   []inner{{Name: "b"}},
+		Fixed:   [2]uint16{1, 2},
+		Counts:  map[string]uint64{"z": 1, "b": 2},
+		Skipped: "skip",
+		hidden:  1,
+	}
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := "01" + // version
+		"01" + "07" + "fffffffe" + "000000000000012c" + // Flag Small Signed Size
+		"02dead" + "00" + // Data Empty
+		"01" + "0161" + "01" + "0178" + // Inner
+		"00" + // Missing
+		"01" + "0162" + "00" + // Items
+		"0001" + "0002" + // Fixed
+		"02" + "0162" + "0000000000000002" + "017a" + "0000000000000001" // Counts sorted by key
+	fmt.Println("Encoded: ", hex.EncodeToString(data))
+	if hex.EncodeToString(data) != golden {
+		t.Fatal("encoding not match golden vector")
+	}
+
+	var decoded sample
+	if err := Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	v.Empty, v.Skipped, v.hidden = nil, "", 0
+	if !reflect.DeepEqual(&decoded, v) {
+		t.Fatal("decoded value not match")
+	}
+
+	// truncated data, trailing data and other versions are rejected
+	if Unmarshal(data[:len(data)-1], &decoded) == nil || Unmarshal(append(data, 0), &decoded) == nil {
+		t.Fatal("decode invalid length")
+	}
+	data[0] = Version + 1
+	if Unmarshal(data, &decoded) == nil {
+		t.Fatal("decode other version")
+	}
+}
+
+func TestUnmarshal_Malformed(t *testing.T) {
+	decode := func(name, data string, v interface{}) {
+		b, _ := hex.DecodeString(data)
+		err := Unmarshal(b, v)
+		fmt.Printf("%s: %v\n", name, err)
+		if err == nil {
+			t.Fatal("decode malformed data: ", name)
+		}
+	}
+	var s string
+	var m map[string]uint64
+	var items []inner
+	var fixed [][4]uint64
+	// "a" with its length padded to two bytes
+	decode("non-minimal length", "01"+"8100"+"61", &s)
+	decode("overlong length", "01"+"ffffffffffffffffffff01", &s)
+	decode("unsorted keys", "01"+"02"+"017a"+"0000000000000001"+"0162"+"0000000000000002", &m)
+	decode("duplicate keys", "01"+"02"+"0162"+"0000000000000001"+"0162"+"0000000000000002", &m)
+	// counts larger than the remaining data can hold are refused before allocation
+	decode("oversized slice", "01"+"04"+"00000000", &items)
+	decode("oversized array elements", "01"+"02"+"0000000000000000000000000000000000000000000000000000000000000000", &fixed)
+
+	// the canonical forms decode
+	b, _ := hex.DecodeString("01" + "02" + "0162" + "0000000000000002" + "017a" + "0000000000000001")
+	if err := Unmarshal(b, &m); err != nil || m["b"] != 2 || m["z"] != 1 {
+		t.Fatal("decode sorted map fail")
+	}
+}
@@ -0,0 +1,69 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/mycrypto"
+	"bytes"
+	"errors"
+)
+
+// Commit certificate signature schemes of pBFT
+const (
+	SchemeECDSA = "ecdsa" // certificate keeps the ECDSA signature of each validator
+	SchemeBLS   = "bls"   // certificate keeps one aggregate BLS signature and a validator bitmap
+)
+
+// SetSignatureScheme selects the signature scheme of stored commit certificates,
+// BLS needs the BLS key of every validator on chain
+func (pbft *PBFT) SetSignatureScheme(scheme string) error {
+	switch scheme {
+	case "", SchemeECDSA:
+		return nil
+	case SchemeBLS:
+	default:
+		return errors.New("unknown signature scheme: " + scheme)
+	}
+	pbft.lock.Lock()
+	defer pbft.lock.Unlock()
+	if !pbft.dynamic || !pbft.validatorSet.HasBLS() {
+		return errors.New("bls signature scheme needs validator bls keys")
+	}
+	blsKey := mycrypto.DeriveBLSKey(pbft.privateKey)
+	if pbft.isValidator && !bytes.Equal(pbft.validatorSet.BLSKeyOf(mycrypto.PublicKey2Bytes(pbft.publicKey)), blsKey.PublicKey()) {
+		return errors.New("bls key not match validator set")
+	}
+	pbft.blsKey = blsKey
+	pbft.useBLS = true
+	return nil
+}
+
+// signBLS signs the commit certificate digest of a block with BLS key, nil if BLS is not used
+func (pbft *PBFT) signBLS(hash []byte, view uint64) []byte {
+	if !pbft.useBLS {
+		return nil
+	}
+	return pbft.blsKey.Sign(blockchain.CertDigest(hash, view))
+}
+
+// verifyBLS checks the BLS signature of a sign or commit message when BLS is used
+func (pbft *PBFT) verifyBLS(pubKey, hash []byte, view uint64, sign []byte) bool {
+	if !pbft.useBLS {
+		return true
+	}
+	blsKey := pbft.validatorSet.BLSKeyOf(pubKey)
+	return blsKey != nil && mycrypto.BLSVerify(blsKey, blockchain.CertDigest(hash, view), sign)
+}
+
+// commitCert builds the commit certificate of a block, aggregated into one BLS signature when BLS is used
+func (pbft *PBFT) commitCert(seq uint64, hash []byte) *blockchain.QuorumCert {
+	cert := pbft.msgLog.CommitCert(seq, pbft.view, hash)
+	if !pbft.useBLS {
+		return cert
+	}
+	compact, err := pbft.validatorSet.CompactCert(cert)
+	if err != nil {
+		pbft.log.Println("Aggregate commit certificate fail: ", err)
+		return cert
+	}
+	return compact
+}
@@ -0,0 +1,279 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/mycrypto"
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// CatchUpRetries is the number of unanswered catch up requests before giving up
+const CatchUpRetries = 3
+
+// checkCatchUp detects the node is behind from signed messages of validators and pauses voting
+// until the missing committed blocks are fetched, returns true if the message is consumed
+func (pbft *PBFT) checkCatchUp(msg *PBFTMessage) bool {
+	data, t := msg.SplitMessage()
+	switch t {
+	case CatchUpResponseMsg:
+		response := data.(CatchUpResponseMessage)
+		pbft.handleCatchUpResponse(&response)
+		return true
+	case PrepareMsg:
+		prepare := data.(PrepareMessage)
+		if prepare.Height > pbft.chain.GetHeight()+SequenceWindow {
+			// proposal extends blocks we do not have yet
+			if !pbft.verifyAheadPrepare(&prepare) {
+				pbft.log.Println("Drop unverified proposal ahead of chain from: ", prepare.ID)
+				return true
+			}
+			pbft.pendingPrepare = msg
+			if target := pbft.reportHeight(prepare.PubKey, prepare.Height-SequenceWindow); target > 0 {
+				pbft.log.Printf("Proposal height %d ahead of chain height %d, catch up", prepare.Height, pbft.chain.GetHeight())
+				pbft.startCatchUp(target)
+			}
+			return true
+		}
+		if pbft.isSyncing {
+			pbft.pendingPrepare = msg
+			return true
+		}
+	case ViewChangeMsg:
+		viewChange := data.(ViewChangeMessage)
+		pbft.lock.Lock()
+		idle := !pbft.isRunning
+		pbft.lock.Unlock()
+		if idle && viewChange.Height > pbft.chain.GetHeight() && pbft.signedByValidator(viewChange.PubKey, viewChange.Digest(), viewChange.Sign) {
+			// peers committed blocks we missed
+			if target := pbft.reportHeight(viewChange.PubKey, viewChange.Height); target > 0 {
+				pbft.log.Printf("Peer height %d ahead of chain height %d, catch up", target, pbft.chain.GetHeight())
+				pbft.startCatchUp(target)
+				return true
+			}
+		}
+	}
+	// voting is paused while catching up
+	return pbft.isSyncing
+}
+
+// verifyAheadPrepare checks a prepare message above the sequence window is signed by a validator
+// and its height is the height of the signed block
+func (pbft *PBFT) verifyAheadPrepare(prepare *PrepareMessage) bool {
+	if !pbft.signedByValidator(prepare.PubKey, blockchain.CertDigest(prepare.BlockHash, prepare.View), prepare.Sign) {
+		return false
+	}
+	var block blockchain.Block
+	if err := codec.Unmarshal(prepare.Block, &block); err != nil || block.Header == nil {
+		return false
+	}
+	return block.Header.Height == prepare.Height && bytes.Equal(block.CalculateHash(), prepare.BlockHash)
+}
+
+// signedByValidator checks a digest is signed by a validator of current epoch,
+// nodes without configured validator public keys trust no signer
+func (pbft *PBFT) signedByValidator(pubKey, digest, sign []byte) bool {
+	if !pbft.dynamic || pbft.validatorSet.Power(pubKey) == 0 {
+		return false
+	}
+	return mycrypto.Verify(mycrypto.Bytes2PublicKey(pubKey), digest, sign)
+}
+
+// reportHeight records a chain height above ours reported by a validator, returns the highest height
+// reached by validators with more than 1/3 of voting power, at least one of them is honest, 0 if none
+func (pbft *PBFT) reportHeight(pubKey []byte, height uint64) uint64 {
+	pbft.aheadHeights[hex.EncodeToString(pubKey)] = height
+	type report struct {
+		height uint64
+		power  uint64
+	}
+	local := pbft.chain.GetHeight()
+	var reports []report
+	for key, h := range pbft.aheadHeights {
+		validator, _ := hex.DecodeString(key)
+		if power := pbft.validatorSet.Power(validator); power > 0 && h > local {
+			reports = append(reports, report{height: h, power: power})
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].height > reports[j].height })
+	var power uint64
+	for _, r := range reports {
+		power += r.power
+		if power*3 > pbft.validatorSet.TotalPower() {
+			return r.height
+		}
+	}
+	return 0
+}
+
+// startCatchUp pauses voting and requests committed blocks up to target height
+func (pbft *PBFT) startCatchUp(target uint64) {
+	if target > pbft.syncTarget || !pbft.isSyncing {
+		pbft.syncTarget = target
+	}
+	if pbft.isSyncing {
+		return
+	}
+	pbft.lock.Lock()
+	pbft.isSyncing = true
+	// a lagging node does not count toward view change
+	pbft.viewChangeTimer.Stop()
+	pbft.lock.Unlock()
+	pbft.syncRetries = 0
+	pbft.requestCatchUp()
+}
+
+// requestCatchUp broadcasts a request of the next batch of missing blocks
+func (pbft *PBFT) requestCatchUp() {
+	if pbft.syncRetries >= CatchUpRetries {
+		pbft.log.Println("No catch up response, give up")
+		pbft.finishCatchUp()
+		return
+	}
+	pbft.syncRetries++
+
+	from := pbft.chain.GetHeight() + 1
+	to := from + CatchUpBatch - 1
+	if to > pbft.syncTarget {
+		to = pbft.syncTarget
+	}
+	msg := CatchUpRequestMessage{
+		ID:         pbft.id,
+		FromHeight: from,
+		ToHeight:   to,
+	}
+	p2pMessage, err := pbft.packBroadcastMessage(CatchUpRequestMsg, msg)
+	if err != nil {
+		pbft.log.Println(err)
+		return
+	}
+	pbft.log.Printf("Broadcast catch up request, height: %d-%d", from, to)
+	pbft.net.Broadcast(p2pMessage)
+	pbft.catchUpTimer.Reset(CatchUpTimeout * time.Second)
+}
+
+// handleCatchUpRequest sends committed blocks with their commit certificates to the requesting peer
+func (pbft *PBFT) handleCatchUpRequest(msg *PBFTMessage, peerID string) {
+	data, _ := msg.SplitMessage()
+	request, ok := data.(CatchUpRequestMessage)
+	if !ok || request.FromHeight == 0 || request.ToHeight < request.FromHeight {
+		return
+	}
+	to := request.ToHeight
+	if to >= request.FromHeight+CatchUpBatch {
+		to = request.FromHeight + CatchUpBatch - 1
+	}
+	if height := pbft.chain.GetHeight(); to > height {
+		to = height
+	}
+	if to < request.FromHeight {
+		return
+	}
+
+	// reply in height order
+	var blocks []*CertifiedBlock
+	for _, block := range pbft.chain.FindBlocksInRange(request.FromHeight, to) {
+		cert := pbft.chain.GetCert(block.Header.Hash)
+		if cert == nil {
+			break
+		}
+		blocks = append(blocks, &CertifiedBlock{Block: block, Cert: cert})
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	response := CatchUpResponseMessage{
+		ID:     pbft.id,
+		Blocks: blocks,
+	}
+	p2pMessage, err := pbft.packBroadcastMessage(CatchUpResponseMsg, response)
+	if err != nil {
+		pbft.log.Println(err)
+		return
+	}
+	pbft.log.Printf("Send catch up response, blocks: %d", len(blocks))
+	pbft.net.BroadcastToPeer(p2pMessage, peerID)
+}
+
+// handleCatchUpResponse verifies certificates of received blocks and adds them to chain
+func (pbft *PBFT) handleCatchUpResponse(response *CatchUpResponseMessage) {
+	if !pbft.isSyncing {
+		return
+	}
+	pbft.log.Println("Receive a catch up response from: ", response.ID)
+	progress := false
+	for _, certified := range response.Blocks {
+		block, cert := certified.Block, certified.Cert
+		if block == nil || block.Header == nil || cert == nil {
+			break
+		}
+		if block.Header.Height != pbft.chain.GetHeight()+1 {
+			// already added from another response
+			continue
+		}
+		if !bytes.Equal(cert.BlockHash, block.Header.Hash) || cert.Height != block.Header.Height {
+			pbft.log.Println("Catch up block not match certificate")
+			break
+		}
+		if !pbft.verifyCert(cert) {
+			pbft.log.Println("Invalid commit certificate")
+			break
+		}
+		if err := pbft.VerifyBlock(block); err != nil {
+			pbft.log.Println("Verify catch up block fail: ", err)
+			break
+		}
+		if !pbft.blockPool.CommitBlock(block) {
+			pbft.log.Println("Add catch up block to chain fail, height: ", block.Header.Height)
+			break
+		}
+		if err := pbft.chain.AddCert(cert); err != nil {
+			pbft.log.Println("Store commit certificate fail: ", err)
+		}
+		for _, tx := range block.Transactions {
+			pbft.txPool.RemoveTransaction(hex.EncodeToString(tx.ID))
+		}
+		pbft.replyRequests(block)
+		pbft.reconfigure()
+		progress = true
+	}
+	if !progress {
+		return
+	}
+
+	pbft.syncRetries = 0
+	pbft.lock.Lock()
+	pbft.resetViewTimeout()
+	pbft.lock.Unlock()
+	if pbft.chain.GetHeight() >= pbft.syncTarget {
+		pbft.finishCatchUp()
+	} else {
+		pbft.requestCatchUp()
+	}
+}
+
+// finishCatchUp resumes voting in the current view
+func (pbft *PBFT) finishCatchUp() {
+	pbft.catchUpTimer.Stop()
+	pbft.ResetState()
+	// instances of caught up sequence numbers are done
+	pbft.pruneExecuted()
+	pbft.lock.Lock()
+	pbft.isSyncing = false
+	pbft.aheadHeights = make(map[string]uint64)
+	pbft.isRunning = pbft.runningInstances() > 0
+	// primary node of the next height in current view
+	pbft.leaderIndex = pbft.leaderOf(pbft.chain.BestHeight + 1)
+	pbft.isPrimary = pbft.leaderIndex == pbft.index
+	pbft.lock.Unlock()
+	pbft.log.Println("Catch up finished, chain height: ", pbft.chain.GetHeight())
+
+	// handle the proposal which triggered the catch up
+	pending := pbft.pendingPrepare
+	pbft.pendingPrepare = nil
+	if pending != nil && !pbft.checkCatchUp(pending) {
+		pbft.NextState(pending)
+	}
+}
@@ -0,0 +1,61 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	p2pnet "BlockChain/src/network"
+	"bytes"
+	"errors"
+	"time"
+)
+
+// Names of the supported consensus engines
+const (
+	EnginePBFT     = "pbft"     // classic pBFT with rotating primary
+	EngineSolo     = "solo"     // single node engine for local development
+	EnginePoW      = "pow"      // proof of work with difficulty retargeting
+	EngineHotStuff = "hotstuff" // chained HotStuff with pipelined certificates
+	EngineRaft     = "raft"     // crash fault tolerant Raft for a single trust domain
+)
+
+// Engine is the interface implemented by every consensus algorithm
+type Engine interface {
+	Run()                                                           // run engine main loop
+	Start()                                                         // start handling messages
+	Stop()                                                          // stop handling messages
+	OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) // consensus message callback
+	Status() *Status                                                // current engine status
+	VerifyBlock(block *blockchain.Block) error                      // check a block received from peers
+}
+
+// Status describes the runtime state of a consensus engine
+type Status struct {
+	Engine     string        // engine name
+	IsPrimary  bool          // flag of block producing node
+	View       uint64        // current view
+	Difficulty uint32        // proof of work difficulty bits of next block
+	Syncing    bool          // flag of catching up committed blocks, voting paused
+	Timeout    time.Duration // current view change timeout
+	Epoch      uint64        // epoch of on chain validator set
+	NodeNum    uint64        // number of validators
+}
+
+// verifyContent checks a block matches its hash and merkle root and carries valid transactions,
+// only proof of work mints coins, blocks of the other engines have no coinbase
+func verifyContent(chain *blockchain.Chain, block *blockchain.Block) error {
+	if !bytes.Equal(block.CalculateHash(), block.Header.Hash) {
+		return errors.New("block hash not match")
+	}
+	if !bytes.Equal(block.Header.MerkleRoot, block.CalculateMerkleRoot()) {
+		return errors.New("merkle root not match")
+	}
+	if block.IsGenesisBlock() {
+		return nil
+	}
+	if err := blockchain.CheckCoinbase(block, 0); err != nil {
+		return err
+	}
+	if !blockchain.VerifyTransactions(chain, block.Transactions) {
+		return errors.New("tx verify error")
+	}
+	return nil
+}
@@ -2,11 +2,11 @@ package consensus
 
 import (
 	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	"BlockChain/src/mycrypto"
 	p2pnet "BlockChain/src/network"
 	"bytes"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"sync"
 	"time"
@@ -21,9 +21,25 @@ const (
 	ViewChangeState
 )
 
+// Instance consensus instance of one sequence number, the sequence number is the block height
+type Instance struct {
+	seq       uint64            // sequence number
+	state     State             // state of the instance
+	block     *blockchain.Block // prepared block
+	committed bool              // committed locally, waiting for lower sequence numbers to execute
+}
+
+// bufferKey identifies a buffered prepare message by its view and sequence number
+type bufferKey struct {
+	view uint64
+	seq  uint64
+}
+
 // PBFTEngine pBFT consensus engine
 type PBFTEngine struct {
-	currentState State // current state of pBFT node
+	currentState State                      // current state of pBFT node, normal or view change
+	instances    map[uint64]*Instance       // running instances in the sequence window
+	buffered     map[bufferKey]*PBFTMessage // verified prepare messages waiting for the parent block
 	lock         sync.Mutex
 }
 
@@ -31,134 +47,339 @@ type PBFTEngine struct {
 func NewEngine() *PBFTEngine {
 	return &PBFTEngine{
 		currentState: PrePrepareState, // initialize state
+		instances:    make(map[uint64]*Instance),
+		buffered:     make(map[bufferKey]*PBFTMessage),
 	}
 }
 
 // NextState run engine change state when receive a PBFTMessage
 func (pbft *PBFT) NextState(msg *PBFTMessage) {
 	data, _ := msg.SplitMessage()
-	switch pbft.engine.currentState {
-	case PrePrepareState:
-		// node in this state wait primary node prepare message
-		if prepare, ok := data.(PrepareMessage); ok {
-			// receive prepare message from primary node
-			// set status and start consensus
-			pbft.lock.Lock()
-			pbft.isRunning = true
-			// receive primary prepare message, start timer
-			pbft.log.Println("Start view change timer")
-			pbft.viewChangeTimer.Reset(ViewTimeout * time.Second)
-			pbft.lock.Unlock()
-
-			next, err := pbft.handlePrepare(&prepare)
-			if next {
-				// change state to prepare state, wait sign message
-				pbft.SetState(PrepareState)
-			} else if err != nil {
-				pbft.log.Println(err)
-			}
-		} else if viewChange, ok := data.(ViewChangeMessage); ok {
-			// receive view change message
-			pbft.handleViewChange(&viewChange)
-		}
-	case PrepareState:
-		if sign, ok := data.(SignMessage); ok {
-			next, err := pbft.handleSign(&sign)
-			if next {
-				// change state to commit state
-				pbft.SetState(CommitState)
-			} else if err != nil {
-				pbft.log.Println(err)
-			}
-		} else if viewChange, ok := data.(ViewChangeMessage); ok {
-			// receive view change message
-			pbft.handleViewChange(&viewChange)
-		}
-	case CommitState:
-		if commit, ok := data.(CommitMessage); ok {
-			next, err := pbft.handleCommit(&commit)
-			if next {
-				pbft.log.Println("Finish consensus")
-				// finish consensus, reset State
-				pbft.ResetState()
-				// update status
-				pbft.lock.Lock()
-				// change primary node
-				pbft.leaderIndex = (pbft.view + pbft.chain.BestHeight) % pbft.nodeNum
-				if pbft.leaderIndex == pbft.index {
-					pbft.isPrimary = true
-				} else {
-					pbft.isPrimary = false
-				}
-				// stop running
-				pbft.viewChangeTimer.Stop()
-				pbft.isRunning = false
-				// clear log cache
-				if pbft.chain.BestHeight%pbft.nodeNum == 0 {
-					pbft.msgLog.ClearLog()
-				}
-				pbft.lock.Unlock()
-			} else if err != nil {
-				pbft.log.Println(err)
-			}
-		} else if viewChange, ok := data.(ViewChangeMessage); ok {
-			// receive view change message
-			pbft.handleViewChange(&viewChange)
-		}
-	case ViewChangeState:
+	if !pbft.isValidator {
+		// not in current validator set, blocks come from catch up
+		return
+	}
+	if pbft.GetState() == ViewChangeState {
+		// only view change messages are handled during view change
 		if viewChange, ok := data.(ViewChangeMessage); ok {
-			// receive view change message
 			next, err := pbft.handleViewChange(&viewChange)
 			if next {
-				// reset state
-				pbft.ResetState()
-				// update status
-				pbft.lock.Lock()
-				// change primary node
-				pbft.leaderIndex = (pbft.view + pbft.chain.BestHeight) % pbft.nodeNum
-				if pbft.leaderIndex == pbft.index {
-					pbft.isPrimary = true
-				} else {
-					pbft.isPrimary = false
-				}
-				// stop running
-				pbft.log.Println("view change timeout, running view change")
-				pbft.viewChangeTimer.Stop()
-				pbft.isRunning = false
-				// clear log cache
-				pbft.msgLog.ClearLog()
-				pbft.lock.Unlock()
+				pbft.log.Println("Finish view change")
+				pbft.finishViewChange()
 			} else if err != nil {
 				pbft.log.Println(err)
 			}
-
 		}
+		return
+	}
+	switch m := data.(type) {
+	case PrepareMessage:
+		pbft.onPrepare(&m, msg)
+	case SignMessage:
+		if _, err := pbft.handleSign(&m); err != nil {
+			pbft.log.Println(err)
+		}
+	case CommitMessage:
+		next, err := pbft.handleCommit(&m)
+		if next {
+			pbft.executeCommitted()
+		} else if err != nil {
+			pbft.log.Println(err)
+		}
+	case ViewChangeMessage:
+		pbft.handleViewChange(&m)
 	default:
 		pbft.log.Println("Unknown message")
 	}
 }
 
-func (pbft *PBFT) handlePrepare(prepare *PrepareMessage) (bool, error) {
+// onPrepare starts the instance of a prepare message once its parent block is known,
+// a verified prepare arriving before the one of the previous sequence number is buffered
+func (pbft *PBFT) onPrepare(prepare *PrepareMessage, msg *PBFTMessage) {
+	if err := pbft.checkWindow(prepare.Height); err != nil {
+		pbft.log.Println(err)
+		return
+	}
+	parent := pbft.parentHash(prepare.Height)
+	if parent == nil {
+		if err := pbft.verifyPrepare(prepare); err != nil {
+			pbft.log.Println(err)
+			return
+		}
+		pbft.log.Println("Buffer prepare message, sequence: ", prepare.Height)
+		key := bufferKey{view: prepare.View, seq: prepare.Height}
+		pbft.engine.lock.Lock()
+		if _, ok := pbft.engine.buffered[key]; !ok {
+			pbft.engine.buffered[key] = msg
+		}
+		pbft.engine.lock.Unlock()
+		return
+	}
+
+	next, err := pbft.handlePrepare(prepare, parent)
+	if !next {
+		if err != nil {
+			pbft.log.Println(err)
+		}
+		return
+	}
+	// receive primary prepare message, start timer
+	pbft.lock.Lock()
+	pbft.isRunning = true
+	pbft.log.Println("Start view change timer")
+	pbft.viewChangeTimer.Reset(pbft.viewTimeout)
+	pbft.lock.Unlock()
+
+	// sign and commit messages may arrive before the prepare message
+	if prepared, err := pbft.checkPrepared(prepare.Height); err != nil {
+		pbft.log.Println(err)
+	} else if prepared && pbft.checkCommitted(prepare.Height) {
+		pbft.executeCommitted()
+	}
+
+	// the next sequence number can be prepared now
+	key := bufferKey{view: pbft.view, seq: prepare.Height + 1}
+	pbft.engine.lock.Lock()
+	buffered, ok := pbft.engine.buffered[key]
+	delete(pbft.engine.buffered, key)
+	pbft.engine.lock.Unlock()
+	if ok && pbft.GetState() != ViewChangeState {
+		pbft.NextState(buffered)
+	}
+	if pbft.txPool.IsFull() {
+		pbft.tryPropose()
+	}
+}
+
+// checkWindow checks the sequence number is in the window of running instances
+func (pbft *PBFT) checkWindow(seq uint64) error {
+	height := pbft.chain.GetHeight()
+	if seq <= height {
+		return errors.New("expired sequence number")
+	} else if seq > height+SequenceWindow {
+		return errors.New("sequence number out of window")
+	} else if pbft.dynamic && seq > (height/blockchain.EpochLength+1)*blockchain.EpochLength {
+		// instances of the next epoch wait for the new validator set
+		return errors.New("sequence number in next epoch")
+	}
+	return nil
+}
+
+// parentHash returns the parent block hash of a sequence number, nil if not prepared yet
+func (pbft *PBFT) parentHash(seq uint64) []byte {
+	if seq == pbft.chain.GetHeight()+1 {
+		return pbft.chain.Tip
+	}
+	inst := pbft.getInstance(seq - 1)
+	if inst == nil || inst.block == nil {
+		return nil
+	}
+	return inst.block.Header.Hash
+}
+
+// nextSequence returns the first sequence number without a prepared block
+func (pbft *PBFT) nextSequence() uint64 {
+	seq := pbft.chain.GetHeight() + 1
+	for {
+		inst := pbft.getInstance(seq)
+		if inst == nil || inst.block == nil {
+			return seq
+		}
+		seq++
+	}
+}
+
+// leaderOf returns the primary node index of a sequence number in current view,
+// primary nodes of an on chain validator set rotate in proportion to voting power
+func (pbft *PBFT) leaderOf(seq uint64) uint64 {
+	if pbft.useVRF {
+		return pbft.vrfLeader(seq)
+	}
+	if pbft.dynamic {
+		return pbft.validatorSet.Proposer(pbft.view + seq - 1)
+	}
+	return (pbft.view + seq - 1) % pbft.nodeNum
+}
+
+// tryPropose packs a block of the next sequence number if this node is its primary
+func (pbft *PBFT) tryPropose() {
+	if pbft.GetState() == ViewChangeState || pbft.isSyncing || !pbft.isValidator {
+		return
+	}
+	seq := pbft.nextSequence()
+	if pbft.leaderOf(seq) != pbft.index || pbft.checkWindow(seq) != nil || pbft.txPool.Count() == 0 {
+		return
+	}
+	parent := pbft.parentHash(seq)
+	if parent == nil {
+		return
+	}
+
+	// primary node pack Txs into block and send prepare message
+	msg, err := pbft.PBFTSealer(parent, seq)
+	if err != nil {
+		pbft.log.Println(err)
+		return
+	}
+	// serialize PBFTMessage
+	serialized, err := codec.Marshal(msg)
+	if err != nil {
+		pbft.log.Println(err)
+		return
+	}
+	// pack P2P network message
+	p2pMessage := &p2pnet.Message{
+		Type: p2pnet.ConsensusMsg,
+		Data: serialized,
+	}
+	// broadcast
+	pbft.log.Println("Broadcast prepare message, sequence: ", seq)
+	pbft.net.Broadcast(p2pMessage)
+	time.Sleep(10 * time.Millisecond)
+
+	// send prepare message to self engine
+	pbft.NextState(msg)
+}
+
+// executeCommitted adds committed blocks to chain in sequence number order
+func (pbft *PBFT) executeCommitted() {
+	executed := false
+	for {
+		seq := pbft.chain.GetHeight() + 1
+		inst := pbft.getInstance(seq)
+		if inst == nil || !inst.committed {
+			break
+		}
+		pbft.log.Println("Add block to chain, sequence: ", seq)
+		if !pbft.blockPool.CommitBlock(inst.block) {
+			pbft.log.Println("Add block to chain fail, sequence: ", seq)
+			break
+		}
+
+		// reply client requests committed in the block
+		pbft.replyRequests(inst.block)
+
+		// store commit certificate for lagging nodes
+		err := pbft.chain.AddCert(pbft.commitCert(seq, inst.block.Header.Hash))
+		if err != nil {
+			pbft.log.Println("Store commit certificate fail: ", err)
+		}
+		// observers fetch the block without waiting for sync polling
+		pbft.blockPool.AnnounceBlock(inst.block)
+
+		pbft.engine.lock.Lock()
+		delete(pbft.engine.instances, seq)
+		pbft.engine.lock.Unlock()
+		pbft.msgLog.Prune(seq)
+		executed = true
+		// validator set may change at epoch boundary
+		pbft.reconfigure()
+	}
+
+	pbft.pruneExecuted()
+	pbft.log.Println("Finish consensus, chain height: ", pbft.chain.GetHeight())
+	pbft.lock.Lock()
+	if executed {
+		pbft.resetViewTimeout()
+	}
+	// change primary node
+	pbft.leaderIndex = pbft.leaderOf(pbft.chain.BestHeight + 1)
+	pbft.isPrimary = pbft.leaderIndex == pbft.index
+	if pbft.runningInstances() == 0 {
+		// stop running
+		pbft.viewChangeTimer.Stop()
+		pbft.isRunning = false
+	} else {
+		// progress made, wait for the remaining instances
+		pbft.viewChangeTimer.Reset(pbft.viewTimeout)
+	}
+	pbft.lock.Unlock()
+	pbft.tryPropose()
+}
+
+// finishViewChange drops running instances and resumes in the new view
+func (pbft *PBFT) finishViewChange() {
+	// reset state
+	pbft.ResetState()
+	pbft.engine.lock.Lock()
+	pbft.engine.instances = make(map[uint64]*Instance)
+	pbft.engine.buffered = make(map[bufferKey]*PBFTMessage)
+	pbft.engine.lock.Unlock()
+	// update status
+	pbft.lock.Lock()
+	// change primary node
+	pbft.leaderIndex = pbft.leaderOf(pbft.chain.BestHeight + 1)
+	pbft.isPrimary = pbft.leaderIndex == pbft.index
+	// stop running
+	pbft.viewChangeTimer.Stop()
+	pbft.isRunning = false
+	// clear log cache
+	pbft.msgLog.ClearLog()
+	pbft.lock.Unlock()
+}
+
+// verifyPrepare checks a prepare message is of current view and signed by the primary node of its
+// sequence number, a primary chosen by VRF is known with the parent block only, any validator passes before
+func (pbft *PBFT) verifyPrepare(prepare *PrepareMessage) error {
+	if pbft.view != prepare.View {
+		return errors.New("not in current view")
+	}
+	if pbft.dynamic {
+		if primary := pbft.leaderOf(prepare.Height); primary < pbft.validatorSet.NodeNum() {
+			if !bytes.Equal(prepare.PubKey, pbft.validatorSet.Validators[primary]) {
+				return errors.New("prepare not from primary node")
+			}
+		} else if !pbft.isMember(prepare.PubKey) {
+			return errors.New("prepare not from validator")
+		}
+	}
+	pubKey := mycrypto.Bytes2PublicKey(prepare.PubKey)
+	if !mycrypto.Verify(pubKey, blockchain.CertDigest(prepare.BlockHash, prepare.View), prepare.Sign) {
+		return errors.New("verify signature fail")
+	}
+	return nil
+}
+
+func (pbft *PBFT) handlePrepare(prepare *PrepareMessage, parent []byte) (bool, error) {
 	pbft.log.Println("Receive a prepare message from: ", prepare.ID)
 	// verify
-	pubKey := mycrypto.Bytes2PublicKey(prepare.PubKey)
-	if pbft.view != prepare.View {
-		// check view
-		return false, errors.New("not in current view")
-	} else if !mycrypto.Verify(pubKey, prepare.BlockHash, prepare.Sign) {
-		// verify signature fail
-		return false, errors.New("verify signature fail")
-	} else if pbft.msgLog.HaveLog(PrepareMsg, prepare.ID, prepare.Height) {
+	if err := pbft.verifyPrepare(prepare); err != nil {
+		return false, err
+	} else if pbft.msgLog.HaveLog(PrepareMsg, prepare.PubKey, prepare.Height) {
 		return false, errors.New("already receive this prepare message")
+	} else if pbft.msgLog.HaveBlock(prepare.Height) {
+		return false, errors.New("sequence number already prepared")
 	} else {
 		var block blockchain.Block
-		err := json.Unmarshal(prepare.Block, &block)
-		if err != nil {
+		err := codec.Unmarshal(prepare.Block, &block)
+		if err != nil || block.Header == nil {
 			return false, errors.New("unmarshal block error")
 		}
-		if !bytes.Equal(block.Header.PrevHash, pbft.chain.Tip) {
+		// the signed hash must cover the proposed transactions
+		if !bytes.Equal(block.Header.Hash, prepare.BlockHash) || !bytes.Equal(block.CalculateHash(), prepare.BlockHash) {
+			return false, errors.New("block hash not match")
+		}
+		if !bytes.Equal(block.Header.MerkleRoot, block.CalculateMerkleRoot()) {
+			return false, errors.New("merkle root not match")
+		}
+		if err := blockchain.CheckCoinbase(&block, 0); err != nil {
+			return false, err
+		}
+		if block.Header.Height != prepare.Height {
+			return false, errors.New("block height not match")
+		}
+		if !bytes.Equal(block.Header.PrevHash, parent) {
 			return false, errors.New("block previous hash not match")
 		}
+		// the state root is required when the snapshot is stored locally
+		if root := pbft.chain.StateCommitment(block.Header.Height); (root != nil && !bytes.Equal(block.Header.StateRoot, root)) || !pbft.chain.CheckStateRoot(&block) {
+			return false, errors.New("state root not match snapshot")
+		}
+		if pbft.useVRF {
+			if err := pbft.verifyVRF(&block, prepare.PubKey); err != nil {
+				return false, err
+			}
+		}
 		if len(block.Transactions) == 0 {
 			// empty Tx, raise view change
 			pbft.viewChangeTimer.Stop()
@@ -178,12 +399,19 @@ func (pbft *PBFT) handlePrepare(prepare *PrepareMessage) (bool, error) {
 
 		// add block to log cache
 		pbft.msgLog.CacheBlock(&block)
+		pbft.engine.lock.Lock()
+		pbft.engine.instances[prepare.Height] = &Instance{
+			seq:   prepare.Height,
+			state: PrepareState,
+			block: &block,
+		}
+		pbft.engine.lock.Unlock()
 
 		// add message to cache
 		pbft.msgLog.AddMessage(PrepareMsg, *prepare)
 
 		// sign
-		signature, err := mycrypto.Sign(pbft.privateKey, prepare.BlockHash)
+		signature, err := mycrypto.Sign(pbft.privateKey, blockchain.CertDigest(prepare.BlockHash, pbft.view))
 		if err != nil {
 			return false, errors.New("sign message fail")
 		}
@@ -196,6 +424,7 @@ func (pbft *PBFT) handlePrepare(prepare *PrepareMessage) (bool, error) {
 			View:      pbft.view,
 			Sign:      signature,
 			PubKey:    mycrypto.PublicKey2Bytes(pbft.publicKey),
+			BLSSign:   pbft.signBLS(prepare.BlockHash, pbft.view),
 		}
 
 		p2pMessage, err := pbft.packBroadcastMessage(SignMsg, msg)
@@ -221,52 +450,64 @@ func (pbft *PBFT) handleSign(sign *SignMessage) (bool, error) {
 	if pbft.view != sign.View {
 		// check view
 		return false, errors.New("not in current view")
-	} else if !mycrypto.Verify(pubKey, sign.BlockHash, sign.Sign) {
+	} else if err := pbft.checkWindow(sign.Height); err != nil {
+		return false, err
+	} else if !pbft.isMember(sign.PubKey) {
+		return false, errors.New("sign message not from validator")
+	} else if !mycrypto.Verify(pubKey, blockchain.CertDigest(sign.BlockHash, sign.View), sign.Sign) {
 		// verify signature fail
 		return false, errors.New("verify signature fail")
-	} else if pbft.msgLog.HaveLog(SignMsg, sign.ID, sign.Height) {
+	} else if !pbft.verifyBLS(sign.PubKey, sign.BlockHash, sign.View, sign.BLSSign) {
+		return false, errors.New("verify bls signature fail")
+	} else if pbft.msgLog.HaveLog(SignMsg, sign.PubKey, sign.Height) {
 		// check already receive message from the peer
 		return false, errors.New("already receive this sign message")
-	} else if !pbft.msgLog.HaveBlock(sign.Height) {
-		// check cache block hash
-		return false, errors.New("unsigned block")
-	} else {
-		// add message to cache
-		pbft.msgLog.AddMessage(SignMsg, *sign)
-		pbft.log.Println("Verify sign message successfully, sign count: ", pbft.msgLog.Count(SignMsg, sign.Height))
-		if pbft.msgLog.Count(SignMsg, sign.Height) == 2*pbft.maxFaultNode+1 {
-			pbft.log.Println("Already receive enough sign message")
-			// had received enough prepare message
-
-			// get self Sign message
-			selfSign := pbft.msgLog.GetSignLog(pbft.id, sign.Height)
-
-			// pack commit message
-			msg := CommitMessage{
-				ID:        pbft.id,
-				Height:    selfSign.Height,
-				BlockHash: selfSign.BlockHash,
-				View:      pbft.view,
-				Sign:      selfSign.Sign,
-				PubKey:    mycrypto.PublicKey2Bytes(pbft.publicKey),
-			}
+	}
+	// add message to cache, it is counted once the block is prepared
+	pbft.msgLog.AddMessage(SignMsg, *sign)
+	pbft.log.Println("Verify sign message successfully, sign count: ", pbft.msgLog.Count(SignMsg, sign.Height))
+	return pbft.checkPrepared(sign.Height)
+}
 
-			p2pMessage, err := pbft.packBroadcastMessage(CommitMsg, msg)
-			if err != nil {
-				return false, err
-			}
+// checkPrepared sends commit message once the instance has enough sign messages of its block
+func (pbft *PBFT) checkPrepared(seq uint64) (bool, error) {
+	inst := pbft.getInstance(seq)
+	if inst == nil || inst.state != PrepareState {
+		return false, nil
+	}
+	if !pbft.hasQuorum(SignMsg, seq, inst.block.Header.Hash) {
+		return false, nil
+	}
+	pbft.log.Println("Already receive enough sign message, sequence: ", seq)
+	// had received enough prepare message
+
+	// get self Sign message
+	selfSign := pbft.msgLog.GetSignLog(mycrypto.PublicKey2Bytes(pbft.publicKey), seq)
+
+	// pack commit message
+	msg := CommitMessage{
+		ID:        pbft.id,
+		Height:    selfSign.Height,
+		BlockHash: selfSign.BlockHash,
+		View:      pbft.view,
+		Sign:      selfSign.Sign,
+		PubKey:    mycrypto.PublicKey2Bytes(pbft.publicKey),
+		BLSSign:   selfSign.BLSSign,
+	}
 
-			// add message to cache
-			pbft.msgLog.AddMessage(CommitMsg, msg)
+	p2pMessage, err := pbft.packBroadcastMessage(CommitMsg, msg)
+	if err != nil {
+		return false, err
+	}
 
-			// broadcast prepare message
-			pbft.log.Println("Broadcast commit message")
-			pbft.net.Broadcast(p2pMessage)
+	// add message to cache
+	pbft.msgLog.AddMessage(CommitMsg, msg)
+	pbft.setInstanceState(seq, CommitState)
 
-			return true, nil
-		}
-	}
-	return false, nil
+	// broadcast prepare message
+	pbft.log.Println("Broadcast commit message")
+	pbft.net.Broadcast(p2pMessage)
+	return true, nil
 }
 
 func (pbft *PBFT) handleCommit(commit *CommitMessage) (bool, error) {
@@ -276,31 +517,38 @@ func (pbft *PBFT) handleCommit(commit *CommitMessage) (bool, error) {
 	pubKey := mycrypto.Bytes2PublicKey(commit.PubKey)
 	if pbft.view != commit.View {
 		return false, errors.New("not in current view")
-	} else if !mycrypto.Verify(pubKey, commit.BlockHash, commit.Sign) {
+	} else if err := pbft.checkWindow(commit.Height); err != nil {
+		return false, err
+	} else if !pbft.isMember(commit.PubKey) {
+		return false, errors.New("commit message not from validator")
+	} else if !mycrypto.Verify(pubKey, blockchain.CertDigest(commit.BlockHash, commit.View), commit.Sign) {
 		return false, errors.New("verify digest fail")
-	} else if pbft.msgLog.HaveLog(CommitMsg, commit.ID, commit.Height) {
+	} else if !pbft.verifyBLS(commit.PubKey, commit.BlockHash, commit.View, commit.BLSSign) {
+		return false, errors.New("verify bls signature fail")
+	} else if pbft.msgLog.HaveLog(CommitMsg, commit.PubKey, commit.Height) {
 		return false, errors.New("already receive this commit message")
-	} else if !pbft.msgLog.HaveBlock(commit.Height) {
-		// check cache block hash
-		return false, errors.New("unsigned block")
-	} else {
-		// add message to cache
-		pbft.msgLog.AddMessage(CommitMsg, *commit)
-		pbft.log.Println("Verify commit message successfully, commit count: ", pbft.msgLog.Count(CommitMsg, commit.Height))
-
-		// check already receive commit message
-		if pbft.msgLog.Count(CommitMsg, commit.Height) == 2*pbft.maxFaultNode+1 {
-			// had received enough commit message
-			pbft.log.Println("Already receive enough commit message")
-			// add block to block pool
-			pbft.log.Println("Add block to chain")
-			block := pbft.msgLog.GetBlock(commit.Height)
-			pbft.blockPool.AddBlock(block)
+	}
+	// add message to cache, it is counted once the block is prepared
+	pbft.msgLog.AddMessage(CommitMsg, *commit)
+	pbft.log.Println("Verify commit message successfully, commit count: ", pbft.msgLog.Count(CommitMsg, commit.Height))
+	return pbft.checkCommitted(commit.Height), nil
+}
 
-			return true, nil
-		}
+// checkCommitted marks the instance committed once it has enough commit messages of its block
+func (pbft *PBFT) checkCommitted(seq uint64) bool {
+	inst := pbft.getInstance(seq)
+	if inst == nil || inst.state != CommitState || inst.committed {
+		return false
 	}
-	return false, nil
+	if !pbft.hasQuorum(CommitMsg, seq, inst.block.Header.Hash) {
+		return false
+	}
+	// had received enough commit message
+	pbft.log.Println("Already receive enough commit message, sequence: ", seq)
+	pbft.engine.lock.Lock()
+	inst.committed = true
+	pbft.engine.lock.Unlock()
+	return true
 }
 
 func (pbft *PBFT) handleViewChange(viewChange *ViewChangeMessage) (bool, error) {
@@ -310,11 +558,16 @@ func (pbft *PBFT) handleViewChange(viewChange *ViewChangeMessage) (bool, error)
 	pubKey := mycrypto.Bytes2PublicKey(viewChange.PubKey)
 	if pbft.chain.BestHeight > viewChange.Height {
 		return false, errors.New("expired request")
+	} else if viewChange.Height > pbft.chain.BestHeight+SequenceWindow {
+		// log entries are kept for the sequence window only
+		return false, errors.New("view change height out of window")
 	} else if (pbft.view+1)%pbft.nodeNum != viewChange.ToView {
 		return false, errors.New("invalid view change")
-	} else if !mycrypto.Verify(pubKey, viewChange.BlockHash, viewChange.Sign) {
+	} else if !pbft.isMember(viewChange.PubKey) {
+		return false, errors.New("view change message not from validator")
+	} else if !mycrypto.Verify(pubKey, viewChange.Digest(), viewChange.Sign) {
 		return false, errors.New("verify digest fail")
-	} else if pbft.msgLog.HaveLog(ViewChangeMsg, viewChange.ID, viewChange.Height) {
+	} else if pbft.msgLog.HaveLog(ViewChangeMsg, viewChange.PubKey, viewChange.Height) {
 		return false, errors.New("already receive this view change message")
 	} else {
 		// add message to cache
@@ -328,7 +581,7 @@ func (pbft *PBFT) handleViewChange(viewChange *ViewChangeMessage) (bool, error)
 		}
 
 		// check already receive view change message
-		if pbft.msgLog.Count(ViewChangeMsg, viewChange.Height) == 2*pbft.maxFaultNode+1 {
+		if pbft.hasQuorum(ViewChangeMsg, viewChange.Height, viewChange.BlockHash) {
 			// had received enough view change message
 			pbft.log.Println("Already receive enough view change message")
 			// change view
@@ -355,6 +608,51 @@ func (pbft *PBFT) GetState() State {
 	return pbft.engine.currentState
 }
 
+// getInstance returns the running instance of a sequence number
+func (pbft *PBFT) getInstance(seq uint64) *Instance {
+	pbft.engine.lock.Lock()
+	defer pbft.engine.lock.Unlock()
+	return pbft.engine.instances[seq]
+}
+
+// setInstanceState sets the state of the running instance of a sequence number
+func (pbft *PBFT) setInstanceState(seq uint64, s State) {
+	pbft.engine.lock.Lock()
+	defer pbft.engine.lock.Unlock()
+	if inst, ok := pbft.engine.instances[seq]; ok {
+		inst.state = s
+	}
+}
+
+// pruneExecuted drops instances of sequence numbers already in chain, blocks may be
+// connected by catch up or block sync before this node commits them, returns true if any is dropped
+func (pbft *PBFT) pruneExecuted() bool {
+	height := pbft.chain.GetHeight()
+	pruned := false
+	pbft.engine.lock.Lock()
+	for seq := range pbft.engine.instances {
+		if seq <= height {
+			delete(pbft.engine.instances, seq)
+			pruned = true
+		}
+	}
+	for key := range pbft.engine.buffered {
+		if key.seq <= height {
+			delete(pbft.engine.buffered, key)
+		}
+	}
+	pbft.engine.lock.Unlock()
+	pbft.msgLog.Prune(height + 1)
+	return pruned
+}
+
+// runningInstances returns the number of running instances
+func (pbft *PBFT) runningInstances() int {
+	pbft.engine.lock.Lock()
+	defer pbft.engine.lock.Unlock()
+	return len(pbft.engine.instances)
+}
+
 // ResetState resets the state of the PBFT consensus engine to the PrePrepareState.
 func (pbft *PBFT) ResetState() {
 	pbft.engine.lock.Lock()
@@ -371,28 +669,42 @@ func (pbft *PBFT) packBroadcastMessage(t PBFTMsgType, msg interface{}) (*p2pnet.
 	switch t {
 	case PrepareMsg:
 		if m, ok := msg.(PrepareMessage); ok {
-			payload, err = json.Marshal(m)
+			payload, err = codec.Marshal(m)
 			if err != nil {
 				return nil, err
 			}
 		}
 	case SignMsg:
 		if m, ok := msg.(SignMessage); ok {
-			payload, err = json.Marshal(m)
+			payload, err = codec.Marshal(m)
 			if err != nil {
 				return nil, err
 			}
 		}
 	case CommitMsg:
 		if m, ok := msg.(CommitMessage); ok {
-			payload, err = json.Marshal(m)
+			payload, err = codec.Marshal(m)
 			if err != nil {
 				return nil, err
 			}
 		}
 	case ViewChangeMsg:
 		if m, ok := msg.(ViewChangeMessage); ok {
-			payload, err = json.Marshal(m)
+			payload, err = codec.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case CatchUpRequestMsg:
+		if m, ok := msg.(CatchUpRequestMessage); ok {
+			payload, err = codec.Marshal(m)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case CatchUpResponseMsg:
+		if m, ok := msg.(CatchUpResponseMessage); ok {
+			payload, err = codec.Marshal(m)
 			if err != nil {
 				return nil, err
 			}
@@ -406,7 +718,7 @@ func (pbft *PBFT) packBroadcastMessage(t PBFTMsgType, msg interface{}) (*p2pnet.
 	}
 
 	// Serialize the PBFTMessage to JSON
-	serialized, err := json.Marshal(pbftMessage)
+	serialized, err := codec.Marshal(pbftMessage)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,688 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/mycrypto"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"BlockChain/src/utils"
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// hotStuffEnvelope is a received HotStuff message with its sender peer
+type hotStuffEnvelope struct {
+	msg    *HotStuffMessage
+	peerID string
+}
+
+// HotStuff chained HotStuff consensus engine, votes go to the next leader only
+// and every proposal carries the certificate of its parent, so consecutive blocks are pipelined
+type HotStuff struct {
+	id     string       // node ID
+	msgLog *HotStuffLog // cache of uncommitted blocks and messages
+
+	net        *p2pnet.P2PNet    // network layer
+	privateKey *ecdsa.PrivateKey // private key
+	publicKey  *ecdsa.PublicKey  // public key
+	chain      *blockchain.Chain // block chain
+	blockPool  *pool.BlockPool   // block pool
+	txPool     *pool.TxPool      // tx pool
+
+	isStart      bool // flag of start
+	timerRunning bool // flag of view timer running
+
+	view         uint64 // current view
+	index        uint64 // node index
+	nodeNum      uint64 // total consensus node number
+	maxFaultNode uint64 // max fault node number
+
+	replicas [][]byte // replica public keys in index order, from the configured validators
+
+	highQC        *blockchain.QuorumCert // highest certificate known
+	lockedQC      *blockchain.QuorumCert // certificate of the locked block
+	voted         bool                   // flag of voted at least once
+	lastVotedView uint64                 // view of the last vote
+	readyView     uint64                 // view this node may propose in as leader
+	proposedView  uint64                 // view of the last own proposal
+	proposed      bool                   // flag of proposed at least once
+
+	peers map[uint64]string // node index -> peer ID, learned from proposals
+
+	viewTimer   *time.Timer            // view timer
+	hotStuffMsg chan *hotStuffEnvelope // consensus message channel
+	lock        sync.Mutex
+	log         *log.Logger
+}
+
+// NewHotStuff create HotStuff engine
+func NewHotStuff(num, index uint64, f uint64, v uint64, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*HotStuff, error) {
+	// initialize logger
+	l := utils.NewLogger("[hotstuff] ", logPath)
+
+	if num < 3*f+1 {
+		return nil, errors.New("consensus node number less than 3f+1")
+	}
+	// messages and certificates are checked against the replica key of each index
+	vs := chain.GetValidators()
+	if vs == nil || vs.NodeNum() != num {
+		return nil, errors.New("hotstuff needs the public key of every consensus node")
+	}
+
+	hs := &HotStuff{
+		msgLog:       NewHotStuffLog(),
+		net:          net,
+		chain:        chain,
+		publicKey:    wallet.GetPublicKey(),
+		privateKey:   wallet.GetPrivateKey(),
+		blockPool:    bp,
+		txPool:       tp,
+		view:         v + 1,
+		index:        index,
+		nodeNum:      num,
+		maxFaultNode: f,
+		replicas:     vs.Validators,
+		readyView:    v + 1,
+		peers:        make(map[uint64]string),
+		hotStuffMsg:  make(chan *hotStuffEnvelope),
+		log:          l,
+	}
+	hs.id = string(wallet.GetAddress())
+	// the committed tip is certified by an empty genesis certificate
+	genesisQC := &blockchain.QuorumCert{
+		View:      v,
+		Height:    chain.BestHeight,
+		BlockHash: chain.Tip,
+	}
+	hs.highQC = genesisQC
+	hs.lockedQC = genesisQC
+	// initialize timer
+	hs.viewTimer = time.NewTimer(time.Duration(blockchain.ActiveParams().ViewTimeout) * time.Second)
+	hs.viewTimer.Stop()
+	return hs, nil
+}
+
+// OnReceive receive callback func
+func (hs *HotStuff) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
+	if t != p2pnet.ConsensusMsg {
+		return
+	}
+	var msg HotStuffMessage
+	err := codec.Unmarshal(msgBytes, &msg)
+	if err != nil {
+		hs.log.Println("Unmarshal HotStuffMessage fail")
+		return
+	}
+	hs.hotStuffMsg <- &hotStuffEnvelope{msg: &msg, peerID: peerID}
+}
+
+// Run handle messages, view timer and tx pool signal in one routine
+func (hs *HotStuff) Run() {
+	hs.log.Println("Run HotStuff consensus")
+	hs.Start()
+	// register callback func
+	hs.net.RegisterCallback(p2pnet.ConsensusMsg, hs.OnReceive)
+
+	for {
+		select {
+		case envelope := <-hs.hotStuffMsg:
+			if !hs.isStarted() {
+				continue
+			}
+			hs.handleMessage(envelope.msg, envelope.peerID)
+		case <-hs.viewTimer.C:
+			hs.timerRunning = false
+			hs.onViewTimeout()
+		case <-hs.txPool.FullSignal:
+			if !hs.isStarted() {
+				continue
+			}
+			if hs.leaderOf(hs.GetView()) == hs.index {
+				hs.log.Println("TxPool full, propose block...")
+				hs.tryPropose()
+			} else {
+				// wait leader proposal
+				hs.startViewTimer()
+			}
+		}
+	}
+}
+
+// handleMessage dispatch a HotStuff message to its handler
+func (hs *HotStuff) handleMessage(msg *HotStuffMessage, peerID string) {
+	data, t := msg.SplitMessage()
+	var err error
+	switch t {
+	case ProposalMsg:
+		proposal := data.(ProposalMessage)
+		err = hs.handleProposal(&proposal, peerID)
+	case VoteMsg:
+		vote := data.(VoteMessage)
+		err = hs.handleVote(&vote)
+	case NewViewMsg:
+		newView := data.(NewViewMessage)
+		err = hs.handleNewView(&newView)
+	default:
+		err = errors.New("unknown message")
+	}
+	if err != nil {
+		hs.log.Println(err)
+	}
+}
+
+func (hs *HotStuff) handleProposal(proposal *ProposalMessage, peerID string) error {
+	hs.log.Println("Receive a proposal from: ", proposal.ID)
+	if proposal.View < hs.GetView() {
+		return errors.New("proposal of expired view")
+	} else if proposal.Index != hs.leaderOf(proposal.View) || !bytes.Equal(hs.replicas[proposal.Index], proposal.PubKey) {
+		return errors.New("proposal not from view leader")
+	}
+	var block blockchain.Block
+	err := codec.Unmarshal(proposal.Block, &block)
+	if err != nil || block.Header == nil {
+		return errors.New("unmarshal block error")
+	}
+	pubKey := mycrypto.Bytes2PublicKey(proposal.PubKey)
+	if !mycrypto.Verify(pubKey, blockchain.CertDigest(block.Header.Hash, proposal.View), proposal.Sign) {
+		return errors.New("verify signature fail")
+	}
+
+	// the block must extend the block certified by its justify
+	justify := block.Header.Justify
+	if justify == nil {
+		return errors.New("proposal without justify certificate")
+	} else if justify.View >= proposal.View {
+		return errors.New("justify certificate not before proposal view")
+	} else if !hs.verifyQC(justify) {
+		return errors.New("invalid justify certificate")
+	} else if !bytes.Equal(block.Header.PrevHash, justify.BlockHash) || block.Header.Height != justify.Height+1 {
+		return errors.New("block not extend justify block")
+	} else if hs.getBlock(justify.BlockHash) == nil {
+		return errors.New("unknown parent block")
+	}
+	if err := verifyContent(hs.chain, &block); err != nil {
+		return err
+	}
+	if peerID != "" {
+		hs.peers[proposal.Index] = peerID
+	}
+
+	// cache block and update tx pool
+	hs.msgLog.CacheBlock(&block)
+	for _, tx := range block.Transactions {
+		hs.txPool.RemoveTransaction(hex.EncodeToString(tx.ID))
+	}
+
+	// safety rule: vote only for a block extending the locked block or with a newer justify
+	safe := hs.extends(&block, hs.lockedQC.BlockHash) || justify.View > hs.lockedQC.View
+	if safe && (!hs.voted || proposal.View > hs.lastVotedView) {
+		err = hs.vote(&block, proposal.View)
+		if err != nil {
+			hs.log.Println(err)
+		}
+	} else {
+		hs.log.Println("Unsafe proposal, skip vote")
+	}
+
+	// update certificates, lock and commit along the chain
+	hs.updateQC(justify)
+	hs.update(&block)
+
+	// move to next view and wait next proposal
+	hs.enterView(proposal.View + 1)
+	hs.stopViewTimer()
+	if hs.hasPendingWork() {
+		hs.startViewTimer()
+	}
+	return nil
+}
+
+// vote sends the vote of block to the leader of next view
+func (hs *HotStuff) vote(block *blockchain.Block, view uint64) error {
+	signature, err := mycrypto.Sign(hs.privateKey, blockchain.CertDigest(block.Header.Hash, view))
+	if err != nil {
+		return errors.New("sign message fail")
+	}
+	vote := VoteMessage{
+		ID:        hs.id,
+		View:      view,
+		Height:    block.Header.Height,
+		BlockHash: block.Header.Hash,
+		Sign:      signature,
+		PubKey:    mycrypto.PublicKey2Bytes(hs.publicKey),
+	}
+	hs.voted = true
+	hs.lastVotedView = view
+
+	next := hs.leaderOf(view + 1)
+	if next == hs.index {
+		return hs.handleVote(&vote)
+	}
+	hs.log.Println("Send vote to next leader: ", next)
+	return hs.sendToNode(next, VoteMsg, vote)
+}
+
+func (hs *HotStuff) handleVote(vote *VoteMessage) error {
+	if hs.leaderOf(vote.View+1) != hs.index {
+		// vote broadcast to all when leader peer unknown
+		return nil
+	}
+	hs.log.Println("Receive a vote from: ", vote.ID)
+	index, ok := hs.replicaIndex(vote.PubKey)
+	pubKey := mycrypto.Bytes2PublicKey(vote.PubKey)
+	if vote.View < hs.highQC.View {
+		return errors.New("vote of expired view")
+	} else if !ok {
+		return errors.New("vote from unknown replica")
+	} else if !mycrypto.Verify(pubKey, blockchain.CertDigest(vote.BlockHash, vote.View), vote.Sign) {
+		return errors.New("verify signature fail")
+	} else if !hs.msgLog.AddVote(index, *vote) {
+		return errors.New("already receive this vote")
+	}
+
+	votes := hs.msgLog.GetVotes(vote.View, vote.BlockHash)
+	hs.log.Printf("view: %d, vote count: %d", vote.View, len(votes))
+	if uint64(len(votes)) != 2*hs.maxFaultNode+1 {
+		return nil
+	}
+
+	// enough votes, build certificate of the block
+	hs.log.Println("Already receive enough votes")
+	qc := &blockchain.QuorumCert{
+		View:      vote.View,
+		Height:    vote.Height,
+		BlockHash: vote.BlockHash,
+	}
+	for _, v := range votes {
+		qc.AddSignature(&blockchain.CertSignature{
+			ID:     v.ID,
+			Sign:   v.Sign,
+			PubKey: v.PubKey,
+		})
+	}
+	hs.updateQC(qc)
+	if hs.readyView < vote.View+1 {
+		hs.readyView = vote.View + 1
+	}
+	hs.enterView(vote.View + 1)
+	hs.tryPropose()
+	return nil
+}
+
+func (hs *HotStuff) handleNewView(newView *NewViewMessage) error {
+	if hs.leaderOf(newView.View) != hs.index {
+		return nil
+	}
+	hs.log.Println("Receive a new view message from: ", newView.ID)
+	if newView.View < hs.GetView() {
+		return errors.New("new view of expired view")
+	} else if newView.HighQC == nil {
+		return errors.New("new view without high certificate")
+	}
+	index, ok := hs.replicaIndex(newView.PubKey)
+	if !ok {
+		return errors.New("new view from unknown replica")
+	}
+	pubKey := mycrypto.Bytes2PublicKey(newView.PubKey)
+	if !mycrypto.Verify(pubKey, blockchain.CertDigest(newView.HighQC.BlockHash, newView.View), newView.Sign) {
+		return errors.New("verify signature fail")
+	} else if !hs.verifyQC(newView.HighQC) {
+		return errors.New("invalid high certificate")
+	} else if !hs.msgLog.AddNewView(index, *newView) {
+		return errors.New("already receive this new view message")
+	}
+
+	hs.updateQC(newView.HighQC)
+	count := len(hs.msgLog.GetNewViews(newView.View))
+	hs.log.Printf("new view: %d, count: %d", newView.View, count)
+	if uint64(count) == 2*hs.maxFaultNode+1 {
+		// lead the new view with the highest certificate
+		hs.log.Println("Already receive enough new view message")
+		hs.enterView(newView.View)
+		if hs.readyView < newView.View {
+			hs.readyView = newView.View
+		}
+		hs.tryPropose()
+	}
+	return nil
+}
+
+// onViewTimeout give up current view and send new view message to the next leader
+func (hs *HotStuff) onViewTimeout() {
+	next := hs.GetView() + 1
+	hs.log.Println("View timeout, change to view: ", next)
+	hs.enterView(next)
+
+	signature, err := mycrypto.Sign(hs.privateKey, blockchain.CertDigest(hs.highQC.BlockHash, next))
+	if err != nil {
+		hs.log.Println("Sign new view message fail")
+		return
+	}
+	newView := NewViewMessage{
+		ID:     hs.id,
+		View:   next,
+		HighQC: hs.highQC,
+		Sign:   signature,
+		PubKey: mycrypto.PublicKey2Bytes(hs.publicKey),
+	}
+	leader := hs.leaderOf(next)
+	if leader == hs.index {
+		err = hs.handleNewView(&newView)
+	} else {
+		err = hs.sendToNode(leader, NewViewMsg, newView)
+	}
+	if err != nil {
+		hs.log.Println(err)
+	}
+	// keep changing view while the new leader makes no progress
+	if hs.hasPendingWork() {
+		hs.startViewTimer()
+	}
+}
+
+// tryPropose proposes a block on top of the highest certificate when this node leads the current view
+func (hs *HotStuff) tryPropose() {
+	view := hs.GetView()
+	if hs.leaderOf(view) != hs.index || hs.readyView != view || (hs.proposed && hs.proposedView >= view) {
+		return
+	}
+	if !hs.hasPendingWork() {
+		hs.log.Println("No pending transactions, wait")
+		return
+	}
+	parent := hs.getBlock(hs.highQC.BlockHash)
+	if parent == nil {
+		hs.log.Println("Block of high certificate not found")
+		return
+	}
+
+	// get txs from pool
+	var txs []*blockchain.Transaction
+	for id, tx := range hs.txPool.GetTransactions() {
+		hs.txPool.RemoveTransaction(id)
+		txs = append(txs, tx)
+	}
+	if !blockchain.VerifyTransactions(hs.chain, txs) {
+		hs.log.Println("tx verify error, drop transactions")
+		txs = nil
+	}
+
+	// pack block with certificate of parent
+	block := blockchain.NewCertifiedBlock(parent.Header.Hash, txs, parent.Header.Height+1, hs.highQC)
+	blockData, err := codec.Marshal(block)
+	if err != nil {
+		hs.log.Println("Marshal block data fail")
+		return
+	}
+	signature, err := mycrypto.Sign(hs.privateKey, blockchain.CertDigest(block.Header.Hash, view))
+	if err != nil {
+		hs.log.Println("Sign message fail")
+		return
+	}
+	proposal := ProposalMessage{
+		ID:     hs.id,
+		Index:  hs.index,
+		View:   view,
+		Block:  blockData,
+		Sign:   signature,
+		PubKey: mycrypto.PublicKey2Bytes(hs.publicKey),
+	}
+	p2pMessage, err := hs.packMessage(ProposalMsg, proposal)
+	if err != nil {
+		hs.log.Println(err)
+		return
+	}
+	hs.proposed = true
+	hs.proposedView = view
+
+	// broadcast proposal and handle it locally
+	hs.log.Printf("Broadcast proposal, view: %d, height: %d", view, block.Header.Height)
+	hs.net.Broadcast(p2pMessage)
+	err = hs.handleProposal(&proposal, "")
+	if err != nil {
+		hs.log.Println(err)
+	}
+}
+
+// update locks the two-chain head and commits the three-chain head of block
+func (hs *HotStuff) update(block *blockchain.Block) {
+	b2 := hs.getBlock(block.Header.Justify.BlockHash)
+	if b2 == nil || b2.Header.Justify == nil {
+		return
+	}
+	b1 := hs.getBlock(b2.Header.Justify.BlockHash)
+	if b1 == nil {
+		return
+	}
+	if b1.Header.Height > hs.lockedQC.Height {
+		hs.lockedQC = b2.Header.Justify
+	}
+	if b1.Header.Justify == nil {
+		return
+	}
+	b0 := hs.getBlock(b1.Header.Justify.BlockHash)
+	if b0 == nil {
+		return
+	}
+	if bytes.Equal(b2.Header.PrevHash, b1.Header.Hash) && bytes.Equal(b1.Header.PrevHash, b0.Header.Hash) {
+		hs.commit(b0, b1.Header.Justify)
+	}
+}
+
+// commit adds block and its uncommitted ancestors to chain in order with their certificates,
+// qc certifies block and the justify of each child certifies its parent
+func (hs *HotStuff) commit(block *blockchain.Block, qc *blockchain.QuorumCert) {
+	height := hs.chain.GetHeight()
+	if block.Header.Height <= height {
+		return
+	}
+	var blocks []*blockchain.Block
+	for b := block; b != nil && b.Header.Height > height; b = hs.msgLog.GetBlock(b.Header.PrevHash) {
+		blocks = append(blocks, b)
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		hs.log.Printf("Commit block, height: %d", blocks[i].Header.Height)
+		hs.blockPool.AddBlock(blocks[i])
+		cert := qc
+		if i > 0 {
+			cert = blocks[i-1].Header.Justify
+		}
+		if err := hs.chain.AddCert(cert); err != nil {
+			hs.log.Println("Store certificate fail: ", err)
+		}
+	}
+	view := hs.GetView()
+	if view > 0 {
+		view--
+	}
+	hs.msgLog.Prune(block.Header.Height, view)
+}
+
+// updateQC replaces the high certificate with a newer one
+func (hs *HotStuff) updateQC(qc *blockchain.QuorumCert) {
+	if qc.View > hs.highQC.View {
+		hs.highQC = qc
+	}
+}
+
+// verifyQC checks a certificate is signed by 2f+1 distinct replicas, an empty certificate only certifies a committed block
+func (hs *HotStuff) verifyQC(qc *blockchain.QuorumCert) bool {
+	if len(qc.Signatures) == 0 {
+		return qc.Height <= hs.chain.GetHeight() && hs.chain.HaveBlock(qc.BlockHash)
+	}
+	return qc.Verify(hs.replicas, 2*hs.maxFaultNode+1)
+}
+
+// replicaIndex returns the node index of a replica public key
+func (hs *HotStuff) replicaIndex(pubKey []byte) (uint64, bool) {
+	for i, key := range hs.replicas {
+		if bytes.Equal(key, pubKey) {
+			return uint64(i), true
+		}
+	}
+	return 0, false
+}
+
+// extends checks if block is a descendant of ancestor hash
+func (hs *HotStuff) extends(block *blockchain.Block, ancestor []byte) bool {
+	for b := block; b != nil; b = hs.getBlock(b.Header.PrevHash) {
+		if bytes.Equal(b.Header.Hash, ancestor) {
+			return true
+		}
+		if b.IsGenesisBlock() {
+			break
+		}
+	}
+	return false
+}
+
+// hasPendingWork checks if there are pool transactions or uncommitted blocks with transactions
+func (hs *HotStuff) hasPendingWork() bool {
+	if hs.txPool.Count() > 0 {
+		return true
+	}
+	height := hs.chain.GetHeight()
+	for b := hs.msgLog.GetBlock(hs.highQC.BlockHash); b != nil && b.Header.Height > height; b = hs.msgLog.GetBlock(b.Header.PrevHash) {
+		if len(b.Transactions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getBlock returns an uncommitted block from cache or a committed block from chain
+func (hs *HotStuff) getBlock(hash []byte) *blockchain.Block {
+	if block := hs.msgLog.GetBlock(hash); block != nil {
+		return block
+	}
+	return hs.chain.GetBlock(hash)
+}
+
+// leaderOf returns the leader index of view
+func (hs *HotStuff) leaderOf(view uint64) uint64 {
+	return view % hs.nodeNum
+}
+
+// enterView moves to a higher view
+func (hs *HotStuff) enterView(view uint64) {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	if view > hs.view {
+		hs.view = view
+	}
+}
+
+func (hs *HotStuff) startViewTimer() {
+	if hs.timerRunning {
+		return
+	}
+	hs.log.Println("Start view timer")
+	hs.timerRunning = true
+	hs.viewTimer.Reset(time.Duration(blockchain.ActiveParams().ViewTimeout) * time.Second)
+}
+
+func (hs *HotStuff) stopViewTimer() {
+	if hs.timerRunning {
+		hs.viewTimer.Stop()
+		hs.timerRunning = false
+	}
+}
+
+// sendToNode sends a message to the peer of node index, or to all peers when its peer is unknown
+func (hs *HotStuff) sendToNode(index uint64, t HotStuffMsgType, msg interface{}) error {
+	p2pMessage, err := hs.packMessage(t, msg)
+	if err != nil {
+		return err
+	}
+	if peerID, ok := hs.peers[index]; ok {
+		if hs.net.BroadcastToPeer(p2pMessage, peerID) == nil {
+			return nil
+		}
+	}
+	return hs.net.Broadcast(p2pMessage)
+}
+
+// packMessage pack a P2P network message by packaging a HotStuff message with its type
+func (hs *HotStuff) packMessage(t HotStuffMsgType, msg interface{}) (*p2pnet.Message, error) {
+	payload, err := codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	hotStuffMessage := HotStuffMessage{
+		Type: t,
+		Data: payload,
+	}
+	serialized, err := codec.Marshal(hotStuffMessage)
+	if err != nil {
+		return nil, err
+	}
+	return &p2pnet.Message{
+		Type: p2pnet.ConsensusMsg,
+		Data: serialized,
+	}, nil
+}
+
+// Start sets the HotStuff engine as started
+func (hs *HotStuff) Start() {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.isStart = true
+}
+
+// Stop sets the HotStuff engine as stopped
+func (hs *HotStuff) Stop() {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	hs.isStart = false
+}
+
+func (hs *HotStuff) isStarted() bool {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return hs.isStart
+}
+
+// GetView retrieves the current view
+func (hs *HotStuff) GetView() uint64 {
+	hs.lock.Lock()
+	defer hs.lock.Unlock()
+	return hs.view
+}
+
+// Status returns current HotStuff engine status
+func (hs *HotStuff) Status() *Status {
+	view := hs.GetView()
+	return &Status{
+		Engine:    EngineHotStuff,
+		IsPrimary: hs.leaderOf(view) == hs.index,
+		View:      view,
+	}
+}
+
+// VerifyBlock checks a synchronized block matches its hash and carries a valid certificate of its previous block,
+// the certificate of the block itself is checked by the block pool
+func (hs *HotStuff) VerifyBlock(block *blockchain.Block) error {
+	if block.IsGenesisBlock() {
+		return verifyContent(hs.chain, block)
+	}
+	justify := block.Header.Justify
+	if justify == nil {
+		return errors.New("block without justify certificate")
+	} else if !bytes.Equal(justify.BlockHash, block.Header.PrevHash) {
+		return errors.New("justify certificate not for previous block")
+	} else if !hs.verifyQC(justify) {
+		return errors.New("invalid justify certificate")
+	}
+	return verifyContent(hs.chain, block)
+}
+
+// VerifyCert checks a certificate of a block received from peers is signed by 2f+1 distinct replicas
+func (hs *HotStuff) VerifyCert(cert *blockchain.QuorumCert) bool {
+	return len(cert.Signatures) > 0 && cert.Verify(hs.replicas, 2*hs.maxFaultNode+1)
+}
@@ -0,0 +1,113 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"encoding/hex"
+	"sync"
+)
+
+// HotStuffLog represents a cache of uncommitted blocks and HotStuff messages
+type HotStuffLog struct {
+	blocks   map[string]*blockchain.Block                  // proposed block cache, hash -> block
+	votes    map[uint64]map[string]map[uint64]*VoteMessage // vote cache, view -> block hash -> node index -> vote
+	newViews map[uint64]map[uint64]*NewViewMessage         // new view cache, view -> node index -> message
+	lock     sync.Mutex
+}
+
+// NewHotStuffLog creates and initializes a new HotStuffLog instance
+func NewHotStuffLog() *HotStuffLog {
+	return &HotStuffLog{
+		blocks:   make(map[string]*blockchain.Block),
+		votes:    make(map[uint64]map[string]map[uint64]*VoteMessage),
+		newViews: make(map[uint64]map[uint64]*NewViewMessage),
+	}
+}
+
+// CacheBlock adds a proposed block into cache
+func (l *HotStuffLog) CacheBlock(b *blockchain.Block) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.blocks[hex.EncodeToString(b.Header.Hash)] = b
+}
+
+// GetBlock returns a cached block by hash
+func (l *HotStuffLog) GetBlock(hash []byte) *blockchain.Block {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.blocks[hex.EncodeToString(hash)]
+}
+
+// AddVote adds a vote of node index to cache, returns false if the node already voted for it
+func (l *HotStuffLog) AddVote(index uint64, vote VoteMessage) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.votes[vote.View]; !ok {
+		l.votes[vote.View] = make(map[string]map[uint64]*VoteMessage)
+	}
+	hash := hex.EncodeToString(vote.BlockHash)
+	if _, ok := l.votes[vote.View][hash]; !ok {
+		l.votes[vote.View][hash] = make(map[uint64]*VoteMessage)
+	}
+	if _, ok := l.votes[vote.View][hash][index]; ok {
+		return false
+	}
+	l.votes[vote.View][hash][index] = &vote
+	return true
+}
+
+// GetVotes returns cached votes of a block in a view
+func (l *HotStuffLog) GetVotes(view uint64, hash []byte) []*VoteMessage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var votes []*VoteMessage
+	for _, vote := range l.votes[view][hex.EncodeToString(hash)] {
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// AddNewView adds a new view message of node index to cache, returns false if the node already sent it
+func (l *HotStuffLog) AddNewView(index uint64, newView NewViewMessage) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.newViews[newView.View]; !ok {
+		l.newViews[newView.View] = make(map[uint64]*NewViewMessage)
+	}
+	if _, ok := l.newViews[newView.View][index]; ok {
+		return false
+	}
+	l.newViews[newView.View][index] = &newView
+	return true
+}
+
+// GetNewViews returns cached new view messages of a view
+func (l *HotStuffLog) GetNewViews(view uint64) []*NewViewMessage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var newViews []*NewViewMessage
+	for _, newView := range l.newViews[view] {
+		newViews = append(newViews, newView)
+	}
+	return newViews
+}
+
+// Prune removes blocks at or below the committed height and messages of views before view
+func (l *HotStuffLog) Prune(height, view uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for id, b := range l.blocks {
+		if b.Header.Height <= height {
+			delete(l.blocks, id)
+		}
+	}
+	for v := range l.votes {
+		if v < view {
+			delete(l.votes, v)
+		}
+	}
+	for v := range l.newViews {
+		if v < view {
+			delete(l.newViews, v)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+)
+
+type HotStuffMsgType int32
+
+const (
+	DefaultHotStuffMsg HotStuffMsgType = iota
+	ProposalMsg
+	VoteMsg
+	NewViewMsg
+)
+
+// HotStuffMessage type
+type HotStuffMessage struct {
+	Type HotStuffMsgType `json:"type"`
+	Data []byte          `json:"data"`
+}
+
+// ProposalMessage HotStuff leader proposal, the block header carries the justify certificate
+type ProposalMessage struct {
+	ID     string `json:"id"`     // sender ID
+	Index  uint64 `json:"index"`  // sender node index
+	View   uint64 `json:"view"`   // proposal view
+	Block  []byte `json:"block"`  // proposed block data
+	Sign   []byte `json:"sign"`   // signature of block hash and view
+	PubKey []byte `json:"pubKey"` // sender public key
+}
+
+// VoteMessage HotStuff vote, sent to the leader of next view only
+type VoteMessage struct {
+	ID        string `json:"id"`        // sender ID
+	View      uint64 `json:"view"`      // voted proposal view
+	Height    uint64 `json:"height"`    // voted block height
+	BlockHash []byte `json:"blockHash"` // voted block hash
+	Sign      []byte `json:"sign"`      // signature of block hash and view
+	PubKey    []byte `json:"pubKey"`    // sender public key
+}
+
+// NewViewMessage HotStuff new view, sent to the leader of the new view on timeout
+type NewViewMessage struct {
+	ID     string                 `json:"id"`     // sender ID
+	View   uint64                 `json:"view"`   // new view
+	HighQC *blockchain.QuorumCert `json:"highQC"` // highest certificate of sender
+	Sign   []byte                 `json:"sign"`   // signature of high certificate block hash and new view
+	PubKey []byte                 `json:"pubKey"` // sender public key
+}
+
+// SplitMessage splits the HotStuffMessage into the corresponding message struct based on its type.
+func (m *HotStuffMessage) SplitMessage() (interface{}, HotStuffMsgType) {
+	switch m.Type {
+	case ProposalMsg:
+		var pMsg ProposalMessage
+		err := codec.Unmarshal(m.Data, &pMsg)
+		if err != nil {
+			return nil, DefaultHotStuffMsg
+		}
+		return pMsg, ProposalMsg
+
+	case VoteMsg:
+		var vMsg VoteMessage
+		err := codec.Unmarshal(m.Data, &vMsg)
+		if err != nil {
+			return nil, DefaultHotStuffMsg
+		}
+		return vMsg, VoteMsg
+
+	case NewViewMsg:
+		var nvMsg NewViewMessage
+		err := codec.Unmarshal(m.Data, &nvMsg)
+		if err != nil {
+			return nil, DefaultHotStuffMsg
+		}
+		return nvMsg, NewViewMsg
+
+	default:
+		return nil, DefaultHotStuffMsg
+	}
+}
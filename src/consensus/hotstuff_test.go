@@ -0,0 +1,159 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/mycrypto"
+	"BlockChain/src/storage"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestHotStuff_RejectForgedMessages(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if _, err := NewHotStuff(4, 1, 1, 0, nil, nil, nil, chain, wallets[1], filepath.Join(dir, "hotstuff.log")); err == nil {
+		t.Fatal("create hotstuff without replica keys")
+	}
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	hs, err := NewHotStuff(4, 1, 1, 0, nil, nil, nil, chain, wallets[1], filepath.Join(dir, "hotstuff.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a certificate needs 2f+1 distinct configured replicas
+	qc := certOf(chain.Tip, 1, 0, wallets[:3]...)
+	if !hs.verifyQC(qc) {
+		t.Fatal("verify certificate of replicas fail")
+	}
+	outsiders := []*blockchain.Wallet{blockchain.CreateWallet(), blockchain.CreateWallet(), blockchain.CreateWallet()}
+	forged := certOf(chain.Tip, 1, 0, outsiders...)
+	if hs.verifyQC(forged) {
+		t.Fatal("accept certificate of unknown keys")
+	}
+	repeated := certOf(chain.Tip, 1, 0, wallets[0], wallets[0], wallets[0])
+	if hs.verifyQC(repeated) {
+		t.Fatal("accept certificate of one replica signing three times")
+	}
+
+	// the leader of view 1 is replica 1
+	if err := hs.handleProposal(proposalOf(wallets[1], 1, 1, blockchain.NewCertifiedBlock(chain.Tip, nil, 2, forged)), ""); err == nil {
+		t.Fatal("accept proposal with forged certificate")
+	} else {
+		fmt.Println(err)
+	}
+	block := blockchain.NewCertifiedBlock(chain.Tip, nil, 2, qc)
+	if err := hs.handleProposal(proposalOf(wallets[3], 1, 1, block), ""); err == nil {
+		t.Fatal("accept proposal signed by non-leader with leader index")
+	} else {
+		fmt.Println(err)
+	}
+	if err := hs.handleProposal(proposalOf(wallets[3], 3, 1, block), ""); err == nil {
+		t.Fatal("accept proposal from non-leader")
+	} else {
+		fmt.Println(err)
+	}
+
+	// votes of view 0 go to replica 1, votes of unknown keys are not counted
+	vote := VoteMessage{ID: "outsider", View: 0, Height: 2, BlockHash: block.Header.Hash, PubKey: outsiders[0].GetPublicKeyBytes()}
+	vote.Sign, _ = mycrypto.Sign(outsiders[0].GetPrivateKey(), blockchain.CertDigest(block.Header.Hash, 0))
+	if err := hs.handleVote(&vote); err == nil {
+		t.Fatal("accept vote of unknown key")
+	} else {
+		fmt.Println(err)
+	}
+	if len(hs.msgLog.GetVotes(0, block.Header.Hash)) != 0 {
+		t.Fatal("count vote of unknown key")
+	}
+}
+
+func TestHotStuff_VerifyBlock(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	hs, err := NewHotStuff(4, 1, 1, 0, nil, nil, nil, chain, wallets[1], filepath.Join(dir, "hotstuff.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the committed tip certifies a forged next block, its content is checked
+	block := blockchain.NewCertifiedBlock(chain.Tip, []*blockchain.Transaction{}, chain.GetHeight()+1, &blockchain.QuorumCert{Height: chain.GetHeight(), BlockHash: chain.Tip})
+	if err := hs.VerifyBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	block.Transactions = []*blockchain.Transaction{blockchain.NewRewardTx(wallets[0].GetAddress(), 10, block.Header.Height)}
+	block.TransactionCounter = 1
+	err = hs.VerifyBlock(block)
+	fmt.Println("swapped transactions: ", err)
+	if err == nil {
+		t.Fatal("accept block not matching its hash")
+	}
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	block.Header.Hash = block.CalculateHash()
+	err = hs.VerifyBlock(block)
+	fmt.Println("rewarded block: ", err)
+	if err == nil {
+		t.Fatal("accept HotStuff block with reward")
+	}
+
+	// only a quorum certificate of the block itself proves it to peers
+	if hs.VerifyCert(&blockchain.QuorumCert{Height: block.Header.Height, BlockHash: block.Header.Hash}) {
+		t.Fatal("accept empty certificate of a new block")
+	}
+	if !hs.VerifyCert(certOf(block.Header.Hash, block.Header.Height, 1, wallets[:3]...)) {
+		t.Fatal("verify quorum certificate fail")
+	}
+}
+
+// certOf returns a certificate of block hash in view signed by wallets
+func certOf(hash []byte, height, view uint64, wallets ...*blockchain.Wallet) *blockchain.QuorumCert {
+	qc := &blockchain.QuorumCert{View: view, Height: height, BlockHash: hash}
+	for _, w := range wallets {
+		sign, _ := mycrypto.Sign(w.GetPrivateKey(), blockchain.CertDigest(hash, view))
+		qc.Signatures = append(qc.Signatures, &blockchain.CertSignature{
+			ID:     string(w.GetAddress()),
+			Sign:   sign,
+			PubKey: w.GetPublicKeyBytes(),
+		})
+	}
+	return qc
+}
+
+// proposalOf returns a proposal of block in view signed by wallet as node index
+func proposalOf(w *blockchain.Wallet, index, view uint64, block *blockchain.Block) *ProposalMessage {
+	data, _ := codec.Marshal(block)
+	sign, _ := mycrypto.Sign(w.GetPrivateKey(), blockchain.CertDigest(block.Header.Hash, view))
+	return &ProposalMessage{
+		ID:     string(w.GetAddress()),
+		Index:  index,
+		View:   view,
+		Block:  data,
+		Sign:   sign,
+		PubKey: w.GetPublicKeyBytes(),
+	}
+}
@@ -2,18 +2,18 @@ package consensus
 
 import (
 	"BlockChain/src/blockchain"
+	"bytes"
 	"sync"
 )
 
 // MsgLog represents a cache for consensus messages
 type MsgLog struct {
-	nodeNum uint64      // pBFT node number
-	logs    []*LogEntry // cache of each checkpoint receive message
-	lock    sync.Mutex
+	logs map[uint64]*LogEntry // cache of each sequence number receive message
+	lock sync.Mutex
 }
 
-// LogEntry represents a log entry for a checkpoint
-// map: node ID -> message
+// LogEntry represents a log entry for a sequence number
+// map: signer public key -> message, the node ID is not signed and can not tell signers apart
 type LogEntry struct {
 	prepares map[string]*PrepareMessage    // prepare message cache
 	signs    map[string]*SignMessage       // sign message cache
@@ -34,15 +34,28 @@ func initEntry() *LogEntry {
 }
 
 // NewMsgLog creates and initializes a new MsgLog instance
-func NewMsgLog(num uint64) *MsgLog {
-	log := &MsgLog{
-		nodeNum: num,
-		logs:    make([]*LogEntry, num),
+func NewMsgLog() *MsgLog {
+	return &MsgLog{
+		logs: make(map[uint64]*LogEntry),
 	}
-	for i := 0; i < int(num); i++ {
-		log.logs[i] = initEntry()
+}
+
+// entry returns the log entry of a sequence number, creating it if needed
+func (l *MsgLog) entry(seq uint64) *LogEntry {
+	e, ok := l.logs[seq]
+	if !ok {
+		e = initEntry()
+		l.logs[seq] = e
 	}
-	return log
+	return e
+}
+
+// get returns the log entry of a sequence number without creating it, empty if not found
+func (l *MsgLog) get(seq uint64) *LogEntry {
+	if e, ok := l.logs[seq]; ok {
+		return e
+	}
+	return initEntry()
 }
 
 // AddMessage adds a message of a specific type to the MsgLog cache
@@ -53,40 +66,41 @@ func (l *MsgLog) AddMessage(msgType PBFTMsgType, data interface{}) {
 	switch msgType {
 	case PrepareMsg:
 		if prepare, ok := data.(PrepareMessage); ok {
-			l.logs[prepare.Height%l.nodeNum].prepares[prepare.ID] = &prepare
+			l.entry(prepare.Height).prepares[string(prepare.PubKey)] = &prepare
 		}
 	case CommitMsg:
 		if commit, ok := data.(CommitMessage); ok {
-			l.logs[commit.Height%l.nodeNum].commits[commit.ID] = &commit
+			l.entry(commit.Height).commits[string(commit.PubKey)] = &commit
 		}
 	case SignMsg:
 		if sign, ok := data.(SignMessage); ok {
-			l.logs[sign.Height%l.nodeNum].signs[sign.ID] = &sign
+			l.entry(sign.Height).signs[string(sign.PubKey)] = &sign
 		}
 	case ViewChangeMsg:
 		if view, ok := data.(ViewChangeMessage); ok {
-			l.logs[view.Height%l.nodeNum].views[view.ID] = &view
+			l.entry(view.Height).views[string(view.PubKey)] = &view
 		}
 	}
 }
 
-// HaveLog checks if a specific type of message with a given ID exists in the MsgLog cache
-func (l *MsgLog) HaveLog(msgType PBFTMsgType, id string, height uint64) bool {
+// HaveLog checks if a specific type of message signed by a public key exists in the MsgLog cache
+func (l *MsgLog) HaveLog(msgType PBFTMsgType, pubKey []byte, height uint64) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
+	id := string(pubKey)
 
 	switch msgType {
 	case PrepareMsg:
-		_, ok := l.logs[height%l.nodeNum].prepares[id]
+		_, ok := l.get(height).prepares[id]
 		return ok
 	case CommitMsg:
-		_, ok := l.logs[height%l.nodeNum].commits[id]
+		_, ok := l.get(height).commits[id]
 		return ok
 	case SignMsg:
-		_, ok := l.logs[height%l.nodeNum].signs[id]
+		_, ok := l.get(height).signs[id]
 		return ok
 	case ViewChangeMsg:
-		_, ok := l.logs[height%l.nodeNum].views[id]
+		_, ok := l.get(height).views[id]
 		return ok
 	}
 	return false
@@ -97,28 +111,50 @@ func (l *MsgLog) CacheBlock(b *blockchain.Block) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	height := b.Header.Height
-	l.logs[height%l.nodeNum].block = b
+	l.entry(height).block = b
 }
 
 // GetBlock return log cache block
 func (l *MsgLog) GetBlock(height uint64) *blockchain.Block {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	return l.logs[height%l.nodeNum].block
+	return l.get(height).block
 }
 
 // HaveBlock check if a block exists in the MsgLog cache
 func (l *MsgLog) HaveBlock(height uint64) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	return l.logs[height%l.nodeNum].block != nil
+	return l.get(height).block != nil
+}
+
+// GetSignLog retrieves a SignMessage from the MsgLog cache using its signer public key.
+func (l *MsgLog) GetSignLog(pubKey []byte, height uint64) *SignMessage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.get(height).signs[string(pubKey)]
 }
 
-// GetSignLog retrieves a SignMessage from the MsgLog cache using its ID.
-func (l *MsgLog) GetSignLog(id string, height uint64) *SignMessage {
+// CommitCert builds the commit certificate of a block from cached commit messages
+func (l *MsgLog) CommitCert(height, view uint64, hash []byte) *blockchain.QuorumCert {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	return l.logs[height%l.nodeNum].signs[id]
+	cert := &blockchain.QuorumCert{
+		View:      view,
+		Height:    height,
+		BlockHash: hash,
+	}
+	for _, commit := range l.get(height).commits {
+		if commit.View == view && bytes.Equal(commit.BlockHash, hash) {
+			cert.AddSignature(&blockchain.CertSignature{
+				ID:      commit.ID,
+				Sign:    commit.Sign,
+				PubKey:  commit.PubKey,
+				BLSSign: commit.BLSSign,
+			})
+		}
+	}
+	return cert
 }
 
 // Count returns the count of messages of a specific type in the MsgLog cache
@@ -128,21 +164,65 @@ func (l *MsgLog) Count(msgType PBFTMsgType, height uint64) uint64 {
 
 	switch msgType {
 	case PrepareMsg:
-		return uint64(len(l.logs[height%l.nodeNum].prepares))
+		return uint64(len(l.get(height).prepares))
 	case CommitMsg:
-		return uint64(len(l.logs[height%l.nodeNum].commits))
+		return uint64(len(l.get(height).commits))
 	case SignMsg:
-		return uint64(len(l.logs[height%l.nodeNum].signs))
+		return uint64(len(l.get(height).signs))
 	case ViewChangeMsg:
-		return uint64(len(l.logs[height%l.nodeNum].views))
+		return uint64(len(l.get(height).views))
 	}
 	return 0
 }
 
-func (l *MsgLog) ClearLog() {
+// CountFor returns the voting weight of sign, commit or view change messages of a sequence number
+// voting for block hash, weight gives the voting power of a signer, nil counts every signer as 1
+func (l *MsgLog) CountFor(msgType PBFTMsgType, height uint64, hash []byte, weight func(pubKey []byte) uint64) uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var count uint64
+	add := func(blockHash, pubKey []byte) {
+		if !bytes.Equal(blockHash, hash) {
+			return
+		}
+		if weight == nil {
+			count++
+		} else {
+			count += weight(pubKey)
+		}
+	}
+	switch msgType {
+	case SignMsg:
+		for _, sign := range l.get(height).signs {
+			add(sign.BlockHash, sign.PubKey)
+		}
+	case CommitMsg:
+		for _, commit := range l.get(height).commits {
+			add(commit.BlockHash, commit.PubKey)
+		}
+	case ViewChangeMsg:
+		for _, view := range l.get(height).views {
+			add(view.BlockHash, view.PubKey)
+		}
+	}
+	return count
+}
+
+// Prune removes entries of sequence numbers below height
+func (l *MsgLog) Prune(height uint64) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	for i := 0; i < int(l.nodeNum); i++ {
-		l.logs[i] = initEntry()
+	for seq := range l.logs {
+		if seq < height {
+			delete(l.logs, seq)
+		}
 	}
 }
+
+// ClearLog removes all entries
+func (l *MsgLog) ClearLog() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.logs = make(map[uint64]*LogEntry)
+}
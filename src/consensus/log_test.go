@@ -6,7 +6,7 @@ import (
 )
 
 func TestMsgLog(t *testing.T) {
-	log := NewMsgLog(4)
+	log := NewMsgLog()
 	testPrepareMessage := PrepareMessage{
 		ID:        "test1",
 		Height:    0,
@@ -62,14 +62,71 @@ func TestMsgLog(t *testing.T) {
 	log.AddMessage(CommitMsg, testCommitMessage2)
 	log.AddMessage(CommitMsg, testCommitMessage3)
 	log.AddMessage(SignMsg, testSignMessage)
-	fmt.Printf("have message: %v\n", log.HaveLog(PrepareMsg, testPrepareMessage.ID, testPrepareMessage.Height))
+	fmt.Printf("have message: %v\n", log.HaveLog(PrepareMsg, testPrepareMessage.PubKey, testPrepareMessage.Height))
 	fmt.Printf("Commit count: %d\n", log.Count(CommitMsg, testCommitMessage.Height))
-	fmt.Printf("Sign log: %v\n", log.GetSignLog(testSignMessage.ID, testSignMessage.Height))
+	fmt.Printf("Sign log: %v\n", log.GetSignLog(testSignMessage.PubKey, testSignMessage.Height))
 
-	fmt.Printf("have checkpoint %d prepare message: %v\n", anotherPrepareMessage.Height, log.HaveLog(PrepareMsg, anotherPrepareMessage.ID, anotherPrepareMessage.Height))
+	fmt.Printf("have checkpoint %d prepare message: %v\n", anotherPrepareMessage.Height, log.HaveLog(PrepareMsg, anotherPrepareMessage.PubKey, anotherPrepareMessage.Height))
 	log.AddMessage(PrepareMsg, anotherPrepareMessage)
 	fmt.Printf("prepare count: %d\n", log.Count(PrepareMsg, anotherPrepareMessage.Height))
 	log.ClearLog()
 	fmt.Printf("Commit count: %d\n", log.Count(CommitMsg, testCommitMessage.Height))
 
 }
+
+func TestMsgLog_CommitCert(t *testing.T) {
+	log := NewMsgLog()
+	hash := []byte("block")
+	for i := 0; i < 3; i++ {
+		log.AddMessage(CommitMsg, CommitMessage{
+			ID:        fmt.Sprintf("node%d", i),
+			Height:    5,
+			BlockHash: hash,
+			View:      1,
+			Sign:      []byte("sign"),
+			PubKey:    []byte(fmt.Sprintf("key%d", i)),
+		})
+	}
+	// commit of another view is not part of the certificate
+	log.AddMessage(CommitMsg, CommitMessage{
+		ID:        "node3",
+		Height:    5,
+		BlockHash: hash,
+		View:      2,
+		Sign:      []byte("sign"),
+		PubKey:    []byte("key3"),
+	})
+	cert := log.CommitCert(5, 1, hash)
+	fmt.Println(cert.Height, cert.View, len(cert.Signatures))
+	if len(cert.Signatures) != 3 {
+		t.Error("commit certificate signature count error")
+	}
+}
+
+func TestMsgLog_Prune(t *testing.T) {
+	log := NewMsgLog()
+	for height := uint64(1); height <= 3; height++ {
+		log.AddMessage(SignMsg, SignMessage{
+			ID:        "node0",
+			Height:    height,
+			BlockHash: []byte("block"),
+			PubKey:    []byte("key0"),
+		})
+		log.AddMessage(SignMsg, SignMessage{
+			ID:        "node1",
+			Height:    height,
+			BlockHash: []byte("other"),
+			PubKey:    []byte("key1"),
+		})
+	}
+	fmt.Printf("sign count for block: %d\n", log.CountFor(SignMsg, 2, []byte("block"), nil))
+	if log.CountFor(SignMsg, 2, []byte("block"), nil) != 1 {
+		t.Error("sign count for block error")
+	}
+	// sequence numbers below 3 are executed
+	log.Prune(3)
+	fmt.Printf("sign count after prune: %d, %d\n", log.Count(SignMsg, 2), log.Count(SignMsg, 3))
+	if log.Count(SignMsg, 2) != 0 || log.Count(SignMsg, 3) != 2 {
+		t.Error("prune log error")
+	}
+}
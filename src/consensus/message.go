@@ -1,6 +1,11 @@
 package consensus
 
-import "encoding/json"
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/utils"
+	"encoding/binary"
+)
 
 type PBFTMsgType int32
 
@@ -10,6 +15,8 @@ const (
 	PrepareMsg
 	CommitMsg
 	ViewChangeMsg
+	CatchUpRequestMsg
+	CatchUpResponseMsg
 )
 
 // PBFTMessage type
@@ -31,22 +38,24 @@ type PrepareMessage struct {
 
 // SignMessage pBFT sign message
 type SignMessage struct {
-	ID        string `json:"id"`        // sender ID
-	Height    uint64 `json:"height"`    // signed block height
-	BlockHash []byte `json:"blockHash"` // signed block hash
-	View      uint64 `json:"view"`      // current view
-	Sign      []byte `json:"sign"`      // signature
-	PubKey    []byte `json:"pubKey"`    // sender public key
+	ID        string `json:"id"`                // sender ID
+	Height    uint64 `json:"height"`            // signed block height
+	BlockHash []byte `json:"blockHash"`         // signed block hash
+	View      uint64 `json:"view"`              // current view
+	Sign      []byte `json:"sign"`              // signature
+	PubKey    []byte `json:"pubKey"`            // sender public key
+	BLSSign   []byte `json:"blsSign,omitempty"` // BLS signature of the commit certificate digest
 }
 
 // CommitMessage pBFT commit message
 type CommitMessage struct {
-	ID        string `json:"id"`        // sender ID
-	Height    uint64 `json:"height"`    // commit block height
-	BlockHash []byte `json:"blockHash"` // commit block hash
-	View      uint64 `json:"view"`      // current view
-	Sign      []byte `json:"sign"`      // signature
-	PubKey    []byte `json:"pubKey"`    //sender pbulic key
+	ID        string `json:"id"`                // sender ID
+	Height    uint64 `json:"height"`            // commit block height
+	BlockHash []byte `json:"blockHash"`         // commit block hash
+	View      uint64 `json:"view"`              // current view
+	Sign      []byte `json:"sign"`              // signature
+	PubKey    []byte `json:"pubKey"`            //sender pbulic key
+	BLSSign   []byte `json:"blsSign,omitempty"` // BLS signature of the commit certificate digest
 }
 
 // ViewChangeMessage pBFT view change message
@@ -60,12 +69,39 @@ type ViewChangeMessage struct {
 	PubKey    []byte `json:"pubKey"`    // sender public key
 }
 
+// CatchUpRequestMessage lagging node asks for committed blocks in a height range
+type CatchUpRequestMessage struct {
+	ID         string `json:"id"`         // sender ID
+	FromHeight uint64 `json:"fromHeight"` // first requested block height
+	ToHeight   uint64 `json:"toHeight"`   // last requested block height
+}
+
+// CatchUpResponseMessage committed blocks with their commit certificates in height order
+type CatchUpResponseMessage struct {
+	ID     string            `json:"id"`     // sender ID
+	Blocks []*CertifiedBlock `json:"blocks"` // certified blocks
+}
+
+// CertifiedBlock is a committed block with its commit certificate
+type CertifiedBlock struct {
+	Block *blockchain.Block      `json:"block"` // committed block
+	Cert  *blockchain.QuorumCert `json:"cert"`  // commit certificate of block
+}
+
+// Digest returns the digest a replica signs for a view change, covering its chain height and the target view
+func (m *ViewChangeMessage) Digest() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], m.Height)
+	binary.BigEndian.PutUint64(buf[8:], m.ToView)
+	return utils.Sha256Hash(append(append([]byte{}, m.BlockHash...), buf...))
+}
+
 // SplitMessage splits the PBFTMessage into the corresponding message struct based on its type.
 func (m *PBFTMessage) SplitMessage() (interface{}, PBFTMsgType) {
 	switch m.Type {
 	case SignMsg:
 		var sMsg SignMessage
-		err := json.Unmarshal(m.Data, &sMsg)
+		err := codec.Unmarshal(m.Data, &sMsg)
 		if err != nil {
 			return nil, DefaultMsg // Return default message type on unmarshal error
 		}
@@ -73,7 +109,7 @@ func (m *PBFTMessage) SplitMessage() (interface{}, PBFTMsgType) {
 
 	case PrepareMsg:
 		var pMsg PrepareMessage
-		err := json.Unmarshal(m.Data, &pMsg)
+		err := codec.Unmarshal(m.Data, &pMsg)
 		if err != nil {
 			return nil, DefaultMsg // Return default message type on unmarshal error
 		}
@@ -81,7 +117,7 @@ func (m *PBFTMessage) SplitMessage() (interface{}, PBFTMsgType) {
 
 	case CommitMsg:
 		var cMsg CommitMessage
-		err := json.Unmarshal(m.Data, &cMsg)
+		err := codec.Unmarshal(m.Data, &cMsg)
 		if err != nil {
 			return nil, DefaultMsg // Return default message type on unmarshal error
 		}
@@ -89,12 +125,28 @@ func (m *PBFTMessage) SplitMessage() (interface{}, PBFTMsgType) {
 
 	case ViewChangeMsg:
 		var vcMsg ViewChangeMessage
-		err := json.Unmarshal(m.Data, &vcMsg)
+		err := codec.Unmarshal(m.Data, &vcMsg)
 		if err != nil {
 			return nil, DefaultMsg // Return default message type on unmarshal error
 		}
 		return vcMsg, ViewChangeMsg // Return the ViewChangeMessage and its corresponding message type
 
+	case CatchUpRequestMsg:
+		var crMsg CatchUpRequestMessage
+		err := codec.Unmarshal(m.Data, &crMsg)
+		if err != nil {
+			return nil, DefaultMsg // Return default message type on unmarshal error
+		}
+		return crMsg, CatchUpRequestMsg // Return the CatchUpRequestMessage and its corresponding message type
+
+	case CatchUpResponseMsg:
+		var cpMsg CatchUpResponseMessage
+		err := codec.Unmarshal(m.Data, &cpMsg)
+		if err != nil {
+			return nil, DefaultMsg // Return default message type on unmarshal error
+		}
+		return cpMsg, CatchUpResponseMsg // Return the CatchUpResponseMessage and its corresponding message type
+
 	default:
 		return nil, DefaultMsg // Return default message type for unknown PBFTMsgType
 	}
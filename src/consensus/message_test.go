@@ -0,0 +1,36 @@
+package consensus
+
+import (
+	"BlockChain/src/codec"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// golden vector of a pBFT message in the canonical encoding
+const goldenSignMessage = "01000000011b01026e31000000000000000201aa00000000000000030101010200"
+
+func TestPBFTMessage_Golden(t *testing.T) {
+	sign := SignMessage{ID: "n1", Height: 2, BlockHash: []byte{0xaa}, View: 3, Sign: []byte{0x01}, PubKey: []byte{0x02}}
+	payload, err := codec.Marshal(&sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := codec.Marshal(&PBFTMessage{Type: SignMsg, Data: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("Sign message: ", hex.EncodeToString(data))
+	if hex.EncodeToString(data) != goldenSignMessage {
+		t.Fatal("pBFT message encoding not match golden vector")
+	}
+
+	var msg PBFTMessage
+	if err := codec.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	decoded, msgType := msg.SplitMessage()
+	if msgType != SignMsg || decoded.(SignMessage).Height != 2 {
+		t.Fatal("split decoded message fail")
+	}
+}
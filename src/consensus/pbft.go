@@ -2,12 +2,14 @@ package consensus
 
 import (
 	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	"BlockChain/src/mycrypto"
 	p2pnet "BlockChain/src/network"
 	"BlockChain/src/pool"
 	"BlockChain/src/utils"
+	"bytes"
 	"crypto/ecdsa"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"log"
 	"sync"
@@ -15,7 +17,10 @@ import (
 )
 
 const (
-	ViewTimeout = 20
+	MaxViewBackoff = 6  // max doublings of view change timeout on consecutive failed views
+	CatchUpTimeout = 5  // catch up request retry timeout in seconds
+	CatchUpBatch   = 16 // max blocks in one catch up response
+	SequenceWindow = 8  // max running instances, sequence numbers above chain height
 )
 
 // PBFT type
@@ -34,6 +39,15 @@ type PBFT struct {
 	isStart   bool // flag of start
 	isPrimary bool // flag of primary node
 	isRunning bool // flag of running
+	isSyncing bool // flag of catching up committed blocks, voting paused
+
+	requestClient *RequestClient             // client library sending replies
+	requests      map[string]*RequestMessage // tx ID -> client request waiting for commit
+
+	syncTarget     uint64            // chain height to catch up to
+	syncRetries    int               // unanswered catch up requests
+	pendingPrepare *PBFTMessage      // latest prepare message received while catching up
+	aheadHeights   map[string]uint64 // hex validator public key -> chain height above ours reported in a signed message
 
 	view         uint64 // current view
 	index        uint64 // node index
@@ -41,20 +55,32 @@ type PBFT struct {
 	nodeNum      uint64 // total consensus node number
 	maxFaultNode uint64 // max pBFT fault node number
 
+	dynamic      bool                     // validator set is kept on chain and changed by governance votes
+	epoch        uint64                   // epoch of current validator set
+	validatorSet *blockchain.ValidatorSet // validator public keys and voting power
+	isValidator  bool                     // flag of a member of current validator set
+	useVRF       bool                     // primary node is chosen by VRF output of the parent block
+	useBLS       bool                     // sign and commit votes carry BLS signatures aggregated in commit certificates
+	blsKey       *mycrypto.BLSKey         // BLS key derived from private key
+
+	baseTimeout     time.Duration     // configured view change timeout
+	viewTimeout     time.Duration     // current view change timeout
+	failedViews     uint              // consecutive views timed out without a committed block
 	viewChangeTimer *time.Timer       // view change timer
+	catchUpTimer    *time.Timer       // catch up request retry timer
 	consensusMsg    chan *PBFTMessage // consensus message channel
 	lock            sync.Mutex
 	log             *log.Logger
 }
 
 // NewPBFT create pBFT engine
-func NewPBFT(num, index uint64, f uint64, v uint64, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*PBFT, error) {
+func NewPBFT(num, index uint64, f uint64, v uint64, timeout uint64, election string, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*PBFT, error) {
 	// initialize logger
 	l := utils.NewLogger("[pbft] ", logPath)
 
 	pbft := &PBFT{
 		engine:       NewEngine(),
-		msgLog:       NewMsgLog(num),
+		msgLog:       NewMsgLog(),
 		net:          net,
 		chain:        chain,
 		publicKey:    wallet.GetPublicKey(),
@@ -67,18 +93,44 @@ func NewPBFT(num, index uint64, f uint64, v uint64, tp *pool.TxPool, bp *pool.Bl
 		nodeNum:      num,
 		index:        index,
 		maxFaultNode: f,
+		isValidator:  true,
 		log:          l,
 		consensusMsg: make(chan *PBFTMessage),
+		requests:     make(map[string]*RequestMessage),
+		aheadHeights: make(map[string]uint64),
 	}
 	pbft.id = string(wallet.GetAddress())
+	if timeout == 0 {
+		timeout = blockchain.ActiveParams().ViewTimeout
+	}
+	pbft.baseTimeout = time.Duration(timeout) * time.Second
+	pbft.viewTimeout = pbft.baseTimeout
+	// on chain validator set overrides the configured one
+	pbft.reconfigure()
+	if !pbft.dynamic {
+		pbft.log.Println("No validator public keys, certificates of peers are not accepted and catch up is disabled")
+	}
+	switch election {
+	case "", LeaderRotate:
+	case LeaderVRF:
+		// replicas check the elected primary by its public key
+		if !pbft.dynamic {
+			return nil, errors.New("vrf leader election needs validator public keys")
+		}
+		pbft.useVRF = true
+	default:
+		return nil, errors.New("unknown leader election: " + election)
+	}
 	// set primary node
-	pbft.leaderIndex = (v + chain.BestHeight) % pbft.nodeNum
+	pbft.leaderIndex = pbft.leaderOf(chain.BestHeight + 1)
 	if pbft.leaderIndex == pbft.index {
 		pbft.isPrimary = true
 	}
 	// initialize timer
-	pbft.viewChangeTimer = time.NewTimer(ViewTimeout * time.Second)
+	pbft.viewChangeTimer = time.NewTimer(pbft.viewTimeout)
 	pbft.viewChangeTimer.Stop()
+	pbft.catchUpTimer = time.NewTimer(CatchUpTimeout * time.Second)
+	pbft.catchUpTimer.Stop()
 	return pbft, nil
 }
 
@@ -89,11 +141,16 @@ func (pbft *PBFT) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string
 	}
 	// Unmarshal message byte
 	var msg PBFTMessage
-	err := json.Unmarshal(msgBytes, &msg)
+	err := codec.Unmarshal(msgBytes, &msg)
 	if err != nil {
 		pbft.log.Println("Unmarshal PBFTMessage fail")
 		return
 	}
+	// serve catch up request from chain directly, it does not change consensus state
+	if msg.Type == CatchUpRequestMsg {
+		pbft.handleCatchUpRequest(&msg, peerID)
+		return
+	}
 	// run consensus engine
 	pbft.consensusMsg <- &msg
 }
@@ -112,34 +169,56 @@ func (pbft *PBFT) Run() {
 			if !pbft.isStart {
 				continue
 			}
+			// catch up lagging blocks before voting
+			if pbft.checkCatchUp(msg) {
+				continue
+			}
 			// run FSM handle message
 			pbft.NextState(msg)
+		case <-pbft.catchUpTimer.C:
+			// no response in time, ask again
+			if pbft.isSyncing {
+				pbft.requestCatchUp()
+			}
 		case <-pbft.viewChangeTimer.C:
+			// a lagging node or a removed validator does not count toward view change
+			if pbft.isSyncing || !pbft.isValidator {
+				continue
+			}
+			// running blocks were connected by block sync, nothing is stuck
+			if pbft.GetState() != ViewChangeState && pbft.pruneExecuted() && pbft.runningInstances() == 0 {
+				pbft.lock.Lock()
+				pbft.isRunning = false
+				pbft.lock.Unlock()
+				continue
+			}
 			// primary node timeout or running timeout
 			// raise view change
-			pbft.log.Println("View change timeout, run view change")
-
 			pbft.lock.TryLock()
-			pbft.viewChangeTimer.Stop()
+			// wait longer in the next view, a slow network may be the cause
+			pbft.backoffViewTimeout()
+			pbft.log.Println("View change timeout, run view change, next timeout: ", pbft.viewTimeout)
+			// retry if the view change does not finish in time
+			pbft.viewChangeTimer.Reset(pbft.viewTimeout)
 			pbft.isRunning = true
 			pbft.SetState(ViewChangeState)
 			pbft.lock.Unlock()
 
 			// sign
-			signature, err := mycrypto.Sign(pbft.privateKey, pbft.chain.Tip)
-			if err != nil {
-				pbft.log.Println("Sign view change message fail")
-				continue
-			}
 			msg := ViewChangeMessage{
 				ID:        pbft.id,
 				Height:    pbft.chain.BestHeight,
 				BlockHash: pbft.chain.Tip,
 				View:      pbft.view,
 				ToView:    (pbft.view + 1) % pbft.nodeNum,
-				Sign:      signature,
 				PubKey:    mycrypto.PublicKey2Bytes(pbft.publicKey),
 			}
+			signature, err := mycrypto.Sign(pbft.privateKey, msg.Digest())
+			if err != nil {
+				pbft.log.Println("Sign view change message fail")
+				continue
+			}
+			msg.Sign = signature
 			p2pmsg, err := pbft.packBroadcastMessage(ViewChangeMsg, msg)
 			if err != nil {
 				pbft.log.Println(err)
@@ -147,7 +226,7 @@ func (pbft *PBFT) Run() {
 			// broadcast
 			pbft.net.Broadcast(p2pmsg)
 			var pbftMsg PBFTMessage
-			json.Unmarshal(p2pmsg.Data, &pbftMsg)
+			codec.Unmarshal(p2pmsg.Data, &pbftMsg)
 			// run consensus engine
 			pbft.NextState(&pbftMsg)
 
@@ -155,51 +234,152 @@ func (pbft *PBFT) Run() {
 			// receive TxPool interrupt
 			pbft.log.Println("TxPool full, pack into block...")
 			pbft.lock.Lock()
-			if !pbft.isStart || pbft.isRunning {
-				// is running or not start, ignore
+			if !pbft.isStart || pbft.isSyncing || !pbft.isValidator || pbft.GetState() == ViewChangeState {
+				// not start or not voting, ignore
 				pbft.lock.Unlock()
 				continue
 			}
-			if !pbft.isPrimary {
-				// not primary node
+			if pbft.leaderOf(pbft.nextSequence()) != pbft.index {
+				// not primary node of the next sequence number
 				// start viewChange timer, wait primary node prepare message
+				if !pbft.isRunning {
+					pbft.log.Println("Start primary node timer")
+					pbft.viewChangeTimer.Reset(pbft.viewTimeout)
+				}
 				pbft.lock.Unlock()
-				pbft.log.Println("Start primary node timer")
-				pbft.viewChangeTimer.Reset(ViewTimeout * time.Second)
 				continue
 			}
 			pbft.lock.Unlock()
 
 			// primary node pack Txs into block and send prepare message
-			msg, err := pbft.PBFTSealer()
-			if err != nil {
-				pbft.log.Println(err)
-				continue
-			}
-			// serialize PBFTMessage
-			serialized, err := json.Marshal(msg)
-			if err != nil {
-				pbft.log.Println(err)
-				continue
-			}
-			// pack P2P network message
-			p2pMessage := &p2pnet.Message{
-				Type: p2pnet.ConsensusMsg,
-				Data: serialized,
-			}
-			// broadcast
-			pbft.log.Println("Broadcast prepare message")
-			pbft.net.Broadcast(p2pMessage)
-			time.Sleep(10 * time.Millisecond)
+			pbft.tryPropose()
+		}
+	}
+}
 
-			// send prepare message to self engine
-			pbft.NextState(msg)
+// backoffViewTimeout doubles view change timeout after a failed view
+func (pbft *PBFT) backoffViewTimeout() {
+	if pbft.failedViews < MaxViewBackoff {
+		pbft.failedViews++
+	}
+	pbft.viewTimeout = pbft.baseTimeout << pbft.failedViews
+}
+
+// resetViewTimeout restores the configured view change timeout after a committed block
+func (pbft *PBFT) resetViewTimeout() {
+	pbft.failedViews = 0
+	pbft.viewTimeout = pbft.baseTimeout
+}
+
+// reconfigure loads validator set from chain when a new epoch starts,
+// quorum, node index and leader rotation follow the new set
+func (pbft *PBFT) reconfigure() {
+	vs := pbft.chain.GetValidators()
+	if vs == nil || (pbft.dynamic && vs.Epoch == pbft.epoch) {
+		return
+	}
+	index, member := vs.IndexOf(mycrypto.PublicKey2Bytes(pbft.publicKey))
+	pbft.lock.Lock()
+	pbft.dynamic = true
+	pbft.epoch = vs.Epoch
+	pbft.validatorSet = vs
+	pbft.nodeNum = vs.NodeNum()
+	pbft.maxFaultNode = vs.MaxFault()
+	pbft.index = index
+	pbft.isValidator = member
+	pbft.view = pbft.view % pbft.nodeNum
+	pbft.leaderIndex = pbft.leaderOf(pbft.chain.BestHeight + 1)
+	pbft.isPrimary = member && pbft.leaderIndex == pbft.index
+	rc := pbft.requestClient
+	pbft.lock.Unlock()
+	if rc != nil {
+		rc.SetMaxFaultNode(vs.MaxFault())
+	}
+	pbft.log.Printf("Epoch %d, validators: %d, max fault: %d, index: %d, validator: %v", vs.Epoch, vs.NodeNum(), vs.MaxFault(), index, member)
+}
+
+// isMember checks a message signer is a validator of current epoch
+func (pbft *PBFT) isMember(pubKey []byte) bool {
+	return !pbft.dynamic || pbft.validatorSet.Power(pubKey) > 0
+}
+
+// hasQuorum checks messages of a sequence number voting for block hash reach the quorum,
+// 2f+1 messages for a fixed set or more than 2/3 of voting power for an on chain set
+func (pbft *PBFT) hasQuorum(msgType PBFTMsgType, seq uint64, hash []byte) bool {
+	if !pbft.dynamic {
+		return pbft.msgLog.CountFor(msgType, seq, hash, nil) >= 2*pbft.maxFaultNode+1
+	}
+	return pbft.validatorSet.HasQuorum(pbft.msgLog.CountFor(msgType, seq, hash, pbft.validatorSet.Power))
+}
+
+// verifyCert checks a commit certificate reaches the quorum of validators, without configured
+// validator public keys no signer can be bound to a node and no certificate of peers is accepted
+func (pbft *PBFT) verifyCert(cert *blockchain.QuorumCert) bool {
+	if !pbft.dynamic {
+		return false
+	}
+	return pbft.validatorSet.VerifyCert(cert)
+}
+
+// VerifyCert checks a commit certificate received with a block from peers,
+// observers load validator set changes of connected blocks first
+func (pbft *PBFT) VerifyCert(cert *blockchain.QuorumCert) bool {
+	pbft.reconfigure()
+	return pbft.verifyCert(cert)
+}
+
+// SetRequestClient sets the client library receiving requests and sending replies
+func (pbft *PBFT) SetRequestClient(rc *RequestClient) {
+	pbft.lock.Lock()
+	pbft.requestClient = rc
+	pbft.lock.Unlock()
+	rc.SetRequestHandler(pbft.OnRequest)
+	if pbft.dynamic {
+		rc.SetMaxFaultNode(pbft.maxFaultNode)
+	}
+}
+
+// OnRequest receive a client request, the transaction goes to tx pool and is replied once committed
+func (pbft *PBFT) OnRequest(request *RequestMessage) {
+	var tx blockchain.Transaction
+	err := codec.Unmarshal(request.Tx, &tx)
+	if err != nil || !bytes.Equal(tx.ID, request.TxID) {
+		pbft.log.Println("Invalid request transaction")
+		return
+	}
+	id := hex.EncodeToString(tx.ID)
+	pbft.lock.Lock()
+	pbft.requests[id] = request
+	pbft.lock.Unlock()
+	if !pbft.txPool.HaveTransaction(id) {
+		pbft.txPool.AddTransaction(&tx)
+	}
+}
+
+// replyRequests replies client requests of transactions in a committed block
+func (pbft *PBFT) replyRequests(block *blockchain.Block) {
+	pbft.lock.Lock()
+	rc := pbft.requestClient
+	view := pbft.view
+	var requests []*RequestMessage
+	for _, tx := range block.Transactions {
+		id := hex.EncodeToString(tx.ID)
+		if request, ok := pbft.requests[id]; ok {
+			requests = append(requests, request)
+			delete(pbft.requests, id)
 		}
 	}
+	pbft.lock.Unlock()
+	if rc == nil {
+		return
+	}
+	for _, request := range requests {
+		rc.Reply(request, view, block)
+	}
 }
 
-// PBFTSealer primary node pack block
-func (pbft *PBFT) PBFTSealer() (*PBFTMessage, error) {
+// PBFTSealer primary node pack block of a sequence number on its parent block
+func (pbft *PBFT) PBFTSealer(parent []byte, seq uint64) (*PBFTMessage, error) {
 	pbft.log.Println("Primary node pack block...")
 	// get txs from pool
 	txMap := pbft.txPool.GetTransactions()
@@ -209,14 +389,28 @@ func (pbft *PBFT) PBFTSealer() (*PBFTMessage, error) {
 	}
 
 	// pack block
-	newBlock := blockchain.NewBlock(pbft.chain.Tip, txs, pbft.chain.BestHeight+1)
-	blockData, err := json.Marshal(newBlock)
+	newBlock := blockchain.NewBlock(parent, txs, seq)
+	if root := pbft.chain.StateCommitment(seq); root != nil {
+		// commit the state snapshot for fast sync
+		newBlock.Header.StateRoot = root
+		newBlock.Header.Hash = newBlock.CalculateHash()
+	}
+	if pbft.useVRF {
+		// the VRF output of the block elects the next primary
+		proof, err := pbft.proveVRF(seq)
+		if err != nil {
+			return nil, err
+		}
+		newBlock.Header.VRF = proof
+		newBlock.Header.Hash = newBlock.CalculateHash()
+	}
+	blockData, err := codec.Marshal(newBlock)
 	if err != nil {
 		return nil, errors.New("Marshal block data fail")
 	}
 
 	// sign
-	signature, err := mycrypto.Sign(pbft.privateKey, newBlock.Header.Hash)
+	signature, err := mycrypto.Sign(pbft.privateKey, blockchain.CertDigest(newBlock.Header.Hash, pbft.view))
 	if err != nil {
 		return nil, errors.New("Sign message fail")
 	}
@@ -232,7 +426,7 @@ func (pbft *PBFT) PBFTSealer() (*PBFTMessage, error) {
 		PubKey:    mycrypto.PublicKey2Bytes(pbft.publicKey),
 	}
 
-	payload, err := json.Marshal(prepare)
+	payload, err := codec.Marshal(prepare)
 	if err != nil {
 		return nil, errors.New("marshal error")
 	}
@@ -270,3 +464,24 @@ func (pbft *PBFT) IsPrimary() bool {
 	defer pbft.lock.Unlock()
 	return pbft.isPrimary // Check if the PBFT node is a primary node
 }
+
+// Status returns current pBFT engine status
+func (pbft *PBFT) Status() *Status {
+	pbft.lock.Lock()
+	defer pbft.lock.Unlock()
+	return &Status{
+		Engine:    EnginePBFT,
+		IsPrimary: pbft.isPrimary && pbft.isValidator,
+		View:      pbft.view,
+		Syncing:   pbft.isSyncing,
+		Timeout:   pbft.viewTimeout,
+		Epoch:     pbft.epoch,
+		NodeNum:   pbft.nodeNum,
+	}
+}
+
+// VerifyBlock checks a block received from peers matches its hash, a commit certificate of the hash
+// is checked by the block pool, pBFT blocks carry no reward
+func (pbft *PBFT) VerifyBlock(block *blockchain.Block) error {
+	return verifyContent(pbft.chain, block)
+}
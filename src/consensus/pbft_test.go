@@ -0,0 +1,254 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/mycrypto"
+	"BlockChain/src/storage"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPBFT_ViewTimeoutBackoff(t *testing.T) {
+	pbft := &PBFT{
+		baseTimeout: 2 * time.Second,
+		viewTimeout: 2 * time.Second,
+	}
+	for i := 0; i < MaxViewBackoff+2; i++ {
+		pbft.backoffViewTimeout()
+		fmt.Println("view change timeout: ", pbft.viewTimeout)
+	}
+	if pbft.viewTimeout != 2*time.Second<<MaxViewBackoff {
+		t.Error("view change timeout backoff error")
+	}
+	pbft.resetViewTimeout()
+	if pbft.viewTimeout != 2*time.Second || pbft.failedViews != 0 {
+		t.Error("reset view change timeout error")
+	}
+}
+
+func TestPBFT_CatchUpReports(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	pbft := &PBFT{chain: chain, aheadHeights: make(map[string]uint64)}
+
+	viewChange := &ViewChangeMessage{Height: 100, BlockHash: []byte("tip"), ToView: 1, PubKey: keys[1]}
+	viewChange.Sign, _ = mycrypto.Sign(wallets[1].GetPrivateKey(), viewChange.Digest())
+	if pbft.signedByValidator(viewChange.PubKey, viewChange.Digest(), viewChange.Sign) {
+		t.Fatal("trust signer without validator public keys")
+	}
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pbft.dynamic, pbft.validatorSet = true, chain.GetValidators()
+	if !pbft.signedByValidator(viewChange.PubKey, viewChange.Digest(), viewChange.Sign) {
+		t.Fatal("verify view change of validator fail")
+	}
+	viewChange.Height = 1000
+	if pbft.signedByValidator(viewChange.PubKey, viewChange.Digest(), viewChange.Sign) {
+		t.Fatal("accept view change with changed height")
+	}
+	outsider := blockchain.CreateWallet()
+	sign, _ := mycrypto.Sign(outsider.GetPrivateKey(), viewChange.Digest())
+	if pbft.signedByValidator(outsider.GetPublicKeyBytes(), viewChange.Digest(), sign) {
+		t.Fatal("accept view change of unknown key")
+	}
+
+	// one validator can not start a catch up, f+1 validators can
+	if pbft.reportHeight(keys[1], 100) != 0 || pbft.reportHeight(keys[1], 200) != 0 {
+		t.Fatal("catch up on report of one validator")
+	}
+	target := pbft.reportHeight(keys[2], 50)
+	fmt.Println("catch up target: ", target)
+	if target != 50 {
+		t.Fatal("catch up target not reached by f+1 validators")
+	}
+}
+
+func TestPBFT_BufferVerifiedPrepare(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pbft := &PBFT{
+		chain:        chain,
+		engine:       NewEngine(),
+		msgLog:       NewMsgLog(),
+		dynamic:      true,
+		validatorSet: chain.GetValidators(),
+		log:          log.New(io.Discard, "", 0),
+	}
+
+	// the parent of sequence height+2 is not prepared yet
+	seq := chain.GetHeight() + 2
+	primary := pbft.leaderOf(seq)
+	prepareOf := func(w *blockchain.Wallet) *PrepareMessage {
+		prepare := &PrepareMessage{ID: string(w.GetAddress()), Height: seq, BlockHash: []byte("block"), PubKey: w.GetPublicKeyBytes()}
+		prepare.Sign, _ = mycrypto.Sign(w.GetPrivateKey(), blockchain.CertDigest(prepare.BlockHash, prepare.View))
+		return prepare
+	}
+	other := wallets[(primary+1)%4]
+	pbft.onPrepare(prepareOf(other), &PBFTMessage{})
+	forged := prepareOf(wallets[primary])
+	forged.BlockHash = []byte("forged")
+	pbft.onPrepare(forged, &PBFTMessage{})
+	if len(pbft.engine.buffered) != 0 {
+		t.Fatal("buffer prepare not signed by primary")
+	}
+	pbft.onPrepare(prepareOf(wallets[primary]), &PBFTMessage{})
+	if _, ok := pbft.engine.buffered[bufferKey{view: 0, seq: seq}]; !ok {
+		t.Fatal("prepare of primary not buffered")
+	}
+
+	// reading the log does not create entries
+	pbft.msgLog.HaveLog(PrepareMsg, []byte("key"), 1<<40)
+	pbft.msgLog.Count(CommitMsg, 1<<40)
+	if len(pbft.msgLog.logs) != 0 {
+		t.Fatal("log entry created by read")
+	}
+}
+
+func TestPBFT_ReplayedVote(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pbft := &PBFT{
+		chain:        chain,
+		engine:       NewEngine(),
+		msgLog:       NewMsgLog(),
+		dynamic:      true,
+		validatorSet: chain.GetValidators(),
+		log:          log.New(io.Discard, "", 0),
+	}
+
+	// the node ID is not signed, a vote replayed under other IDs counts once
+	seq, hash := chain.GetHeight()+1, []byte("block")
+	commit := CommitMessage{ID: "node1", Height: seq, BlockHash: hash, PubKey: keys[1]}
+	commit.Sign, _ = mycrypto.Sign(wallets[1].GetPrivateKey(), blockchain.CertDigest(hash, 0))
+	if _, err := pbft.handleCommit(&commit); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"node2", "node3"} {
+		replayed := commit
+		replayed.ID = id
+		_, err := pbft.handleCommit(&replayed)
+		fmt.Println("replayed commit: ", err)
+		if err == nil {
+			t.Fatal("accept replayed commit")
+		}
+	}
+	if pbft.msgLog.CountFor(CommitMsg, seq, hash, pbft.validatorSet.Power) != 1 || len(pbft.msgLog.CommitCert(seq, 0, hash).Signatures) != 1 {
+		t.Fatal("replayed commit counted")
+	}
+}
+
+func TestPBFT_VerifyBlock(t *testing.T) {
+	dir := t.TempDir()
+	wallet := blockchain.CreateWallet()
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	pbft := &PBFT{chain: chain, log: log.New(io.Discard, "", 0)}
+
+	block := blockchain.NewBlock(chain.Tip, []*blockchain.Transaction{}, chain.GetHeight()+1)
+	if err := pbft.VerifyBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	// the certified hash does not cover swapped transactions
+	reward := blockchain.NewRewardTx(wallet.GetAddress(), blockchain.ActiveParams().MinerReward, block.Header.Height)
+	block.Transactions = []*blockchain.Transaction{reward}
+	block.TransactionCounter = 1
+	err = pbft.VerifyBlock(block)
+	fmt.Println("swapped transactions: ", err)
+	if err == nil {
+		t.Fatal("accept block not matching its hash")
+	}
+	// a rehashed block pays no reward
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	block.Header.Hash = block.CalculateHash()
+	err = pbft.VerifyBlock(block)
+	fmt.Println("rewarded block: ", err)
+	if err == nil {
+		t.Fatal("accept pBFT block with reward")
+	}
+}
+
+func TestPBFT_PrepareHash(t *testing.T) {
+	dir := t.TempDir()
+	var wallets []*blockchain.Wallet
+	var keys [][]byte
+	for i := 0; i < 4; i++ {
+		wallets = append(wallets, blockchain.CreateWallet())
+		keys = append(keys, wallets[i].GetPublicKeyBytes())
+	}
+	chain, err := blockchain.CreateChain(wallets[0].GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	if err := chain.InitValidators(keys, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	pbft := &PBFT{
+		chain:        chain,
+		engine:       NewEngine(),
+		msgLog:       NewMsgLog(),
+		dynamic:      true,
+		validatorSet: chain.GetValidators(),
+		log:          log.New(io.Discard, "", 0),
+	}
+
+	// the primary signs the hash of a block, the transactions are swapped in transit
+	seq := chain.GetHeight() + 1
+	primary := wallets[pbft.leaderOf(seq)]
+	block := blockchain.NewBlock(chain.Tip, []*blockchain.Transaction{}, seq)
+	block.Transactions = []*blockchain.Transaction{blockchain.NewRewardTx(primary.GetAddress(), 10, seq)}
+	block.TransactionCounter = 1
+	data, _ := codec.Marshal(block)
+	prepare := &PrepareMessage{ID: string(primary.GetAddress()), Height: seq, BlockHash: block.Header.Hash, Block: data, PubKey: primary.GetPublicKeyBytes()}
+	prepare.Sign, _ = mycrypto.Sign(primary.GetPrivateKey(), blockchain.CertDigest(prepare.BlockHash, prepare.View))
+	_, err = pbft.handlePrepare(prepare, chain.Tip)
+	fmt.Println("swapped transactions: ", err)
+	if err == nil || pbft.msgLog.HaveBlock(seq) {
+		t.Fatal("accept prepare of block not matching its hash")
+	}
+}
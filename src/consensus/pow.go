@@ -0,0 +1,290 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"BlockChain/src/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBits        = 16                // initial difficulty bits
+	MinBits            = 8                 // lowest difficulty bits
+	MaxBits            = utils.MaxWorkBits // highest difficulty bits
+	RetargetInterval   = 10                // blocks between two difficulty retargets
+	TargetBlockSpacing = 10                // expected seconds between two blocks
+	MedianTimeBlocks   = 11                // blocks of the median time past a new block must be later than
+	MaxFutureDrift     = 7200              // seconds a block timestamp may be ahead of local time
+)
+
+// PoW proof of work consensus engine
+type PoW struct {
+	wallet    *blockchain.Wallet // local wallet, receives block reward
+	net       *p2pnet.P2PNet     // network layer
+	chain     *blockchain.Chain  // block chain
+	blockPool *pool.BlockPool    // block pool
+	txPool    *pool.TxPool       // tx pool
+
+	isStart bool   // flag of start
+	bits    uint32 // difficulty bits of the first blocks
+
+	lock sync.Mutex
+	log  *log.Logger
+}
+
+// NewPoW create proof of work engine
+func NewPoW(bits uint32, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*PoW, error) {
+	// initialize logger
+	l := utils.NewLogger("[pow] ", logPath)
+
+	if wallet == nil {
+		return nil, errors.New("unknown wallet")
+	}
+	if bits == 0 {
+		bits = DefaultBits
+	}
+	if bits < MinBits || bits > MaxBits {
+		return nil, fmt.Errorf("difficulty bits out of range [%d, %d]", MinBits, MaxBits)
+	}
+
+	pow := &PoW{
+		wallet:    wallet,
+		net:       net,
+		chain:     chain,
+		blockPool: bp,
+		txPool:    tp,
+		isStart:   false,
+		bits:      bits,
+		log:       l,
+	}
+	return pow, nil
+}
+
+// Run mine a new block every time tx pool is full
+func (pow *PoW) Run() {
+	pow.log.Println("Run proof of work consensus")
+	pow.Start()
+	// register callback func
+	pow.net.RegisterCallback(p2pnet.ConsensusMsg, pow.OnReceive)
+
+	for {
+		<-pow.txPool.FullSignal
+		if !pow.isStarted() {
+			continue
+		}
+		pow.log.Println("TxPool full, mining block...")
+		block, err := pow.mine()
+		if err != nil {
+			pow.log.Println(err)
+			continue
+		}
+		pow.log.Printf("Mined block %s, height: %d, nonce: %d", hex.EncodeToString(block.Header.Hash), block.Header.Height, block.Header.Nonce)
+		if !pow.blockPool.CommitBlock(block) {
+			pow.log.Println("Mined block not connected, keep transactions in pool")
+			continue
+		}
+		// transactions leave the pool only after the block is committed
+		for _, tx := range block.Transactions[1:] {
+			pow.txPool.RemoveTransaction(hex.EncodeToString(tx.ID))
+		}
+		pow.blockPool.BroadcastBlock(block)
+	}
+}
+
+// mine pack pool transactions and a reward into a block and search its nonce,
+// mining is given up when the tip changes
+func (pow *PoW) mine() (*blockchain.Block, error) {
+	parent := pow.chain.GetBlock(pow.chain.Tip)
+	if parent == nil {
+		return nil, errors.New("tip block not found")
+	}
+
+	// collect valid transactions not yet on chain, they stay in pool until the block is committed
+	height := parent.Header.Height + 1
+	params := blockchain.ActiveParams()
+	var txs []*blockchain.Transaction
+	for id, tx := range pow.txPool.GetTransactions() {
+		// leave room for the reward
+		if len(txs)+1 >= params.MaxBlockTxs {
+			break
+		}
+		if _, err := pow.chain.FindTransaction(tx.ID); err == nil {
+			// committed by a block of another miner
+			pow.txPool.RemoveTransaction(id)
+			continue
+		}
+		if !blockchain.VerifyTransactions(pow.chain, append(append([]*blockchain.Transaction{}, txs...), tx)) {
+			pow.log.Println("Drop invalid transaction: ", id)
+			pow.txPool.RemoveTransaction(id)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	txs = append([]*blockchain.Transaction{blockchain.NewRewardTx(pow.wallet.GetAddress(), params.MinerReward, height)}, txs...)
+
+	block := blockchain.NewWorkBlock(parent.Header.Hash, txs, height, pow.NextBits(parent))
+	// the timestamp must be later than the median time past
+	if mtp := pow.medianTimePast(parent); block.Header.Timestamp <= mtp {
+		block.Header.Timestamp = mtp + 1
+	}
+	tip := pow.chain.GetTip()
+	stop := func() bool {
+		return !pow.isStarted() || pow.chain.GetTip() != tip
+	}
+	nonce, hash, ok := utils.Mining(block.Header.SerializeHeader(), block.Header.Bits, stop)
+	if !ok {
+		return nil, errors.New("mining interrupted")
+	}
+	block.Header.Nonce = nonce
+	block.Header.Hash = hash
+	return block, nil
+}
+
+// NextBits returns the difficulty bits of the block on top of parent,
+// difficulty is retargeted every RetargetInterval blocks by the time spent on the last interval
+func (pow *PoW) NextBits(parent *blockchain.Block) uint32 {
+	bits := parent.Header.Bits
+	if bits == 0 {
+		// parent is genesis or not mined
+		return pow.bits
+	}
+	if (parent.Header.Height+1)%RetargetInterval != 0 {
+		return bits
+	}
+
+	// find the first block of the interval
+	first := parent
+	for i := 1; i < RetargetInterval; i++ {
+		prev := pow.chain.GetBlock(first.Header.PrevHash)
+		if prev == nil {
+			break
+		}
+		first = prev
+	}
+	actual := parent.Header.Timestamp - first.Header.Timestamp
+	expected := int64(parent.Header.Height-first.Header.Height) * TargetBlockSpacing
+	if actual < expected/2 && bits < MaxBits {
+		bits++
+	} else if actual > expected*2 && bits > MinBits {
+		bits--
+	}
+	return bits
+}
+
+// medianTimePast returns the median timestamp of parent and the MedianTimeBlocks-1 blocks before it
+func (pow *PoW) medianTimePast(parent *blockchain.Block) int64 {
+	var times []int64
+	for block := parent; block != nil && len(times) < MedianTimeBlocks; block = pow.chain.GetBlock(block.Header.PrevHash) {
+		times = append(times, block.Header.Timestamp)
+		if block.IsGenesisBlock() {
+			break
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// VerifyHeader checks the proof of work of a header, headers are checked before block bodies are synchronized
+func (pow *PoW) VerifyHeader(header *blockchain.BlockHeader) error {
+	if header.Bits < MinBits || header.Bits > MaxBits {
+		return errors.New("difficulty bits out of range")
+	}
+	// fields outside the proof of work would make the header malleable
+	if len(header.PrevHash) != sha256.Size || len(header.MerkleRoot) != sha256.Size {
+		return errors.New("wrong hash size in header")
+	}
+	if len(header.StateRoot) != 0 || len(header.VRF) != 0 || header.Justify != nil {
+		return errors.New("header field not covered by proof of work")
+	}
+	if !utils.Proof(header.SerializeHeader(), header.Nonce, header.Bits, header.Hash) {
+		return errors.New("invalid proof of work")
+	}
+	return nil
+}
+
+// VerifyBlock checks the proof of work, difficulty and reward of a block received from peers
+func (pow *PoW) VerifyBlock(block *blockchain.Block) error {
+	header := block.Header
+	if err := pow.VerifyHeader(header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header.MerkleRoot, block.CalculateMerkleRoot()) {
+		return errors.New("merkle root not match")
+	}
+	if block.TransactionCounter != len(block.Transactions) {
+		return errors.New("transaction counter not match")
+	}
+	if header.Timestamp > time.Now().Unix()+MaxFutureDrift {
+		return errors.New("block timestamp too far in the future")
+	}
+	// difficulty and median time past can only be checked when parent is known,
+	// orphans are verified again by the block pool when their parent is connected
+	if parent := pow.chain.GetBlock(header.PrevHash); parent != nil {
+		if header.Height != parent.Header.Height+1 {
+			return errors.New("wrong block height")
+		}
+		if header.Bits != pow.NextBits(parent) {
+			return errors.New("wrong difficulty bits")
+		}
+		if header.Timestamp <= pow.medianTimePast(parent) {
+			return errors.New("block timestamp not after median time past")
+		}
+	}
+	if err := blockchain.CheckCoinbase(block, blockchain.ActiveParams().MinerReward); err != nil {
+		return err
+	}
+	if !blockchain.VerifyTransactions(pow.chain, block.Transactions) {
+		return errors.New("tx verify error")
+	}
+	return nil
+}
+
+// OnReceive blocks are propagated by block pool, consensus messages are ignored
+func (pow *PoW) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
+	if t != p2pnet.ConsensusMsg {
+		return
+	}
+	pow.log.Println("Ignore consensus message from: ", peerID)
+}
+
+// Start sets the PoW engine as started
+func (pow *PoW) Start() {
+	pow.lock.Lock()
+	defer pow.lock.Unlock()
+	pow.isStart = true
+}
+
+// Stop sets the PoW engine as stopped, mining in progress is interrupted
+func (pow *PoW) Stop() {
+	pow.lock.Lock()
+	defer pow.lock.Unlock()
+	pow.isStart = false
+}
+
+func (pow *PoW) isStarted() bool {
+	pow.lock.Lock()
+	defer pow.lock.Unlock()
+	return pow.isStart
+}
+
+// Status returns current PoW engine status
+func (pow *PoW) Status() *Status {
+	status := &Status{
+		Engine:    EnginePoW,
+		IsPrimary: true,
+		View:      0,
+	}
+	if tip := pow.chain.GetBlock(pow.chain.Tip); tip != nil {
+		status.Difficulty = pow.NextBits(tip)
+	}
+	return status
+}
@@ -0,0 +1,41 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/utils"
+	"fmt"
+	"testing"
+)
+
+func TestPoW_HeaderMalleability(t *testing.T) {
+	wallet := blockchain.CreateWallet()
+	reward := blockchain.NewRewardTx(wallet.GetAddress(), blockchain.ActiveParams().MinerReward, 2)
+	block := blockchain.NewWorkBlock(utils.Sha256Hash([]byte("parent")), []*blockchain.Transaction{reward}, 2, MinBits)
+	nonce, hash, _ := utils.Mining(block.Header.SerializeHeader(), block.Header.Bits, func() bool { return false })
+	block.Header.Nonce, block.Header.Hash = nonce, hash
+	pow := &PoW{}
+	if err := pow.VerifyHeader(block.Header); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes keeping the proof of work are rejected
+	mutations := map[string]func(header *blockchain.BlockHeader){
+		"state root": func(header *blockchain.BlockHeader) { header.StateRoot = []byte("root") },
+		"vrf":        func(header *blockchain.BlockHeader) { header.VRF = []byte("proof") },
+		"justify":    func(header *blockchain.BlockHeader) { header.Justify = &blockchain.QuorumCert{} },
+		"moved byte": func(header *blockchain.BlockHeader) {
+			header.MerkleRoot = append(header.PrevHash[len(header.PrevHash)-1:], header.MerkleRoot...)
+			header.PrevHash = header.PrevHash[:len(header.PrevHash)-1]
+		},
+	}
+	for name, mutate := range mutations {
+		header := *block.Header
+		header.PrevHash = append([]byte{}, block.Header.PrevHash...)
+		mutate(&header)
+		err := pow.VerifyHeader(&header)
+		fmt.Println(name, ": ", err)
+		if err == nil {
+			t.Fatal("accept mutated header: ", name)
+		}
+	}
+}
@@ -0,0 +1,698 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"BlockChain/src/utils"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	RaftElectionTimeout   = 5  // base election timeout in seconds, randomized up to twice
+	RaftHeartbeatInterval = 1  // leader heartbeat interval in seconds
+	RaftMaxEntries        = 16 // max entries in one AppendEntries message
+	RaftSnapshotInterval  = 32 // applied entries kept in log before compaction
+	RaftSnapshotChunk     = 16 // max blocks in one InstallSnapshot message
+)
+
+// RaftRole is the role of a raft node
+type RaftRole int32
+
+const (
+	RaftFollower RaftRole = iota
+	RaftCandidate
+	RaftLeader
+)
+
+// raftEnvelope is a received raft message with its sender peer
+type raftEnvelope struct {
+	msg    *RaftMessage
+	peerID string
+}
+
+// Raft crash fault tolerant ordering engine for nodes inside one trust domain,
+// log index is block height and committed entries are appended to chain
+type Raft struct {
+	id      string   // node ID
+	raftLog *RaftLog // persistent log
+
+	net       *p2pnet.P2PNet    // network layer
+	chain     *blockchain.Chain // block chain
+	blockPool *pool.BlockPool   // block pool
+	txPool    *pool.TxPool      // tx pool
+
+	isStart   bool     // flag of start
+	role      RaftRole // current role
+	index     uint64   // node index
+	nodeNum   uint64   // total node number
+	leader    uint64   // index of current leader, valid if hasLeader
+	hasLeader bool     // flag of leader known in current term
+
+	commitIndex     uint64          // highest entry known committed
+	lastApplied     uint64          // highest entry appended to chain
+	votes           map[uint64]bool // votes granted to this candidate
+	nextIndex       map[uint64]uint64
+	matchIndex      map[uint64]uint64
+	pendingProposal bool // flag of tx pool full and waiting for a block
+
+	peers     map[uint64]string      // node index -> peer ID, learned from messages
+	transport func(msg *RaftMessage) // delivers a message to its receiver, the p2p network by default
+
+	electionTimer   *time.Timer
+	heartbeatTicker *time.Ticker
+	raftMsg         chan *raftEnvelope // consensus message channel
+	lock            sync.Mutex
+	log             *log.Logger
+}
+
+// NewRaft create Raft engine
+func NewRaft(num, index uint64, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*Raft, error) {
+	// initialize logger
+	l := utils.NewLogger("[raft] ", logPath)
+
+	if num == 0 || index >= num {
+		return nil, errors.New("invalid raft node number or index")
+	}
+
+	r := &Raft{
+		raftLog:    NewRaftLog(chain),
+		net:        net,
+		chain:      chain,
+		blockPool:  bp,
+		txPool:     tp,
+		role:       RaftFollower,
+		index:      index,
+		nodeNum:    num,
+		votes:      make(map[uint64]bool),
+		nextIndex:  make(map[uint64]uint64),
+		matchIndex: make(map[uint64]uint64),
+		peers:      make(map[uint64]string),
+		raftMsg:    make(chan *raftEnvelope),
+		log:        l,
+	}
+	r.id = string(wallet.GetAddress())
+	r.transport = r.sendToPeer
+	// blocks in chain are committed entries
+	r.lastApplied = chain.GetHeight()
+	if last := r.raftLog.LastIndex(); r.lastApplied > last {
+		r.lastApplied = last
+	}
+	r.commitIndex = r.lastApplied
+	// initialize timers
+	r.electionTimer = time.NewTimer(r.electionTimeout())
+	r.electionTimer.Stop()
+	return r, nil
+}
+
+// OnReceive receive callback func
+func (r *Raft) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
+	if t != p2pnet.ConsensusMsg {
+		return
+	}
+	var msg RaftMessage
+	err := codec.Unmarshal(msgBytes, &msg)
+	if err != nil {
+		r.log.Println("Unmarshal RaftMessage fail")
+		return
+	}
+	// messages to other nodes reach us when their peer is unknown
+	if msg.To != r.index {
+		return
+	}
+	r.raftMsg <- &raftEnvelope{msg: &msg, peerID: peerID}
+}
+
+// Run handle messages, timers and tx pool signal in one routine
+func (r *Raft) Run() {
+	r.log.Println("Run Raft consensus")
+	r.Start()
+	// register callback func
+	r.net.RegisterCallback(p2pnet.ConsensusMsg, r.OnReceive)
+
+	r.resetElectionTimer()
+	r.heartbeatTicker = time.NewTicker(RaftHeartbeatInterval * time.Second)
+	for {
+		select {
+		case envelope := <-r.raftMsg:
+			if !r.isStarted() {
+				continue
+			}
+			r.peers[envelope.msg.From] = envelope.peerID
+			r.handleMessage(envelope.msg)
+		case <-r.electionTimer.C:
+			if r.isStarted() && r.getRole() != RaftLeader {
+				r.startElection()
+			}
+		case <-r.heartbeatTicker.C:
+			if r.isStarted() && r.getRole() == RaftLeader {
+				r.tryPropose()
+				r.broadcastAppendEntries()
+			}
+		case <-r.txPool.FullSignal:
+			if !r.isStarted() {
+				continue
+			}
+			// followers drop txs when the leader's entries arrive
+			if r.getRole() == RaftLeader {
+				r.log.Println("TxPool full, propose block...")
+				r.pendingProposal = true
+				r.tryPropose()
+			}
+		}
+	}
+}
+
+// handleMessage dispatch a raft message to its handler
+func (r *Raft) handleMessage(msg *RaftMessage) {
+	data, t := msg.SplitMessage()
+	var err error
+	switch t {
+	case RequestVoteMsg:
+		err = r.handleRequestVote(msg, data.(*RequestVoteMessage))
+	case RequestVoteReplyMsg:
+		err = r.handleRequestVoteReply(msg, data.(*RequestVoteReplyMessage))
+	case AppendEntriesMsg:
+		err = r.handleAppendEntries(msg, data.(*AppendEntriesMessage))
+	case AppendEntriesReplyMsg:
+		err = r.handleAppendEntriesReply(msg, data.(*AppendEntriesReplyMessage))
+	case InstallSnapshotMsg:
+		err = r.handleInstallSnapshot(msg, data.(*InstallSnapshotMessage))
+	case InstallSnapshotReplyMsg:
+		err = r.handleInstallSnapshotReply(msg, data.(*InstallSnapshotReplyMessage))
+	default:
+		err = errors.New("unknown message")
+	}
+	if err != nil {
+		r.log.Println(err)
+	}
+}
+
+// startElection becomes candidate of next term and asks all nodes for votes
+func (r *Raft) startElection() {
+	state := r.raftLog.State()
+	term := state.CurrentTerm + 1
+	r.log.Println("Election timeout, start election of term: ", term)
+	if err := r.raftLog.SetTerm(term); err != nil {
+		r.log.Println("Persist term fail: ", err)
+		return
+	}
+	if err := r.raftLog.Vote(r.index); err != nil {
+		r.log.Println("Persist vote fail: ", err)
+		return
+	}
+	r.setRole(RaftCandidate)
+	r.hasLeader = false
+	r.votes = map[uint64]bool{r.index: true}
+	r.resetElectionTimer()
+	if r.isMajority(uint64(len(r.votes))) {
+		r.becomeLeader()
+		return
+	}
+
+	request := RequestVoteMessage{
+		LastLogIndex: r.raftLog.LastIndex(),
+		LastLogTerm:  r.raftLog.LastTerm(),
+	}
+	for i := uint64(0); i < r.nodeNum; i++ {
+		if i != r.index {
+			r.send(i, RequestVoteMsg, request)
+		}
+	}
+}
+
+func (r *Raft) handleRequestVote(msg *RaftMessage, request *RequestVoteMessage) error {
+	r.log.Printf("Receive a vote request from: %d, term: %d", msg.From, msg.Term)
+	r.checkTerm(msg.Term)
+	state := r.raftLog.State()
+	granted := false
+	if msg.Term == state.CurrentTerm && (!state.Voted || state.VotedFor == msg.From) {
+		// candidate's log must be at least as up-to-date as ours
+		lastTerm := r.raftLog.LastTerm()
+		if request.LastLogTerm > lastTerm || (request.LastLogTerm == lastTerm && request.LastLogIndex >= r.raftLog.LastIndex()) {
+			if err := r.raftLog.Vote(msg.From); err != nil {
+				return err
+			}
+			granted = true
+			r.resetElectionTimer()
+		}
+	}
+	r.send(msg.From, RequestVoteReplyMsg, RequestVoteReplyMessage{VoteGranted: granted})
+	return nil
+}
+
+func (r *Raft) handleRequestVoteReply(msg *RaftMessage, reply *RequestVoteReplyMessage) error {
+	if r.checkTerm(msg.Term) {
+		return nil
+	}
+	if r.getRole() != RaftCandidate || msg.Term != r.raftLog.State().CurrentTerm || !reply.VoteGranted {
+		return nil
+	}
+	r.votes[msg.From] = true
+	r.log.Printf("term: %d, vote count: %d", msg.Term, len(r.votes))
+	if r.isMajority(uint64(len(r.votes))) {
+		r.becomeLeader()
+	}
+	return nil
+}
+
+// becomeLeader takes leadership of current term and starts replication
+func (r *Raft) becomeLeader() {
+	r.log.Println("Become leader of term: ", r.raftLog.State().CurrentTerm)
+	r.setRole(RaftLeader)
+	r.leader = r.index
+	r.hasLeader = true
+	lastIndex := r.raftLog.LastIndex()
+	for i := uint64(0); i < r.nodeNum; i++ {
+		r.nextIndex[i] = lastIndex + 1
+		r.matchIndex[i] = 0
+	}
+	r.matchIndex[r.index] = lastIndex
+	// entries of earlier terms commit with an entry of this term
+	if lastIndex > r.commitIndex {
+		r.appendBlock(nil)
+	}
+	r.broadcastAppendEntries()
+	r.tryPropose()
+}
+
+// tryPropose packs pool txs into a block once all earlier entries are committed
+func (r *Raft) tryPropose() {
+	if !r.pendingProposal || r.commitIndex != r.raftLog.LastIndex() {
+		return
+	}
+	// get txs from pool
+	var txs []*blockchain.Transaction
+	for id, tx := range r.txPool.GetTransactions() {
+		r.txPool.RemoveTransaction(id)
+		txs = append(txs, tx)
+	}
+	r.pendingProposal = false
+	if len(txs) == 0 {
+		return
+	}
+	if !blockchain.VerifyTransactions(r.chain, txs) {
+		r.log.Println("tx verify error, drop transactions")
+		return
+	}
+	r.appendBlock(txs)
+	r.broadcastAppendEntries()
+}
+
+// appendBlock appends a block entry of current term to the leader's log
+func (r *Raft) appendBlock(txs []*blockchain.Transaction) {
+	lastIndex := r.raftLog.LastIndex()
+	var prevHash []byte
+	if entry := r.raftLog.Entry(lastIndex); entry != nil {
+		prevHash = entry.Block.Header.Hash
+	} else if r.chain.GetHeight() == lastIndex {
+		prevHash = r.chain.Tip
+	} else {
+		r.log.Println("Last block of log not found")
+		return
+	}
+	entry := &RaftEntry{
+		Term:  r.raftLog.State().CurrentTerm,
+		Index: lastIndex + 1,
+		Block: blockchain.NewBlock(prevHash, txs, lastIndex+1),
+	}
+	if err := r.raftLog.Append(entry); err != nil {
+		r.log.Println("Persist entry fail: ", err)
+		return
+	}
+	r.log.Printf("Append block, index: %d, term: %d", entry.Index, entry.Term)
+	r.matchIndex[r.index] = entry.Index
+	r.advanceCommit()
+}
+
+// broadcastAppendEntries replicates log to every follower, empty messages are heartbeats
+func (r *Raft) broadcastAppendEntries() {
+	for i := uint64(0); i < r.nodeNum; i++ {
+		if i != r.index {
+			r.replicate(i)
+		}
+	}
+}
+
+// replicate sends entries from nextIndex to a follower, or the snapshot if they are compacted
+func (r *Raft) replicate(to uint64) {
+	next := r.nextIndex[to]
+	state := r.raftLog.State()
+	if next <= state.SnapshotIndex {
+		r.sendSnapshot(to, next, state)
+		return
+	}
+	prevTerm, _ := r.raftLog.Term(next - 1)
+	r.send(to, AppendEntriesMsg, AppendEntriesMessage{
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		Entries:      r.raftLog.EntriesFrom(next, RaftMaxEntries),
+		LeaderCommit: r.commitIndex,
+	})
+}
+
+func (r *Raft) handleAppendEntries(msg *RaftMessage, request *AppendEntriesMessage) error {
+	state := r.raftLog.State()
+	if msg.Term < state.CurrentTerm {
+		r.send(msg.From, AppendEntriesReplyMsg, AppendEntriesReplyMessage{MatchIndex: r.raftLog.LastIndex()})
+		return nil
+	}
+	r.followLeader(msg)
+	state = r.raftLog.State()
+
+	// the log must contain the entry preceding new ones
+	lastIndex := r.raftLog.LastIndex()
+	if request.PrevLogIndex > lastIndex {
+		r.send(msg.From, AppendEntriesReplyMsg, AppendEntriesReplyMessage{MatchIndex: lastIndex})
+		return nil
+	}
+	if request.PrevLogIndex > state.SnapshotIndex {
+		term, _ := r.raftLog.Term(request.PrevLogIndex)
+		if term != request.PrevLogTerm {
+			// drop the conflicting entry and those following it
+			if err := r.raftLog.TruncateFrom(request.PrevLogIndex); err != nil {
+				return err
+			}
+			r.send(msg.From, AppendEntriesReplyMsg, AppendEntriesReplyMessage{MatchIndex: request.PrevLogIndex - 1})
+			return nil
+		}
+	}
+
+	// append new entries, entries covered by the snapshot are already committed
+	var appended []*RaftEntry
+	for _, entry := range request.Entries {
+		if entry.Index <= state.SnapshotIndex {
+			continue
+		}
+		if term, ok := r.raftLog.Term(entry.Index); ok {
+			if term == entry.Term {
+				continue
+			}
+			if err := r.raftLog.TruncateFrom(entry.Index); err != nil {
+				return err
+			}
+		}
+		if entry.Index != r.raftLog.LastIndex()+1 || entry.Block == nil {
+			return errors.New("entries not continuous")
+		}
+		if err := r.raftLog.Append(entry); err != nil {
+			return err
+		}
+		appended = append(appended, entry)
+	}
+	for _, entry := range appended {
+		for _, tx := range entry.Block.Transactions {
+			r.txPool.RemoveTransaction(hex.EncodeToString(tx.ID))
+		}
+	}
+
+	matchIndex := request.PrevLogIndex + uint64(len(request.Entries))
+	commitIndex := request.LeaderCommit
+	if commitIndex > matchIndex {
+		commitIndex = matchIndex
+	}
+	if commitIndex > r.commitIndex {
+		r.commitIndex = commitIndex
+		r.apply()
+	}
+	r.send(msg.From, AppendEntriesReplyMsg, AppendEntriesReplyMessage{Success: true, MatchIndex: matchIndex})
+	return nil
+}
+
+func (r *Raft) handleAppendEntriesReply(msg *RaftMessage, reply *AppendEntriesReplyMessage) error {
+	if r.checkTerm(msg.Term) || r.getRole() != RaftLeader || msg.Term != r.raftLog.State().CurrentTerm {
+		return nil
+	}
+	if reply.Success {
+		if reply.MatchIndex > r.matchIndex[msg.From] {
+			r.matchIndex[msg.From] = reply.MatchIndex
+		}
+		r.nextIndex[msg.From] = r.matchIndex[msg.From] + 1
+		r.advanceCommit()
+		if r.nextIndex[msg.From] <= r.raftLog.LastIndex() {
+			r.replicate(msg.From)
+		}
+		return nil
+	}
+	// back off to the follower's hint and retry
+	next := r.nextIndex[msg.From] - 1
+	if reply.MatchIndex+1 < next {
+		next = reply.MatchIndex + 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	r.nextIndex[msg.From] = next
+	r.replicate(msg.From)
+	return nil
+}
+
+// sendSnapshot sends the next chunk of committed blocks to a follower behind the leader's snapshot
+func (r *Raft) sendSnapshot(to uint64, next uint64, state RaftState) {
+	if next < 1 {
+		next = 1
+	}
+	max := next + RaftSnapshotChunk - 1
+	if max > state.SnapshotIndex {
+		max = state.SnapshotIndex
+	}
+	blocks := r.chain.FindBlocksInRange(next, max)
+	r.log.Printf("Send snapshot to: %d, blocks: %d-%d", to, next, max)
+	r.send(to, InstallSnapshotMsg, InstallSnapshotMessage{
+		LastIncludedIndex: state.SnapshotIndex,
+		LastIncludedTerm:  state.SnapshotTerm,
+		Blocks:            blocks,
+	})
+}
+
+func (r *Raft) handleInstallSnapshot(msg *RaftMessage, request *InstallSnapshotMessage) error {
+	if msg.Term < r.raftLog.State().CurrentTerm {
+		return nil
+	}
+	r.followLeader(msg)
+	r.log.Printf("Receive a snapshot chunk, blocks: %d", len(request.Blocks))
+	for _, block := range request.Blocks {
+		if !r.blockPool.CommitBlock(block) {
+			r.log.Println("Add snapshot block to chain fail, height: ", block.Header.Height)
+			break
+		}
+		for _, tx := range block.Transactions {
+			r.txPool.RemoveTransaction(hex.EncodeToString(tx.ID))
+		}
+	}
+
+	// the whole snapshot is in chain, replace the log
+	height := r.chain.GetHeight()
+	if height >= request.LastIncludedIndex && r.raftLog.State().SnapshotIndex < request.LastIncludedIndex {
+		if err := r.raftLog.InstallSnapshot(request.LastIncludedIndex, request.LastIncludedTerm); err != nil {
+			return err
+		}
+		if r.commitIndex < request.LastIncludedIndex {
+			r.commitIndex = request.LastIncludedIndex
+		}
+		if r.lastApplied < request.LastIncludedIndex {
+			r.lastApplied = request.LastIncludedIndex
+		}
+		r.log.Println("Install snapshot, index: ", request.LastIncludedIndex)
+	}
+	r.send(msg.From, InstallSnapshotReplyMsg, InstallSnapshotReplyMessage{Height: height})
+	return nil
+}
+
+func (r *Raft) handleInstallSnapshotReply(msg *RaftMessage, reply *InstallSnapshotReplyMessage) error {
+	if r.checkTerm(msg.Term) || r.getRole() != RaftLeader || msg.Term != r.raftLog.State().CurrentTerm {
+		return nil
+	}
+	snapshotIndex := r.raftLog.State().SnapshotIndex
+	if reply.Height >= snapshotIndex {
+		// follower's log now matches the snapshot
+		if snapshotIndex > r.matchIndex[msg.From] {
+			r.matchIndex[msg.From] = snapshotIndex
+		}
+		r.nextIndex[msg.From] = snapshotIndex + 1
+	} else {
+		r.nextIndex[msg.From] = reply.Height + 1
+	}
+	r.replicate(msg.From)
+	return nil
+}
+
+// advanceCommit commits the highest entry of current term stored on a majority
+func (r *Raft) advanceCommit() {
+	term := r.raftLog.State().CurrentTerm
+	for n := r.raftLog.LastIndex(); n > r.commitIndex; n-- {
+		if t, ok := r.raftLog.Term(n); !ok || t != term {
+			break
+		}
+		count := uint64(0)
+		for i := uint64(0); i < r.nodeNum; i++ {
+			if r.matchIndex[i] >= n {
+				count++
+			}
+		}
+		if r.isMajority(count) {
+			r.commitIndex = n
+			r.apply()
+			break
+		}
+	}
+}
+
+// apply appends committed entries to chain and compacts the log
+func (r *Raft) apply() {
+	for r.lastApplied < r.commitIndex {
+		entry := r.raftLog.Entry(r.lastApplied + 1)
+		if entry == nil {
+			r.log.Println("Committed entry not found, index: ", r.lastApplied+1)
+			return
+		}
+		if !r.blockPool.CommitBlock(entry.Block) {
+			r.log.Println("Add committed block to chain fail, index: ", entry.Index)
+			return
+		}
+		r.log.Printf("Commit block, height: %d", entry.Index)
+		r.lastApplied = entry.Index
+	}
+	if r.lastApplied-r.raftLog.State().SnapshotIndex >= RaftSnapshotInterval {
+		if err := r.raftLog.Compact(r.lastApplied); err != nil {
+			r.log.Println("Compact log fail: ", err)
+		} else {
+			r.log.Println("Compact log, snapshot index: ", r.lastApplied)
+		}
+	}
+	if r.getRole() == RaftLeader {
+		r.tryPropose()
+	}
+}
+
+// checkTerm steps down to follower if msg term is newer, returns true if it stepped down
+func (r *Raft) checkTerm(term uint64) bool {
+	if term <= r.raftLog.State().CurrentTerm {
+		return false
+	}
+	r.log.Println("Find newer term: ", term)
+	if err := r.raftLog.SetTerm(term); err != nil {
+		r.log.Println("Persist term fail: ", err)
+	}
+	r.setRole(RaftFollower)
+	r.hasLeader = false
+	r.resetElectionTimer()
+	return true
+}
+
+// followLeader accepts the sender of a current term leader message as leader
+func (r *Raft) followLeader(msg *RaftMessage) {
+	r.checkTerm(msg.Term)
+	r.setRole(RaftFollower)
+	r.leader = msg.From
+	r.hasLeader = true
+	r.resetElectionTimer()
+}
+
+func (r *Raft) isMajority(count uint64) bool {
+	return count >= r.nodeNum/2+1
+}
+
+// electionTimeout returns a random timeout to avoid split votes
+func (r *Raft) electionTimeout() time.Duration {
+	base := RaftElectionTimeout * time.Second
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
+
+func (r *Raft) resetElectionTimer() {
+	if !r.electionTimer.Stop() {
+		select {
+		case <-r.electionTimer.C:
+		default:
+		}
+	}
+	r.electionTimer.Reset(r.electionTimeout())
+}
+
+// send sends a message of current term to node index through the transport
+func (r *Raft) send(to uint64, t RaftMsgType, msg interface{}) {
+	payload, err := codec.Marshal(msg)
+	if err != nil {
+		r.log.Println("Marshal message fail")
+		return
+	}
+	r.transport(&RaftMessage{
+		Type: t,
+		From: r.index,
+		To:   to,
+		Term: r.raftLog.State().CurrentTerm,
+		Data: payload,
+	})
+}
+
+// sendToPeer sends a message to the peer of its receiver, or to all peers when the peer is unknown
+func (r *Raft) sendToPeer(msg *RaftMessage) {
+	serialized, err := codec.Marshal(msg)
+	if err != nil {
+		r.log.Println("Marshal message fail")
+		return
+	}
+	p2pMessage := &p2pnet.Message{
+		Type: p2pnet.ConsensusMsg,
+		Data: serialized,
+	}
+	if peerID, ok := r.peers[msg.To]; ok {
+		if r.net.BroadcastToPeer(p2pMessage, peerID) == nil {
+			return
+		}
+	}
+	r.net.Broadcast(p2pMessage)
+}
+
+// Start sets the Raft engine as started
+func (r *Raft) Start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.isStart = true
+}
+
+// Stop sets the Raft engine as stopped
+func (r *Raft) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.isStart = false
+}
+
+func (r *Raft) isStarted() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.isStart
+}
+
+func (r *Raft) getRole() RaftRole {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.role
+}
+
+func (r *Raft) setRole(role RaftRole) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.role = role
+}
+
+// Status returns current Raft engine status, the view is the current term
+func (r *Raft) Status() *Status {
+	return &Status{
+		Engine:    EngineRaft,
+		IsPrimary: r.getRole() == RaftLeader,
+		View:      r.raftLog.State().CurrentTerm,
+	}
+}
+
+// VerifyBlock checks a synchronized block matches its hash, Raft nodes share one trust domain and
+// never broadcast blocks, so the block pool drops new block broadcasts of any peer
+func (r *Raft) VerifyBlock(block *blockchain.Block) error {
+	return verifyContent(r.chain, block)
+}
@@ -0,0 +1,247 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/utils"
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	raftStateKey = "state" // key of persistent raft state in RaftTable
+	raftEntryKey = "e"     // key prefix of log entries in RaftTable
+)
+
+// RaftState is the raft state persisted before replying to any message
+type RaftState struct {
+	CurrentTerm   uint64 // latest term seen
+	VotedFor      uint64 // candidate index voted for in current term
+	Voted         bool   // flag of voted in current term
+	SnapshotIndex uint64 // last log index covered by the chain snapshot
+	SnapshotTerm  uint64 // term of SnapshotIndex
+}
+
+// RaftLog is the persistent raft log, entries up to SnapshotIndex are compacted into the chain
+type RaftLog struct {
+	chain   *blockchain.Chain // block chain holding the snapshot and the database
+	state   RaftState         // persistent state
+	entries []*RaftEntry      // entries after SnapshotIndex in index order
+	lock    sync.Mutex
+}
+
+// NewRaftLog loads raft state and log entries from the chain database
+func NewRaftLog(chain *blockchain.Chain) *RaftLog {
+	l := &RaftLog{chain: chain}
+	data, err := blockchain.ReadFromDB(chain.DataBase, []byte(blockchain.RaftTable), []byte(raftStateKey))
+	if err != nil || utils.Deserialize(data, &l.state) != nil {
+		// the existing chain is the initial snapshot
+		l.state = RaftState{SnapshotIndex: chain.GetHeight()}
+	}
+	for index := l.state.SnapshotIndex + 1; ; index++ {
+		data, err = blockchain.ReadFromDB(chain.DataBase, []byte(blockchain.RaftTable), entryKey(index))
+		if err != nil {
+			break
+		}
+		var entry RaftEntry
+		if utils.Deserialize(data, &entry) != nil {
+			break
+		}
+		l.entries = append(l.entries, &entry)
+	}
+	// blocks synchronized outside raft extend the snapshot
+	if height := chain.GetHeight(); height > l.lastIndex() {
+		l.state.SnapshotIndex = height
+		l.entries = nil
+	}
+	return l
+}
+
+// State returns a copy of the persistent state
+func (l *RaftLog) State() RaftState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.state
+}
+
+// SetTerm moves to a new term and clears the vote
+func (l *RaftLog) SetTerm(term uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.state.CurrentTerm = term
+	l.state.Voted = false
+	l.state.VotedFor = 0
+	return l.saveState()
+}
+
+// Vote records the vote of current term
+func (l *RaftLog) Vote(index uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.state.Voted = true
+	l.state.VotedFor = index
+	return l.saveState()
+}
+
+// LastIndex returns the index of the last entry
+func (l *RaftLog) LastIndex() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lastIndex()
+}
+
+// LastTerm returns the term of the last entry
+func (l *RaftLog) LastTerm() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.entries) == 0 {
+		return l.state.SnapshotTerm
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+// Term returns the term of the entry at index, false if it is compacted or missing
+func (l *RaftLog) Term(index uint64) (uint64, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if index == l.state.SnapshotIndex {
+		return l.state.SnapshotTerm, true
+	}
+	entry := l.entry(index)
+	if entry == nil {
+		return 0, false
+	}
+	return entry.Term, true
+}
+
+// Entry returns the entry at index, nil if it is compacted or missing
+func (l *RaftLog) Entry(index uint64) *RaftEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.entry(index)
+}
+
+// EntriesFrom returns at most max entries starting at index
+func (l *RaftLog) EntriesFrom(index uint64, max int) []*RaftEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if index <= l.state.SnapshotIndex {
+		return nil
+	}
+	start := index - l.state.SnapshotIndex - 1
+	if start >= uint64(len(l.entries)) {
+		return nil
+	}
+	end := start + uint64(max)
+	if end > uint64(len(l.entries)) {
+		end = uint64(len(l.entries))
+	}
+	return append([]*RaftEntry{}, l.entries[start:end]...)
+}
+
+// Append persists entries after the last entry
+func (l *RaftLog) Append(entries ...*RaftEntry) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, entry := range entries {
+		data, err := utils.Serialize(entry)
+		if err != nil {
+			return err
+		}
+		err = blockchain.WriteToDB(l.chain.DataBase, []byte(blockchain.RaftTable), entryKey(entry.Index), data)
+		if err != nil {
+			return err
+		}
+		l.entries = append(l.entries, entry)
+	}
+	return nil
+}
+
+// TruncateFrom removes the entry at index and all that follow it
+func (l *RaftLog) TruncateFrom(index uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if index <= l.state.SnapshotIndex {
+		return nil
+	}
+	for i := index; i <= l.lastIndex(); i++ {
+		err := blockchain.DeleteFromDB(l.chain.DataBase, []byte(blockchain.RaftTable), entryKey(i))
+		if err != nil {
+			return err
+		}
+	}
+	if start := index - l.state.SnapshotIndex - 1; start < uint64(len(l.entries)) {
+		l.entries = l.entries[:start]
+	}
+	return nil
+}
+
+// Compact drops entries up to index, they are kept as blocks in the chain
+func (l *RaftLog) Compact(index uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	entry := l.entry(index)
+	if entry == nil {
+		return nil
+	}
+	for i := l.state.SnapshotIndex + 1; i <= index; i++ {
+		err := blockchain.DeleteFromDB(l.chain.DataBase, []byte(blockchain.RaftTable), entryKey(i))
+		if err != nil {
+			return err
+		}
+	}
+	l.entries = l.entries[index-l.state.SnapshotIndex:]
+	l.state.SnapshotIndex = index
+	l.state.SnapshotTerm = entry.Term
+	return l.saveState()
+}
+
+// InstallSnapshot replaces the log with a snapshot ending at index
+func (l *RaftLog) InstallSnapshot(index, term uint64) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// keep the entries following the snapshot if the log matches it
+	var rest []*RaftEntry
+	if entry := l.entry(index); entry != nil && entry.Term == term {
+		rest = l.entries[index-l.state.SnapshotIndex:]
+	}
+	for i := l.state.SnapshotIndex + 1; i <= l.lastIndex(); i++ {
+		if i > index && rest != nil {
+			break
+		}
+		err := blockchain.DeleteFromDB(l.chain.DataBase, []byte(blockchain.RaftTable), entryKey(i))
+		if err != nil {
+			return err
+		}
+	}
+	l.entries = rest
+	l.state.SnapshotIndex = index
+	l.state.SnapshotTerm = term
+	return l.saveState()
+}
+
+func (l *RaftLog) lastIndex() uint64 {
+	return l.state.SnapshotIndex + uint64(len(l.entries))
+}
+
+func (l *RaftLog) entry(index uint64) *RaftEntry {
+	if index <= l.state.SnapshotIndex || index > l.lastIndex() {
+		return nil
+	}
+	return l.entries[index-l.state.SnapshotIndex-1]
+}
+
+func (l *RaftLog) saveState() error {
+	data, err := utils.Serialize(l.state)
+	if err != nil {
+		return err
+	}
+	return blockchain.WriteToDB(l.chain.DataBase, []byte(blockchain.RaftTable), []byte(raftStateKey), data)
+}
+
+// entryKey returns the database key of the entry at index
+func entryKey(index uint64) []byte {
+	key := make([]byte, len(raftEntryKey)+8)
+	copy(key, raftEntryKey)
+	binary.BigEndian.PutUint64(key[len(raftEntryKey):], index)
+	return key
+}
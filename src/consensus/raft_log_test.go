@@ -0,0 +1,55 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"fmt"
+	"testing"
+)
+
+func TestRaftLog(t *testing.T) {
+	wallet := blockchain.CreateWallet()
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), t.TempDir(), "./test.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+
+	l := NewRaftLog(chain)
+	if l.LastIndex() != chain.GetHeight() {
+		t.Fatal("chain is not the initial snapshot")
+	}
+	prevHash := chain.Tip
+	for i := uint64(2); i <= 5; i++ {
+		block := blockchain.NewBlock(prevHash, nil, i)
+		prevHash = block.Header.Hash
+		if err = l.Append(&RaftEntry{Term: 1, Index: i, Block: block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = l.SetTerm(2); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Vote(1); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.TruncateFrom(5); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload from database
+	reload := NewRaftLog(chain)
+	state := reload.State()
+	fmt.Println(state, reload.LastIndex(), reload.LastTerm())
+	if state.CurrentTerm != 2 || !state.Voted || state.VotedFor != 1 {
+		t.Fatal("term and vote not persisted")
+	}
+	if state.SnapshotIndex != 2 || reload.LastIndex() != 4 || len(reload.EntriesFrom(3, RaftMaxEntries)) != 2 {
+		t.Fatal("log entries not persisted")
+	}
+	if term, ok := reload.Term(2); !ok || term != 1 {
+		t.Fatal("snapshot term error")
+	}
+}
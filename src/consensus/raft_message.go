@@ -0,0 +1,97 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+)
+
+type RaftMsgType int32
+
+const (
+	DefaultRaftMsg RaftMsgType = iota
+	RequestVoteMsg
+	RequestVoteReplyMsg
+	AppendEntriesMsg
+	AppendEntriesReplyMsg
+	InstallSnapshotMsg
+	InstallSnapshotReplyMsg
+)
+
+// RaftMessage type, nodes share one trust domain so messages are not signed
+type RaftMessage struct {
+	Type RaftMsgType `json:"type"`
+	From uint64      `json:"from"` // sender node index
+	To   uint64      `json:"to"`   // receiver node index
+	Term uint64      `json:"term"` // sender term
+	Data []byte      `json:"data"`
+}
+
+// RaftEntry is a replicated log entry, the entry index is the block height
+type RaftEntry struct {
+	Term  uint64            `json:"term"`  // term the entry was created in
+	Index uint64            `json:"index"` // log index
+	Block *blockchain.Block `json:"block"` // block of the entry
+}
+
+// RequestVoteMessage candidate asks for vote
+type RequestVoteMessage struct {
+	LastLogIndex uint64 `json:"lastLogIndex"` // index of candidate's last log entry
+	LastLogTerm  uint64 `json:"lastLogTerm"`  // term of candidate's last log entry
+}
+
+// RequestVoteReplyMessage vote result
+type RequestVoteReplyMessage struct {
+	VoteGranted bool `json:"voteGranted"`
+}
+
+// AppendEntriesMessage leader replicates entries, an empty one is a heartbeat
+type AppendEntriesMessage struct {
+	PrevLogIndex uint64       `json:"prevLogIndex"` // index of entry preceding new ones
+	PrevLogTerm  uint64       `json:"prevLogTerm"`  // term of entry preceding new ones
+	Entries      []*RaftEntry `json:"entries"`      // entries to store
+	LeaderCommit uint64       `json:"leaderCommit"` // leader's commit index
+}
+
+// AppendEntriesReplyMessage replication result
+type AppendEntriesReplyMessage struct {
+	Success    bool   `json:"success"`
+	MatchIndex uint64 `json:"matchIndex"` // last matched index on success, last log index hint on failure
+}
+
+// InstallSnapshotMessage leader sends a chunk of committed blocks to a follower behind its snapshot
+type InstallSnapshotMessage struct {
+	LastIncludedIndex uint64              `json:"lastIncludedIndex"` // snapshot last index
+	LastIncludedTerm  uint64              `json:"lastIncludedTerm"`  // snapshot last term
+	Blocks            []*blockchain.Block `json:"blocks"`            // committed blocks of this chunk in height order
+}
+
+// InstallSnapshotReplyMessage follower reports its chain height after a chunk
+type InstallSnapshotReplyMessage struct {
+	Height uint64 `json:"height"`
+}
+
+// SplitMessage splits the RaftMessage into the corresponding message struct based on its type.
+func (m *RaftMessage) SplitMessage() (interface{}, RaftMsgType) {
+	var data interface{}
+	switch m.Type {
+	case RequestVoteMsg:
+		data = &RequestVoteMessage{}
+	case RequestVoteReplyMsg:
+		data = &RequestVoteReplyMessage{}
+	case AppendEntriesMsg:
+		data = &AppendEntriesMessage{}
+	case AppendEntriesReplyMsg:
+		data = &AppendEntriesReplyMessage{}
+	case InstallSnapshotMsg:
+		data = &InstallSnapshotMessage{}
+	case InstallSnapshotReplyMsg:
+		data = &InstallSnapshotReplyMessage{}
+	default:
+		return nil, DefaultRaftMsg
+	}
+	err := codec.Unmarshal(m.Data, data)
+	if err != nil {
+		return nil, DefaultRaftMsg
+	}
+	return data, m.Type
+}
@@ -0,0 +1,174 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// raftCluster runs raft nodes in one routine, messages are queued in memory and delivered in order
+type raftCluster struct {
+	nodes   []*Raft
+	queue   []*RaftMessage
+	blocked map[uint64]bool // partitioned nodes, their messages are dropped
+}
+
+func newRaftCluster(t *testing.T, num int) *raftCluster {
+	dir := t.TempDir()
+	wallet := blockchain.CreateWallet()
+	// every node starts from the same genesis block
+	genesis := filepath.Join(dir, "genesis")
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), genesis, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.DataBase.Close()
+
+	c := &raftCluster{blocked: make(map[uint64]bool)}
+	for i := 0; i < num; i++ {
+		path := filepath.Join(dir, fmt.Sprint("node", i))
+		copyDir(t, genesis, path)
+		chain, err := blockchain.LoadChain(path, filepath.Join(dir, "chain.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { chain.DataBase.Close() })
+		logPath := filepath.Join(dir, "raft.log")
+		net := &p2pnet.P2PNet{}
+		r, err := NewRaft(uint64(num), uint64(i), pool.NewTxPool(1, net, logPath), pool.NewBlockPool(1, net, chain, logPath), net, chain, wallet, logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.transport = c.send
+		c.nodes = append(c.nodes, r)
+	}
+	return c
+}
+
+// send queues an encoded copy of a message, as the network does
+func (c *raftCluster) send(msg *RaftMessage) {
+	data, _ := codec.Marshal(msg)
+	var copied RaftMessage
+	codec.Unmarshal(data, &copied)
+	c.queue = append(c.queue, &copied)
+}
+
+// deliver handles queued messages until no message is left
+func (c *raftCluster) deliver() {
+	for len(c.queue) > 0 {
+		msg := c.queue[0]
+		c.queue = c.queue[1:]
+		if c.blocked[msg.From] || c.blocked[msg.To] {
+			continue
+		}
+		c.nodes[msg.To].handleMessage(msg)
+	}
+}
+
+func copyDir(t *testing.T, from, to string) {
+	if err := os.MkdirAll(to, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(from, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(to, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRaft_ElectionAndReplication(t *testing.T) {
+	c := newRaftCluster(t, 3)
+	n0, n1, n2 := c.nodes[0], c.nodes[1], c.nodes[2]
+
+	// leader election
+	n0.startElection()
+	c.deliver()
+	fmt.Println("term 1 leader: ", n0.getRole() == RaftLeader)
+	if n0.getRole() != RaftLeader || n1.getRole() != RaftFollower || !n1.hasLeader || n1.leader != 0 {
+		t.Fatal("node 0 not elected")
+	}
+	if n2.raftLog.State().CurrentTerm != 1 || n2.raftLog.State().VotedFor != 0 {
+		t.Fatal("term or vote not persisted")
+	}
+
+	// log replication
+	n0.appendBlock(nil)
+	n0.broadcastAppendEntries()
+	c.deliver()
+	n0.broadcastAppendEntries()
+	c.deliver()
+	for i, r := range c.nodes {
+		if r.commitIndex != 2 || r.chain.GetHeight() != 2 {
+			t.Fatal("entry not committed on node ", i)
+		}
+	}
+
+	// an entry of a partitioned leader is not committed
+	c.blocked[0] = true
+	n0.appendBlock(nil)
+	n0.broadcastAppendEntries()
+	c.deliver()
+	if n0.commitIndex != 2 || n0.raftLog.LastIndex() != 3 {
+		t.Fatal("entry committed without majority")
+	}
+
+	// term change, the majority elects a new leader and commits another entry at the same index
+	n1.startElection()
+	c.deliver()
+	if n1.getRole() != RaftLeader || n1.raftLog.State().CurrentTerm != 2 {
+		t.Fatal("node 1 not elected in term 2")
+	}
+	n1.appendBlock(nil)
+	n1.broadcastAppendEntries()
+	c.deliver()
+	if n1.commitIndex != 3 {
+		t.Fatal("entry of term 2 not committed")
+	}
+
+	// the old leader steps down and its conflicting entry is truncated
+	delete(c.blocked, 0)
+	n1.broadcastAppendEntries()
+	c.deliver()
+	n1.broadcastAppendEntries()
+	c.deliver()
+	term, _ := n0.raftLog.Term(3)
+	fmt.Println("node 0 role: ", n0.getRole(), ", term: ", n0.raftLog.State().CurrentTerm, ", entry 3 term: ", term)
+	if n0.getRole() != RaftFollower || n0.raftLog.State().CurrentTerm != 2 || term != 2 {
+		t.Fatal("conflicting entry not replaced")
+	}
+	for i, r := range c.nodes {
+		if r.chain.GetHeight() != 3 || !bytes.Equal(r.chain.Tip, n1.chain.Tip) {
+			t.Fatal("chain diverged on node ", i)
+		}
+	}
+}
+
+func TestRaft_VerifyBlock(t *testing.T) {
+	r := newRaftCluster(t, 1).nodes[0]
+	block := blockchain.NewBlock(r.chain.Tip, []*blockchain.Transaction{}, r.chain.GetHeight()+1)
+	if err := r.VerifyBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	// a forged block keeps the hash of another block
+	block.Transactions = []*blockchain.Transaction{blockchain.NewRewardTx([]byte("forger"), 10, block.Header.Height)}
+	block.TransactionCounter = 1
+	err := r.VerifyBlock(block)
+	fmt.Println("swapped transactions: ", err)
+	if err == nil {
+		t.Fatal("accept block not matching its hash")
+	}
+}
@@ -0,0 +1,348 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/mycrypto"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/utils"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	RequestTimeout = 30 // client request retransmission timeout in seconds
+	RequestRetries = 3  // client request retransmissions before giving up
+	MaxSeenMessage = 4096
+)
+
+// RequestHandler handles a verified client request on a consensus node
+type RequestHandler func(request *RequestMessage)
+
+// ReplyCallback is called once a request has f+1 matching replies
+type ReplyCallback func(reply *ReplyMessage, count int)
+
+// pendingRequest is a submitted request waiting for replies
+type pendingRequest struct {
+	request  *RequestMessage
+	replies  map[uint64]*ReplyMessage // replica index -> reply
+	callback ReplyCallback
+	timer    *time.Timer
+}
+
+// RequestClient is the pBFT client library, it sends signed requests and collects replica replies,
+// every node runs one to relay requests and replies of other clients
+type RequestClient struct {
+	id           string            // client ID
+	privateKey   *ecdsa.PrivateKey // private key
+	publicKey    *ecdsa.PublicKey  // public key
+	maxFaultNode uint64            // max fault node number, f+1 matching replies are final
+	net          *p2pnet.P2PNet    // network layer
+
+	validators func() *blockchain.ValidatorSet // current validator set, replies come from its members only
+	handler    RequestHandler                  // local consensus node handler of requests
+	pending    map[string]*pendingRequest      // tx ID -> pending request
+	seen       map[string]struct{}             // relayed request and reply keys
+	lock       sync.Mutex
+	log        *log.Logger
+}
+
+// NewRequestClient create a pBFT client
+func NewRequestClient(f uint64, net *p2pnet.P2PNet, wallet *blockchain.Wallet, logPath string) *RequestClient {
+	// initialize logger
+	l := utils.NewLogger("[request] ", logPath)
+
+	return &RequestClient{
+		id:           string(wallet.GetAddress()),
+		privateKey:   wallet.GetPrivateKey(),
+		publicKey:    wallet.GetPublicKey(),
+		maxFaultNode: f,
+		net:          net,
+		pending:      make(map[string]*pendingRequest),
+		seen:         make(map[string]struct{}),
+		log:          l,
+	}
+}
+
+// Run registers request and reply callbacks
+func (rc *RequestClient) Run() {
+	rc.log.Println("Run request client")
+	rc.net.RegisterCallback(p2pnet.RequestMsg, rc.OnReceive)
+	rc.net.RegisterCallback(p2pnet.ReplyMsg, rc.OnReceive)
+}
+
+// SetRequestHandler sets the local consensus node handler of client requests
+func (rc *RequestClient) SetRequestHandler(h RequestHandler) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.handler = h
+}
+
+// SetValidators sets the source of the current validator set replicas are checked against
+func (rc *RequestClient) SetValidators(validators func() *blockchain.ValidatorSet) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.validators = validators
+}
+
+// SetMaxFaultNode sets the max fault node number after validator set changes
+func (rc *RequestClient) SetMaxFaultNode(f uint64) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.maxFaultNode = f
+}
+
+// Submit signs a request of tx and sends it to consensus nodes, callback runs after f+1 matching replies
+func (rc *RequestClient) Submit(tx *blockchain.Transaction, callback ReplyCallback) error {
+	txData, err := codec.Marshal(tx)
+	if err != nil {
+		return errors.New("marshal transaction fail")
+	}
+	request := &RequestMessage{
+		ClientID:  rc.id,
+		Timestamp: time.Now().UnixNano(),
+		TxID:      tx.ID,
+		Tx:        txData,
+		PubKey:    mycrypto.PublicKey2Bytes(rc.publicKey),
+	}
+	request.Sign, err = mycrypto.Sign(rc.privateKey, request.Digest())
+	if err != nil {
+		return errors.New("sign request fail")
+	}
+
+	id := hex.EncodeToString(tx.ID)
+	p := &pendingRequest{
+		request:  request,
+		replies:  make(map[uint64]*ReplyMessage),
+		callback: callback,
+		timer:    time.AfterFunc(RequestTimeout*time.Second, func() { rc.retransmit(id) }),
+	}
+	rc.lock.Lock()
+	rc.pending[id] = p
+	rc.markSeen(requestKey(request))
+	handler := rc.handler
+	rc.lock.Unlock()
+
+	// the local consensus node receives its own request as well
+	if handler != nil {
+		go handler(request)
+	}
+	rc.log.Println("Send request: ", id)
+	rc.broadcast(p2pnet.RequestMsg, request, "")
+	return nil
+}
+
+// retransmit sends the request again when replies do not arrive in time
+func (rc *RequestClient) retransmit(id string) {
+	rc.lock.Lock()
+	p, ok := rc.pending[id]
+	if !ok {
+		rc.lock.Unlock()
+		return
+	}
+	if p.request.Retry >= RequestRetries {
+		delete(rc.pending, id)
+		rc.lock.Unlock()
+		rc.log.Println("Request timeout, give up: ", id)
+		return
+	}
+	// a new retry is relayed again to reach the primary
+	p.request.Retry++
+	request := *p.request
+	rc.markSeen(requestKey(&request))
+	rc.lock.Unlock()
+
+	rc.log.Printf("Request timeout, retransmit: %s, retry: %d", id, request.Retry)
+	rc.broadcast(p2pnet.RequestMsg, &request, "")
+	p.timer.Reset(RequestTimeout * time.Second)
+}
+
+// OnReceive receive callback func
+func (rc *RequestClient) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
+	switch t {
+	case p2pnet.RequestMsg:
+		var request RequestMessage
+		if err := codec.Unmarshal(msgBytes, &request); err != nil {
+			rc.log.Println("Unmarshal request fail")
+			return
+		}
+		rc.handleRequest(&request, peerID)
+	case p2pnet.ReplyMsg:
+		var reply ReplyMessage
+		if err := codec.Unmarshal(msgBytes, &reply); err != nil {
+			rc.log.Println("Unmarshal reply fail")
+			return
+		}
+		rc.handleReply(&reply, peerID)
+	}
+}
+
+// handleRequest relays a new request and passes it to the local consensus node,
+// the client ID must be the address of the signer
+func (rc *RequestClient) handleRequest(request *RequestMessage, peerID string) {
+	pubKey := mycrypto.Bytes2PublicKey(request.PubKey)
+	if !mycrypto.Verify(pubKey, request.Digest(), request.Sign) {
+		rc.log.Println("Verify request signature fail")
+		return
+	}
+	if request.ClientID != string(blockchain.GenerateAddress(request.PubKey)) {
+		rc.log.Println("Request client ID not match signer")
+		return
+	}
+	rc.lock.Lock()
+	if rc.isSeen(requestKey(request)) {
+		rc.lock.Unlock()
+		return
+	}
+	rc.markSeen(requestKey(request))
+	handler := rc.handler
+	rc.lock.Unlock()
+
+	rc.log.Println("Receive a request from: ", request.ClientID)
+	rc.broadcast(p2pnet.RequestMsg, request, peerID)
+	if handler != nil {
+		handler(request)
+	}
+}
+
+// handleReply collects replies of own requests and relays replies to other clients,
+// only replies signed by current validators are accepted, one per validator index
+func (rc *RequestClient) handleReply(reply *ReplyMessage, peerID string) {
+	pubKey := mycrypto.Bytes2PublicKey(reply.PubKey)
+	if !mycrypto.Verify(pubKey, reply.Digest(), reply.Sign) {
+		rc.log.Println("Verify reply signature fail")
+		return
+	}
+	index, ok := rc.replicaIndex(reply.PubKey)
+	if !ok {
+		rc.log.Println("Reply not from validator: ", reply.ReplicaID)
+		return
+	}
+	if reply.ClientID != rc.id {
+		rc.lock.Lock()
+		key := replyKey(reply)
+		seen := rc.isSeen(key)
+		rc.markSeen(key)
+		rc.lock.Unlock()
+		if !seen {
+			rc.broadcast(p2pnet.ReplyMsg, reply, peerID)
+		}
+		return
+	}
+
+	id := hex.EncodeToString(reply.TxID)
+	rc.lock.Lock()
+	p, ok := rc.pending[id]
+	if !ok || p.request.Timestamp != reply.Timestamp {
+		rc.lock.Unlock()
+		return
+	}
+	// one reply per replica
+	p.replies[index] = reply
+	count := 0
+	for _, r := range p.replies {
+		if r.Result() == reply.Result() {
+			count++
+		}
+	}
+	rc.log.Printf("Receive a reply from: %s, tx: %s, matching: %d", reply.ReplicaID, id, count)
+	if uint64(count) < rc.maxFaultNode+1 {
+		rc.lock.Unlock()
+		return
+	}
+	// at least one correct replica committed the result
+	delete(rc.pending, id)
+	rc.lock.Unlock()
+	p.timer.Stop()
+	rc.log.Println("Request finished: ", id)
+	if p.callback != nil {
+		p.callback(reply, count)
+	}
+}
+
+// replicaIndex returns the validator index of a replica public key,
+// without a validator set no replica is known
+func (rc *RequestClient) replicaIndex(pubKey []byte) (uint64, bool) {
+	rc.lock.Lock()
+	validators := rc.validators
+	rc.lock.Unlock()
+	if validators == nil {
+		return 0, false
+	}
+	vs := validators()
+	if vs == nil {
+		return 0, false
+	}
+	return vs.IndexOf(pubKey)
+}
+
+// Reply sends a signed reply of a committed request
+func (rc *RequestClient) Reply(request *RequestMessage, view uint64, block *blockchain.Block) {
+	reply := &ReplyMessage{
+		View:      view,
+		Timestamp: request.Timestamp,
+		ClientID:  request.ClientID,
+		ReplicaID: rc.id,
+		TxID:      request.TxID,
+		Height:    block.Header.Height,
+		BlockHash: block.Header.Hash,
+		PubKey:    mycrypto.PublicKey2Bytes(rc.publicKey),
+	}
+	var err error
+	reply.Sign, err = mycrypto.Sign(rc.privateKey, reply.Digest())
+	if err != nil {
+		rc.log.Println("Sign reply fail")
+		return
+	}
+	if reply.ClientID == rc.id {
+		go rc.handleReply(reply, "")
+		return
+	}
+	rc.lock.Lock()
+	rc.markSeen(replyKey(reply))
+	rc.lock.Unlock()
+	rc.broadcast(p2pnet.ReplyMsg, reply, "")
+}
+
+// broadcast sends msg to all peers except the peer it came from
+func (rc *RequestClient) broadcast(t p2pnet.MessageType, msg interface{}, peerID string) {
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		rc.log.Println("Marshal message fail")
+		return
+	}
+	p2pMessage := &p2pnet.Message{
+		Type: t,
+		Data: data,
+	}
+	if peerID == "" {
+		rc.net.Broadcast(p2pMessage)
+	} else {
+		rc.net.BroadcastExceptPeer(p2pMessage, peerID)
+	}
+}
+
+func (rc *RequestClient) isSeen(key string) bool {
+	_, ok := rc.seen[key]
+	return ok
+}
+
+func (rc *RequestClient) markSeen(key string) {
+	if len(rc.seen) >= MaxSeenMessage {
+		rc.seen = make(map[string]struct{})
+	}
+	rc.seen[key] = struct{}{}
+}
+
+func requestKey(request *RequestMessage) string {
+	return fmt.Sprintf("q/%s/%d/%d", request.ClientID, request.Timestamp, request.Retry)
+}
+
+func replyKey(reply *ReplyMessage) string {
+	return fmt.Sprintf("p/%s/%s/%d/%x", reply.ReplicaID, reply.ClientID, reply.Timestamp, reply.TxID)
+}
@@ -0,0 +1,60 @@
+package consensus
+
+import (
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/binary"
+)
+
+// RequestMessage pBFT client request carrying a transaction
+type RequestMessage struct {
+	ClientID  string `json:"clientID"`  // client ID
+	Timestamp int64  `json:"timestamp"` // client timestamp, orders requests of one client
+	TxID      []byte `json:"txID"`      // requested transaction ID
+	Tx        []byte `json:"tx"`        // requested transaction data
+	Retry     uint32 `json:"retry"`     // retransmission count, not signed
+	Sign      []byte `json:"sign"`      // client signature of Digest
+	PubKey    []byte `json:"pubKey"`    // client public key
+}
+
+// ReplyMessage pBFT replica reply sent once the requested transaction is committed
+type ReplyMessage struct {
+	View      uint64 `json:"view"`      // view the block was committed in
+	Timestamp int64  `json:"timestamp"` // timestamp of the request
+	ClientID  string `json:"clientID"`  // client ID of the request
+	ReplicaID string `json:"replicaID"` // replica ID
+	TxID      []byte `json:"txID"`      // committed transaction ID
+	Height    uint64 `json:"height"`    // height of the block containing the transaction
+	BlockHash []byte `json:"blockHash"` // hash of the block containing the transaction
+	Sign      []byte `json:"sign"`      // replica signature of Digest
+	PubKey    []byte `json:"pubKey"`    // replica public key
+}
+
+// Digest returns the digest the client signs
+func (r *RequestMessage) Digest() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(r.ClientID)
+	binary.Write(buf, binary.BigEndian, r.Timestamp)
+	buf.Write(r.TxID)
+	buf.Write(r.Tx)
+	return utils.Sha256Hash(buf.Bytes())
+}
+
+// Digest returns the digest the replica signs
+func (r *ReplyMessage) Digest() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(r.ClientID)
+	binary.Write(buf, binary.BigEndian, r.Timestamp)
+	buf.Write(r.TxID)
+	binary.Write(buf, binary.BigEndian, r.Height)
+	buf.Write(r.BlockHash)
+	return utils.Sha256Hash(buf.Bytes())
+}
+
+// Result returns the key replies must match on, view and replica may differ
+func (r *ReplyMessage) Result() string {
+	buf := bytes.NewBuffer(nil)
+	binary.Write(buf, binary.BigEndian, r.Height)
+	buf.Write(r.BlockHash)
+	return string(buf.Bytes())
+}
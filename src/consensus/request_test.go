@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/mycrypto"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRequestClient_Replies(t *testing.T) {
+	client := blockchain.CreateWallet()
+	rc := NewRequestClient(1, nil, client, "./test.log")
+	request := &RequestMessage{
+		ClientID:  string(client.GetAddress()),
+		Timestamp: time.Now().UnixNano(),
+		TxID:      []byte("tx"),
+	}
+	finished := 0
+	rc.pending["7478"] = &pendingRequest{
+		request:  request,
+		replies:  make(map[uint64]*ReplyMessage),
+		callback: func(reply *ReplyMessage, count int) { finished = count },
+		timer:    time.NewTimer(time.Hour),
+	}
+	block := &blockchain.Block{Header: &blockchain.BlockHeader{Height: 2, Hash: []byte("block")}}
+	fake := &blockchain.Block{Header: &blockchain.BlockHeader{Height: 2, Hash: []byte("fake")}}
+
+	// a faulty replica and a duplicated reply are not enough
+	replica1 := NewRequestClient(1, nil, blockchain.CreateWallet(), "./test.log")
+	replica2 := NewRequestClient(1, nil, blockchain.CreateWallet(), "./test.log")
+	replica3 := NewRequestClient(1, nil, blockchain.CreateWallet(), "./test.log")
+	vs := &blockchain.ValidatorSet{}
+	for _, replica := range []*RequestClient{replica1, replica2, replica3} {
+		vs.Validators = append(vs.Validators, mycrypto.PublicKey2Bytes(replica.publicKey))
+	}
+	rc.SetValidators(func() *blockchain.ValidatorSet { return vs })
+
+	// replies of keys out of the validator set are not counted
+	outsider := NewRequestClient(1, nil, blockchain.CreateWallet(), "./test.log")
+	rc.handleReply(signedReply(outsider, request, block), "")
+	rc.handleReply(signedReply(replica1, request, fake), "")
+	rc.handleReply(signedReply(replica2, request, block), "")
+	rc.handleReply(signedReply(replica2, request, block), "")
+	if finished != 0 {
+		t.Fatal("request finished without f+1 matching replies")
+	}
+	if len(rc.pending["7478"].replies) != 2 {
+		t.Fatal("reply of unknown key counted")
+	}
+	rc.handleReply(signedReply(replica3, request, block), "")
+	fmt.Println("matching replies: ", finished)
+	if finished != 2 {
+		t.Fatal("request not finished with f+1 matching replies")
+	}
+}
+
+func TestRequestClient_RequestSigner(t *testing.T) {
+	client := blockchain.CreateWallet()
+	rc := NewRequestClient(1, nil, blockchain.CreateWallet(), "./test.log")
+	handled := 0
+	rc.SetRequestHandler(func(request *RequestMessage) { handled++ })
+	// a request signed by one key for the client ID of another is dropped
+	request := &RequestMessage{
+		ClientID:  string(blockchain.CreateWallet().GetAddress()),
+		Timestamp: time.Now().UnixNano(),
+		TxID:      []byte("tx"),
+		PubKey:    client.GetPublicKeyBytes(),
+	}
+	request.Sign, _ = mycrypto.Sign(client.GetPrivateKey(), request.Digest())
+	rc.handleRequest(request, "")
+	if handled != 0 {
+		t.Fatal("request of other client ID handled")
+	}
+}
+
+func signedReply(rc *RequestClient, request *RequestMessage, block *blockchain.Block) *ReplyMessage {
+	reply := &ReplyMessage{
+		Timestamp: request.Timestamp,
+		ClientID:  request.ClientID,
+		ReplicaID: rc.id,
+		TxID:      request.TxID,
+		Height:    block.Header.Height,
+		BlockHash: block.Header.Hash,
+		PubKey:    mycrypto.PublicKey2Bytes(rc.publicKey),
+	}
+	reply.Sign, _ = mycrypto.Sign(rc.privateKey, reply.Digest())
+	return reply
+}
@@ -0,0 +1,164 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"BlockChain/src/utils"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Solo single node consensus engine, seals blocks without any peer
+type Solo struct {
+	wallet    *blockchain.Wallet // local wallet
+	net       *p2pnet.P2PNet     // network layer
+	chain     *blockchain.Chain  // block chain
+	blockPool *pool.BlockPool    // block pool
+	txPool    *pool.TxPool       // tx pool
+
+	isStart  bool          // flag of start
+	interval time.Duration // seal interval, 0 means seal when tx pool full
+
+	lock sync.Mutex
+	log  *log.Logger
+}
+
+// NewSolo create solo engine
+func NewSolo(interval uint64, tp *pool.TxPool, bp *pool.BlockPool, net *p2pnet.P2PNet, chain *blockchain.Chain, wallet *blockchain.Wallet, logPath string) (*Solo, error) {
+	// initialize logger
+	l := utils.NewLogger("[solo] ", logPath)
+
+	if wallet == nil {
+		return nil, errors.New("unknown wallet")
+	}
+
+	solo := &Solo{
+		wallet:    wallet,
+		net:       net,
+		chain:     chain,
+		blockPool: bp,
+		txPool:    tp,
+		isStart:   false,
+		interval:  time.Duration(interval) * time.Second,
+		log:       l,
+	}
+	return solo, nil
+}
+
+// Run seal blocks when tx pool is full or seal timer fires
+func (s *Solo) Run() {
+	s.log.Println("Run solo consensus")
+	s.Start()
+	// register callback func
+	s.net.RegisterCallback(p2pnet.ConsensusMsg, s.OnReceive)
+
+	// an empty chain has no funds to spend, create genesis block for local wallet
+	if s.chain.GetHeight() == 0 {
+		genesis, err := blockchain.NewGenesisBlock(s.wallet.GetAddress())
+		if err != nil {
+			s.log.Println(err)
+		} else if s.chain.AddBlock(genesis) {
+			s.log.Println("Create genesis block")
+		}
+	}
+
+	var sealTimer <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		sealTimer = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.txPool.FullSignal:
+			// seal on timer only, tx pool signal just drained
+			if s.interval > 0 || !s.isStarted() {
+				continue
+			}
+			s.log.Println("TxPool full, pack into block...")
+			s.seal()
+		case <-sealTimer:
+			if s.txPool.Count() == 0 || !s.isStarted() {
+				continue
+			}
+			s.log.Println("Seal timer fired, pack into block...")
+			s.seal()
+		}
+	}
+}
+
+// seal packs the valid transactions in pool into a new block and adds it to chain,
+// invalid transactions are dropped one by one and the others are still sealed
+func (s *Solo) seal() {
+	txMap := s.txPool.GetTransactions()
+	ids := make([]string, 0, len(txMap))
+	for id := range txMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var txs []*blockchain.Transaction
+	for _, id := range ids {
+		tx := txMap[id]
+		s.txPool.RemoveTransaction(id)
+		// solo blocks mint no coins, and a transaction must not double spend the ones before it
+		if tx.IsCoinBase() || !blockchain.VerifyTransactions(s.chain, append(txs, tx)) {
+			s.log.Println("tx verify error, drop transaction: ", id)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	if len(txs) == 0 {
+		return
+	}
+
+	block := blockchain.NewBlock(s.chain.Tip, txs, s.chain.BestHeight+1)
+	s.log.Printf("Add block to chain, height: %d", block.Header.Height)
+	s.blockPool.AddBlock(block)
+}
+
+// OnReceive solo engine has no peers, consensus messages are ignored
+func (s *Solo) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
+	if t != p2pnet.ConsensusMsg {
+		return
+	}
+	s.log.Println("Ignore consensus message from: ", peerID)
+}
+
+// Start sets the solo engine as started
+func (s *Solo) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.isStart = true
+}
+
+// Stop sets the solo engine as stopped
+func (s *Solo) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.isStart = false
+}
+
+func (s *Solo) isStarted() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.isStart
+}
+
+// Status returns current solo engine status
+func (s *Solo) Status() *Status {
+	return &Status{
+		Engine:    EngineSolo,
+		IsPrimary: true,
+		View:      0,
+	}
+}
+
+// VerifyBlock checks a solo block received from peers matches its hash and mints no coins
+func (s *Solo) VerifyBlock(block *blockchain.Block) error {
+	return verifyContent(s.chain, block)
+}
@@ -0,0 +1,52 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/pool"
+	"BlockChain/src/storage"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSolo_SealValidTransactions(t *testing.T) {
+	dir := t.TempDir()
+	wallet := blockchain.CreateWallet()
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	logPath := filepath.Join(dir, "solo.log")
+	net := &p2pnet.P2PNet{}
+	tp := pool.NewTxPool(10, net, logPath)
+	s, err := NewSolo(0, tp, pool.NewBlockPool(1, net, chain, logPath), net, chain, wallet, logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one invalid transaction and a coinbase do not drop the valid transaction
+	tx, err := blockchain.NewTransaction(wallet, chain, blockchain.CreateWallet().GetAddress(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := &blockchain.Transaction{ID: []byte("forged"), Inputs: []blockchain.TXinput{{TxID: []byte("missing")}}}
+	tp.AddTransaction(tx)
+	tp.AddTransaction(forged)
+	tp.AddTransaction(blockchain.NewRewardTx(wallet.GetAddress(), 10, 2))
+	s.seal()
+
+	block := chain.GetBlock(chain.Tip)
+	fmt.Println("height: ", chain.GetHeight(), "sealed: ", len(block.Transactions), "pooled: ", tp.Count())
+	if chain.GetHeight() != 2 || len(block.Transactions) != 1 || !bytes.Equal(block.Transactions[0].ID, tx.ID) {
+		t.Fatal("valid transaction not sealed alone")
+	}
+	if tp.Count() != 0 {
+		t.Fatal("sealed or invalid transactions left in pool")
+	}
+	if err := s.VerifyBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package consensus
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/mycrypto"
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Primary node election modes of pBFT
+const (
+	LeaderRotate = "rotate" // primary rotates by view and sequence number
+	LeaderVRF    = "vrf"    // primary is chosen by the VRF output in the parent block
+)
+
+// beaconOf returns the random beacon of a block, the VRF output of its proposer or the block hash
+func beaconOf(block *blockchain.Block) []byte {
+	if output := mycrypto.VRFOutput(block.Header.VRF); output != nil {
+		return output
+	}
+	return block.Header.Hash
+}
+
+// vrfInput returns the VRF input of a sequence number extending the parent beacon
+func vrfInput(beacon []byte, seq uint64) []byte {
+	seqBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(seqBuf, seq)
+	return append(append([]byte{}, beacon...), seqBuf...)
+}
+
+// parentBlock returns the parent block of a sequence number, nil if not prepared yet
+func (pbft *PBFT) parentBlock(seq uint64) *blockchain.Block {
+	if seq == pbft.chain.GetHeight()+1 {
+		return pbft.chain.GetBlock(pbft.chain.Tip)
+	}
+	inst := pbft.getInstance(seq - 1)
+	if inst == nil {
+		return nil
+	}
+	return inst.block
+}
+
+// vrfLeader returns the primary node index of a sequence number chosen by the parent beacon and view,
+// nodeNum if the parent block is unknown
+func (pbft *PBFT) vrfLeader(seq uint64) uint64 {
+	parent := pbft.parentBlock(seq)
+	if parent == nil {
+		return pbft.nodeNum
+	}
+	viewBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(viewBuf, pbft.view)
+	hash := utils.Sha256Hash(append(vrfInput(beaconOf(parent), seq), viewBuf...))
+	n := binary.BigEndian.Uint64(hash[:8])
+	if pbft.dynamic {
+		return pbft.validatorSet.Proposer(n)
+	}
+	return n % pbft.nodeNum
+}
+
+// proveVRF computes the VRF proof a primary node puts in the block of a sequence number
+func (pbft *PBFT) proveVRF(seq uint64) ([]byte, error) {
+	parent := pbft.parentBlock(seq)
+	if parent == nil {
+		return nil, errors.New("parent block not found")
+	}
+	_, proof, err := mycrypto.VRFProve(pbft.privateKey, vrfInput(beaconOf(parent), seq))
+	return proof, err
+}
+
+// verifyVRF checks the VRF proof of a proposed block is made by its proposer on the parent beacon
+func (pbft *PBFT) verifyVRF(block *blockchain.Block, pubKey []byte) error {
+	parent := pbft.parentBlock(block.Header.Height)
+	if parent == nil || !bytes.Equal(parent.Header.Hash, block.Header.PrevHash) {
+		return errors.New("parent block not found")
+	}
+	input := vrfInput(beaconOf(parent), block.Header.Height)
+	if _, ok := mycrypto.VRFVerify(mycrypto.Bytes2PublicKey(pubKey), input, block.Header.VRF); !ok {
+		return errors.New("verify vrf proof fail")
+	}
+	return nil
+}
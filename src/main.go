@@ -4,6 +4,7 @@ import (
 	"BlockChain/src/blockchain"
 	"BlockChain/src/client"
 	"fmt"
+	"os"
 	"sync"
 )
 
@@ -15,6 +16,26 @@ func main() {
 		return
 	}
 
+	// select chain parameters before any address or database is touched
+	if err := blockchain.SelectParams(config.ChainCfg.Network); err != nil {
+		fmt.Println("Select network fail: ", err)
+		return
+	}
+
+	// "migrate" upgrades a chain database of schema version MinSchemaVersion or later to the current version and exits,
+	// older databases can not be upgraded and must be synchronized again
+	if len(os.Args) == 2 && os.Args[1] == "migrate" {
+		chain, err := blockchain.LoadChain(config.ChainCfg.ChainDataBasePath, config.ChainCfg.LogPath)
+		if err != nil {
+			fmt.Println("Migrate database fail: ", err)
+			fmt.Printf("Schema versions %d to %d can be upgraded, remove older databases and synchronize the chain again\n", blockchain.MinSchemaVersion, blockchain.SchemaVersion)
+			return
+		}
+		fmt.Println("Database schema version: ", chain.GetSchemaVersion())
+		chain.DataBase.Close()
+		return
+	}
+
 	// create wallet
 	wallet, err := blockchain.LoadWallet(config.WalletCfg.PubKeyPath, config.WalletCfg.PriKeyPath)
 	if err != nil {
@@ -33,7 +54,7 @@ func main() {
 	//chain, err := blockchain.CreateChain(wallet.GetAddress(), config.ChainCfg.ChainDataBasePath, config.ChainCfg.LogPath)
 	chain, err := blockchain.LoadChain(config.ChainCfg.ChainDataBasePath, config.ChainCfg.LogPath)
 	if err != nil {
-		fmt.Println("Create chain fail")
+		fmt.Println("Create chain fail: ", err)
 		return
 	}
 
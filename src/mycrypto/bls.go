@@ -0,0 +1,135 @@
+package mycrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	bls "github.com/cloudflare/circl/ecc/bls12381"
+)
+
+// BLS signatures on BLS12-381 with public keys in G1 and signatures in G2,
+// signatures of the same message aggregate into one, proof of possession prevents rogue keys
+const (
+	BLSPublicKeyLen = 48                                // compressed G1 public key length
+	BLSSignatureLen = 96                                // compressed G2 signature length
+	BLSKeyLen       = BLSPublicKeyLen + BLSSignatureLen // public key with its proof of possession
+)
+
+var (
+	blsSignDST = []byte("BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+	blsPopDST  = []byte("BLS_POP_BLS12381G2_XMD:SHA-256_SSWU_RO_POP_")
+)
+
+// BLSKey is the BLS key pair of a validator
+type BLSKey struct {
+	secret *bls.Scalar
+	public []byte
+}
+
+// DeriveBLSKey derives the BLS key of a validator from its ECDSA private key
+func DeriveBLSKey(key *ecdsa.PrivateKey) *BLSKey {
+	seed := sha256.Sum256(append([]byte("BLS key"), padScalar(key.D)...))
+	secret := new(bls.Scalar)
+	secret.SetBytes(seed[:])
+	if secret.IsZero() == 1 {
+		secret.SetOne()
+	}
+	pub := new(bls.G1)
+	pub.ScalarMult(secret, bls.G1Generator())
+	return &BLSKey{secret: secret, public: pub.BytesCompressed()}
+}
+
+// PublicKey returns the compressed public key
+func (k *BLSKey) PublicKey() []byte {
+	return k.public
+}
+
+// Sign signs message
+func (k *BLSKey) Sign(message []byte) []byte {
+	return k.sign(message, blsSignDST)
+}
+
+// KeyWithProof returns the public key followed by its proof of possession
+func (k *BLSKey) KeyWithProof() []byte {
+	return append(append([]byte{}, k.public...), k.sign(k.public, blsPopDST)...)
+}
+
+func (k *BLSKey) sign(message, dst []byte) []byte {
+	h := new(bls.G2)
+	h.Hash(message, dst)
+	sig := new(bls.G2)
+	sig.ScalarMult(k.secret, h)
+	return sig.BytesCompressed()
+}
+
+// BLSVerify checks a signature or an aggregate signature of message with a public key
+func BLSVerify(pubKey, message, sign []byte) bool {
+	pub := new(bls.G1)
+	if pub.SetBytes(pubKey) != nil || pub.IsIdentity() {
+		return false
+	}
+	return blsVerify(pub, message, sign, blsSignDST)
+}
+
+// BLSVerifyKey checks the proof of possession of a public key with proof, returns the public key
+func BLSVerifyKey(keyWithProof []byte) ([]byte, bool) {
+	if len(keyWithProof) != BLSKeyLen {
+		return nil, false
+	}
+	pubKey := keyWithProof[:BLSPublicKeyLen]
+	pub := new(bls.G1)
+	if pub.SetBytes(pubKey) != nil || pub.IsIdentity() {
+		return nil, false
+	}
+	if !blsVerify(pub, pubKey, keyWithProof[BLSPublicKeyLen:], blsPopDST) {
+		return nil, false
+	}
+	return pubKey, true
+}
+
+// BLSAggregate aggregates signatures into one signature
+func BLSAggregate(signs [][]byte) ([]byte, error) {
+	if len(signs) == 0 {
+		return nil, errors.New("no signature to aggregate")
+	}
+	agg := new(bls.G2)
+	agg.SetIdentity()
+	for _, s := range signs {
+		sig := new(bls.G2)
+		if sig.SetBytes(s) != nil {
+			return nil, errors.New("invalid bls signature")
+		}
+		agg.Add(agg, sig)
+	}
+	return agg.BytesCompressed(), nil
+}
+
+// BLSVerifyAggregate checks an aggregate signature of the same message by all public keys,
+// the public keys must have a verified proof of possession
+func BLSVerifyAggregate(pubKeys [][]byte, message, sign []byte) bool {
+	if len(pubKeys) == 0 {
+		return false
+	}
+	agg := new(bls.G1)
+	agg.SetIdentity()
+	for _, k := range pubKeys {
+		pub := new(bls.G1)
+		if pub.SetBytes(k) != nil || pub.IsIdentity() {
+			return false
+		}
+		agg.Add(agg, pub)
+	}
+	return blsVerify(agg, message, sign, blsSignDST)
+}
+
+// blsVerify checks e(pub, H(message)) == e(g1, sign)
+func blsVerify(pub *bls.G1, message, sign, dst []byte) bool {
+	sig := new(bls.G2)
+	if sig.SetBytes(sign) != nil {
+		return false
+	}
+	h := new(bls.G2)
+	h.Hash(message, dst)
+	e := bls.ProdPairFrac([]*bls.G1{pub, bls.G1Generator()}, []*bls.G2{h, sig}, []int{1, -1})
+	return e.IsIdentity()
+}
@@ -0,0 +1,41 @@
+package mycrypto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBLSAggregate(t *testing.T) {
+	message := []byte("block")
+	var pubKeys, signs [][]byte
+	for i := 0; i < 4; i++ {
+		_, priv, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := DeriveBLSKey(priv)
+		pubKey, ok := BLSVerifyKey(key.KeyWithProof())
+		if !ok {
+			t.Fatal("verify proof of possession fail")
+		}
+		sign := key.Sign(message)
+		if !BLSVerify(pubKey, message, sign) {
+			t.Fatal("verify bls signature fail")
+		}
+		pubKeys = append(pubKeys, pubKey)
+		signs = append(signs, sign)
+	}
+
+	agg, err := BLSAggregate(signs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("aggregate signature: %d bytes\n", len(agg))
+	if !BLSVerifyAggregate(pubKeys, message, agg) {
+		t.Error("verify aggregate signature fail")
+	}
+	// missing signer or another message must not verify
+	if BLSVerifyAggregate(pubKeys[:3], message, agg) || BLSVerifyAggregate(pubKeys, []byte("other"), agg) {
+		t.Error("wrong aggregate signature verified")
+	}
+}
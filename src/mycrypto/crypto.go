@@ -38,16 +38,21 @@ func Sign(key *ecdsa.PrivateKey, message []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	// serialize
-	rBytes := r.Bytes()
-	sBytes := s.Bytes()
-	signature := append(rBytes, sBytes...)
+	// serialize, r and s are padded to the curve size so Verify splits them in the middle
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
 
 	return signature, nil
 }
 
 // Verify signature
 func Verify(key *ecdsa.PublicKey, message []byte, signature []byte) bool {
+	if key == nil || key.X == nil || key.Y == nil {
+		// malformed public key
+		return false
+	}
 	hash := sha256.Sum256(message)
 
 	// get signature
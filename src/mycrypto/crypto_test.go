@@ -1 +1,24 @@
 package mycrypto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// r or s with leading zero bytes must still verify
+	for i := 0; i < 1000; i++ {
+		message := []byte(fmt.Sprint("message ", i))
+		sign, err := Sign(priv, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sign) != 64 || !Verify(pub, message, sign) {
+			t.Fatal("verify signature fail, length: ", len(sign))
+		}
+	}
+}
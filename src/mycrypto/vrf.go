@@ -0,0 +1,143 @@
+package mycrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// VRF on P-256 following ECVRF with try-and-increment hash to curve,
+// proof = Gamma (33 bytes compressed) || c (16 bytes) || s (32 bytes)
+const (
+	vrfSuite    = 0x01 // suite string of the construction
+	vrfCLen     = 16   // challenge length
+	vrfPointLen = 33   // compressed point length
+	vrfScalar   = 32   // scalar length
+	VRFProofLen = vrfPointLen + vrfCLen + vrfScalar
+)
+
+// VRFProve computes the VRF output of alpha and its proof with private key
+func VRFProve(key *ecdsa.PrivateKey, alpha []byte) ([]byte, []byte, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	pubKey := PublicKey2Bytes(&key.PublicKey)
+
+	hx, hy, err := vrfHashToCurve(pubKey, alpha)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := key.D.Bytes()
+	gx, gy := curve.ScalarMult(hx, hy, x)
+
+	// deterministic nonce from private key and H
+	nonce := sha256.Sum256(append(padScalar(key.D), elliptic.MarshalCompressed(curve, hx, hy)...))
+	k := new(big.Int).Mod(new(big.Int).SetBytes(nonce[:]), n)
+	if k.Sign() == 0 {
+		return nil, nil, errors.New("vrf nonce is zero")
+	}
+	ux, uy := curve.ScalarBaseMult(k.Bytes())
+	vx, vy := curve.ScalarMult(hx, hy, k.Bytes())
+	c := vrfChallenge(hx, hy, gx, gy, ux, uy, vx, vy)
+
+	// s = k + c*x mod n
+	s := new(big.Int).Mul(new(big.Int).SetBytes(c), key.D)
+	s.Add(s, k).Mod(s, n)
+
+	proof := append(elliptic.MarshalCompressed(curve, gx, gy), c...)
+	proof = append(proof, padScalar(s)...)
+	return vrfOutput(gx, gy), proof, nil
+}
+
+// VRFVerify checks the proof of alpha with public key and returns the VRF output
+func VRFVerify(key *ecdsa.PublicKey, alpha, proof []byte) ([]byte, bool) {
+	if key == nil || key.X == nil || len(proof) != VRFProofLen {
+		return nil, false
+	}
+	curve := elliptic.P256()
+	n := curve.Params().N
+	gx, gy := elliptic.UnmarshalCompressed(curve, proof[:vrfPointLen])
+	if gx == nil {
+		return nil, false
+	}
+	c := proof[vrfPointLen : vrfPointLen+vrfCLen]
+	s := new(big.Int).SetBytes(proof[vrfPointLen+vrfCLen:])
+	if s.Cmp(n) >= 0 {
+		return nil, false
+	}
+	hx, hy, err := vrfHashToCurve(PublicKey2Bytes(key), alpha)
+	if err != nil {
+		return nil, false
+	}
+
+	// U = s*G - c*Y, V = s*H - c*Gamma
+	ux, uy := curve.ScalarBaseMult(padScalar(s))
+	cyx, cyy := curve.ScalarMult(key.X, key.Y, c)
+	ux, uy = curve.Add(ux, uy, cyx, negate(curve, cyy))
+	vx, vy := curve.ScalarMult(hx, hy, padScalar(s))
+	cgx, cgy := curve.ScalarMult(gx, gy, c)
+	vx, vy = curve.Add(vx, vy, cgx, negate(curve, cgy))
+
+	if string(vrfChallenge(hx, hy, gx, gy, ux, uy, vx, vy)) != string(c) {
+		return nil, false
+	}
+	return vrfOutput(gx, gy), true
+}
+
+// VRFOutput returns the VRF output of a proof without verifying it
+func VRFOutput(proof []byte) []byte {
+	if len(proof) != VRFProofLen {
+		return nil
+	}
+	gx, gy := elliptic.UnmarshalCompressed(elliptic.P256(), proof[:vrfPointLen])
+	if gx == nil {
+		return nil
+	}
+	return vrfOutput(gx, gy)
+}
+
+// vrfHashToCurve maps public key and alpha to a curve point by try and increment
+func vrfHashToCurve(pubKey, alpha []byte) (*big.Int, *big.Int, error) {
+	curve := elliptic.P256()
+	for ctr := 0; ctr < 256; ctr++ {
+		data := append([]byte{vrfSuite, 0x01}, pubKey...)
+		data = append(data, alpha...)
+		data = append(data, byte(ctr), 0x00)
+		hash := sha256.Sum256(data)
+		x, y := elliptic.UnmarshalCompressed(curve, append([]byte{0x02}, hash[:]...))
+		if x != nil {
+			return x, y, nil
+		}
+	}
+	return nil, nil, errors.New("vrf hash to curve fail")
+}
+
+// vrfChallenge hashes the points of a proof into the challenge c
+func vrfChallenge(points ...*big.Int) []byte {
+	curve := elliptic.P256()
+	data := []byte{vrfSuite, 0x02}
+	for i := 0; i+1 < len(points); i += 2 {
+		data = append(data, elliptic.MarshalCompressed(curve, points[i], points[i+1])...)
+	}
+	data = append(data, 0x00)
+	hash := sha256.Sum256(data)
+	return hash[:vrfCLen]
+}
+
+// vrfOutput hashes Gamma into the VRF output
+func vrfOutput(gx, gy *big.Int) []byte {
+	data := append([]byte{vrfSuite, 0x03}, elliptic.MarshalCompressed(elliptic.P256(), gx, gy)...)
+	data = append(data, 0x00)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+func negate(curve elliptic.Curve, y *big.Int) *big.Int {
+	return new(big.Int).Sub(curve.Params().P, y)
+}
+
+func padScalar(v *big.Int) []byte {
+	buf := make([]byte, vrfScalar)
+	return v.FillBytes(buf)
+}
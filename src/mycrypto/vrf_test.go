@@ -0,0 +1,42 @@
+package mycrypto
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestVRF(t *testing.T) {
+	pub, priv, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, proof, err := VRFProve(priv, []byte("seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("vrf output: %x\n", output)
+
+	// the output is unique for a key and input
+	again, _, _ := VRFProve(priv, []byte("seed"))
+	if !bytes.Equal(output, again) || !bytes.Equal(output, VRFOutput(proof)) {
+		t.Error("vrf output not unique")
+	}
+	verified, ok := VRFVerify(pub, []byte("seed"), proof)
+	if !ok || !bytes.Equal(verified, output) {
+		t.Fatal("verify vrf proof fail")
+	}
+
+	// proof of another input or key must not verify
+	if _, ok := VRFVerify(pub, []byte("other"), proof); ok {
+		t.Error("vrf proof verified with another input")
+	}
+	otherPub, _, _ := GenerateKeyPair()
+	if _, ok := VRFVerify(otherPub, []byte("seed"), proof); ok {
+		t.Error("vrf proof verified with another key")
+	}
+	proof[len(proof)-1] ^= 1
+	if _, ok := VRFVerify(pub, []byte("seed"), proof); ok {
+		t.Error("tampered vrf proof verified")
+	}
+}
@@ -11,10 +11,15 @@ func (node *P2PNet) handleStream(stream network.Stream) {
 	p2pStream := &P2PStream{
 		peerID:          stream.Conn().RemotePeer().String(),
 		stream:          stream,
+		reader:          bufio.NewReader(stream),
 		MessageChan:     make(chan *Message, 0),
 		closeReadStrem:  make(chan struct{}, 1),
 		closeWriteStrem: make(chan struct{}, 1),
 	}
+	if err := node.handshake(p2pStream); err != nil {
+		node.rejectPeer(p2pStream, err)
+		return
+	}
 
 	// Add P2PStream to peerTable
 	node.Lock()
@@ -23,11 +28,12 @@ func (node *P2PNet) handleStream(stream network.Stream) {
 
 	go node.recvData(p2pStream) // Start receiving data
 	go node.sendData(p2pStream) // Start sending data
+	node.peerConnected(p2pStream)
 }
 
 // recvData handles receiving data from the peer stream.
 func (node *P2PNet) recvData(stream *P2PStream) {
-	rw := bufio.NewReader(stream.stream)
+	rw := stream.reader
 
 outLoop:
 	for {
@@ -45,10 +51,10 @@ outLoop:
 			if err != nil {
 				break outLoop
 			}
-			node.log.Printf("receive message type: %s", msg.Type)
+			node.log.Printf("receive message type: %v", msg.Type)
 			callback := node.callBacks[msg.Type]
 			if callback != nil {
-				node.log.Printf("Run callback for message type: %s", msg.Type)
+				node.log.Printf("Run callback for message type: %v", msg.Type)
 				go callback(msg.Type, msg.Data, stream.peerID)
 			} else {
 				node.log.Printf("unknown Message Type")
@@ -85,7 +91,7 @@ outLoop:
 		case msg := <-stream.MessageChan:
 			node.log.Printf("send message to: %s", stream.peerID)
 			msgBuf, err := PackMessage(msg)
-			node.log.Printf("send message type: %s", msg.Type)
+			node.log.Printf("send message type: %v", msg.Type)
 			if err != nil {
 				break outLoop
 			}
@@ -0,0 +1,159 @@
+package p2pnet
+
+import (
+	"BlockChain/src/codec"
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ProtocolVersion    = 1  // version of the handshake and messages sent by this node
+	MinProtocolVersion = 1  // oldest protocol version of peers accepted
+	HandshakeTimeout   = 10 // time in seconds to wait for the handshake of a peer
+)
+
+// Node roles announced in the handshake
+const (
+	RoleValidator = "validator" // node running the consensus engine
+	RoleObserver  = "observer"  // node following the chain only
+)
+
+// RequiredMessageTypes are handled by every node, peers missing one are rejected
+var RequiredMessageTypes = []MessageType{BlockMsg, TransactionMsg}
+
+// Handshake is the first frame on every stream, incompatible peers are disconnected
+type Handshake struct {
+	Version      uint32        // protocol version, filled by the network
+	MessageTypes []MessageType // message types the node handles, other messages are not sent to it
+	ChainID      uint32        // chain ID of the network
+	Genesis      []byte        // genesis block hash, empty if unknown
+	BestHeight   uint64        // best block height when the stream opened
+	Role         string        // RoleValidator or RoleObserver
+}
+
+// HandshakeFunc returns the local handshake when a stream opens
+type HandshakeFunc func() *Handshake
+
+// PeerHandler is called with the handshake of a peer after it is connected
+type PeerHandler func(peerID string, handshake *Handshake)
+
+// SetHandshake sets the provider of the local handshake
+func (node *P2PNet) SetHandshake(f HandshakeFunc) {
+	node.Lock()
+	defer node.Unlock()
+	node.handshakeFunc = f
+}
+
+// SetPeerHandler sets the handler of connected peers
+func (node *P2PNet) SetPeerHandler(h PeerHandler) {
+	node.Lock()
+	defer node.Unlock()
+	node.peerHandler = h
+}
+
+// Supports checks the node handles a message type
+func (h *Handshake) Supports(t MessageType) bool {
+	for _, supported := range h.MessageTypes {
+		if supported == t {
+			return true
+		}
+	}
+	return false
+}
+
+// compatible checks the handshake of a peer, genesis hashes are compared only if both are known.
+// A peer without one is connected, the block pool never synchronizes from it
+func (h *Handshake) compatible(peer *Handshake) error {
+	if peer.Version < MinProtocolVersion {
+		return fmt.Errorf("protocol version %d below minimum version %d", peer.Version, MinProtocolVersion)
+	}
+	if peer.Role != RoleValidator && peer.Role != RoleObserver {
+		return fmt.Errorf("unknown role %q", peer.Role)
+	}
+	for _, t := range RequiredMessageTypes {
+		if !peer.Supports(t) {
+			return fmt.Errorf("message type %d not supported", t)
+		}
+	}
+	if h.ChainID != peer.ChainID {
+		return fmt.Errorf("chain ID %d not match local chain ID %d", peer.ChainID, h.ChainID)
+	}
+	if len(h.Genesis) != 0 && len(peer.Genesis) != 0 && !bytes.Equal(h.Genesis, peer.Genesis) {
+		return errors.New("genesis hash not match")
+	}
+	return nil
+}
+
+// handshake sends the local handshake on a new stream and checks the one of the peer
+func (node *P2PNet) handshake(stream *P2PStream) error {
+	local := &Handshake{Role: RoleObserver, MessageTypes: RequiredMessageTypes}
+	node.RLock()
+	f := node.handshakeFunc
+	node.RUnlock()
+	if f != nil {
+		local = f()
+	}
+	local.Version = ProtocolVersion
+	data, err := codec.Marshal(local)
+	if err != nil {
+		return err
+	}
+	frame, err := PackMessage(&Message{Type: HandshakeMsg, Data: data})
+	if err != nil {
+		return err
+	}
+
+	stream.stream.SetDeadline(time.Now().Add(HandshakeTimeout * time.Second))
+	defer stream.stream.SetDeadline(time.Time{})
+	if _, err := stream.stream.Write(frame); err != nil {
+		return err
+	}
+	msg, err := UnpackMessage(stream.reader)
+	if err != nil {
+		return err
+	}
+	if msg.Type != HandshakeMsg {
+		return errors.New("first message is not a handshake")
+	}
+	var remote Handshake
+	if err := codec.Unmarshal(msg.Data, &remote); err != nil {
+		return err
+	}
+	if err := local.compatible(&remote); err != nil {
+		return err
+	}
+	stream.handshake = &remote
+	return nil
+}
+
+// rejectPeer closes the stream and all connections to a peer failing the handshake
+func (node *P2PNet) rejectPeer(stream *P2PStream, err error) {
+	node.log.Printf("Handshake with peer %s fail: %v", stream.peerID, err)
+	stream.stream.Reset()
+	node.Host.Network().ClosePeer(stream.stream.Conn().RemotePeer())
+}
+
+// peerConnected passes the handshake of a connected peer to the peer handler
+func (node *P2PNet) peerConnected(stream *P2PStream) {
+	node.log.Printf("Peer %s connected, version: %d, role: %s, height: %d",
+		stream.peerID, stream.handshake.Version, stream.handshake.Role, stream.handshake.BestHeight)
+	node.RLock()
+	h := node.peerHandler
+	node.RUnlock()
+	if h != nil {
+		go h(stream.peerID, stream.handshake)
+	}
+}
+
+// PeerHandshake returns the handshake of a connected peer, nil if not connected
+func (node *P2PNet) PeerHandshake(peerID string) *Handshake {
+	node.RLock()
+	defer node.RUnlock()
+	stream, ok := node.peerTable[peerID]
+	if !ok {
+		return nil
+	}
+	return stream.handshake
+}
@@ -0,0 +1,40 @@
+package p2pnet
+
+import (
+	"BlockChain/src/codec"
+	"fmt"
+	"testing"
+)
+
+func TestHandshake_Compatible(t *testing.T) {
+	local := &Handshake{Version: ProtocolVersion, MessageTypes: RequiredMessageTypes, ChainID: 1, Genesis: []byte{0x01}, Role: RoleValidator}
+	peer := &Handshake{Version: ProtocolVersion, MessageTypes: RequiredMessageTypes, ChainID: 1, BestHeight: 5, Role: RoleObserver}
+
+	// version is the first field of the handshake frame
+	data, err := codec.Marshal(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Handshake
+	if err := codec.Unmarshal(data, &decoded); err != nil || data[1] != 0 || data[4] != ProtocolVersion {
+		t.Fatal("handshake encoding fail")
+	}
+	if err := local.compatible(&decoded); err != nil || decoded.BestHeight != 5 {
+		t.Fatal("peer with unknown genesis rejected: ", err)
+	}
+
+	cases := map[string]Handshake{
+		"old version":   {Version: MinProtocolVersion - 1, MessageTypes: RequiredMessageTypes, ChainID: 1, Role: RoleObserver},
+		"unknown role":  {Version: ProtocolVersion, MessageTypes: RequiredMessageTypes, ChainID: 1, Role: "miner"},
+		"missing types": {Version: ProtocolVersion, MessageTypes: []MessageType{BlockMsg}, ChainID: 1, Role: RoleObserver},
+		"other chain":   {Version: ProtocolVersion, MessageTypes: RequiredMessageTypes, ChainID: 2, Role: RoleObserver},
+		"other genesis": {Version: ProtocolVersion, MessageTypes: RequiredMessageTypes, ChainID: 1, Genesis: []byte{0x02}, Role: RoleObserver},
+	}
+	for name, peer := range cases {
+		err := local.compatible(&peer)
+		fmt.Println(name, ": ", err)
+		if err == nil {
+			t.Fatal("accept incompatible peer: ", name)
+		}
+	}
+}
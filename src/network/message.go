@@ -1,10 +1,10 @@
 package p2pnet
 
 import (
+	"BlockChain/src/codec"
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"hash/crc32"
 	"io"
@@ -18,6 +18,9 @@ const (
 	BlockMsg       MessageType = iota // Message type for handling blocks
 	TransactionMsg                    // Message type for handling transactions
 	ConsensusMsg                      // Message type for handling consensus-related messages
+	RequestMsg                        // Message type for handling client requests to consensus nodes
+	ReplyMsg                          // Message type for handling consensus node replies to clients
+	HandshakeMsg                      // Message type for the handshake sent first on every stream
 )
 
 // Message represents a generic message type transmitted over the P2P network.
@@ -33,7 +36,7 @@ func PackMessage(msg *Message) ([]byte, error) {
 	dataBuf := bytes.NewBuffer(nil)
 
 	// Convert message to JSON byte array
-	msgBuf, err := json.Marshal(msg)
+	msgBuf, err := codec.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -91,6 +94,6 @@ func UnpackMessage(rw *bufio.Reader) (*Message, error) {
 
 	// Unmarshal the message content into a Message struct
 	var msg Message
-	err = json.Unmarshal(msgBuf, &msg)
+	err = codec.Unmarshal(msgBuf, &msg)
 	return &msg, err
 }
@@ -3,6 +3,7 @@ package p2pnet
 import (
 	"BlockChain/src/mycrypto"
 	"BlockChain/src/utils"
+	"bufio"
 	"context"
 	"errors"
 	"github.com/libp2p/go-libp2p"
@@ -27,23 +28,27 @@ type P2PNet struct {
 	//bootstrap        bool                  // is bootstrap peer
 	//kademliaDHT      *dht.IpfsDHT          // KDH table
 	//routingDiscovery *drouting.RoutingDiscovery
-	sync.RWMutex                             // lock
-	peerTable    map[string]*P2PStream       // already connect peers
-	callBacks    map[MessageType]RecvHandler // receive call back func
-	log          *log.Logger
+	sync.RWMutex                              // lock
+	peerTable     map[string]*P2PStream       // already connect peers
+	callBacks     map[MessageType]RecvHandler // receive call back func
+	handshakeFunc HandshakeFunc               // local handshake sent on new streams
+	peerHandler   PeerHandler                 // handler of peers connected after handshake
+	log           *log.Logger
 }
 
 // P2PStream describe stream between peer
 type P2PStream struct {
 	peerID          string
 	stream          network.Stream
+	reader          *bufio.Reader // reads the handshake and later messages
+	handshake       *Handshake    // handshake of the peer
 	MessageChan     chan *Message
 	closeReadStrem  chan struct{}
 	closeWriteStrem chan struct{}
 }
 
-// CreateNode create a P2P network Node
-func CreateNode(keyPath string, addr string, logPath string) *P2PNet {
+// CreateNode create a P2P network Node, nodes of different networks use different protocols
+func CreateNode(network string, keyPath string, addr string, logPath string) *P2PNet {
 	// initialize logger
 	l := utils.NewLogger("[net] ", logPath)
 
@@ -68,8 +73,8 @@ func CreateNode(keyPath string, addr string, logPath string) *P2PNet {
 	p2pNode := &P2PNet{
 		Host:       host,
 		ID:         host.ID().String(),
-		protocol:   "/chain/1.0.0",
-		rendezvous: "chain-discovery",
+		protocol:   "/chain/" + network + "/1.0.0",
+		rendezvous: "chain-discovery-" + network,
 		peerTable:  make(map[string]*P2PStream),
 		callBacks:  make(map[MessageType]RecvHandler),
 		log:        l,
@@ -112,10 +117,15 @@ func (node *P2PNet) StartNode() {
 			p2pStream := &P2PStream{
 				peerID:          info.ID.String(),
 				stream:          stream,
+				reader:          bufio.NewReader(stream),
 				MessageChan:     make(chan *Message),
 				closeReadStrem:  make(chan struct{}, 1),
 				closeWriteStrem: make(chan struct{}, 1),
 			}
+			if err := node.handshake(p2pStream); err != nil {
+				node.rejectPeer(p2pStream, err)
+				continue
+			}
 
 			node.Lock()
 			node.log.Printf("add peer %s", info.ID.String())
@@ -125,6 +135,7 @@ func (node *P2PNet) StartNode() {
 			// Start goroutines to handle receiving and sending data for this peer.
 			go node.recvData(p2pStream)
 			go node.sendData(p2pStream)
+			node.peerConnected(p2pStream)
 		}
 	}
 }
@@ -146,6 +157,10 @@ func (node *P2PNet) BroadcastToPeer(msg *Message, peerID string) error {
 	if !ok {
 		return errors.New("Peer not found")
 	}
+	if !stream.handshake.Supports(msg.Type) {
+		node.log.Printf("peer %s not support message type: %v", peerID, msg.Type)
+		return nil
+	}
 	go func() {
 		select {
 		// Send message to the peer's message channel.
@@ -153,7 +168,7 @@ func (node *P2PNet) BroadcastToPeer(msg *Message, peerID string) error {
 			node.log.Printf("send message to peer: %s, type: %v", peerID, msg.Type)
 		// Handle timeout if the sending operation takes too long.
 		case <-time.After(1 * time.Minute):
-			node.log.Println("Timeout!: %s", peerID)
+			node.log.Printf("Timeout!: %s", peerID)
 			return
 		}
 	}()
@@ -2,10 +2,10 @@ package pool
 
 import (
 	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	"BlockChain/src/network"
 	"BlockChain/src/utils"
 	"encoding/hex"
-	"encoding/json"
 	"log"
 	"sync"
 )
@@ -59,13 +59,16 @@ func (tp *TxPool) AddTransaction(tx *blockchain.Transaction) {
 	tp.lock.Unlock()
 }
 
-// GetTransactions get all transactions from pool
+// GetTransactions get transactions for the next block from pool, at most MaxBlockTxs of the network
 func (tp *TxPool) GetTransactions() map[string]*blockchain.Transaction {
 	tp.lock.Lock()
 	defer tp.lock.Unlock()
 
 	transactions := make(map[string]*blockchain.Transaction)
 	for id, tx := range tp.pool {
+		if len(transactions) >= blockchain.ActiveParams().MaxBlockTxs {
+			break
+		}
 		transactions[id] = tx
 	}
 
@@ -99,6 +102,14 @@ func (tp *TxPool) Count() int {
 	return len(tp.pool)
 }
 
+// IsFull check if transaction num in pool reaches the full size
+func (tp *TxPool) IsFull() bool {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	return len(tp.pool) >= tp.full
+}
+
 // OnReceive handle transaction message receive from peer
 func (tp *TxPool) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string) {
 	if t != p2pnet.TransactionMsg {
@@ -106,7 +117,7 @@ func (tp *TxPool) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string
 	}
 	var txMsg TxMessage
 	tp.log.Println("Receive new tx")
-	err := json.Unmarshal(msgBytes, &txMsg)
+	err := codec.Unmarshal(msgBytes, &txMsg)
 	if err != nil {
 		tp.log.Println("Unmarshal message fail")
 		return
@@ -114,7 +125,7 @@ func (tp *TxPool) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID string
 	switch txMsg.Type {
 	case SendTxMsg:
 		var tx blockchain.Transaction
-		err = json.Unmarshal(txMsg.TxBytes, &tx)
+		err = codec.Unmarshal(txMsg.TxBytes, &tx)
 		if err != nil {
 			tp.log.Println("Unmarshal tx fail")
 			return
@@ -2,17 +2,21 @@ package pool
 
 import (
 	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	p2pnet "BlockChain/src/network"
 	"BlockChain/src/utils"
 	"bytes"
 	"encoding/hex"
-	"encoding/json"
 	"log"
 	"sync"
 	"time"
 )
 
-const SyncPollingTime = 5
+// BlockVerifier checks a block received from peers before it is connected to chain
+type BlockVerifier func(block *blockchain.Block) error
+
+// CertVerifier checks a commit certificate received from peers
+type CertVerifier func(cert *blockchain.QuorumCert) bool
 
 type BlockPool struct {
 	full           int
@@ -21,10 +25,23 @@ type BlockPool struct {
 	network        *p2pnet.P2PNet
 	peerBestHeight uint64
 	bestPeerID     string
+	verifier       BlockVerifier  // consensus verifier of received blocks
+	certVerifier   CertVerifier   // consensus verifier of received commit certificates
+	requested      uint64         // highest announced height requested from peers
+	requestedAt    time.Time      // time of the last announced block request
+	requestedFrom  string         // peer of the last announced block request
+	headerVerifier HeaderVerifier // consensus verifier of synchronized headers
+	sync           *headerSync    // header first synchronization state
+	snapshot       *snapshotSync  // state snapshot synchronization state
+	syncTarget     uint64         // height synchronizing to, 0 if not synchronizing
+	forkChoice     bool           // switch to the side branch with most cumulative work
+	broadcast      bool           // accept new blocks broadcast by peers, only mined blocks prove themselves
+	fastSync       bool           // synchronize an empty chain from a state snapshot
 	newBlock       chan *BlockMessage
 	syncMsg        chan *BlockMessage
 	log            *log.Logger
 	lock           sync.Mutex
+	connectLock    sync.Mutex // serialize connecting blocks to chain
 }
 
 // NewBlockPool create a new block pool
@@ -44,6 +61,8 @@ func NewBlockPool(f int, net *p2pnet.P2PNet, chain *blockchain.Chain, logPath st
 		peerBestHeight: 0,
 		newBlock:       make(chan *BlockMessage),
 		syncMsg:        make(chan *BlockMessage),
+		sync:           newHeaderSync(),
+		snapshot:       newSnapshotSync(),
 		log:            l,
 	}
 	return pool
@@ -53,6 +72,7 @@ func (bp *BlockPool) Run() {
 	bp.log.Println("Run Block Pool")
 	// register receive callback func
 	bp.network.RegisterCallback(p2pnet.BlockMsg, bp.OnReceive)
+	bp.network.SetPeerHandler(bp.OnPeerConnected)
 
 	// run block sync
 	bp.log.Println("Begin Block synchronization")
@@ -65,7 +85,7 @@ func (bp *BlockPool) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID str
 		return
 	}
 	var blockMsg BlockMessage
-	err := json.Unmarshal(msgBytes, &blockMsg)
+	err := codec.Unmarshal(msgBytes, &blockMsg)
 	if err != nil {
 		bp.log.Println("Unmarshal message fail")
 		return
@@ -79,21 +99,289 @@ func (bp *BlockPool) OnReceive(t p2pnet.MessageType, msgBytes []byte, peerID str
 	case BlockRequestMsg:
 		fallthrough
 	case BlockResponseMsg:
+		fallthrough
+	case HeaderRequestMsg:
+		fallthrough
+	case HeaderResponseMsg:
+		fallthrough
+	case SnapshotRequestMsg:
+		fallthrough
+	case SnapshotResponseMsg:
+		fallthrough
+	case ChunkRequestMsg:
+		fallthrough
+	case ChunkResponseMsg:
+		// sync accounting uses the connected peer, not the claimed sender ID
+		blockMsg.From = peerID
 		bp.syncMsg <- &blockMsg
 		bp.log.Println("Receive a sync message")
-
+	case NewBlockBroadcastMsg:
+		msg, _ := blockMsg.SplitMessage()
+		if newBlock, ok := msg.(NewBlockMessage); ok {
+			bp.log.Println("Receive a NewBlock message, height: ", newBlock.Height)
+			var block blockchain.Block
+			err := codec.Unmarshal(newBlock.Block, &block)
+			if err != nil {
+				bp.log.Println("Deserialize block fail")
+				return
+			}
+			bp.lock.Lock()
+			broadcast := bp.broadcast
+			bp.lock.Unlock()
+			if !broadcast {
+				// blocks of other engines come with their certificates from announcements and sync
+				bp.log.Println("Drop new block broadcast")
+				return
+			}
+			if bp.processBlock(&block) {
+				// relay new block to other peers
+				p2pMsg := &p2pnet.Message{
+					Type: p2pnet.BlockMsg,
+					Data: msgBytes,
+				}
+				bp.network.BroadcastExceptPeer(p2pMsg, peerID)
+			}
+		}
+	case NewBlockAnnounceMsg:
+		msg, _ := blockMsg.SplitMessage()
+		if announce, ok := msg.(NewBlockAnnounceMessage); ok {
+			bp.log.Println("Receive a NewBlockAnnounce message, height: ", announce.Height)
+			announce.FromID = peerID
+			bp.requestAnnounced(&announce)
+		}
 	default:
 		return
 	}
 }
 
+// SetVerifier sets the consensus verifier of blocks received from peers
+func (bp *BlockPool) SetVerifier(v BlockVerifier) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.verifier = v
+}
+
+// SetForkChoice enables switching to the side branch with most cumulative work
+func (bp *BlockPool) SetForkChoice(enabled bool) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.forkChoice = enabled
+}
+
+// SetBroadcast enables accepting new blocks broadcast by peers
+func (bp *BlockPool) SetBroadcast(enabled bool) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.broadcast = enabled
+}
+
+// SetCertVerifier sets the consensus verifier of commit certificates received with blocks
+func (bp *BlockPool) SetCertVerifier(v CertVerifier) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.certVerifier = v
+}
+
+// AnnounceBlock pushes the height and hash of a committed block to peers,
+// peers missing it fetch the block with its commit certificate
+func (bp *BlockPool) AnnounceBlock(block *blockchain.Block) {
+	blockMsg, err := CreateBlockMessage(NewBlockAnnounceMsg, bp.network.ID, block.Header.Height, block.Header.Hash)
+	if err != nil {
+		bp.log.Println("Create message fail")
+		return
+	}
+	data, err := codec.Marshal(blockMsg)
+	if err != nil {
+		bp.log.Println("Marshal message fail")
+		return
+	}
+	p2pMsg := p2pnet.Message{
+		Type: p2pnet.BlockMsg,
+		Data: data,
+	}
+	bp.log.Printf("Announce new block, height: %d", block.Header.Height)
+	bp.network.Broadcast(&p2pMsg)
+}
+
+// requestAnnounced requests the announced block and the missing blocks before it from the announcing peer,
+// a height recently requested from another peer is skipped, nodes far behind use header sync instead
+func (bp *BlockPool) requestAnnounced(announce *NewBlockAnnounceMessage) {
+	height := bp.chain.GetHeight()
+	if announce.Height <= height || announce.Height > height+SyncWindowSize || bp.HaveBlock(announce.Hash) || bp.chain.HaveBlock(announce.Hash) {
+		return
+	}
+	if !bp.followsGenesis(announce.FromID) {
+		return
+	}
+	bp.lock.Lock()
+	if announce.Height <= bp.requested && time.Since(bp.requestedAt) < SyncWindowTimeout*time.Second {
+		bp.lock.Unlock()
+		return
+	}
+	bp.requested = announce.Height
+	bp.requestedAt = time.Now()
+	bp.requestedFrom = announce.FromID
+	bp.lock.Unlock()
+
+	blockMsg, err := CreateBlockMessage(BlockRequestMsg, bp.network.ID, height+1, announce.Height)
+	if err != nil {
+		bp.log.Println("Create message fail")
+		return
+	}
+	data, err := codec.Marshal(blockMsg)
+	if err != nil {
+		bp.log.Println("Marshal message fail")
+		return
+	}
+	p2pMsg := p2pnet.Message{
+		Type: p2pnet.BlockMsg,
+		Data: data,
+	}
+	bp.log.Println("Send block request message to announcing peer: ", announce.FromID)
+	bp.network.BroadcastToPeer(&p2pMsg, announce.FromID)
+}
+
+// isRequested reports whether a block response answers the last announced block request
+func (bp *BlockPool) isRequested(from string, height uint64) bool {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	return from == bp.requestedFrom && height <= bp.requested && time.Since(bp.requestedAt) < SyncWindowTimeout*time.Second
+}
+
+// processCertifiedBlock verifies the commit certificate received with a block, connects the block
+// and stores the certificate, a connected block is announced to peers.
+// Chains with commit certificates accept no block without one
+func (bp *BlockPool) processCertifiedBlock(block *blockchain.Block, certBytes []byte) {
+	bp.lock.Lock()
+	certVerifier := bp.certVerifier
+	bp.lock.Unlock()
+	if len(certBytes) == 0 {
+		if certVerifier != nil {
+			bp.log.Println("Block without commit certificate, height: ", block.Header.Height)
+			return
+		}
+		bp.processBlock(block)
+		return
+	}
+	var cert blockchain.QuorumCert
+	if err := codec.Unmarshal(certBytes, &cert); err != nil {
+		bp.log.Println("Deserialize commit certificate fail")
+		return
+	}
+	if !bytes.Equal(cert.BlockHash, block.Header.Hash) || cert.Height != block.Header.Height {
+		bp.log.Println("Block not match commit certificate")
+		return
+	}
+	if certVerifier != nil && !certVerifier(&cert) {
+		bp.log.Println("Invalid commit certificate, height: ", cert.Height)
+		return
+	}
+	if !bp.processBlock(block) {
+		return
+	}
+	// certificate of a pooled block is kept for when it is connected
+	if err := bp.chain.AddCert(&cert); err != nil {
+		bp.log.Println("Store commit certificate fail: ", err)
+	}
+	if bp.chain.HaveBlock(block.Header.Hash) {
+		// relay to peers behind this node
+		bp.AnnounceBlock(block)
+	}
+}
+
+// BroadcastBlock announces a newly produced block to peers
+func (bp *BlockPool) BroadcastBlock(block *blockchain.Block) {
+	serializedData, err := codec.Marshal(block)
+	if err != nil {
+		bp.log.Println("Marshal block fail")
+		return
+	}
+	blockMsg, err := CreateBlockMessage(NewBlockBroadcastMsg, block.Header.Height, block.Header.Hash, serializedData)
+	if err != nil {
+		bp.log.Println("Create message fail")
+		return
+	}
+	data, err := codec.Marshal(blockMsg)
+	if err != nil {
+		bp.log.Println("Marshal message fail")
+		return
+	}
+	p2pMsg := p2pnet.Message{
+		Type: p2pnet.BlockMsg,
+		Data: data,
+	}
+	bp.log.Printf("Broadcast new block, height: %d", block.Header.Height)
+	bp.network.Broadcast(&p2pMsg)
+}
+
+// processBlock verifies a block received from peer and connects it to chain,
+// blocks which can not be connected yet are kept in the pool
+func (bp *BlockPool) processBlock(block *blockchain.Block) bool {
+	if bp.HaveBlock(block.Header.Hash) || bp.chain.HaveBlock(block.Header.Hash) {
+		return false
+	}
+	bp.lock.Lock()
+	verifier := bp.verifier
+	bp.lock.Unlock()
+	if verifier != nil {
+		if err := verifier(block); err != nil {
+			bp.log.Println("Verify block fail: ", err)
+			return false
+		}
+	}
+	bp.connectLock.Lock()
+	connected := bp.connectBlock(block)
+	bp.connectLock.Unlock()
+	if !connected {
+		bp.log.Println("Add Block to chain fail, put it in the pool")
+		bp.AddBlock(block)
+	} else {
+		bp.log.Println("Add Block to chain successfully")
+		bp.log.Println("Reindex pool")
+		bp.Reindex()
+	}
+	return true
+}
+
+// CommitBlock adds a block ordered by consensus to the tip of chain, a block already in chain counts as committed
+func (bp *BlockPool) CommitBlock(block *blockchain.Block) bool {
+	bp.connectLock.Lock()
+	defer bp.connectLock.Unlock()
+	if bp.chain.HaveBlock(block.Header.Hash) {
+		return true
+	}
+	return bp.connectBlock(block)
+}
+
+// connectBlock adds block to the tip of chain, or with fork choice to a side branch
+// and reorganize the chain when the branch has more cumulative work
+func (bp *BlockPool) connectBlock(block *blockchain.Block) bool {
+	if bytes.Equal(block.Header.PrevHash, bp.chain.Tip) {
+		return bp.chain.AddBlock(block)
+	}
+	bp.lock.Lock()
+	forkChoice := bp.forkChoice
+	bp.lock.Unlock()
+	if !forkChoice || !bp.chain.HaveBlock(block.Header.PrevHash) {
+		return false
+	}
+	if !bp.chain.AddSideBlock(block) {
+		return false
+	}
+	if bp.chain.GetTotalWork(block.Header.Hash).Cmp(bp.chain.GetTotalWork(bp.chain.Tip)) > 0 {
+		bp.log.Println("Side branch has more work, reorganize chain")
+		return bp.chain.Reorganize(block.Header.Hash)
+	}
+	return true
+}
+
 func (bp *BlockPool) BlockSynchronization() {
 	blockMsg, err := CreateBlockMessage(SyncRequestMsg, bp.network.ID, bp.chain.BestHeight)
 	if err != nil {
 		bp.log.Println("Create message fail")
 		return
 	}
-	data, err := json.Marshal(blockMsg)
+	data, err := codec.Marshal(blockMsg)
 	if err != nil {
 		bp.log.Println("Marshal message fail")
 		return
@@ -108,7 +396,9 @@ func (bp *BlockPool) BlockSynchronization() {
 
 func (bp *BlockPool) BlockSyncRoutine() {
 	bp.BlockSynchronization()
-	syncTimer := time.NewTimer(SyncPollingTime * time.Second)
+	syncTimer := time.NewTimer(time.Duration(blockchain.ActiveParams().SyncPollingTime) * time.Second)
+	stallTicker := time.NewTicker(SyncCheckInterval * time.Second)
+	defer stallTicker.Stop()
 
 	// run synchronization routine
 	for {
@@ -120,11 +410,21 @@ func (bp *BlockPool) BlockSyncRoutine() {
 				// receive other peer sync request
 				if request, ok := msg.(SyncRequestMessage); ok {
 					bp.log.Println("Receive a SyncRequest message")
-					// check best height
-					// send sync response message
-					blockMsg, err := CreateBlockMessage(SyncResponseMsg, bp.network.ID, request.NodeID, bp.chain.BestHeight)
+					// reply to the connected peer, not the claimed sender ID
+					request.NodeID = syncMsg.From
+					// send sync response message, the best block header and certificate back the best height,
+					// a pruned node advertises the bodies it no longer serves
+					tip := bp.chain.GetBlock(bp.chain.Tip)
+					if tip == nil {
+						break
+					}
+					var certData []byte
+					if cert := bp.chain.GetCert(tip.Header.Hash); cert != nil {
+						certData, _ = codec.Marshal(cert)
+					}
+					blockMsg, err := CreateBlockMessage(SyncResponseMsg, bp.network.ID, request.NodeID, tip.Header.Height, tip.Header, certData, bp.chain.PrunedHeight())
 
-					data, err := json.Marshal(blockMsg)
+					data, err := codec.Marshal(blockMsg)
 					if err != nil {
 						bp.log.Println("Marshal message fail")
 						break
@@ -141,27 +441,37 @@ func (bp *BlockPool) BlockSyncRoutine() {
 				// receive other peer response
 				if response, ok := msg.(SyncResponseMessage); ok {
 					bp.log.Println("Receive a SyncResponse message")
-					// check best height and update
-					if response.BestHeight > bp.peerBestHeight {
-						bp.log.Println("Update bestheight to: ", response.BestHeight)
-						bp.UpdatePeerBestHeight(response.BestHeight, response.FromID)
-					}
+					response.FromID = syncMsg.From
+					bp.handleSyncResponse(&response)
 				}
 			case BlockRequestMsg:
 				// handle block request
 				if requestedBlock, ok := msg.(BlockRequestMessage); ok {
 					bp.log.Println("Receive a BlockRequest message")
-					blocksInRange := bp.chain.FindBlocksInRange(requestedBlock.Min, requestedBlock.Max)
-					for _, block := range blocksInRange {
-						serializedData, err := json.Marshal(block)
+					requestedBlock.NodeID = syncMsg.From
+					if requestedBlock.Max < requestedBlock.Min {
+						break
+					}
+					// one body window is served per request
+					to := requestedBlock.Max
+					if to >= requestedBlock.Min+SyncWindowSize {
+						to = requestedBlock.Min + SyncWindowSize - 1
+					}
+					for _, block := range bp.chain.FindBlocksInRange(requestedBlock.Min, to) {
+						serializedData, err := codec.Marshal(block)
 						if err != nil {
 							bp.log.Println("Marshal block fail")
 							continue
 						}
+						// attach commit certificate if stored
+						var certData []byte
+						if cert := bp.chain.GetCert(block.Header.Hash); cert != nil {
+							certData, _ = codec.Marshal(cert)
+						}
 
 						// send block response message
-						blockMsg, err := CreateBlockMessage(BlockResponseMsg, bp.network.ID, requestedBlock.NodeID, block.Header.Height, block.Header.Hash, serializedData)
-						data, err := json.Marshal(blockMsg)
+						blockMsg, err := CreateBlockMessage(BlockResponseMsg, bp.network.ID, requestedBlock.NodeID, block.Header.Height, block.Header.Hash, serializedData, certData)
+						data, err := codec.Marshal(blockMsg)
 						if err != nil {
 							bp.log.Println("Marshal message fail")
 							continue
@@ -180,43 +490,65 @@ func (bp *BlockPool) BlockSyncRoutine() {
 				if response, ok := msg.(BlockResponseMessage); ok {
 					bp.log.Println("Receive a BlockResponse message")
 					var block blockchain.Block
-					err := json.Unmarshal(response.Block, &block)
+					err := codec.Unmarshal(response.Block, &block)
 					if err != nil {
 						bp.log.Println("Deserialize block fail")
 						break
 					}
-					if !(bp.chain.AddBlock(&block)) {
-						bp.log.Println("Add Block to chain fail, put it in the pool")
-						bp.AddBlock(&block)
-					} else {
-						bp.log.Println("Add Block to chain successfully")
-						bp.log.Println("Reindex pool")
-						bp.Reindex()
+					response.FromID = syncMsg.From
+					if bp.handleSyncBlock(&response, &block) {
+						break
+					}
+					if !bp.isRequested(response.FromID, block.Header.Height) {
+						bp.log.Println("Drop unrequested block response from: ", response.FromID)
+						break
 					}
+					bp.processCertifiedBlock(&block, response.Cert)
+				}
+			case HeaderRequestMsg:
+				if request, ok := msg.(HeaderRequestMessage); ok {
+					bp.log.Println("Receive a HeaderRequest message")
+					request.NodeID = syncMsg.From
+					bp.handleHeaderRequest(&request)
+				}
+			case HeaderResponseMsg:
+				if response, ok := msg.(HeaderResponseMessage); ok {
+					bp.log.Println("Receive a HeaderResponse message")
+					response.FromID = syncMsg.From
+					bp.handleHeaderResponse(&response)
+				}
+			case SnapshotRequestMsg:
+				if request, ok := msg.(SnapshotRequestMessage); ok {
+					bp.log.Println("Receive a SnapshotRequest message")
+					request.NodeID = syncMsg.From
+					bp.handleSnapshotRequest(&request)
+				}
+			case SnapshotResponseMsg:
+				if response, ok := msg.(SnapshotResponseMessage); ok {
+					bp.log.Println("Receive a SnapshotResponse message")
+					response.FromID = syncMsg.From
+					bp.handleSnapshotResponse(&response)
+				}
+			case ChunkRequestMsg:
+				if request, ok := msg.(ChunkRequestMessage); ok {
+					request.NodeID = syncMsg.From
+					bp.handleChunkRequest(&request)
+				}
+			case ChunkResponseMsg:
+				if response, ok := msg.(ChunkResponseMessage); ok {
+					response.FromID = syncMsg.From
+					bp.handleChunkResponse(&response)
 				}
 			default:
 
 			}
 		case <-syncTimer.C:
-			// sync timeout
-			if bp.peerBestHeight > bp.chain.BestHeight {
-				bp.log.Println("Send block request message to peer: ", bp.bestPeerID)
-				blockMsg, err := CreateBlockMessage(BlockRequestMsg, bp.network.ID, bp.chain.BestHeight+1, bp.peerBestHeight)
-				data, err := json.Marshal(blockMsg)
-				if err != nil {
-					bp.log.Println("Marshal block fail")
-					syncTimer.Reset(10 * time.Second)
-					break
-				}
-				p2pMsg := p2pnet.Message{
-					Type: p2pnet.BlockMsg,
-					Data: data,
-				}
-				bp.network.BroadcastToPeer(&p2pMsg, bp.bestPeerID)
-			} else {
-				bp.BlockSynchronization()
-				syncTimer.Reset(10 * time.Second)
-			}
+			// refresh peer heights, synchronize from peers ahead of chain
+			bp.startHeaderSync()
+			bp.BlockSynchronization()
+			syncTimer.Reset(10 * time.Second)
+		case <-stallTicker.C:
+			bp.checkStalls()
 		}
 	}
 }
@@ -239,14 +571,29 @@ func (bp *BlockPool) AddBlock(block *blockchain.Block) {
 	}
 }
 
-// Reindex add orphan block to chain
+// Reindex add orphan block to chain, an orphan is verified again once its parent is known
+// because rules depending on the parent, such as difficulty, could not be checked when it arrived
 func (bp *BlockPool) Reindex() {
+	bp.connectLock.Lock()
+	defer bp.connectLock.Unlock()
+	bp.lock.Lock()
+	verifier := bp.verifier
+	bp.lock.Unlock()
 	if bp.Count() != 0 {
 		for {
 			found := false
-			for _, block := range bp.pool {
-				if bytes.Equal(block.Header.PrevHash, bp.chain.Tip) {
-					bp.chain.AddBlock(block)
+			for _, block := range bp.getBlocks() {
+				if !bp.chain.HaveBlock(block.Header.PrevHash) {
+					continue
+				}
+				if verifier != nil {
+					if err := verifier(block); err != nil {
+						bp.log.Println("Verify orphan block fail, drop it: ", err)
+						bp.RemoveBlock(block.Header.Hash)
+						continue
+					}
+				}
+				if bp.connectBlock(block) {
 					bp.RemoveBlock(block.Header.Hash)
 					found = true
 					break
@@ -254,13 +601,24 @@ func (bp *BlockPool) Reindex() {
 					continue
 				}
 			}
-			if len(bp.pool) == 0 || !found {
+			if bp.Count() == 0 || !found {
 				break
 			}
 		}
 	}
 }
 
+// getBlocks returns a snapshot of blocks in pool
+func (bp *BlockPool) getBlocks() []*blockchain.Block {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	var blocks []*blockchain.Block
+	for _, block := range bp.pool {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
 // GetBlock get block from pool by hash
 func (bp *BlockPool) GetBlock(hash []byte) *blockchain.Block {
 	bp.lock.Lock()
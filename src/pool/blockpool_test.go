@@ -0,0 +1,83 @@
+package pool
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	p2pnet "BlockChain/src/network"
+	"BlockChain/src/storage"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlockPool_RequireCert(t *testing.T) {
+	dir := t.TempDir()
+	wallet := blockchain.CreateWallet()
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	bp := NewBlockPool(1, &p2pnet.P2PNet{}, chain, filepath.Join(dir, "pool.log"))
+	reward := blockchain.NewRewardTx(wallet.GetAddress(), blockchain.ActiveParams().MinerReward, chain.GetHeight()+1)
+	block := blockchain.NewBlock(chain.Tip, []*blockchain.Transaction{reward}, chain.GetHeight()+1)
+
+	// a chain with commit certificates drops blocks without a valid one
+	bp.SetCertVerifier(func(cert *blockchain.QuorumCert) bool { return len(cert.Signatures) > 0 })
+	bp.processCertifiedBlock(block, nil)
+	forged, _ := codec.Marshal(&blockchain.QuorumCert{Height: block.Header.Height, BlockHash: block.Header.Hash})
+	bp.processCertifiedBlock(block, forged)
+	if chain.HaveBlock(block.Header.Hash) || bp.HaveBlock(block.Header.Hash) {
+		t.Fatal("accept block without valid commit certificate")
+	}
+	cert, _ := codec.Marshal(&blockchain.QuorumCert{
+		Height:     block.Header.Height,
+		BlockHash:  block.Header.Hash,
+		Signatures: []*blockchain.CertSignature{{ID: "replica"}},
+	})
+	bp.processCertifiedBlock(block, cert)
+	fmt.Println("chain height: ", chain.GetHeight())
+	if !chain.HaveBlock(block.Header.Hash) {
+		t.Fatal("certified block not connected")
+	}
+
+	// only engines broadcasting mined blocks accept new block broadcasts
+	next := blockchain.NewBlock(chain.Tip, []*blockchain.Transaction{}, chain.GetHeight()+1)
+	data, _ := codec.Marshal(next)
+	broadcast, _ := CreateBlockMessage(NewBlockBroadcastMsg, next.Header.Height, next.Header.Hash, data)
+	msgBytes, _ := codec.Marshal(broadcast)
+	bp.OnReceive(p2pnet.BlockMsg, msgBytes, "peer")
+	if chain.HaveBlock(next.Header.Hash) || bp.HaveBlock(next.Header.Hash) {
+		t.Fatal("accept new block broadcast")
+	}
+
+	// block responses are accepted only from the peer of the last announced request
+	if bp.isRequested("peer", block.Header.Height) {
+		t.Fatal("accept unrequested block response")
+	}
+	bp.requested, bp.requestedAt, bp.requestedFrom = block.Header.Height, time.Now(), "peer"
+	if !bp.isRequested("peer", block.Header.Height) || bp.isRequested("other", block.Header.Height) || bp.isRequested("peer", block.Header.Height+1) {
+		t.Fatal("wrong requested block response")
+	}
+}
+
+func TestBlockPool_GenesisSource(t *testing.T) {
+	dir := t.TempDir()
+	wallet := blockchain.CreateWallet()
+	chain, err := blockchain.CreateChain(wallet.GetAddress(), storage.MemoryPath, filepath.Join(dir, "chain.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.DataBase.Close()
+	bp := NewBlockPool(1, &p2pnet.P2PNet{}, chain, filepath.Join(dir, "pool.log"))
+
+	// a peer without the local genesis hash in its handshake is never synchronized from
+	if bp.followsGenesis("peer") {
+		t.Fatal("follow peer without genesis hash")
+	}
+	bp.handleSyncResponse(&SyncResponseMessage{FromID: "peer", BestHeight: chain.GetHeight() + 10})
+	if bp.sync.peers["peer"] != nil || bp.peerBestHeight != 0 {
+		t.Fatal("claim of peer without genesis hash recorded")
+	}
+}
@@ -1,7 +1,8 @@
 package pool
 
 import (
-	"encoding/json"
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
 	"fmt"
 )
 
@@ -25,12 +26,20 @@ const (
 	SyncResponseMsg
 	BlockRequestMsg
 	BlockResponseMsg
-	//NewBlockBroadcastMsg
+	NewBlockBroadcastMsg
+	NewBlockAnnounceMsg
+	HeaderRequestMsg
+	HeaderResponseMsg
+	SnapshotRequestMsg
+	SnapshotResponseMsg
+	ChunkRequestMsg
+	ChunkResponseMsg
 )
 
 type BlockMessage struct {
 	Type BlockMsgType `json:"type"`
 	Data []byte       `json:"data"`
+	From string       `json:"-" codec:"-"` // connected peer the message is received from
 }
 
 type SyncRequestMessage struct {
@@ -39,9 +48,12 @@ type SyncRequestMessage struct {
 }
 
 type SyncResponseMessage struct {
-	FromID     string `json:"fromID"`
-	ToID       string `json:"toID"`
-	BestHeight uint64 `json:"bestHeight"`
+	FromID     string                  `json:"fromID"`
+	ToID       string                  `json:"toID"`
+	BestHeight uint64                  `json:"bestHeight"`
+	Header     *blockchain.BlockHeader `json:"header,omitempty"` // header of the best block backing the claimed height
+	Cert       []byte                  `json:"cert,omitempty"`   // commit certificate of the best block, empty if not stored
+	Pruned     uint64                  `json:"pruned,omitempty"` // latest height whose body is pruned, 0 for a full node
 }
 
 type BlockRequestMessage struct {
@@ -56,13 +68,66 @@ type BlockResponseMessage struct {
 	Height uint64 `json:"height"`
 	Hash   []byte `json:"hash"`
 	Block  []byte `json:"block"`
+	Cert   []byte `json:"cert,omitempty"` // commit certificate of the block, empty if not stored
 }
 
-//type NewBlockMessage struct {
-//	Height uint64 `json:"height"`
-//	Hash   []byte `json:"hash"`
-//	Block  []byte `json:"block"`
-//}
+type NewBlockMessage struct {
+	Height uint64 `json:"height"`
+	Hash   []byte `json:"hash"`
+	Block  []byte `json:"block"`
+}
+
+// NewBlockAnnounceMessage announces a committed block without its body
+type NewBlockAnnounceMessage struct {
+	FromID string `json:"fromID"`
+	Height uint64 `json:"height"`
+	Hash   []byte `json:"hash"`
+}
+
+// HeaderRequestMessage requests block headers in a height range
+type HeaderRequestMessage struct {
+	NodeID string `json:"nodeID"`
+	Min    uint64 `json:"min"`
+	Max    uint64 `json:"max"`
+}
+
+// HeaderResponseMessage returns block headers in height order
+type HeaderResponseMessage struct {
+	FromID  string                    `json:"fromID"`
+	ToID    string                    `json:"toID"`
+	Headers []*blockchain.BlockHeader `json:"headers"`
+}
+
+// SnapshotRequestMessage requests the latest committed state snapshot
+type SnapshotRequestMessage struct {
+	NodeID string `json:"nodeID"`
+}
+
+// SnapshotResponseMessage offers a state snapshot with the blocks from the snapshot block to the block
+// committing its root, the manifest is nil if the peer has no committed snapshot
+type SnapshotResponseMessage struct {
+	FromID   string                       `json:"fromID"`
+	ToID     string                       `json:"toID"`
+	Manifest *blockchain.SnapshotManifest `json:"manifest,omitempty"`
+	Blocks   []*blockchain.Block          `json:"blocks,omitempty"`
+	Cert     []byte                       `json:"cert,omitempty"` // commit certificate of the committing block
+}
+
+// ChunkRequestMessage requests a chunk of the snapshot at height
+type ChunkRequestMessage struct {
+	NodeID string `json:"nodeID"`
+	Height uint64 `json:"height"`
+	Index  int    `json:"index"`
+}
+
+// ChunkResponseMessage returns a snapshot chunk, data is empty if the chunk is not stored
+type ChunkResponseMessage struct {
+	FromID string `json:"fromID"`
+	ToID   string `json:"toID"`
+	Height uint64 `json:"height"`
+	Index  int    `json:"index"`
+	Data   []byte `json:"data"`
+}
 
 // CreateBlockMessage function
 func CreateBlockMessage(t BlockMsgType, data ...interface{}) (interface{}, error) {
@@ -92,15 +157,50 @@ func CreateBlockMessage(t BlockMsgType, data ...interface{}) (interface{}, error
 		if err != nil {
 			return nil, err
 		}
-	//case NewBlockBroadcastMsg:
-	//	msg, err = createNewBlockMessage(data...)
-	//	if err != nil {
-	//		return nil, err
-	//	}
+	case NewBlockBroadcastMsg:
+		msg, err = createNewBlockMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case NewBlockAnnounceMsg:
+		msg, err = createNewBlockAnnounceMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case HeaderRequestMsg:
+		msg, err = createHeaderRequestMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case HeaderResponseMsg:
+		msg, err = createHeaderResponseMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case SnapshotRequestMsg:
+		msg, err = createSnapshotRequestMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case SnapshotResponseMsg:
+		msg, err = createSnapshotResponseMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case ChunkRequestMsg:
+		msg, err = createChunkRequestMessage(data...)
+		if err != nil {
+			return nil, err
+		}
+	case ChunkResponseMsg:
+		msg, err = createChunkResponseMessage(data...)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unknown message type: %v", t)
 	}
-	payload, err := json.Marshal(msg)
+	payload, err := codec.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +227,7 @@ func createSyncRequestMessage(data ...interface{}) (*SyncRequestMessage, error)
 }
 
 func createSyncResponseMessage(data ...interface{}) (*SyncResponseMessage, error) {
-	if len(data) != 3 {
+	if len(data) != 3 && len(data) != 5 && len(data) != 6 {
 		return nil, fmt.Errorf("invalid number of arguments for SyncResponseMessage")
 	}
 
@@ -139,10 +239,34 @@ func createSyncResponseMessage(data ...interface{}) (*SyncResponseMessage, error
 		return nil, fmt.Errorf("invalid argument types for SyncResponseMessage")
 	}
 
+	// optional best block header and commit certificate
+	var header *blockchain.BlockHeader
+	var cert []byte
+	if len(data) >= 5 {
+		var ok4, ok5 bool
+		header, ok4 = data[3].(*blockchain.BlockHeader)
+		cert, ok5 = data[4].([]byte)
+		if !ok4 || !ok5 {
+			return nil, fmt.Errorf("invalid argument types for SyncResponseMessage")
+		}
+	}
+
+	// optional pruned height
+	var pruned uint64
+	if len(data) == 6 {
+		var ok6 bool
+		if pruned, ok6 = data[5].(uint64); !ok6 {
+			return nil, fmt.Errorf("invalid argument types for SyncResponseMessage")
+		}
+	}
+
 	return &SyncResponseMessage{
 		FromID:     fromID,
 		ToID:       toID,
 		BestHeight: bestHeight,
+		Header:     header,
+		Cert:       cert,
+		Pruned:     pruned,
 	}, nil
 }
 
@@ -167,7 +291,7 @@ func createBlockRequestMessage(data ...interface{}) (*BlockRequestMessage, error
 }
 
 func createBlockResponseMessage(data ...interface{}) (*BlockResponseMessage, error) {
-	if len(data) != 5 {
+	if len(data) != 5 && len(data) != 6 {
 		return nil, fmt.Errorf("invalid number of arguments for BlockResponseMessage")
 	}
 
@@ -181,73 +305,286 @@ func createBlockResponseMessage(data ...interface{}) (*BlockResponseMessage, err
 		return nil, fmt.Errorf("invalid argument types for BlockResponseMessage")
 	}
 
+	// optional commit certificate
+	var cert []byte
+	if len(data) == 6 {
+		var ok6 bool
+		if cert, ok6 = data[5].([]byte); !ok6 {
+			return nil, fmt.Errorf("invalid argument types for BlockResponseMessage")
+		}
+	}
+
 	return &BlockResponseMessage{
 		FromID: fromID,
 		ToID:   toID,
 		Height: height,
 		Hash:   hash,
 		Block:  block,
+		Cert:   cert,
+	}, nil
+}
+
+func createNewBlockMessage(data ...interface{}) (*NewBlockMessage, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("invalid number of arguments for NewBlockMessage")
+	}
+
+	height, ok1 := data[0].(uint64)
+	hash, ok2 := data[1].([]byte)
+	block, ok3 := data[2].([]byte)
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("invalid argument types for NewBlockMessage")
+	}
+
+	return &NewBlockMessage{
+		Height: height,
+		Hash:   hash,
+		Block:  block,
+	}, nil
+}
+
+func createNewBlockAnnounceMessage(data ...interface{}) (*NewBlockAnnounceMessage, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("invalid number of arguments for NewBlockAnnounceMessage")
+	}
+
+	fromID, ok1 := data[0].(string)
+	height, ok2 := data[1].(uint64)
+	hash, ok3 := data[2].([]byte)
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("invalid argument types for NewBlockAnnounceMessage")
+	}
+
+	return &NewBlockAnnounceMessage{
+		FromID: fromID,
+		Height: height,
+		Hash:   hash,
+	}, nil
+}
+
+func createHeaderRequestMessage(data ...interface{}) (*HeaderRequestMessage, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("invalid number of arguments for HeaderRequestMessage")
+	}
+
+	nodeID, ok1 := data[0].(string)
+	minimum, ok2 := data[1].(uint64)
+	maximum, ok3 := data[2].(uint64)
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("invalid argument types for HeaderRequestMessage")
+	}
+
+	return &HeaderRequestMessage{
+		NodeID: nodeID,
+		Min:    minimum,
+		Max:    maximum,
 	}, nil
 }
 
-//func createNewBlockMessage(data ...interface{}) (*NewBlockMessage, error) {
-//	if len(data) != 3 {
-//		return nil, fmt.Errorf("invalid number of arguments for NewBlockMessage")
-//	}
-//
-//	height, ok1 := data[0].(uint64)
-//	hash, ok2 := data[1].([]byte)
-//	block, ok3 := data[2].([]byte)
-//
-//	if !ok1 || !ok2 || !ok3 {
-//		return nil, fmt.Errorf("invalid argument types for NewBlockMessage")
-//	}
-//
-//	return &NewBlockMessage{
-//		Height: height,
-//		Hash:   hash,
-//		Block:  block,
-//	}, nil
-//}
+func createHeaderResponseMessage(data ...interface{}) (*HeaderResponseMessage, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("invalid number of arguments for HeaderResponseMessage")
+	}
+
+	fromID, ok1 := data[0].(string)
+	toID, ok2 := data[1].(string)
+	headers, ok3 := data[2].([]*blockchain.BlockHeader)
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("invalid argument types for HeaderResponseMessage")
+	}
+
+	return &HeaderResponseMessage{
+		FromID:  fromID,
+		ToID:    toID,
+		Headers: headers,
+	}, nil
+}
+
+func createSnapshotRequestMessage(data ...interface{}) (*SnapshotRequestMessage, error) {
+	if len(data) != 1 {
+		return nil, fmt.Errorf("invalid number of arguments for SnapshotRequestMessage")
+	}
+
+	nodeID, ok := data[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid argument types for SnapshotRequestMessage")
+	}
+
+	return &SnapshotRequestMessage{
+		NodeID: nodeID,
+	}, nil
+}
+
+func createSnapshotResponseMessage(data ...interface{}) (*SnapshotResponseMessage, error) {
+	if len(data) != 2 && len(data) != 5 {
+		return nil, fmt.Errorf("invalid number of arguments for SnapshotResponseMessage")
+	}
+
+	fromID, ok1 := data[0].(string)
+	toID, ok2 := data[1].(string)
+
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid argument types for SnapshotResponseMessage")
+	}
+
+	// optional snapshot offer
+	var manifest *blockchain.SnapshotManifest
+	var blocks []*blockchain.Block
+	var cert []byte
+	if len(data) == 5 {
+		var ok3, ok4, ok5 bool
+		manifest, ok3 = data[2].(*blockchain.SnapshotManifest)
+		blocks, ok4 = data[3].([]*blockchain.Block)
+		cert, ok5 = data[4].([]byte)
+		if !ok3 || !ok4 || !ok5 {
+			return nil, fmt.Errorf("invalid argument types for SnapshotResponseMessage")
+		}
+	}
+
+	return &SnapshotResponseMessage{
+		FromID:   fromID,
+		ToID:     toID,
+		Manifest: manifest,
+		Blocks:   blocks,
+		Cert:     cert,
+	}, nil
+}
+
+func createChunkRequestMessage(data ...interface{}) (*ChunkRequestMessage, error) {
+	if len(data) != 3 {
+		return nil, fmt.Errorf("invalid number of arguments for ChunkRequestMessage")
+	}
+
+	nodeID, ok1 := data[0].(string)
+	height, ok2 := data[1].(uint64)
+	index, ok3 := data[2].(int)
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("invalid argument types for ChunkRequestMessage")
+	}
+
+	return &ChunkRequestMessage{
+		NodeID: nodeID,
+		Height: height,
+		Index:  index,
+	}, nil
+}
+
+func createChunkResponseMessage(data ...interface{}) (*ChunkResponseMessage, error) {
+	if len(data) != 5 {
+		return nil, fmt.Errorf("invalid number of arguments for ChunkResponseMessage")
+	}
+
+	fromID, ok1 := data[0].(string)
+	toID, ok2 := data[1].(string)
+	height, ok3 := data[2].(uint64)
+	index, ok4 := data[3].(int)
+	chunk, ok5 := data[4].([]byte)
+
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("invalid argument types for ChunkResponseMessage")
+	}
+
+	return &ChunkResponseMessage{
+		FromID: fromID,
+		ToID:   toID,
+		Height: height,
+		Index:  index,
+		Data:   chunk,
+	}, nil
+}
 
 // SplitMessage spilt PBFTMessage into the message struct corresponding to its type
 func (m *BlockMessage) SplitMessage() (interface{}, BlockMsgType) {
 	switch m.Type {
 	case SyncRequestMsg:
 		var syncReqMsg SyncRequestMessage
-		err := json.Unmarshal(m.Data, &syncReqMsg)
+		err := codec.Unmarshal(m.Data, &syncReqMsg)
 		if err != nil {
 			return nil, DefaultMsg
 		}
 		return syncReqMsg, SyncRequestMsg
 	case SyncResponseMsg:
 		var syncResMsg SyncResponseMessage
-		err := json.Unmarshal(m.Data, &syncResMsg)
+		err := codec.Unmarshal(m.Data, &syncResMsg)
 		if err != nil {
 			return nil, DefaultMsg
 		}
 		return syncResMsg, SyncResponseMsg
 	case BlockRequestMsg:
 		var blockReqMsg BlockRequestMessage
-		err := json.Unmarshal(m.Data, &blockReqMsg)
+		err := codec.Unmarshal(m.Data, &blockReqMsg)
 		if err != nil {
 			return nil, DefaultMsg
 		}
 		return blockReqMsg, BlockRequestMsg
 	case BlockResponseMsg:
 		var blockResMsg BlockResponseMessage
-		err := json.Unmarshal(m.Data, &blockResMsg)
+		err := codec.Unmarshal(m.Data, &blockResMsg)
 		if err != nil {
 			return nil, DefaultMsg
 		}
 		return blockResMsg, BlockResponseMsg
-	//case NewBlockBroadcastMsg:
-	//	var newBlockMsg NewBlockMessage
-	//	err := json.Unmarshal(m.Data, &newBlockMsg)
-	//	if err != nil {
-	//		return nil, DefaultMsg
-	//	}
-	//	return newBlockMsg, NewBlockBroadcastMsg
+	case NewBlockBroadcastMsg:
+		var newBlockMsg NewBlockMessage
+		err := codec.Unmarshal(m.Data, &newBlockMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return newBlockMsg, NewBlockBroadcastMsg
+	case NewBlockAnnounceMsg:
+		var announceMsg NewBlockAnnounceMessage
+		err := codec.Unmarshal(m.Data, &announceMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return announceMsg, NewBlockAnnounceMsg
+	case HeaderRequestMsg:
+		var headerReqMsg HeaderRequestMessage
+		err := codec.Unmarshal(m.Data, &headerReqMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return headerReqMsg, HeaderRequestMsg
+	case HeaderResponseMsg:
+		var headerResMsg HeaderResponseMessage
+		err := codec.Unmarshal(m.Data, &headerResMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return headerResMsg, HeaderResponseMsg
+	case SnapshotRequestMsg:
+		var snapshotReqMsg SnapshotRequestMessage
+		err := codec.Unmarshal(m.Data, &snapshotReqMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return snapshotReqMsg, SnapshotRequestMsg
+	case SnapshotResponseMsg:
+		var snapshotResMsg SnapshotResponseMessage
+		err := codec.Unmarshal(m.Data, &snapshotResMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return snapshotResMsg, SnapshotResponseMsg
+	case ChunkRequestMsg:
+		var chunkReqMsg ChunkRequestMessage
+		err := codec.Unmarshal(m.Data, &chunkReqMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return chunkReqMsg, ChunkRequestMsg
+	case ChunkResponseMsg:
+		var chunkResMsg ChunkResponseMessage
+		err := codec.Unmarshal(m.Data, &chunkResMsg)
+		if err != nil {
+			return nil, DefaultMsg
+		}
+		return chunkResMsg, ChunkResponseMsg
 	default:
 		return nil, DefaultMsg
 	}
@@ -0,0 +1,304 @@
+package pool
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	"BlockChain/src/utils"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// chunkRequest is a snapshot chunk requested from a peer
+type chunkRequest struct {
+	peer string
+	sent time.Time
+}
+
+// snapshotSync is the state of fast sync from a state snapshot, used by BlockSyncRoutine only
+type snapshotSync struct {
+	peer     string                       // peer the snapshot offer is requested from
+	sent     time.Time                    // time of the pending offer request, zero if none
+	tried    map[string]bool              // peers asked for an offer
+	lacking  map[string]bool              // peers without the chunks of the offered snapshot
+	manifest *blockchain.SnapshotManifest // verified manifest, nil before an offer is accepted
+	blocks   []*blockchain.Block          // blocks from the snapshot block to the committing block
+	cert     *blockchain.QuorumCert       // commit certificate of the committing block
+	chunks   [][]byte                     // downloaded chunks, nil if missing
+	requests map[int]*chunkRequest        // chunk index -> pending request
+}
+
+func newSnapshotSync() *snapshotSync {
+	ss := &snapshotSync{
+		tried: make(map[string]bool),
+	}
+	ss.reset()
+	return ss
+}
+
+// reset drops the offered snapshot, tried peers are kept
+func (ss *snapshotSync) reset() {
+	ss.peer = ""
+	ss.sent = time.Time{}
+	ss.lacking = make(map[string]bool)
+	ss.manifest = nil
+	ss.blocks = nil
+	ss.cert = nil
+	ss.chunks = nil
+	ss.requests = make(map[int]*chunkRequest)
+}
+
+// SetFastSync enables synchronizing an empty chain from a state snapshot committed by peers
+func (bp *BlockPool) SetFastSync(enabled bool) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.fastSync = enabled
+}
+
+// startSnapshotSync requests a snapshot offer from a verified peer far enough ahead of an empty chain,
+// returns true while the snapshot is synchronizing
+func (bp *BlockPool) startSnapshotSync() bool {
+	ss := bp.snapshot
+	bp.lock.Lock()
+	enabled := bp.fastSync && bp.certVerifier != nil
+	bp.lock.Unlock()
+	if !enabled || bp.chain.GetHeight() != 0 {
+		return false
+	}
+	if ss.manifest != nil || !ss.sent.IsZero() {
+		return true
+	}
+	var peers []string
+	for id, claim := range bp.sync.peers {
+		if claim.verified && !ss.tried[id] && bp.sync.heightOf(id, 0) >= blockchain.CommitHeight(blockchain.SnapshotInterval) {
+			peers = append(peers, id)
+		}
+	}
+	if len(peers) == 0 {
+		return false
+	}
+	sort.Strings(peers)
+	ss.peer = peers[0]
+	ss.sent = time.Now()
+	ss.tried[ss.peer] = true
+	bp.log.Println("Send snapshot request to peer: ", ss.peer)
+	bp.sendToPeer(ss.peer, SnapshotRequestMsg, bp.network.ID)
+	return true
+}
+
+// handleSnapshotRequest offers the latest committed snapshot with the blocks up to its committing block
+func (bp *BlockPool) handleSnapshotRequest(request *SnapshotRequestMessage) {
+	manifest := bp.chain.LatestSnapshot()
+	if manifest == nil {
+		bp.sendToPeer(request.NodeID, SnapshotResponseMsg, bp.network.ID, request.NodeID)
+		return
+	}
+	blocks := bp.chain.FindBlocksInRange(manifest.Height, blockchain.CommitHeight(manifest.Height))
+	if len(blocks) != blockchain.SnapshotDelay+1 || len(blocks[blockchain.SnapshotDelay].Header.StateRoot) == 0 {
+		// snapshot root not committed by consensus
+		bp.sendToPeer(request.NodeID, SnapshotResponseMsg, bp.network.ID, request.NodeID)
+		return
+	}
+	var certData []byte
+	if cert := bp.chain.GetCert(blocks[blockchain.SnapshotDelay].Header.Hash); cert != nil {
+		certData, _ = codec.Marshal(cert)
+	}
+	bp.log.Printf("Send snapshot offer to peer %s, height: %d", request.NodeID, manifest.Height)
+	bp.sendToPeer(request.NodeID, SnapshotResponseMsg, bp.network.ID, request.NodeID, manifest, blocks, certData)
+}
+
+// handleSnapshotResponse verifies a snapshot offer and starts downloading its chunks
+func (bp *BlockPool) handleSnapshotResponse(response *SnapshotResponseMessage) {
+	ss := bp.snapshot
+	if ss.sent.IsZero() || response.FromID != ss.peer {
+		return
+	}
+	ss.sent = time.Time{}
+	if response.Manifest == nil {
+		bp.log.Println("No committed snapshot on peer: ", response.FromID)
+		ss.reset()
+		return
+	}
+	cert, err := bp.verifySnapshot(response)
+	if err == errSnapshotValidators {
+		// not forged, the chain synchronizes from genesis instead
+		bp.log.Printf("Snapshot from peer %s can not be verified: %v", response.FromID, err)
+		ss.reset()
+		return
+	}
+	if err != nil {
+		bp.log.Printf("Invalid snapshot from peer %s: %v", response.FromID, err)
+		ss.reset()
+		bp.dropSyncPeer(response.FromID)
+		return
+	}
+	ss.manifest = response.Manifest
+	ss.blocks = response.Blocks
+	ss.cert = cert
+	ss.chunks = make([][]byte, len(ss.manifest.ChunkHashes))
+	bp.setSyncTarget(blockchain.CommitHeight(ss.manifest.Height))
+	bp.log.Printf("Start snapshot sync at height %d, chunks: %d", ss.manifest.Height, len(ss.chunks))
+	bp.requestChunks()
+}
+
+// errSnapshotValidators reports a snapshot taken after the validator set changed, an empty chain
+// knows the configured validators only and can not verify certificates of later ones
+var errSnapshotValidators = errors.New("snapshot validator set not the configured one")
+
+// verifySnapshot checks the offered snapshot root is committed by a block with a valid commit certificate
+// of the configured validators, which must still be the validator set of the snapshot
+func (bp *BlockPool) verifySnapshot(response *SnapshotResponseMessage) (*blockchain.QuorumCert, error) {
+	if err := blockchain.VerifySnapshot(response.Manifest, response.Blocks); err != nil {
+		return nil, err
+	}
+	local := bp.chain.GetValidators()
+	var vs *blockchain.ValidatorSet
+	if err := json.Unmarshal(response.Manifest.Validators, &vs); err != nil {
+		return nil, errors.New("deserialize snapshot validator set fail")
+	}
+	if local == nil || vs == nil || !local.SameMembers(vs) {
+		return nil, errSnapshotValidators
+	}
+	bp.lock.Lock()
+	certVerifier := bp.certVerifier
+	bp.lock.Unlock()
+	if certVerifier == nil || len(response.Cert) == 0 {
+		return nil, errors.New("snapshot commitment without commit certificate")
+	}
+	var cert blockchain.QuorumCert
+	if err := codec.Unmarshal(response.Cert, &cert); err != nil {
+		return nil, errors.New("deserialize commit certificate fail")
+	}
+	commit := response.Blocks[blockchain.SnapshotDelay]
+	if !bytes.Equal(cert.BlockHash, commit.Header.Hash) || cert.Height != commit.Header.Height {
+		return nil, errors.New("commit certificate not match committing block")
+	}
+	if !certVerifier(&cert) {
+		return nil, errors.New("invalid commit certificate")
+	}
+	return &cert, nil
+}
+
+// requestChunks requests missing chunks from idle peers having the committing block,
+// the snapshot is dropped if no peer can serve a missing chunk
+func (bp *BlockPool) requestChunks() {
+	ss := bp.snapshot
+	busy := make(map[string]bool)
+	for _, r := range ss.requests {
+		busy[r.peer] = true
+	}
+	var peers []string
+	for id := range bp.sync.peers {
+		if !ss.lacking[id] && bp.sync.heightOf(id, 0) >= blockchain.CommitHeight(ss.manifest.Height) {
+			peers = append(peers, id)
+		}
+	}
+	// prefer the peer with fewer timed out requests
+	sort.Slice(peers, func(i, j int) bool {
+		if bp.sync.stalls[peers[i]] != bp.sync.stalls[peers[j]] {
+			return bp.sync.stalls[peers[i]] < bp.sync.stalls[peers[j]]
+		}
+		return peers[i] < peers[j]
+	})
+	missing := false
+	for i, chunk := range ss.chunks {
+		if chunk != nil || ss.requests[i] != nil {
+			continue
+		}
+		missing = true
+		for _, peer := range peers {
+			if busy[peer] {
+				continue
+			}
+			busy[peer] = true
+			ss.requests[i] = &chunkRequest{peer: peer, sent: time.Now()}
+			bp.sendToPeer(peer, ChunkRequestMsg, bp.network.ID, ss.manifest.Height, i)
+			break
+		}
+	}
+	if !missing && len(ss.requests) == 0 {
+		bp.finishSnapshotSync()
+	} else if len(ss.requests) == 0 {
+		bp.log.Println("No peer to download snapshot from")
+		ss.reset()
+		bp.setSyncTarget(0)
+	}
+}
+
+// handleChunkRequest sends a stored snapshot chunk, empty if it is not stored
+func (bp *BlockPool) handleChunkRequest(request *ChunkRequestMessage) {
+	data := bp.chain.GetSnapshotChunk(request.Height, request.Index)
+	bp.sendToPeer(request.NodeID, ChunkResponseMsg, bp.network.ID, request.NodeID, request.Height, request.Index, data)
+}
+
+// handleChunkResponse keeps a chunk matching its hash in the manifest
+func (bp *BlockPool) handleChunkResponse(response *ChunkResponseMessage) {
+	ss := bp.snapshot
+	if ss.manifest == nil || response.Height != ss.manifest.Height {
+		return
+	}
+	r := ss.requests[response.Index]
+	if r == nil || r.peer != response.FromID {
+		return
+	}
+	delete(ss.requests, response.Index)
+	if len(response.Data) == 0 {
+		ss.lacking[response.FromID] = true
+	} else if !bytes.Equal(utils.Sha256Hash(response.Data), ss.manifest.ChunkHashes[response.Index]) {
+		bp.log.Println("Snapshot chunk not match manifest from peer: ", response.FromID)
+		bp.dropSyncPeer(response.FromID)
+	} else {
+		ss.chunks[response.Index] = response.Data
+	}
+	bp.requestChunks()
+}
+
+// finishSnapshotSync restores the downloaded snapshot and connects the blocks up to the committing block,
+// header sync continues from there
+func (bp *BlockPool) finishSnapshotSync() {
+	ss := bp.snapshot
+	manifest, chunks, blocks, cert := ss.manifest, ss.chunks, ss.blocks, ss.cert
+	ss.reset()
+	bp.setSyncTarget(0)
+	if err := bp.chain.RestoreSnapshot(manifest, chunks, blocks[0]); err != nil {
+		bp.log.Println("Restore snapshot fail: ", err)
+		return
+	}
+	for _, block := range blocks[1:] {
+		bp.processBlock(block)
+		if !bp.chain.HaveBlock(block.Header.Hash) {
+			bp.log.Println("Add snapshot block fail, height: ", block.Header.Height)
+			return
+		}
+	}
+	if err := bp.chain.AddCert(cert); err != nil {
+		bp.log.Println("Store commit certificate fail: ", err)
+	}
+	bp.log.Println("Snapshot sync finished, chain height: ", bp.chain.GetHeight())
+	bp.startHeaderSync()
+}
+
+// checkSnapshotStalls retries timed out snapshot requests from other peers
+func (bp *BlockPool) checkSnapshotStalls() {
+	ss := bp.snapshot
+	deadline := time.Now().Add(-SyncWindowTimeout * time.Second)
+	if !ss.sent.IsZero() && ss.sent.Before(deadline) {
+		bp.log.Println("Snapshot request timeout, peer: ", ss.peer)
+		bp.sync.penalize(ss.peer)
+		ss.reset()
+		return
+	}
+	if ss.manifest == nil {
+		return
+	}
+	for i, r := range ss.requests {
+		if r.sent.Before(deadline) {
+			bp.log.Printf("Chunk request to peer %s timeout, index: %d", r.peer, i)
+			bp.sync.penalize(r.peer)
+			delete(ss.requests, i)
+		}
+	}
+	bp.requestChunks()
+}
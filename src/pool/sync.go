@@ -0,0 +1,605 @@
+package pool
+
+import (
+	"BlockChain/src/blockchain"
+	"BlockChain/src/codec"
+	p2pnet "BlockChain/src/network"
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Header first synchronization: the header chain is fetched from the best peer and verified,
+// block bodies are downloaded in windows from several peers and connected in height order
+const (
+	MaxHeadersPerRequest = 128 // headers per header request
+	SyncWindowSize       = 16  // blocks per body request
+	MaxSyncWindows       = 8   // body windows downloaded ahead of chain height
+	SyncWindowTimeout    = 5   // seconds before a request is retried from another peer
+	SyncCheckInterval    = 1   // seconds between stalled request checks
+	MaxPeerStalls        = 3   // timed out requests before a peer is banned
+	SyncBanTime          = 600 // seconds a banned peer is not used for sync
+)
+
+// HeaderVerifier checks a block header received from peers before its body is downloaded
+type HeaderVerifier func(header *blockchain.BlockHeader) error
+
+// syncWindow is a range of block bodies requested from one peer
+type syncWindow struct {
+	min, max uint64
+	peer     string // requested peer, empty if waiting to be requested again
+	sent     time.Time
+}
+
+// peerClaim is the best height a peer claims, an unverified claim is trusted one header batch ahead of chain
+// or of the headers with proof of work the peer linked to chain
+type peerClaim struct {
+	height   uint64 // claimed best height
+	tip      []byte // claimed best block hash, nil if the claim has no best block proof
+	verified bool   // claim backed by a verified commit certificate or a header chain linked to chain
+	proven   uint64 // last height of verified proof of work headers the peer sent from chain
+	pruned   uint64 // latest height whose body the peer pruned, bodies up to it are not requested
+}
+
+// headerSync is the state of header first synchronization, used by BlockSyncRoutine only
+type headerSync struct {
+	peers      map[string]*peerClaim              // peer ID -> claimed best height
+	stalls     map[string]int                     // peer ID -> timed out requests
+	banned     map[string]time.Time               // peer ID -> end of ban
+	headerPeer string                             // peer the header chain is requested from
+	headerSent time.Time                          // time of the pending header request, zero if none
+	headerTo   uint64                             // last height of the pending header request
+	target     uint64                             // height to synchronize to, 0 if not running
+	headers    map[uint64]*blockchain.BlockHeader // verified headers above chain height
+	last       *blockchain.BlockHeader            // last verified header
+	next       uint64                             // first height without a body window
+	windows    map[uint64]*syncWindow             // window start height -> body request
+	bodies     map[uint64]*BlockResponseMessage   // downloaded bodies waiting for their parent
+}
+
+func newHeaderSync() *headerSync {
+	hs := &headerSync{
+		peers:  make(map[string]*peerClaim),
+		stalls: make(map[string]int),
+		banned: make(map[string]time.Time),
+	}
+	hs.reset()
+	return hs
+}
+
+// reset drops the running synchronization, known peers are kept
+func (hs *headerSync) reset() {
+	hs.headerPeer = ""
+	hs.headerSent = time.Time{}
+	hs.target = 0
+	hs.headers = make(map[uint64]*blockchain.BlockHeader)
+	hs.last = nil
+	hs.next = 0
+	hs.windows = make(map[uint64]*syncWindow)
+	hs.bodies = make(map[uint64]*BlockResponseMessage)
+}
+
+// heightOf returns the best height of a peer trusted at chain height, 0 for a banned peer
+func (hs *headerSync) heightOf(peer string, chainHeight uint64) uint64 {
+	claim := hs.peers[peer]
+	if claim == nil || time.Now().Before(hs.banned[peer]) {
+		return 0
+	}
+	if claim.verified {
+		return claim.height
+	}
+	trusted := chainHeight
+	if claim.proven > trusted {
+		trusted = claim.proven
+	}
+	if claim.height > trusted+MaxHeadersPerRequest {
+		return trusted + MaxHeadersPerRequest
+	}
+	return claim.height
+}
+
+// bestPeer returns the usable peer with the highest trusted best height
+func (hs *headerSync) bestPeer(chainHeight uint64) (string, uint64) {
+	var best string
+	var height uint64
+	for id := range hs.peers {
+		h := hs.heightOf(id, chainHeight)
+		if h > height || (h == height && h != 0 && id < best) {
+			best, height = id, h
+		}
+	}
+	return best, height
+}
+
+// penalize counts a timed out request of a peer, a peer timing out MaxPeerStalls times is banned
+func (hs *headerSync) penalize(peer string) {
+	hs.stalls[peer]++
+	if hs.stalls[peer] >= MaxPeerStalls {
+		hs.ban(peer)
+	}
+}
+
+// ban stops using a peer for SyncBanTime, its claim must be sent again after the ban
+func (hs *headerSync) ban(peer string) {
+	hs.banned[peer] = time.Now().Add(SyncBanTime * time.Second)
+	delete(hs.stalls, peer)
+	delete(hs.peers, peer)
+}
+
+// CheckHeaderChain checks headers extend the block of prevHash and prevHeight one by one
+func CheckHeaderChain(prevHash []byte, prevHeight uint64, headers []*blockchain.BlockHeader, verifier HeaderVerifier) error {
+	for _, header := range headers {
+		if header == nil {
+			return errors.New("empty header")
+		}
+		if header.Height != prevHeight+1 || !bytes.Equal(header.PrevHash, prevHash) {
+			return errors.New("header not linked to previous block")
+		}
+		if verifier != nil {
+			if err := verifier(header); err != nil {
+				return err
+			}
+		}
+		prevHash, prevHeight = header.Hash, header.Height
+	}
+	return nil
+}
+
+// SetHeaderVerifier sets the consensus verifier of headers received from peers
+func (bp *BlockPool) SetHeaderVerifier(v HeaderVerifier) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.headerVerifier = v
+}
+
+// SyncProgress returns the chain height and the height synchronizing to, 0 if not synchronizing
+func (bp *BlockPool) SyncProgress() (uint64, uint64) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	return bp.chain.GetHeight(), bp.syncTarget
+}
+
+// sendToPeer sends a block message to a peer
+func (bp *BlockPool) sendToPeer(peerID string, t BlockMsgType, data ...interface{}) {
+	blockMsg, err := CreateBlockMessage(t, data...)
+	if err != nil {
+		bp.log.Println("Create message fail: ", err)
+		return
+	}
+	payload, err := codec.Marshal(blockMsg)
+	if err != nil {
+		bp.log.Println("Marshal message fail")
+		return
+	}
+	bp.network.BroadcastToPeer(&p2pnet.Message{
+		Type: p2pnet.BlockMsg,
+		Data: payload,
+	}, peerID)
+}
+
+// startHeaderSync starts synchronizing to the best peer when it is ahead of chain,
+// an empty chain with fast sync enabled starts from a state snapshot
+func (bp *BlockPool) startHeaderSync() bool {
+	hs := bp.sync
+	if bp.startSnapshotSync() {
+		return true
+	}
+	if hs.target != 0 {
+		return true
+	}
+	peer, height := hs.bestPeer(bp.chain.GetHeight())
+	if peer == "" || height <= bp.chain.GetHeight() {
+		return false
+	}
+	hs.headerPeer = peer
+	hs.target = height
+	hs.next = bp.chain.GetHeight() + 1
+	bp.setSyncTarget(height)
+	bp.log.Printf("Start header sync from peer %s, height: %d-%d", peer, hs.next, height)
+	bp.requestHeaders(hs.next)
+	return true
+}
+
+// requestHeaders requests the next batch of headers from the header peer
+func (bp *BlockPool) requestHeaders(from uint64) {
+	hs := bp.sync
+	to := from + MaxHeadersPerRequest - 1
+	if to > hs.target {
+		to = hs.target
+	}
+	hs.headerSent = time.Now()
+	hs.headerTo = to
+	bp.log.Printf("Send header request to peer %s, height: %d-%d", hs.headerPeer, from, to)
+	bp.sendToPeer(hs.headerPeer, HeaderRequestMsg, bp.network.ID, from, to)
+}
+
+// handleHeaderRequest sends headers of the requested range in height order
+func (bp *BlockPool) handleHeaderRequest(request *HeaderRequestMessage) {
+	if request.Max < request.Min {
+		return
+	}
+	to := request.Max
+	if to >= request.Min+MaxHeadersPerRequest {
+		to = request.Min + MaxHeadersPerRequest - 1
+	}
+	// pruned blocks keep their headers
+	headers := bp.chain.FindHeadersInRange(request.Min, to)
+	bp.log.Printf("Send %d headers to peer: %s", len(headers), request.NodeID)
+	bp.sendToPeer(request.NodeID, HeaderResponseMsg, bp.network.ID, request.NodeID, headers)
+}
+
+// handleHeaderResponse verifies received headers against the verified header chain,
+// body download starts before the whole header chain is received
+func (bp *BlockPool) handleHeaderResponse(response *HeaderResponseMessage) {
+	hs := bp.sync
+	if hs.target == 0 || response.FromID != hs.headerPeer || hs.headerSent.IsZero() {
+		return
+	}
+	hs.headerSent = time.Time{}
+	prevHash, prevHeight := bp.chain.Tip, bp.chain.GetHeight()
+	if hs.last != nil {
+		prevHash, prevHeight = hs.last.Hash, hs.last.Height
+	}
+	bp.lock.Lock()
+	verifier := bp.headerVerifier
+	bp.lock.Unlock()
+	if len(response.Headers) == 0 {
+		bp.log.Println("Empty header response from peer: ", response.FromID)
+		bp.dropSyncPeer(response.FromID)
+		return
+	}
+	if uint64(len(response.Headers)) != hs.headerTo-prevHeight {
+		// peer does not have the blocks it advertised
+		bp.log.Printf("Peer %s sent %d headers, requested %d", response.FromID, len(response.Headers), hs.headerTo-prevHeight)
+		bp.dropSyncPeer(response.FromID)
+		return
+	}
+	if err := CheckHeaderChain(prevHash, prevHeight, response.Headers, verifier); err != nil {
+		bp.log.Printf("Invalid headers from peer %s: %v", response.FromID, err)
+		bp.dropSyncPeer(response.FromID)
+		return
+	}
+	last := response.Headers[len(response.Headers)-1]
+	if claim := hs.peers[response.FromID]; claim != nil && claim.tip != nil && last.Height == claim.height && !bytes.Equal(last.Hash, claim.tip) {
+		bp.log.Println("Header chain not match advertised best block, peer: ", response.FromID)
+		bp.dropSyncPeer(response.FromID)
+		return
+	}
+	for _, header := range response.Headers {
+		hs.headers[header.Height] = header
+	}
+	hs.last = last
+	if claim := hs.peers[response.FromID]; claim != nil && verifier != nil && !claim.verified {
+		// proof of work headers linked to chain prove the work of a claim batch by batch
+		claim.proven = last.Height
+		if last.Height >= claim.height && claim.tip != nil {
+			claim.verified = true
+		}
+		if height := hs.heightOf(response.FromID, bp.chain.GetHeight()); height > hs.target {
+			hs.target = height
+			bp.setSyncTarget(height)
+		}
+	}
+	bp.log.Printf("Verify headers successfully, header height: %d/%d", hs.last.Height, hs.target)
+	if hs.last.Height < hs.target {
+		bp.requestHeaders(hs.last.Height + 1)
+	}
+	bp.scheduleWindows()
+}
+
+// scheduleWindows requests body windows of verified headers from idle peers
+func (bp *BlockPool) scheduleWindows() {
+	hs := bp.sync
+	if hs.last == nil {
+		return
+	}
+	// windows waiting to be requested again come first
+	var starts []uint64
+	for start, w := range hs.windows {
+		if w.peer == "" {
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for _, start := range starts {
+		w := hs.windows[start]
+		if w.peer = bp.idlePeer(w.min, w.max); w.peer == "" {
+			return
+		}
+		bp.requestWindow(w)
+	}
+	// blocks may be added by consensus or announcements meanwhile
+	if height := bp.chain.GetHeight(); hs.next <= height {
+		hs.next = height + 1
+	}
+	for hs.next <= hs.last.Height && hs.next <= bp.chain.GetHeight()+SyncWindowSize*MaxSyncWindows {
+		max := hs.next + SyncWindowSize - 1
+		if max > hs.last.Height {
+			max = hs.last.Height
+		}
+		peer := bp.idlePeer(hs.next, max)
+		if peer == "" {
+			return
+		}
+		w := &syncWindow{min: hs.next, max: max, peer: peer}
+		hs.windows[w.min] = w
+		hs.next = max + 1
+		bp.requestWindow(w)
+	}
+}
+
+// idlePeer returns a usable peer without a pending body request which has block bodies from min to max
+func (bp *BlockPool) idlePeer(min, max uint64) string {
+	hs := bp.sync
+	busy := make(map[string]bool)
+	for _, w := range hs.windows {
+		if !bp.received(w) {
+			busy[w.peer] = true
+		}
+	}
+	var peers []string
+	chainHeight := bp.chain.GetHeight()
+	for id := range hs.peers {
+		if hs.heightOf(id, chainHeight) >= max && hs.peers[id].pruned < min && !busy[id] {
+			peers = append(peers, id)
+		}
+	}
+	if len(peers) == 0 {
+		return ""
+	}
+	// prefer the peer with fewer timed out requests
+	sort.Slice(peers, func(i, j int) bool {
+		if hs.stalls[peers[i]] != hs.stalls[peers[j]] {
+			return hs.stalls[peers[i]] < hs.stalls[peers[j]]
+		}
+		return peers[i] < peers[j]
+	})
+	return peers[0]
+}
+
+// received checks all bodies of a window above chain height are downloaded
+func (bp *BlockPool) received(w *syncWindow) bool {
+	for height := bp.chain.GetHeight() + 1; height <= w.max; height++ {
+		if height >= w.min && bp.sync.bodies[height] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// requestWindow requests the bodies of a window from its peer
+func (bp *BlockPool) requestWindow(w *syncWindow) {
+	w.sent = time.Now()
+	bp.log.Printf("Send block request to peer %s, height: %d-%d", w.peer, w.min, w.max)
+	bp.sendToPeer(w.peer, BlockRequestMsg, bp.network.ID, w.min, w.max)
+}
+
+// handleSyncBlock keeps a block body matching a verified header, returns false if the block is not synchronized
+func (bp *BlockPool) handleSyncBlock(response *BlockResponseMessage, block *blockchain.Block) bool {
+	hs := bp.sync
+	if block.Header == nil {
+		return false
+	}
+	header := hs.headers[block.Header.Height]
+	if header == nil || !bytes.Equal(header.Hash, block.Header.Hash) {
+		return false
+	}
+	if !bytes.Equal(block.CalculateHash(), header.Hash) {
+		bp.log.Println("Block body not match header from peer: ", response.FromID)
+		bp.dropSyncPeer(response.FromID)
+		return true
+	}
+	hs.bodies[block.Header.Height] = response
+	bp.connectBodies()
+	return true
+}
+
+// connectBodies connects downloaded bodies in height order and reports progress
+func (bp *BlockPool) connectBodies() {
+	hs := bp.sync
+	for {
+		height := bp.chain.GetHeight() + 1
+		response := hs.bodies[height]
+		if response == nil {
+			break
+		}
+		delete(hs.bodies, height)
+		var block blockchain.Block
+		if err := codec.Unmarshal(response.Block, &block); err != nil {
+			break
+		}
+		bp.processCertifiedBlock(&block, response.Cert)
+		if !bp.chain.HaveBlock(block.Header.Hash) {
+			bp.log.Println("Add synchronized block fail, height: ", height)
+			bp.dropSyncPeer(response.FromID)
+			return
+		}
+		delete(hs.headers, height)
+	}
+
+	// windows below chain height are done
+	height := bp.chain.GetHeight()
+	for start, w := range hs.windows {
+		if w.max <= height {
+			delete(hs.windows, start)
+		}
+	}
+	bp.log.Printf("Sync progress: %d/%d", height, hs.target)
+	if height >= hs.target {
+		bp.log.Println("Header sync finished, chain height: ", height)
+		hs.reset()
+		hs.stalls = make(map[string]int)
+		bp.setSyncTarget(0)
+		return
+	}
+	bp.scheduleWindows()
+}
+
+// checkStalls retries timed out requests from other peers
+func (bp *BlockPool) checkStalls() {
+	hs := bp.sync
+	bp.checkSnapshotStalls()
+	if hs.target == 0 {
+		return
+	}
+	deadline := time.Now().Add(-SyncWindowTimeout * time.Second)
+	if !hs.headerSent.IsZero() && hs.headerSent.Before(deadline) {
+		bp.log.Println("Header request timeout, peer: ", hs.headerPeer)
+		bp.dropSyncPeer(hs.headerPeer)
+		return
+	}
+	for _, w := range hs.windows {
+		if w.peer != "" && w.sent.Before(deadline) && !bp.received(w) {
+			bp.log.Printf("Block request to peer %s timeout, height: %d-%d", w.peer, w.min, w.max)
+			hs.penalize(w.peer)
+			w.peer = ""
+		}
+	}
+	bp.scheduleWindows()
+}
+
+// dropSyncPeer bans a peer which sent invalid data or did not deliver what it advertised,
+// a failed header peer restarts synchronization from the verified headers with the next best peer
+func (bp *BlockPool) dropSyncPeer(peer string) {
+	hs := bp.sync
+	bp.log.Println("Ban sync peer: ", peer)
+	hs.ban(peer)
+	for _, w := range hs.windows {
+		if w.peer == peer {
+			w.peer = ""
+		}
+	}
+	if peer != hs.headerPeer {
+		bp.scheduleWindows()
+		return
+	}
+	next, height := hs.bestPeer(bp.chain.GetHeight())
+	from := bp.chain.GetHeight() + 1
+	if hs.last != nil {
+		from = hs.last.Height + 1
+	}
+	if next == "" || height < from {
+		if hs.last == nil || bp.chain.GetHeight() >= hs.last.Height {
+			bp.log.Println("No peer to synchronize from")
+			hs.reset()
+			bp.setSyncTarget(0)
+			return
+		}
+		// download bodies of verified headers
+		hs.headerPeer = ""
+		hs.target = hs.last.Height
+		bp.setSyncTarget(hs.target)
+		bp.scheduleWindows()
+		return
+	}
+	hs.headerPeer = next
+	hs.target = height
+	bp.setSyncTarget(height)
+	bp.log.Printf("Switch header sync to peer %s, height: %d-%d", next, from, height)
+	bp.requestHeaders(from)
+}
+
+// OnPeerConnected asks a peer announcing a higher best height in its handshake for its best block proof,
+// the sync response feeds the claim to header synchronization without waiting for the next poll
+func (bp *BlockPool) OnPeerConnected(peerID string, handshake *p2pnet.Handshake) {
+	height := bp.chain.GetHeight()
+	if handshake.BestHeight <= height {
+		return
+	}
+	bp.log.Printf("Peer %s announces height %d, request sync", peerID, handshake.BestHeight)
+	bp.sendToPeer(peerID, SyncRequestMsg, bp.network.ID, height)
+}
+
+// handleSyncResponse records the best height claimed by a peer, a claim ahead of chain is trusted
+// in full only with a verified commit certificate or header of the claimed best block
+func (bp *BlockPool) handleSyncResponse(response *SyncResponseMessage) {
+	hs := bp.sync
+	if time.Now().Before(hs.banned[response.FromID]) {
+		return
+	}
+	if !bp.followsGenesis(response.FromID) {
+		bp.log.Println("Ignore claim of peer without the local genesis hash: ", response.FromID)
+		return
+	}
+	claim := &peerClaim{height: response.BestHeight, pruned: response.Pruned}
+	if response.BestHeight > bp.chain.GetHeight() {
+		verified, err := bp.verifyClaim(response)
+		if err != nil {
+			bp.log.Printf("Invalid best height claim from peer %s: %v", response.FromID, err)
+			bp.dropSyncPeer(response.FromID)
+			return
+		}
+		if response.Header != nil {
+			claim.tip = response.Header.Hash
+		}
+		claim.verified = verified
+	}
+	if old := hs.peers[response.FromID]; old != nil {
+		// headers proven before still link to chain
+		claim.proven = old.proven
+		if old.verified && bytes.Equal(old.tip, claim.tip) {
+			claim.verified = true
+		}
+	}
+	hs.peers[response.FromID] = claim
+	bp.lock.Lock()
+	if response.BestHeight > bp.peerBestHeight && claim.verified {
+		bp.peerBestHeight = response.BestHeight
+		bp.bestPeerID = response.FromID
+	}
+	bp.lock.Unlock()
+	bp.startHeaderSync()
+}
+
+// followsGenesis reports whether a peer announced the local genesis hash in its handshake, peers without one,
+// such as empty chains and chains restored from a snapshot, are served but never synchronized from
+func (bp *BlockPool) followsGenesis(peerID string) bool {
+	genesis := bp.chain.GetGenesisHash()
+	if len(genesis) == 0 {
+		return true
+	}
+	handshake := bp.network.PeerHandshake(peerID)
+	return handshake != nil && bytes.Equal(handshake.Genesis, genesis)
+}
+
+// verifyClaim checks the best block proof of a claim, returns an error for a forged proof and false
+// if the claim can not be verified, such as a commit certificate of a later validator set.
+// One proof of work header is cheap to mine at any height, the claim is verified by header sync
+// once the peer sent the headers linking it to chain
+func (bp *BlockPool) verifyClaim(response *SyncResponseMessage) (bool, error) {
+	header := response.Header
+	if header == nil {
+		return false, nil
+	}
+	if header.Height != response.BestHeight {
+		return false, errors.New("best block header not match best height")
+	}
+	bp.lock.Lock()
+	certVerifier, headerVerifier := bp.certVerifier, bp.headerVerifier
+	bp.lock.Unlock()
+	if headerVerifier != nil {
+		if err := headerVerifier(header); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if certVerifier == nil || len(response.Cert) == 0 {
+		return false, nil
+	}
+	var cert blockchain.QuorumCert
+	if err := codec.Unmarshal(response.Cert, &cert); err != nil {
+		return false, errors.New("deserialize commit certificate fail")
+	}
+	if !bytes.Equal(cert.BlockHash, header.Hash) || cert.Height != header.Height {
+		return false, errors.New("commit certificate not match best block")
+	}
+	return certVerifier(&cert), nil
+}
+
+// setSyncTarget updates the reported synchronization target
+func (bp *BlockPool) setSyncTarget(target uint64) {
+	bp.lock.Lock()
+	defer bp.lock.Unlock()
+	bp.syncTarget = target
+}
@@ -0,0 +1,77 @@
+package pool
+
+import (
+	"BlockChain/src/blockchain"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCheckHeaderChain(t *testing.T) {
+	var headers []*blockchain.BlockHeader
+	prev := []byte("tip")
+	for height := uint64(11); height <= 13; height++ {
+		block := blockchain.NewBlock(prev, nil, height)
+		headers = append(headers, block.Header)
+		prev = block.Header.Hash
+	}
+	err := CheckHeaderChain([]byte("tip"), 10, headers, nil)
+	fmt.Println("linked headers: ", err)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// a gap or another parent breaks the chain
+	if CheckHeaderChain([]byte("tip"), 10, []*blockchain.BlockHeader{headers[0], headers[2]}, nil) == nil {
+		t.Error("header chain with gap accepted")
+	}
+	if CheckHeaderChain([]byte("other"), 10, headers, nil) == nil {
+		t.Error("header chain of another parent accepted")
+	}
+
+	// consensus verifier rejects a header
+	reject := func(header *blockchain.BlockHeader) error {
+		if header.Height == 12 {
+			return errors.New("invalid proof of work")
+		}
+		return nil
+	}
+	err = CheckHeaderChain([]byte("tip"), 10, headers, reject)
+	fmt.Println("rejected header: ", err)
+	if err == nil {
+		t.Error("rejected header accepted")
+	}
+}
+
+func TestHeaderSync_Claims(t *testing.T) {
+	hs := newHeaderSync()
+	hs.peers["liar"] = &peerClaim{height: 1 << 63}
+	hs.peers["honest"] = &peerClaim{height: 300, tip: []byte("tip"), verified: true}
+
+	// an unverified claim is trusted one header batch ahead of chain
+	fmt.Println("trusted heights: ", hs.heightOf("liar", 100), hs.heightOf("honest", 100))
+	if hs.heightOf("liar", 100) != 100+MaxHeadersPerRequest {
+		t.Error("unverified claim not capped")
+	}
+	// proof of work headers linked to chain extend the trust of a claim
+	hs.peers["miner"] = &peerClaim{height: 1000, proven: 200}
+	fmt.Println("trusted height of proven claim: ", hs.heightOf("miner", 100))
+	if hs.heightOf("miner", 100) != 200+MaxHeadersPerRequest {
+		t.Error("proven headers not trusted")
+	}
+	delete(hs.peers, "miner")
+	peer, height := hs.bestPeer(100)
+	if peer != "honest" || height != 300 {
+		t.Error("best peer error: ", peer, height)
+	}
+
+	// timed out requests ban a peer and drop its claim
+	for i := 0; i < MaxPeerStalls; i++ {
+		hs.penalize("honest")
+	}
+	peer, _ = hs.bestPeer(100)
+	fmt.Println("best peer after ban: ", peer)
+	if peer != "liar" || hs.heightOf("honest", 100) != 0 {
+		t.Error("banned peer still used")
+	}
+}
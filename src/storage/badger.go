@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"badger"
+)
+
+// Badger is a KV stored on disk with BadgerDB
+type Badger struct {
+	db *badger.DB
+}
+
+// OpenBadger opens a BadgerDB database at the specified path
+func OpenBadger(path string) (*Badger, error) {
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Badger{db: db}, nil
+}
+
+// Get reads the value of a key, ErrNotFound if it is missing
+func (b *Badger) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = txnGet(txn, key)
+		return err
+	})
+	return value, err
+}
+
+// Set writes a key value pair
+func (b *Badger) Set(key, value []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+// Delete removes a key
+func (b *Badger) Delete(key []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// NewBatch returns a batch written in one badger transaction
+func (b *Badger) NewBatch() Batch {
+	return &batch{write: func(ops []batchOp) error {
+		return b.db.Update(func(txn *badger.Txn) error {
+			for _, op := range ops {
+				var err error
+				if op.delete {
+					err = txn.Delete(op.key)
+				} else {
+					err = txn.Set(op.key, op.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}}
+}
+
+// NewIterator walks keys with prefix in ascending order
+func (b *Badger) NewIterator(prefix []byte) Iterator {
+	return newBadgerIterator(b.db.NewTransaction(false), true, prefix)
+}
+
+// Snapshot returns a view backed by a read only badger transaction
+func (b *Badger) Snapshot() Snapshot {
+	return &badgerSnapshot{txn: b.db.NewTransaction(false)}
+}
+
+// Close closes the store
+func (b *Badger) Close() error {
+	return b.db.Close()
+}
+
+func txnGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s *badgerSnapshot) Get(key []byte) ([]byte, error) {
+	return txnGet(s.txn, key)
+}
+
+func (s *badgerSnapshot) NewIterator(prefix []byte) Iterator {
+	return newBadgerIterator(s.txn, false, prefix)
+}
+
+func (s *badgerSnapshot) Release() {
+	s.txn.Discard()
+}
+
+type badgerIterator struct {
+	txn     *badger.Txn
+	ownTxn  bool // the transaction is discarded with the iterator
+	iter    *badger.Iterator
+	prefix  []byte
+	started bool
+	key     []byte
+	value   []byte
+	err     error
+}
+
+func newBadgerIterator(txn *badger.Txn, ownTxn bool, prefix []byte) *badgerIterator {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	return &badgerIterator{
+		txn:    txn,
+		ownTxn: ownTxn,
+		iter:   txn.NewIterator(opts),
+		prefix: prefix,
+	}
+}
+
+func (it *badgerIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if it.started {
+		it.iter.Next()
+	} else {
+		it.iter.Seek(it.prefix)
+		it.started = true
+	}
+	if !it.iter.ValidForPrefix(it.prefix) {
+		return false
+	}
+	item := it.iter.Item()
+	it.key = item.KeyCopy(nil)
+	it.value, it.err = item.ValueCopy(nil)
+	return it.err == nil
+}
+
+func (it *badgerIterator) Key() []byte {
+	return it.key
+}
+
+func (it *badgerIterator) Value() []byte {
+	return it.value
+}
+
+func (it *badgerIterator) Error() error {
+	return it.err
+}
+
+func (it *badgerIterator) Release() {
+	it.iter.Close()
+	if it.ownTxn {
+		it.txn.Discard()
+	}
+}
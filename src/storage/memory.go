@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// Memory is a KV kept in memory, its data is lost on close
+type Memory struct {
+	data map[string][]byte
+	lock sync.RWMutex
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+// Get reads the value of a key, ErrNotFound if it is missing
+func (m *Memory) Get(key []byte) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	value, ok := m.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Set writes a key value pair
+func (m *Memory) Set(key, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes a key
+func (m *Memory) Delete(key []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+// NewBatch returns a batch applied under one lock
+func (m *Memory) NewBatch() Batch {
+	return &batch{write: func(ops []batchOp) error {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		for _, op := range ops {
+			if op.delete {
+				delete(m.data, string(op.key))
+			} else {
+				m.data[string(op.key)] = op.value
+			}
+		}
+		return nil
+	}}
+}
+
+// NewIterator walks the keys with prefix when it is created
+func (m *Memory) NewIterator(prefix []byte) Iterator {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return newMemoryIterator(m.data, prefix)
+}
+
+// Snapshot copies the data, values are never changed in place so they are shared
+func (m *Memory) Snapshot() Snapshot {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	data := make(map[string][]byte, len(m.data))
+	for key, value := range m.data {
+		data[key] = value
+	}
+	return &memorySnapshot{data: data}
+}
+
+// Close closes the store
+func (m *Memory) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data = make(map[string][]byte)
+	return nil
+}
+
+type memorySnapshot struct {
+	data map[string][]byte
+}
+
+func (s *memorySnapshot) Get(key []byte) ([]byte, error) {
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (s *memorySnapshot) NewIterator(prefix []byte) Iterator {
+	return newMemoryIterator(s.data, prefix)
+}
+
+func (s *memorySnapshot) Release() {
+	s.data = nil
+}
+
+type memoryIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func newMemoryIterator(data map[string][]byte, prefix []byte) *memoryIterator {
+	it := &memoryIterator{index: -1}
+	for key := range data {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			it.keys = append(it.keys, key)
+		}
+	}
+	sort.Strings(it.keys)
+	for _, key := range it.keys {
+		it.values = append(it.values, data[key])
+	}
+	return it
+}
+
+func (it *memoryIterator) Next() bool {
+	if it.index+1 >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *memoryIterator) Key() []byte {
+	return []byte(it.keys[it.index])
+}
+
+func (it *memoryIterator) Value() []byte {
+	return append([]byte{}, it.values[it.index]...)
+}
+
+func (it *memoryIterator) Error() error {
+	return nil
+}
+
+func (it *memoryIterator) Release() {
+	it.keys, it.values = nil, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"errors"
+)
+
+// MemoryPath opens an in-memory store, used by unit tests and ephemeral dev nodes
+const MemoryPath = ":memory:"
+
+// ErrNotFound is returned by Get for a missing key
+var ErrNotFound = errors.New("key not found")
+
+// Reader reads keys of a store or of a snapshot
+type Reader interface {
+	Get(key []byte) ([]byte, error)
+	NewIterator(prefix []byte) Iterator
+}
+
+// KV is a key value store iterating keys in byte order
+type KV interface {
+	Reader
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	NewBatch() Batch
+	Snapshot() Snapshot
+	Close() error
+}
+
+// Batch collects writes which are applied together by Write
+type Batch interface {
+	Set(key, value []byte)
+	Delete(key []byte)
+	Write() error
+}
+
+// Snapshot is a read only view of the store when it is taken, it must be released
+type Snapshot interface {
+	Reader
+	Release()
+}
+
+// Iterator walks keys with a prefix in ascending order, it must be released
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// Open opens a badger store at path, or an in-memory store for MemoryPath
+func Open(path string) (KV, error) {
+	if path == MemoryPath {
+		return NewMemory(), nil
+	}
+	return OpenBadger(path)
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// batch records operations for the Write function of a store
+type batch struct {
+	ops   []batchOp
+	write func(ops []batchOp) error
+}
+
+func (b *batch) Set(key, value []byte) {
+	b.ops = append(b.ops, batchOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+}
+
+func (b *batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: append([]byte{}, key...), delete: true})
+}
+
+func (b *batch) Write() error {
+	err := b.write(b.ops)
+	b.ops = nil
+	return err
+}
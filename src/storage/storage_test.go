@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	disk, err := OpenBadger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, db := range map[string]KV{"disk": disk, "memory": NewMemory()} {
+		testStore(t, name, db)
+		db.Close()
+	}
+}
+
+func testStore(t *testing.T, name string, db KV) {
+	if _, err := db.Get([]byte("a1")); err != ErrNotFound {
+		t.Fatal(name, " missing key: ", err)
+	}
+	batch := db.NewBatch()
+	batch.Set([]byte("a2"), []byte("2"))
+	batch.Set([]byte("a1"), []byte("1"))
+	batch.Set([]byte("b1"), []byte("3"))
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Get([]byte("a1")); err != nil || !bytes.Equal(value, []byte("1")) {
+		t.Fatal(name, " get fail")
+	}
+
+	// a snapshot does not see later writes
+	snapshot := db.Snapshot()
+	db.Delete([]byte("a1"))
+	db.Set([]byte("a3"), []byte("4"))
+
+	var keys []string
+	iter := snapshot.NewIterator([]byte("a"))
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	snapshot.Release()
+	fmt.Println(name, "snapshot keys: ", keys)
+	if fmt.Sprint(keys) != "[a1 a2]" {
+		t.Fatal(name, " snapshot iterate fail")
+	}
+
+	keys = nil
+	iter = db.NewIterator([]byte("a"))
+	for iter.Next() {
+		keys = append(keys, string(iter.Key())+"="+string(iter.Value()))
+	}
+	iter.Release()
+	if fmt.Sprint(keys) != "[a2=2 a3=4]" {
+		t.Fatal(name, " iterate fail: ", keys)
+	}
+}
@@ -22,8 +22,8 @@ func Base58Encode(input []byte) []byte {
 		enc = append(enc, b58Alphabet[mod.Int64()])
 	}
 
-	// bitcoin
-	if input[0] == byte(0x00) {
+	// bitcoin, every leading zero byte is one leading '1'
+	for i := 0; i < len(input) && input[i] == byte(0x00); i++ {
 		enc = append(enc, b58Alphabet[0])
 	}
 
@@ -46,7 +46,7 @@ func Base58Decode(input []byte) []byte {
 
 	decoded := result.Bytes()
 
-	if input[0] == b58Alphabet[0] {
+	for i := 0; i < len(input) && input[i] == b58Alphabet[0]; i++ {
 		decoded = append([]byte{0x00}, decoded...)
 	}
 
@@ -19,3 +19,13 @@ func TestBase58(t *testing.T) {
 	decoded := Base58Decode([]byte("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"))
 	fmt.Println("Decode: ", decoded)
 }
+
+func TestBase58_LeadingZeros(t *testing.T) {
+	// a mainnet address whose key hash starts with a zero byte
+	hash, _ := hex.DecodeString("0000a966776006953D5567439E5E39F86A0D273BEED61967F6")
+	encoded := Base58Encode(hash)
+	fmt.Println("Encode: ", string(encoded))
+	if string(encoded[:2]) != "11" || hex.EncodeToString(Base58Decode(encoded)) != hex.EncodeToString(hash) {
+		t.Fatal("leading zero bytes lost")
+	}
+}
@@ -1,58 +1,71 @@
 package utils
 
-//import (
-//	"bytes"
-//	"math"
-//	"math/big"
-//)
-//
-//func Mining(header *BlockHeader) (int64, []byte) {
-//	var hashInt big.Int
-//	headerBytes := SerializeHeader(header)
-//	target := big.NewInt(1)
-//	target.Lsh(target, uint(256-header.Bits))
-//
-//	var nonce int64
-//	var hash []byte
-//	nonce = 0
-//	for nonce < math.MaxInt64 {
-//		data := append(headerBytes, byte(nonce))
-//		// hash twice
-//		hash = Sha256Hash(data)
-//		hash = Sha256Hash(hash)
-//		hashInt.SetBytes(hash)
-//		if hashInt.Cmp(target) == -1 {
-//			break
-//		} else {
-//			nonce++
-//		}
-//	}
-//	if nonce != math.MaxInt64 {
-//		return nonce, hash[:]
-//	} else {
-//		panic("fail to mine block")
-//	}
-//}
-//
-//// Proof check hash
-//func Proof(header *BlockHeader) bool {
-//	headerBytes := SerializeHeader(header)
-//	nonce := byte(header.Nonce)
-//	headerBytes = append(headerBytes, nonce)
-//	hash := Sha256Hash(headerBytes)
-//	hash = Sha256Hash(hash[:])
-//	return bytes.Equal(hash[:], header.Hash)
-//}
-//
-//func SerializeHeader(header *BlockHeader) []byte {
-//	data := bytes.Join(
-//		[][]byte{
-//			header.PrevHash,
-//			header.MerkleRoot,
-//			Int2Bytes(int64(header.Timestamp)),
-//			Int2Bytes(int64(header.Bits)),
-//		},
-//		[]byte{},
-//	)
-//	return data
-//}
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+)
+
+const (
+	MiningCheckInterval = 1 << 16 // number of nonces tried between two stop checks
+	MaxWorkBits         = 64      // highest difficulty bits counted as work
+)
+
+// Target returns the proof of work target of difficulty bits, a valid hash is below it
+func Target(bits uint32) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+	return target
+}
+
+// Work returns the expected number of hashes to find a block of difficulty bits,
+// bits above MaxWorkBits are counted as MaxWorkBits
+func Work(bits uint32) *big.Int {
+	if bits > MaxWorkBits {
+		bits = MaxWorkBits
+	}
+	work := big.NewInt(1)
+	work.Lsh(work, uint(bits))
+	return work
+}
+
+// Mining searches a nonce whose hash with header data is below the target of bits,
+// stop is checked periodically and mining gives up once it returns true
+func Mining(headerBytes []byte, bits uint32, stop func() bool) (uint64, []byte, bool) {
+	var hashInt big.Int
+	target := Target(bits)
+
+	var nonce uint64
+	for nonce = 0; nonce < math.MaxUint64; nonce++ {
+		if nonce%MiningCheckInterval == 0 && stop != nil && stop() {
+			return 0, nil, false
+		}
+		hash := powHash(headerBytes, nonce)
+		hashInt.SetBytes(hash)
+		if hashInt.Cmp(target) == -1 {
+			return nonce, hash, true
+		}
+	}
+	return 0, nil, false
+}
+
+// Proof checks the hash is the header data hash with nonce and below the target of bits
+func Proof(headerBytes []byte, nonce uint64, bits uint32, hash []byte) bool {
+	var hashInt big.Int
+	calculated := powHash(headerBytes, nonce)
+	if !bytes.Equal(calculated, hash) {
+		return false
+	}
+	hashInt.SetBytes(hash)
+	return hashInt.Cmp(Target(bits)) == -1
+}
+
+// powHash hash header data with nonce twice
+func powHash(headerBytes []byte, nonce uint64) []byte {
+	nonceBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(nonceBuf, nonce)
+	data := append(append([]byte{}, headerBytes...), nonceBuf...)
+	hash := Sha256Hash(data)
+	return Sha256Hash(hash)
+}
@@ -1,42 +1,35 @@
 package utils
 
-//
-//import (
-//	"fmt"
-//	"testing"
-//)
-//
-//func TestMining_and_Proof(t *testing.T) {
-//	input := []TXinput{
-//		{
-//			Index:     1,
-//			Value:     100,
-//			Address:   []byte("input_address_1"),
-//			Signature: []byte("input_signature_1"),
-//			PublicKey: []byte("input_public_key_1"),
-//		},
-//		// Add more inputs as needed
-//	}
-//	output := []TXoutput{
-//		{
-//			Index:   1,
-//			Value:   50,
-//			Address: []byte("output_address_1"),
-//		},
-//		// Add more outputs as needed
-//	}
-//	transaction := Transaction{
-//		ID:     []byte("some_transaction_id"),
-//		Inputs: input,
-//		Output: output,
-//	}
-//
-//	block := &Block{}
-//	Transactions := []*Transaction{
-//		&transaction,
-//	}
-//	block.NewBlock([]byte("previous_hash"), Transactions)
-//	fmt.Println(block.Header.Hash)
-//	fmt.Println(block.Header.Nonce)
-//	fmt.Println(Proof(block.Header))
-//}
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMining_and_Proof(t *testing.T) {
+	headerBytes := []byte("previous_hash merkle_root timestamp")
+	var bits uint32 = 12
+
+	nonce, hash, ok := Mining(headerBytes, bits, nil)
+	if !ok {
+		t.Fatal("fail to mine block")
+	}
+	fmt.Println("Nonce: ", nonce)
+	fmt.Printf("Hash: %x\n", hash)
+
+	if !Proof(headerBytes, nonce, bits, hash) {
+		t.Error("proof of mined hash fail")
+	}
+	if Proof(headerBytes, nonce+1, bits, hash) {
+		t.Error("proof with wrong nonce should fail")
+	}
+	if Proof([]byte("another header"), nonce, bits, hash) {
+		t.Error("proof with wrong header should fail")
+	}
+}
+
+func TestMining_Stop(t *testing.T) {
+	_, _, ok := Mining([]byte("header"), 255, func() bool { return true })
+	if ok {
+		t.Error("mining should stop")
+	}
+}
@@ -1,10 +1,9 @@
 package utils
 
 import (
-	"bytes"
+	"BlockChain/src/codec"
 	"crypto/sha256"
 	"encoding/binary"
-	"encoding/gob"
 	"fmt"
 	"goland/x/crypto/ripemd160"
 )
@@ -43,24 +42,12 @@ func HandleError(err error) {
 	}
 }
 
-// Serialize data
+// Serialize encodes data with the canonical binary encoding used for hashing, storage and messages
 func Serialize(data interface{}) ([]byte, error) {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(data)
-	if err != nil {
-		return nil, err
-	}
-	return buffer.Bytes(), nil
+	return codec.Marshal(data)
 }
 
-// Deserialize data
+// Deserialize decodes data encoded by Serialize into target
 func Deserialize(raw []byte, target interface{}) error {
-	buffer := bytes.NewBuffer(raw)
-	decoder := gob.NewDecoder(buffer)
-	err := decoder.Decode(target)
-	if err != nil {
-		return err
-	}
-	return nil
+	return codec.Unmarshal(raw, target)
 }